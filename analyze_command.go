@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/features/image/di"
+	"github.com/RodolfoBonis/rb-cdn/features/image/domain/entities"
+)
+
+// runAnalyze backfills the image analyses of a bucket, as POST
+// /images/{bucket}/analyze does, in the foreground: analyses go on the
+// objects, in the catalog and in the similarity index. The job's final
+// state is printed as JSON; the exit status is 0 when it succeeded, 1
+// when it failed and 2 on a usage error. A run that stopped halfway is
+// resumed with -start-after set to the last_key it saved.
+func runAnalyze(args []string) int {
+	flags := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: rb-cdn analyze [-prefix p] [-force] [-start-after key] <bucket>")
+		flags.PrintDefaults()
+	}
+
+	var request entities.AnalyzeRequestEntity
+	flags.StringVar(&request.Prefix, "prefix", "", "only analyse the keys under prefix")
+	flags.BoolVar(&request.Force, "force", false, "analyse images again even if they carry an analysis")
+	flags.StringVar(&request.StartAfter, "start-after", "", "skip the keys up to and including this one")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		if err == nil {
+			flags.Usage()
+		}
+		return 2
+	}
+
+	job, appErr := di.ImageInjection().Backfill(flags.Arg(0), request)
+	if appErr != nil {
+		logger.Log.Error(appErr.Message, appErr.ToMap())
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(job)
+
+	if job.Status != entities.AnalyzeJobStatus.Succeeded {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,329 @@
+// Package archive walks the files of zip and tar archives for
+// server-side extraction. Entry names are checked so nothing can land
+// outside the target folder, and the walk stops as soon as an archive
+// has too many entries or expands beyond its limits.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Format is an archive container the extractor understands.
+type Format string
+
+const (
+	Zip     Format = "zip"
+	Tar     Format = "tar"
+	TarGzip Format = "tar.gz"
+)
+
+// ratioAllowance is how far an archive may expand before its
+// expansion ratio is checked at all: small archives of repetitive
+// files legitimately compress far beyond any sensible ratio.
+const ratioAllowance = 1 << 20
+
+var (
+	ErrUnsafePath     = errors.New("archive entry escapes the target folder")
+	ErrTooManyEntries = errors.New("archive has too many entries")
+	ErrTooLarge       = errors.New("archive is too large")
+	ErrExpansion      = errors.New("archive expands beyond the size limit")
+	ErrRatio          = errors.New("archive expands beyond the compression ratio limit")
+	ErrMalformed      = errors.New("archive is malformed")
+)
+
+// Limits bound what a single archive may contain; zero disables a
+// limit.
+type Limits struct {
+	// MaxSize caps the archive itself, in bytes.
+	MaxSize int64
+	// MaxEntries caps the entries of any kind, directories included.
+	MaxEntries int
+	// MaxExpandedSize caps the bytes all entries expand to together.
+	MaxExpandedSize int64
+	// MaxRatio caps the expanded size as a multiple of the archive's.
+	MaxRatio int64
+}
+
+// Entry is one entry of an archive. Path is relative and
+// slash-separated; Skipped says why an entry that isn't a regular file
+// (a symlink, a device) was not extracted, and Body is nil for it.
+type Entry struct {
+	Path    string
+	Size    int64
+	Skipped string
+	Body    io.Reader
+}
+
+// WalkFunc is called for every entry but directories, in archive
+// order. Body is only valid until it returns.
+type WalkFunc func(entry Entry) error
+
+// FormatOf infers an archive's format from its file name.
+func FormatOf(filename string) (Format, bool) {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return Zip, true
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return TarGzip, true
+	case strings.HasSuffix(name, ".tar"):
+		return Tar, true
+	}
+
+	return "", false
+}
+
+// CleanPath turns an entry name into a path relative to the target
+// folder. Backslashes count as separators, since Windows tools write
+// them; absolute names and any ".." segment are refused rather than
+// resolved.
+func CleanPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+
+	segments := strings.Split(name, "/")
+	kept := segments[:0]
+	for _, segment := range segments {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+		}
+		kept = append(kept, segment)
+	}
+
+	return strings.Join(kept, "/"), nil
+}
+
+// WalkZip walks the zip archive of size bytes in r. Zip keeps its
+// directory at the end, so it needs random access to the archive.
+func WalkZip(r io.ReaderAt, size int64, limits Limits, fn WalkFunc) error {
+	if limits.MaxSize > 0 && size > limits.MaxSize {
+		return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, limits.MaxSize)
+	}
+
+	reader, err := zip.NewReader(r, size)
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return fmt.Errorf("%w: %s", ErrMalformed, err)
+	}
+
+	if limits.MaxEntries > 0 && len(reader.File) > limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d", ErrTooManyEntries, limits.MaxEntries)
+	}
+
+	// The declared sizes can lie, so they only allow an early refusal;
+	// the guard below counts what actually comes out.
+	var declared uint64
+	for _, file := range reader.File {
+		declared += file.UncompressedSize64
+	}
+	if limits.MaxExpandedSize > 0 && declared > uint64(limits.MaxExpandedSize) {
+		return fmt.Errorf("%w: more than %d bytes", ErrExpansion, limits.MaxExpandedSize)
+	}
+
+	guard := &budget{limits: limits, compressed: func() int64 { return size }}
+	for _, file := range reader.File {
+		mode := file.Mode()
+		if mode.IsDir() {
+			if _, err := CleanPath(file.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry, err := newEntry(file.Name, int64(file.UncompressedSize64), mode)
+		if err != nil {
+			return err
+		}
+		if entry.Skipped == "" && file.Flags&0x1 != 0 {
+			entry.Skipped = "encrypted entries are not supported"
+		}
+
+		if err := guard.visit(entry, func() (io.ReadCloser, error) { return file.Open() }, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WalkTar walks the tar stream in r, gunzipping it first when gzipped.
+// Tar is read front to back, so the archive is never held in full.
+func WalkTar(r io.Reader, gzipped bool, limits Limits, fn WalkFunc) error {
+	counted := &countingReader{reader: r}
+	guard := &budget{limits: limits, compressed: func() int64 { return counted.read }}
+	counted.guard = guard
+
+	var source io.Reader = counted
+	if gzipped {
+		unzipped, err := gzip.NewReader(counted)
+		if err != nil {
+			return guard.fail(fmt.Errorf("%w: %s", ErrMalformed, err))
+		}
+		defer unzipped.Close()
+		source = unzipped
+	}
+
+	reader := tar.NewReader(source)
+	for entries := 1; ; entries++ {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && !errors.Is(err, tar.ErrInsecurePath) {
+			return guard.fail(fmt.Errorf("%w: %s", ErrMalformed, err))
+		}
+
+		if limits.MaxEntries > 0 && entries > limits.MaxEntries {
+			return fmt.Errorf("%w: more than %d", ErrTooManyEntries, limits.MaxEntries)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeDir:
+			if _, err := CleanPath(header.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		mode := header.FileInfo().Mode()
+		if header.Typeflag == tar.TypeLink {
+			mode |= os.ModeSymlink
+		}
+
+		entry, err := newEntry(header.Name, header.Size, mode)
+		if err != nil {
+			return err
+		}
+
+		body := io.NopCloser(reader)
+		if err := guard.visit(entry, func() (io.ReadCloser, error) { return body, nil }, fn); err != nil {
+			return err
+		}
+	}
+}
+
+func newEntry(name string, size int64, mode os.FileMode) (Entry, error) {
+	clean, err := CleanPath(name)
+	if err != nil {
+		return Entry{}, err
+	}
+	if clean == "" {
+		return Entry{}, fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+
+	entry := Entry{Path: clean, Size: size}
+	switch {
+	case mode&os.ModeSymlink != 0:
+		entry.Skipped = "links are not extracted"
+	case !mode.IsRegular():
+		entry.Skipped = "only regular files are extracted"
+	}
+
+	return entry, nil
+}
+
+// budget tracks how far an archive has expanded. Once a limit is hit
+// the error sticks, so it wins over whatever error fn made of it.
+type budget struct {
+	limits     Limits
+	compressed func() int64
+	expanded   int64
+	err        error
+}
+
+func (b *budget) visit(entry Entry, open func() (io.ReadCloser, error), fn WalkFunc) error {
+	if entry.Skipped != "" {
+		return fn(entry)
+	}
+
+	body, err := open()
+	if err != nil {
+		return b.fail(fmt.Errorf("%w: %s: %s", ErrMalformed, entry.Path, err))
+	}
+	defer body.Close()
+
+	entry.Body = &guardedReader{reader: body, guard: b}
+	err = fn(entry)
+	if b.err != nil {
+		return b.err
+	}
+
+	return err
+}
+
+func (b *budget) fail(err error) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	return err
+}
+
+func (b *budget) account(n int) error {
+	b.expanded += int64(n)
+
+	switch {
+	case b.err != nil:
+	case b.limits.MaxExpandedSize > 0 && b.expanded > b.limits.MaxExpandedSize:
+		b.err = fmt.Errorf("%w: more than %d bytes", ErrExpansion, b.limits.MaxExpandedSize)
+	case b.limits.MaxRatio > 0 && b.expanded > ratioAllowance && b.expanded > b.limits.MaxRatio*max(b.compressed(), 1):
+		b.err = fmt.Errorf("%w: more than %d times its size", ErrRatio, b.limits.MaxRatio)
+	}
+
+	return b.err
+}
+
+// guardedReader is an entry's body, counted against the budget.
+type guardedReader struct {
+	reader io.Reader
+	guard  *budget
+}
+
+func (g *guardedReader) Read(p []byte) (int, error) {
+	if g.guard.err != nil {
+		return 0, g.guard.err
+	}
+
+	n, err := g.reader.Read(p)
+	if limitErr := g.guard.account(n); limitErr != nil {
+		return n, limitErr
+	}
+
+	return n, err
+}
+
+// countingReader counts the archive bytes read so far, which is what
+// the expansion of a tar stream is measured against.
+type countingReader struct {
+	reader io.Reader
+	read   int64
+	guard  *budget
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.guard.err != nil {
+		return 0, c.guard.err
+	}
+
+	n, err := c.reader.Read(p)
+	c.read += int64(n)
+	if limit := c.guard.limits.MaxSize; limit > 0 && c.read > limit {
+		c.guard.err = fmt.Errorf("%w: more than %d bytes", ErrTooLarge, limit)
+		return n, c.guard.err
+	}
+
+	return n, err
+}
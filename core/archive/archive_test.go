@@ -0,0 +1,243 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testFile struct {
+	name string
+	body string
+	link bool
+}
+
+func buildZip(t *testing.T, files ...testFile) *bytes.Reader {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, file := range files {
+		header := &zip.FileHeader{Name: file.name, Method: zip.Deflate}
+		if file.link {
+			header.SetMode(0777 | os.ModeSymlink)
+		}
+		w, err := writer.CreateHeader(header)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(file.body))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+
+	return bytes.NewReader(buffer.Bytes())
+}
+
+func buildTar(t *testing.T, gzipped bool, files ...testFile) *bytes.Buffer {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	var target io.Writer = &buffer
+	var zipper *gzip.Writer
+	if gzipped {
+		zipper = gzip.NewWriter(&buffer)
+		target = zipper
+	}
+
+	writer := tar.NewWriter(target)
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.body)), Typeflag: tar.TypeReg}
+		if file.link {
+			header = &tar.Header{Name: file.name, Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}
+		}
+		assert.NoError(t, writer.WriteHeader(header))
+		_, err := writer.Write([]byte(file.body))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	if zipper != nil {
+		assert.NoError(t, zipper.Close())
+	}
+
+	return &buffer
+}
+
+// collect reads every entry's body into a map keyed by path.
+func collect(into map[string]string) WalkFunc {
+	return func(entry Entry) error {
+		if entry.Skipped != "" {
+			into[entry.Path] = "skipped: " + entry.Skipped
+			return nil
+		}
+
+		body, err := io.ReadAll(entry.Body)
+		into[entry.Path] = string(body)
+		return err
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for name, expected := range map[string]Format{
+		"site.zip":    Zip,
+		"site.ZIP":    Zip,
+		"site.tar":    Tar,
+		"site.tar.gz": TarGzip,
+		"site.tgz":    TarGzip,
+	} {
+		format, ok := FormatOf(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, expected, format, name)
+	}
+
+	_, ok := FormatOf("site.rar")
+	assert.False(t, ok)
+}
+
+func TestCleanPath(t *testing.T) {
+	for name, expected := range map[string]string{
+		"index.html":           "index.html",
+		"./css//site.css":      "css/site.css",
+		`img\logo.png`:         "img/logo.png",
+		"assets/./fonts/a.ttf": "assets/fonts/a.ttf",
+	} {
+		clean, err := CleanPath(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expected, clean, name)
+	}
+
+	for _, name := range []string{"../evil", "a/../../evil", `..\evil`, "/etc/passwd", `C:\evil`, "a/.."} {
+		_, err := CleanPath(name)
+		assert.ErrorIs(t, err, ErrUnsafePath, name)
+	}
+}
+
+func TestWalkZip(t *testing.T) {
+	t.Run("regular files, directories and links", func(t *testing.T) {
+		archive := buildZip(t,
+			testFile{name: "site/"},
+			testFile{name: "site/index.html", body: "<html></html>"},
+			testFile{name: `site\css\app.css`, body: "body{}"},
+			testFile{name: "site/current", body: "index.html", link: true},
+		)
+
+		entries := map[string]string{}
+		assert.NoError(t, WalkZip(archive, archive.Size(), Limits{}, collect(entries)))
+		assert.Equal(t, map[string]string{
+			"site/index.html":  "<html></html>",
+			"site/css/app.css": "body{}",
+			"site/current":     "skipped: links are not extracted",
+		}, entries)
+	})
+
+	t.Run("zip slip", func(t *testing.T) {
+		archive := buildZip(t, testFile{name: "ok.txt", body: "ok"}, testFile{name: "../../etc/cron.d/evil", body: "x"})
+
+		err := WalkZip(archive, archive.Size(), Limits{}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrUnsafePath)
+	})
+
+	t.Run("entry count", func(t *testing.T) {
+		archive := buildZip(t, testFile{name: "a"}, testFile{name: "b"}, testFile{name: "c"})
+
+		err := WalkZip(archive, archive.Size(), Limits{MaxEntries: 2}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrTooManyEntries)
+	})
+
+	t.Run("archive size", func(t *testing.T) {
+		archive := buildZip(t, testFile{name: "a", body: "hello"})
+
+		err := WalkZip(archive, archive.Size(), Limits{MaxSize: 10}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrTooLarge)
+	})
+
+	t.Run("expanded size", func(t *testing.T) {
+		archive := buildZip(t, testFile{name: "a", body: "hello"}, testFile{name: "b", body: "world"})
+
+		err := WalkZip(archive, archive.Size(), Limits{MaxExpandedSize: 8}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrExpansion)
+	})
+
+	t.Run("compression ratio", func(t *testing.T) {
+		bomb := string(bytes.Repeat([]byte{0}, 4<<20))
+		archive := buildZip(t, testFile{name: "zeros.bin", body: bomb})
+
+		err := WalkZip(archive, archive.Size(), Limits{MaxRatio: 100}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrRatio)
+	})
+
+	t.Run("limit wins over the callback's error", func(t *testing.T) {
+		archive := buildZip(t, testFile{name: "a", body: "hello world"})
+
+		err := WalkZip(archive, archive.Size(), Limits{MaxExpandedSize: 100}, func(entry Entry) error {
+			// Declared sizes pass; lie about the limit mid-read.
+			entry.Body.(*guardedReader).guard.limits.MaxExpandedSize = 4
+			_, err := io.ReadAll(entry.Body)
+			return errors.New("upload failed: " + err.Error())
+		})
+		assert.ErrorIs(t, err, ErrExpansion)
+	})
+
+	t.Run("not a zip", func(t *testing.T) {
+		archive := bytes.NewReader([]byte("definitely not a zip archive"))
+
+		err := WalkZip(archive, archive.Size(), Limits{}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrMalformed)
+	})
+}
+
+func TestWalkTar(t *testing.T) {
+	t.Run("plain and gzipped", func(t *testing.T) {
+		for _, gzipped := range []bool{false, true} {
+			archive := buildTar(t, gzipped,
+				testFile{name: "./docs/readme.md", body: "# docs"},
+				testFile{name: "docs/latest", link: true},
+			)
+
+			entries := map[string]string{}
+			assert.NoError(t, WalkTar(archive, gzipped, Limits{}, collect(entries)))
+			assert.Equal(t, map[string]string{
+				"docs/readme.md": "# docs",
+				"docs/latest":    "skipped: links are not extracted",
+			}, entries)
+		}
+	})
+
+	t.Run("tar slip", func(t *testing.T) {
+		archive := buildTar(t, false, testFile{name: "/etc/passwd", body: "root"})
+
+		err := WalkTar(archive, false, Limits{}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrUnsafePath)
+	})
+
+	t.Run("entry count", func(t *testing.T) {
+		archive := buildTar(t, true, testFile{name: "a"}, testFile{name: "b"}, testFile{name: "c"})
+
+		err := WalkTar(archive, true, Limits{MaxEntries: 2}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrTooManyEntries)
+	})
+
+	t.Run("archive size", func(t *testing.T) {
+		archive := buildTar(t, false, testFile{name: "a", body: "hello"})
+
+		err := WalkTar(archive, false, Limits{MaxSize: 100}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrTooLarge)
+	})
+
+	t.Run("compression ratio", func(t *testing.T) {
+		archive := buildTar(t, true, testFile{name: "zeros.bin", body: string(bytes.Repeat([]byte{0}, 4<<20))})
+
+		err := WalkTar(archive, true, Limits{MaxRatio: 100}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrRatio)
+	})
+
+	t.Run("not gzipped", func(t *testing.T) {
+		err := WalkTar(bytes.NewReader([]byte("plain text")), true, Limits{}, collect(map[string]string{}))
+		assert.ErrorIs(t, err, ErrMalformed)
+	})
+}
@@ -0,0 +1,128 @@
+// Package blurhash encodes images as BlurHash strings: a few dozen
+// characters that clients decode into a blurred placeholder while the
+// image itself loads. See https://blurha.sh for the format.
+package blurhash
+
+import (
+	"errors"
+	"image"
+	"math"
+	"strings"
+)
+
+// ErrComponents is returned for component counts outside 1-9.
+var ErrComponents = errors.New("blurhash: components must be between 1 and 9")
+
+const characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Encode returns the BlurHash of img with xComponents by yComponents
+// cosine components. Cost grows with the pixels times the components,
+// so img should be a thumbnail: 32 pixels a side is plenty. Alpha is
+// ignored; flatten transparent images onto a background first.
+func Encode(img image.Image, xComponents int, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", ErrComponents
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return "", errors.New("blurhash: empty image")
+	}
+
+	// The image in linear light, once, rather than per component.
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			linear[y*width+x] = [3]float64{toLinear(r >> 8), toLinear(g >> 8), toLinear(b >> 8)}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			factors = append(factors, factor(linear, width, height, i, j))
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximum := 1.0
+	if len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		hash.WriteString(encode83(quantised, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	hash.WriteString(encode83(toSRGB(dc[0])<<16+toSRGB(dc[1])<<8+toSRGB(dc[2]), 4))
+	for _, f := range ac {
+		hash.WriteString(encode83(quantiseAC(f[0], maximum)*19*19+quantiseAC(f[1], maximum)*19+quantiseAC(f[2], maximum), 2))
+	}
+
+	return hash.String(), nil
+}
+
+// factor is the (i, j) cosine component of the image.
+func factor(linear [][3]float64, width int, height int, i int, j int) [3]float64 {
+	var sum [3]float64
+	for y := 0; y < height; y++ {
+		vertical := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+		for x := 0; x < width; x++ {
+			basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) * vertical
+			pixel := linear[y*width+x]
+			sum[0] += basis * pixel[0]
+			sum[1] += basis * pixel[1]
+			sum[2] += basis * pixel[2]
+		}
+	}
+
+	normalisation := 2.0
+	if i == 0 && j == 0 {
+		normalisation = 1
+	}
+	scale := normalisation / float64(width*height)
+	return [3]float64{sum[0] * scale, sum[1] * scale, sum[2] * scale}
+}
+
+func quantiseAC(value float64, maximum float64) int {
+	return int(math.Max(0, math.Min(18, math.Floor(signedPow(value/maximum, 0.5)*9+9.5))))
+}
+
+func signedPow(value float64, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
+
+func toLinear(value uint32) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func toSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// encode83 writes value as length base-83 digits.
+func encode83(value int, length int) string {
+	digits := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		digits[i] = characters[value%83]
+		value /= 83
+	}
+	return string(digits)
+}
@@ -0,0 +1,48 @@
+package blurhash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 12, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 12; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 20), G: uint8(y * 30), B: uint8(x * y * 7), A: 255})
+		}
+	}
+
+	for components, expected := range map[[2]int]string{
+		{4, 3}: "LiFP4:2+sPt2u-R*jxjKf2fUfTfN",
+		{1, 1}: "00FP4:",
+	} {
+		hash, err := Encode(img, components[0], components[1])
+		assert.NoError(t, err)
+		assert.Equal(t, expected, hash, components)
+	}
+
+	hash, err := Encode(img, 9, 9)
+	assert.NoError(t, err)
+	assert.Len(t, hash, 4+2*9*9)
+
+	_, err = Encode(img, 0, 3)
+	assert.ErrorIs(t, err, ErrComponents)
+	_, err = Encode(image.NewRGBA(image.Rect(0, 0, 0, 0)), 4, 3)
+	assert.Error(t, err)
+}
+
+func TestEncodeSolid(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{200, 100, 50, 255})
+	}
+
+	hash, err := Encode(img, 3, 3)
+	assert.NoError(t, err)
+	// The DC component is the average colour, back in sRGB.
+	assert.Equal(t, encode83(200<<16+100<<8+50, 4), hash[2:6])
+}
@@ -0,0 +1,201 @@
+// Package cachepolicy turns a bucket's cache rules into the caching
+// headers an object is served with, so Traefik, Cloudflare and
+// browsers in front of rb-cdn know what they may keep and for how
+// long.
+package cachepolicy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/sniff"
+)
+
+// ImmutableCacheControl is what content-hashed keys are cached with
+// when no rule matches them: their bytes can't change under the key.
+const ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+// ImmutableRule names the built-in content-hashed key policy.
+const ImmutableRule = "immutable_hashed"
+
+// hashedPattern finds a hex content hash of 16 to 64 characters
+// ending a file name, right before its extension, as bundlers and the
+// {hash} key placeholder write them: "app.3f9c2b1a5d41402a.js",
+// "logo-5d41402abc4b2a76.png". Shorter hex runs, {shortHash}'s twelve
+// characters included, are too often words, ids or versions; such
+// keys get immutable caching from a rule.
+var hashedPattern = regexp.MustCompile(`(?:^|[._-])([0-9a-f]{16,64})(?:\.[0-9a-z]+)?$`)
+
+// Hashed reports whether key's file name carries a content hash. Runs
+// of digits alone, dates mostly, don't count.
+func Hashed(key string) bool {
+	name := key[strings.LastIndex(key, "/")+1:]
+	match := hashedPattern.FindStringSubmatch(strings.ToLower(name))
+
+	return match != nil && strings.ContainsAny(match[1], "abcdef") && strings.ContainsAny(match[1], "0123456789")
+}
+
+// Match is the outcome of matching an object against the rules.
+type Match struct {
+	// Rule names what matched: the rule's name, "rules[i]" for an
+	// unnamed one, ImmutableRule, or "" when nothing did.
+	Rule string
+	// Index is the position of the matched rule, -1 when none did.
+	Index  int
+	policy entities.CacheRuleEntity
+}
+
+// Matched reports whether anything gave the object a policy.
+func (m Match) Matched() bool {
+	return m.Rule != ""
+}
+
+// Evaluate matches the object at key in bucket, of contentType,
+// against settings: the first matching rule, else the immutable
+// default for content-hashed keys. Settings are validated at load, so
+// globs can't fail to compile here.
+func Evaluate(settings entities.CacheSettingsEntity, bucket string, key string, contentType string) Match {
+	for index, rule := range settings.Rules {
+		if !matchGlobs(rule.Buckets, bucket) || !matchGlobs(rule.Keys, key) {
+			continue
+		}
+		if len(rule.ContentTypes) > 0 && !sniff.MatchType(contentType, rule.ContentTypes) {
+			continue
+		}
+
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rules[%d]", index)
+		}
+		return Match{Rule: name, Index: index, policy: rule}
+	}
+
+	if settings.ImmutableHashed && Hashed(key) {
+		return Match{
+			Rule:   ImmutableRule,
+			Index:  -1,
+			policy: entities.CacheRuleEntity{CacheControl: ImmutableCacheControl},
+		}
+	}
+
+	return Match{Index: -1}
+}
+
+// Headers returns the headers m gives a response sent at now.
+func (m Match) Headers(now time.Time) http.Header {
+	header := http.Header{}
+	for name, value := range map[string]string{
+		"Cache-Control":     m.policy.CacheControl,
+		"Surrogate-Control": m.policy.SurrogateControl,
+		"CDN-Cache-Control": m.policy.CDNCacheControl,
+		"Vary":              strings.Join(m.policy.Vary, ", "),
+	} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	if expires := m.policy.Expires.Duration(); expires > 0 {
+		header.Set("Expires", now.Add(expires).UTC().Format(http.TimeFormat))
+	}
+
+	return header
+}
+
+// Apply sets m's headers on header. A Cache-Control already there, the
+// one set on the object at upload time, is kept.
+func (m Match) Apply(header http.Header, now time.Time) {
+	for name, values := range m.Headers(now) {
+		if name == "Cache-Control" && header.Get(name) != "" {
+			continue
+		}
+		header[name] = values
+	}
+}
+
+// Validate checks the globs and header values of settings.
+func Validate(settings entities.CacheSettingsEntity) error {
+	for index, rule := range settings.Rules {
+		for _, pattern := range append(append([]string{}, rule.Buckets...), rule.Keys...) {
+			if _, err := compileGlob(pattern); err != nil {
+				return fmt.Errorf("cache rules[%d]: %w", index, err)
+			}
+		}
+
+		for _, value := range append([]string{rule.CacheControl, rule.SurrogateControl, rule.CDNCacheControl}, rule.Vary...) {
+			if strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("cache rules[%d]: header values must be a single line", index)
+			}
+		}
+
+		if rule.Expires.Duration() < 0 {
+			return fmt.Errorf("cache rules[%d]: expires must not be negative", index)
+		}
+	}
+
+	return nil
+}
+
+// globs caches compiled patterns; rules are matched on every request.
+var globs sync.Map
+
+// matchGlobs reports whether value matches one of patterns; an empty
+// list matches anything.
+func matchGlobs(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if glob, err := compileGlob(pattern); err == nil && glob.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compileGlob turns pattern into an anchored regexp: "**" spans path
+// segments, "*" and "?" stay within one, anything else is literal.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	if glob, found := globs.Load(pattern); found {
+		return glob.(*regexp.Regexp), nil
+	}
+	if pattern == "" {
+		return nil, fmt.Errorf("empty glob")
+	}
+
+	var expression strings.Builder
+	expression.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			// "a/**/b" also matches "a/b".
+			expression.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expression.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expression.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expression.WriteString("[^/]")
+		default:
+			expression.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expression.WriteString("$")
+
+	glob, err := regexp.Compile(expression.String())
+	if err != nil {
+		return nil, fmt.Errorf("glob %q: %w", pattern, err)
+	}
+
+	globs.Store(pattern, glob)
+	return glob, nil
+}
@@ -0,0 +1,100 @@
+package cachepolicy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashed(t *testing.T) {
+	assert.True(t, Hashed("assets/app.3f9c2b1a5d41402a.js"))
+	assert.True(t, Hashed("logo-5d41402abc4b2a76b9719d91.png"))
+	assert.True(t, Hashed("2026/e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855.pdf"))
+	assert.True(t, Hashed("chunk_0a1b2c3d4e5f6a7b"), "no extension")
+	assert.False(t, Hashed("report-20261017123045000.pdf"), "digits alone aren't hashes")
+	assert.False(t, Hashed("deadbeefcafe12345678/logo.png"), "only the file name counts")
+	assert.False(t, Hashed("facade.js"))
+	assert.False(t, Hashed("app.3f9c2b1a.js"), "too short")
+	assert.False(t, Hashed("photo-e3b0c44298fc.jpg"), "too short")
+	assert.False(t, Hashed("build-3f9c2b1a5d41402a-notes.txt"), "not right before the extension")
+	assert.False(t, Hashed("app.3f9c2b1a5d41402a.js.map.old"), "not right before the extension")
+}
+
+func TestEvaluate(t *testing.T) {
+	settings := entities.CacheSettingsEntity{
+		ImmutableHashed: true,
+		Rules: []entities.CacheRuleEntity{
+			{Name: "private", Keys: []string{"private/**"}, CacheControl: "private, no-store"},
+			{Buckets: []string{"static-*"}, Keys: []string{"**/*.css", "*.css"}, CacheControl: "public, max-age=600"},
+			{Name: "images", ContentTypes: []string{"image/*"}, CacheControl: "public, max-age=86400"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		bucket      string
+		key         string
+		contentType string
+		rule        string
+		index       int
+	}{
+		{"first rule wins", "images", "private/avatar.png", "image/png", "private", 0},
+		{"bucket and key globs", "static-web", "themes/dark/site.css", "text/css", "rules[1]", 1},
+		{"** matches no folder", "static-web", "site.css", "text/css", "rules[1]", 1},
+		{"bucket glob misses", "images", "site.css", "text/css", "", -1},
+		{"content type family", "images", "photo.jpg", "image/jpeg; charset=binary", "images", 2},
+		{"hashed key default", "web", "app.3f9c2b1a5d41402a.js", "text/javascript", ImmutableRule, -1},
+		{"nothing", "web", "app.js", "text/javascript", "", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := Evaluate(settings, tt.bucket, tt.key, tt.contentType)
+			assert.Equal(t, tt.rule, match.Rule)
+			assert.Equal(t, tt.index, match.Index)
+			assert.Equal(t, tt.rule != "", match.Matched())
+		})
+	}
+
+	settings.ImmutableHashed = false
+	assert.False(t, Evaluate(settings, "web", "app.3f9c2b1a5d41402a.js", "text/javascript").Matched())
+}
+
+func TestHeaders(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	settings := entities.CacheSettingsEntity{Rules: []entities.CacheRuleEntity{{
+		CacheControl:     "public, max-age=60",
+		SurrogateControl: "max-age=3600",
+		CDNCacheControl:  "max-age=600",
+		Vary:             []string{"Accept", "Accept-Encoding"},
+		Expires:          types.Duration(time.Hour),
+	}}}
+	match := Evaluate(settings, "b", "k", "")
+
+	header := match.Headers(now)
+	assert.Equal(t, "public, max-age=60", header.Get("Cache-Control"))
+	assert.Equal(t, "max-age=3600", header.Get("Surrogate-Control"))
+	assert.Equal(t, "max-age=600", header.Get("CDN-Cache-Control"))
+	assert.Equal(t, "Accept, Accept-Encoding", header.Get("Vary"))
+	assert.Equal(t, "Sat, 17 Oct 2026 13:00:00 GMT", header.Get("Expires"))
+
+	t.Run("upload Cache-Control is kept", func(t *testing.T) {
+		response := http.Header{"Cache-Control": {"no-cache"}}
+		match.Apply(response, now)
+		assert.Equal(t, "no-cache", response.Get("Cache-Control"))
+		assert.Equal(t, "max-age=3600", response.Get("Surrogate-Control"))
+	})
+
+	assert.Empty(t, Evaluate(entities.CacheSettingsEntity{}, "b", "k", "").Headers(now))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(entities.CacheSettingsEntity{Rules: []entities.CacheRuleEntity{{Keys: []string{"a/**/[b].js"}}}}))
+	assert.Error(t, Validate(entities.CacheSettingsEntity{Rules: []entities.CacheRuleEntity{{Keys: []string{""}}}}))
+	assert.Error(t, Validate(entities.CacheSettingsEntity{Rules: []entities.CacheRuleEntity{{CacheControl: "max-age=1\r\nX-Evil: 1"}}}))
+	assert.Error(t, Validate(entities.CacheSettingsEntity{Rules: []entities.CacheRuleEntity{{Expires: types.Duration(-time.Second)}}}))
+}
@@ -0,0 +1,95 @@
+package cas
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// Checksums are the digests a client declared for an upload body.
+// Either may be nil when the client didn't send it.
+type Checksums struct {
+	MD5    []byte
+	SHA256 []byte
+}
+
+// ParseChecksums reads Content-MD5 (base64, RFC 1864) and
+// X-Checksum-SHA256 (hex or base64) from header.
+func ParseChecksums(header http.Header) (Checksums, error) {
+	checksums := Checksums{}
+
+	if value := header.Get("Content-MD5"); value != "" {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != md5.Size {
+			return checksums, fmt.Errorf("Content-MD5 must be the base64 of a 16-byte MD5 digest")
+		}
+		checksums.MD5 = sum
+	}
+
+	if value := header.Get("X-Checksum-SHA256"); value != "" {
+		sum, err := hex.DecodeString(value)
+		if err != nil {
+			sum, err = base64.StdEncoding.DecodeString(value)
+		}
+		if err != nil || len(sum) != sha256.Size {
+			return checksums, fmt.Errorf("X-Checksum-SHA256 must be a SHA-256 digest in hex or base64")
+		}
+		checksums.SHA256 = sum
+	}
+
+	return checksums, nil
+}
+
+func (c Checksums) Empty() bool {
+	return c.MD5 == nil && c.SHA256 == nil
+}
+
+// Verify compares the declared checksums against what was hashed.
+func (c Checksums) Verify(reader *HashingReader) error {
+	if c.MD5 != nil && !bytes.Equal(c.MD5, reader.MD5()) {
+		return fmt.Errorf("Content-MD5 mismatch: got %s", base64.StdEncoding.EncodeToString(reader.MD5()))
+	}
+
+	if c.SHA256 != nil && !bytes.Equal(c.SHA256, reader.SHA256()) {
+		return fmt.Errorf("X-Checksum-SHA256 mismatch: got %s", hex.EncodeToString(reader.SHA256()))
+	}
+
+	return nil
+}
+
+// HashingReader computes MD5 and SHA-256 of everything read through
+// it, so a body can be hashed on its single pass to MinIO.
+type HashingReader struct {
+	reader io.Reader
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+func NewHashingReader(reader io.Reader) *HashingReader {
+	hashing := &HashingReader{md5: md5.New(), sha256: sha256.New()}
+	hashing.reader = io.TeeReader(reader, io.MultiWriter(hashing.md5, hashing.sha256))
+	return hashing
+}
+
+func (r *HashingReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func (r *HashingReader) MD5() []byte {
+	return r.md5.Sum(nil)
+}
+
+func (r *HashingReader) SHA256() []byte {
+	return r.sha256.Sum(nil)
+}
+
+// Digest is the hex SHA-256 of what was read, the name blobs go by.
+func (r *HashingReader) Digest() string {
+	return hex.EncodeToString(r.SHA256())
+}
@@ -0,0 +1,66 @@
+package cas
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	helloMD5    = "XUFAKrxLKna5cZ2REBfFkg=="
+	helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+)
+
+func TestHashingReader(t *testing.T) {
+	reader := NewHashingReader(strings.NewReader("hello"))
+	body, err := io.ReadAll(reader)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, helloSHA256, reader.Digest())
+	assert.Equal(t, "sha256-LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=", SRI(reader.SHA256()))
+}
+
+func TestParseChecksums(t *testing.T) {
+	t.Run("no headers", func(t *testing.T) {
+		checksums, err := ParseChecksums(http.Header{})
+		assert.NoError(t, err)
+		assert.True(t, checksums.Empty())
+	})
+
+	t.Run("sha256 in hex and base64", func(t *testing.T) {
+		hexHeader := http.Header{"X-Checksum-Sha256": {helloSHA256}}
+		base64Header := http.Header{"X-Checksum-Sha256": {"LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="}}
+
+		fromHex, err := ParseChecksums(hexHeader)
+		assert.NoError(t, err)
+		fromBase64, err := ParseChecksums(base64Header)
+		assert.NoError(t, err)
+		assert.Equal(t, fromHex.SHA256, fromBase64.SHA256)
+	})
+
+	t.Run("malformed values", func(t *testing.T) {
+		_, err := ParseChecksums(http.Header{"Content-Md5": {"abc"}})
+		assert.Error(t, err)
+
+		_, err = ParseChecksums(http.Header{"X-Checksum-Sha256": {"abc"}})
+		assert.Error(t, err)
+	})
+}
+
+func TestChecksumsVerify(t *testing.T) {
+	reader := NewHashingReader(strings.NewReader("hello"))
+	_, _ = io.ReadAll(reader)
+
+	matching, _ := ParseChecksums(http.Header{"Content-Md5": {helloMD5}, "X-Checksum-Sha256": {helloSHA256}})
+	assert.NoError(t, matching.Verify(reader))
+
+	wrongMD5, _ := ParseChecksums(http.Header{"Content-Md5": {"AAAAAAAAAAAAAAAAAAAAAA=="}})
+	assert.ErrorContains(t, wrongMD5.Verify(reader), "Content-MD5 mismatch")
+
+	wrongSHA, _ := ParseChecksums(http.Header{"X-Checksum-Sha256": {testDigest}})
+	assert.ErrorContains(t, wrongSHA.Verify(reader), "X-Checksum-SHA256 mismatch")
+}
@@ -0,0 +1,95 @@
+// Package cas holds the object layout of content-addressed buckets.
+//
+// A content-addressed bucket stores every distinct body once, as a blob
+// named after its SHA-256 digest. The key a client uploaded to becomes
+// an empty pointer object whose metadata names the blob, and every
+// pointer registers a ref marker next to the blob so the blob can be
+// dropped when the last pointer to it goes away:
+//
+//	.cas/sha256/ab/ab12…ef           the blob
+//	.cas/refs/ab12…ef/<sha256(key)>  one marker per logical key
+//	photos/cat.jpg                   pointer, X-Amz-Meta-Cas-Digest: ab12…ef
+package cas
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/minio/minio-go"
+)
+
+// Prefix is the reserved area of a bucket the layout lives under.
+const Prefix = ".cas/"
+
+// DigestMetadata is the user metadata key a pointer object carries.
+const DigestMetadata = "Cas-Digest"
+
+func BlobKey(digest string) string {
+	return fmt.Sprintf("%ssha256/%s/%s", Prefix, digest[:2], digest)
+}
+
+func RefPrefix(digest string) string {
+	return fmt.Sprintf("%srefs/%s/", Prefix, digest)
+}
+
+// RefKey is the marker logicalKey holds on digest. The key is hashed
+// so markers stay flat whatever the logical key looks like.
+func RefKey(digest string, logicalKey string) string {
+	sum := sha256.Sum256([]byte(logicalKey))
+	return RefPrefix(digest) + hex.EncodeToString(sum[:])
+}
+
+// StagingKey is where a body is written while its digest is still
+// being computed.
+func StagingKey(id string) string {
+	return fmt.Sprintf("%sstaging/%s", Prefix, id)
+}
+
+// SRI formats a SHA-256 sum as a Subresource Integrity string.
+func SRI(sum []byte) string {
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// PointerDigest returns the blob digest a pointer object's metadata
+// names, if it is one.
+func PointerDigest(metadata http.Header) (string, bool) {
+	digest := metadata.Get("X-Amz-Meta-" + DigestMetadata)
+	if !isDigest(digest) {
+		return "", false
+	}
+
+	return digest, true
+}
+
+func isDigest(value string) bool {
+	if len(value) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// ObjectStater is the part of the MinIO service Resolve needs.
+type ObjectStater interface {
+	GetObjectInfo(bucket string, objectName string) (*minio.ObjectInfo, *errors.AppError)
+}
+
+// Resolve maps a logical key to the object holding its bytes: the
+// blob for a pointer, the key itself for anything else. Stat failures
+// fall back to the key so the caller's own fetch reports them.
+func Resolve(stater ObjectStater, bucket string, objectName string) string {
+	info, appErr := stater.GetObjectInfo(bucket, objectName)
+	if appErr != nil {
+		return objectName
+	}
+
+	if digest, found := PointerDigest(info.Metadata); found {
+		return BlobKey(digest)
+	}
+
+	return objectName
+}
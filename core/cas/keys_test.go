@@ -0,0 +1,57 @@
+package cas
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDigest = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestKeys(t *testing.T) {
+	assert.Equal(t, ".cas/sha256/e3/"+testDigest, BlobKey(testDigest))
+	assert.Equal(t, ".cas/refs/"+testDigest+"/", RefPrefix(testDigest))
+	assert.True(t, strings.HasPrefix(RefKey(testDigest, "photos/cat.jpg"), RefPrefix(testDigest)))
+	assert.NotEqual(t, RefKey(testDigest, "a"), RefKey(testDigest, "b"))
+	assert.Equal(t, ".cas/staging/abc", StagingKey("abc"))
+}
+
+func TestPointerDigest(t *testing.T) {
+	metadata := http.Header{}
+	_, found := PointerDigest(metadata)
+	assert.False(t, found)
+
+	metadata.Set("X-Amz-Meta-Cas-Digest", "not-a-digest")
+	_, found = PointerDigest(metadata)
+	assert.False(t, found)
+
+	metadata.Set("X-Amz-Meta-Cas-Digest", testDigest)
+	digest, found := PointerDigest(metadata)
+	assert.True(t, found)
+	assert.Equal(t, testDigest, digest)
+}
+
+type fakeStater map[string]*minio.ObjectInfo
+
+func (f fakeStater) GetObjectInfo(bucket string, objectName string) (*minio.ObjectInfo, *errors.AppError) {
+	info, found := f[objectName]
+	if !found {
+		return nil, errors.ServiceError("The specified key does not exist.")
+	}
+	return info, nil
+}
+
+func TestResolve(t *testing.T) {
+	stater := fakeStater{
+		"pointer": {Metadata: http.Header{"X-Amz-Meta-Cas-Digest": {testDigest}}},
+		"plain":   {Metadata: http.Header{}},
+	}
+
+	assert.Equal(t, BlobKey(testDigest), Resolve(stater, "bucket", "pointer"))
+	assert.Equal(t, "plain", Resolve(stater, "bucket", "plain"))
+	assert.Equal(t, "missing", Resolve(stater, "bucket", "missing"))
+}
@@ -0,0 +1,146 @@
+// Package clamd scans streams with a clamd-compatible daemon using
+// the INSTREAM command, over TCP or a unix socket.
+package clamd
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ChunkSize is how much of the stream goes into one INSTREAM chunk.
+const ChunkSize = 64 * 1024
+
+// QuarantinePrefix is where infected uploads are held in buckets
+// that quarantine rather than reject them. Nothing under it is served.
+const QuarantinePrefix = ".quarantine/"
+
+// Verdicts are recorded on stored objects as user metadata.
+const (
+	StatusMetadata    = "Scan-Status"
+	SignatureMetadata = "Scan-Signature"
+)
+
+var Status = struct {
+	Clean     string
+	Infected  string
+	Unscanned string
+}{
+	Clean:     "clean",
+	Infected:  "infected",
+	Unscanned: "unscanned",
+}
+
+// ErrSizeLimit is returned when the stream is longer than the daemon's
+// StreamMaxLength; the content was not fully scanned.
+var ErrSizeLimit = stdErrors.New("stream exceeds clamd's StreamMaxLength")
+
+// Result is the daemon's verdict on a stream.
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Client talks to one daemon. Each scan opens its own connection, so
+// a Client is safe for concurrent use.
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClient accepts "unix:///path/to/clamd.sock", "tcp://host:port" or
+// a bare "host:port". timeout bounds each scan as a whole.
+func NewClient(address string, timeout time.Duration) *Client {
+	if path, found := strings.CutPrefix(address, "unix://"); found {
+		return &Client{network: "unix", address: path, timeout: timeout}
+	}
+
+	return &Client{network: "tcp", address: strings.TrimPrefix(address, "tcp://"), timeout: timeout}
+}
+
+// Scan streams reader to the daemon and returns its verdict. Scan
+// stops reading reader as soon as the daemon stops listening, for
+// example once StreamMaxLength is reached.
+func (c *Client) Scan(ctx context.Context, reader io.Reader) (Result, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return Result{}, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// The daemon answers early (and closes) when it gives up on the
+	// stream; a failed write is only reported if no reply explains it.
+	writeErr := writeStream(conn, reader)
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		if writeErr != nil {
+			return Result{}, fmt.Errorf("send to clamd: %w", writeErr)
+		}
+		return Result{}, fmt.Errorf("read clamd reply: %w", err)
+	}
+
+	return parseReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// writeStream sends the INSTREAM command, reader as length-prefixed
+// chunks and the zero-length chunk that ends the stream.
+func writeStream(conn net.Conn, reader io.Reader) error {
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+
+	buffer := make([]byte, 4+ChunkSize)
+	for {
+		n, err := io.ReadFull(reader, buffer[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buffer[:4], uint32(n))
+			if _, err := conn.Write(buffer[:4+n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+// parseReply reads "stream: OK", "stream: <signature> FOUND" or
+// "<message> ERROR".
+func parseReply(reply string) (Result, error) {
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return Result{Infected: true, Signature: signature}, nil
+	case strings.Contains(reply, "size limit exceeded"):
+		return Result{}, ErrSizeLimit
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
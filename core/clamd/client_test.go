@@ -0,0 +1,125 @@
+package clamd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd answers INSTREAM like clamd: it flags streams containing
+// the EICAR test string and gives up on streams beyond maxLength.
+func fakeClamd(t *testing.T, network string, address string, maxLength int) string {
+	t.Helper()
+
+	listener, err := net.Listen(network, address)
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveInstream(conn, maxLength)
+		}
+	}()
+
+	if network == "unix" {
+		return "unix://" + address
+	}
+	return "tcp://" + listener.Addr().String()
+}
+
+func serveInstream(conn net.Conn, maxLength int) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	if command, err := reader.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var stream bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&stream, reader, int64(size)); err != nil {
+			return
+		}
+		if stream.Len() > maxLength {
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			// Closing with unread input resets the connection; let the
+			// client finish writing first.
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			io.Copy(io.Discard, reader)
+			return
+		}
+	}
+
+	if strings.Contains(stream.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+		conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		return
+	}
+	conn.Write([]byte("stream: OK\x00"))
+}
+
+func TestScan(t *testing.T) {
+	client := NewClient(fakeClamd(t, "tcp", "127.0.0.1:0", 1<<20), time.Second)
+
+	result, err := client.Scan(context.Background(), strings.NewReader(strings.Repeat("clean ", 30000)))
+	assert.NoError(t, err)
+	assert.False(t, result.Infected)
+
+	result, err = client.Scan(context.Background(), strings.NewReader("prefix "+eicar))
+	assert.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Equal(t, "Eicar-Test-Signature", result.Signature)
+}
+
+func TestScanOverUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	client := NewClient(fakeClamd(t, "unix", socket, 1<<20), time.Second)
+
+	result, err := client.Scan(context.Background(), strings.NewReader(eicar))
+	assert.NoError(t, err)
+	assert.True(t, result.Infected)
+}
+
+func TestScanSizeLimit(t *testing.T) {
+	client := NewClient(fakeClamd(t, "tcp", "127.0.0.1:0", ChunkSize), time.Second)
+
+	_, err := client.Scan(context.Background(), bytes.NewReader(make([]byte, 4*ChunkSize)))
+	assert.ErrorIs(t, err, ErrSizeLimit)
+}
+
+func TestScanUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	_, err = NewClient(address, time.Second).Scan(context.Background(), strings.NewReader("x"))
+	assert.ErrorContains(t, err, "connect to clamd")
+}
+
+func TestParseReply(t *testing.T) {
+	_, err := parseReply("Can't allocate memory ERROR")
+	assert.ErrorContains(t, err, "Can't allocate memory")
+}
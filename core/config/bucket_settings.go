@@ -0,0 +1,328 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/cachepolicy"
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/RodolfoBonis/rb-cdn/core/types"
+)
+
+// bucketSettingsDocument is the shape of BUCKET_SETTINGS_FILE:
+//
+//	{
+//	  "default": { "delivery": { "mode": "proxy" } },
+//	  "buckets": { "videos": { "delivery": { "mode": "redirect" } } }
+//	}
+//
+// Sections are kept raw so each bucket can be decoded on top of the
+// default: fields a bucket doesn't mention inherit the default.
+type bucketSettingsDocument struct {
+	Default json.RawMessage            `json:"default"`
+	Buckets map[string]json.RawMessage `json:"buckets"`
+}
+
+var (
+	bucketSettingsMu       sync.RWMutex
+	bucketSettingsLoaded   bool
+	bucketSettingsDoc      bucketSettingsDocument
+	bucketSettingsResolved map[string]entities.BucketSettingsEntity
+)
+
+func EnvBucketSettingsFile() string {
+	return GetEnv("BUCKET_SETTINGS_FILE", "")
+}
+
+// LoadBucketSettings (re)reads BUCKET_SETTINGS_FILE. It runs at boot so
+// a malformed file stops the deploy instead of silently falling back
+// to defaults; a missing env var simply means "defaults everywhere".
+func LoadBucketSettings() error {
+	document := bucketSettingsDocument{}
+
+	if filename := EnvBucketSettingsFile(); filename != "" {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("bucket settings: %w", err)
+		}
+		if err := json.Unmarshal(content, &document); err != nil {
+			return fmt.Errorf("bucket settings: %s: %w", filename, err)
+		}
+	}
+
+	// Validate the default section on its own too, so a bad default
+	// fails the boot even when no bucket overrides it.
+	if _, err := resolveBucketSettings(document, ""); err != nil {
+		return err
+	}
+
+	resolved := map[string]entities.BucketSettingsEntity{}
+	for bucket := range document.Buckets {
+		settings, err := resolveBucketSettings(document, bucket)
+		if err != nil {
+			return err
+		}
+		resolved[bucket] = settings
+	}
+
+	bucketSettingsMu.Lock()
+	defer bucketSettingsMu.Unlock()
+	bucketSettingsDoc = document
+	bucketSettingsResolved = resolved
+	bucketSettingsLoaded = true
+	return nil
+}
+
+// BucketSettings returns the effective settings for bucket: built-in
+// defaults, overlaid with the file's "default" section, overlaid with
+// the bucket's own section.
+func BucketSettings(bucket string) entities.BucketSettingsEntity {
+	bucketSettingsMu.RLock()
+	loaded := bucketSettingsLoaded
+	settings, found := bucketSettingsResolved[bucket]
+	document := bucketSettingsDoc
+	bucketSettingsMu.RUnlock()
+
+	if !loaded {
+		if err := LoadBucketSettings(); err != nil {
+			panic(err)
+		}
+		return BucketSettings(bucket)
+	}
+
+	if found {
+		return settings
+	}
+
+	// Buckets without their own section share the default; it was
+	// validated at load time, so decoding can't fail here.
+	settings, _ = resolveBucketSettings(document, bucket)
+	return settings
+}
+
+// EncryptionEnabled reports whether the default section or any bucket
+// turns encryption on, in which case the keyring must hold a key.
+func EncryptionEnabled() bool {
+	if BucketSettings("").Encryption.Mode != entities.EncryptionMode.None {
+		return true
+	}
+
+	bucketSettingsMu.RLock()
+	defer bucketSettingsMu.RUnlock()
+
+	for _, settings := range bucketSettingsResolved {
+		if settings.Encryption.Mode != entities.EncryptionMode.None {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MaxUploadSize is the largest file any bucket accepts: the largest
+// upload.max_size, or EnvUploadSpoolMaxSize when a bucket, or the
+// default section, sets none. It bounds what is read before the bucket
+// of an upload is known.
+func MaxUploadSize() int64 {
+	largest := BucketSettings("").Upload.MaxSize
+	if largest == 0 {
+		return EnvUploadSpoolMaxSize()
+	}
+
+	bucketSettingsMu.RLock()
+	defer bucketSettingsMu.RUnlock()
+
+	for _, settings := range bucketSettingsResolved {
+		if settings.Upload.MaxSize == 0 {
+			return EnvUploadSpoolMaxSize()
+		}
+		largest = max(largest, settings.Upload.MaxSize)
+	}
+
+	return largest
+}
+
+func resolveBucketSettings(document bucketSettingsDocument, bucket string) (entities.BucketSettingsEntity, error) {
+	settings := defaultBucketSettings()
+
+	for _, section := range []json.RawMessage{document.Default, document.Buckets[bucket]} {
+		if len(section) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(section, &settings); err != nil {
+			return settings, fmt.Errorf("bucket settings: %q: %w", bucket, err)
+		}
+	}
+
+	if err := validateBucketSettings(settings); err != nil {
+		return settings, fmt.Errorf("bucket settings: %q: %w", bucket, err)
+	}
+
+	// A presigned URL would hand out ciphertext, or nothing at all
+	// under SSE-C: encrypted buckets can only be proxied.
+	if settings.Encryption.Mode != entities.EncryptionMode.None {
+		settings.Delivery.Mode = entities.DeliveryMode.ProxyOnly
+	}
+
+	return settings, nil
+}
+
+func defaultBucketSettings() entities.BucketSettingsEntity {
+	return entities.BucketSettingsEntity{
+		Delivery: entities.DeliverySettingsEntity{
+			Mode:           entities.DeliveryMode.Proxy,
+			RedirectStatus: http.StatusTemporaryRedirect,
+			RedirectExpiry: types.Duration(5 * time.Minute),
+			Charset:        "utf-8",
+		},
+		Keys: entities.KeySettingsEntity{
+			Template:  keys.DefaultTemplate,
+			Collision: entities.KeyCollision.Overwrite,
+		},
+		Scan: entities.ScanSettingsEntity{
+			OnInfected: entities.ScanAction.Reject,
+			OnError:    entities.ScanAction.Reject,
+		},
+		Encryption: entities.EncryptionSettingsEntity{
+			Mode: entities.EncryptionMode.None,
+		},
+		Cache: entities.CacheSettingsEntity{
+			ImmutableHashed: true,
+		},
+		Images: entities.ImageSettingsEntity{
+			Duplicates: entities.ImageDuplicateSettingsEntity{
+				Distance: 5,
+			},
+		},
+	}
+}
+
+func validateBucketSettings(settings entities.BucketSettingsEntity) error {
+	switch settings.Delivery.Mode {
+	case entities.DeliveryMode.Proxy, entities.DeliveryMode.Redirect, entities.DeliveryMode.ProxyOnly:
+	default:
+		return fmt.Errorf("unknown delivery mode %q", settings.Delivery.Mode)
+	}
+
+	if settings.Delivery.RedirectStatus != http.StatusFound && settings.Delivery.RedirectStatus != http.StatusTemporaryRedirect {
+		return fmt.Errorf("delivery redirect_status must be 302 or 307")
+	}
+
+	if expiry := settings.Delivery.RedirectExpiry.Duration(); expiry < time.Second || expiry > 7*24*time.Hour {
+		return fmt.Errorf("delivery redirect_expiry must be between 1s and 7 days")
+	}
+
+	for extension, contentType := range settings.Delivery.ContentTypes {
+		if strings.Trim(extension, ". ") == "" {
+			return fmt.Errorf("delivery content_types has an empty extension")
+		}
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("delivery content_types %q: %w", extension, err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, format := range settings.Delivery.NegotiateFormats {
+		if format != "avif" && format != "webp" {
+			return fmt.Errorf("delivery negotiate_formats: unknown format %q", format)
+		}
+		if seen[format] {
+			return fmt.Errorf("delivery negotiate_formats: %q is listed twice", format)
+		}
+		seen[format] = true
+	}
+
+	// imaging.MaxDistance: hashes are indexed so that lookups find
+	// every image fewer than 8 bits away.
+	if distance := settings.Images.Duplicates.Distance; distance < 0 || distance > 7 {
+		return fmt.Errorf("images duplicates distance must be between 0 and 7")
+	}
+
+	for name, preset := range settings.Images.Presets {
+		if err := validateImagePreset(name, preset); err != nil {
+			return err
+		}
+	}
+
+	if upload := settings.Upload; upload.MinSize < 0 || upload.MaxSize < 0 || upload.MaxFilesPerFolder < 0 {
+		return fmt.Errorf("upload min_size, max_size and max_files_per_folder must not be negative")
+	}
+
+	if upload := settings.Upload; upload.MaxSize != 0 && upload.MaxSize < upload.MinSize {
+		return fmt.Errorf("upload max_size must not be below min_size")
+	}
+
+	if _, err := keys.Parse(settings.Keys.Template); err != nil {
+		return err
+	}
+
+	switch settings.Keys.Collision {
+	case entities.KeyCollision.Overwrite, entities.KeyCollision.Rename, entities.KeyCollision.Reject:
+	default:
+		return fmt.Errorf("unknown keys collision policy %q", settings.Keys.Collision)
+	}
+
+	switch settings.Scan.OnInfected {
+	case entities.ScanAction.Reject, entities.ScanAction.Quarantine:
+	default:
+		return fmt.Errorf("unknown scan on_infected action %q", settings.Scan.OnInfected)
+	}
+
+	switch settings.Scan.OnError {
+	case entities.ScanAction.Reject, entities.ScanAction.Quarantine, entities.ScanAction.Publish:
+	default:
+		return fmt.Errorf("unknown scan on_error action %q", settings.Scan.OnError)
+	}
+
+	switch settings.Encryption.Mode {
+	case entities.EncryptionMode.None, entities.EncryptionMode.SSEC, entities.EncryptionMode.Envelope:
+	default:
+		return fmt.Errorf("unknown encryption mode %q", settings.Encryption.Mode)
+	}
+
+	if err := cachepolicy.Validate(settings.Cache); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// presetName keeps preset names usable as a key suffix ("a.jpg@card").
+var presetName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+func validateImagePreset(name string, preset entities.ImagePresetEntity) error {
+	if !presetName.MatchString(name) {
+		return fmt.Errorf("images presets: %q must be up to 32 lowercase letters, digits, - and _", name)
+	}
+
+	maxDimension := EnvImageMaxDimension()
+	if preset.Width < 0 || preset.Height < 0 || preset.Width > maxDimension || preset.Height > maxDimension {
+		return fmt.Errorf("images presets %q: width and height must be between 0 and %d", name, maxDimension)
+	}
+
+	switch preset.Fit {
+	case "", "contain", "cover", "fill":
+	default:
+		return fmt.Errorf("images presets %q: fit must be contain, cover or fill", name)
+	}
+
+	switch preset.Format {
+	case "", "jpeg", "png", "gif", "webp":
+	default:
+		return fmt.Errorf("images presets %q: format must be jpeg, png, gif or webp", name)
+	}
+
+	if preset.Quality < 0 || preset.Quality > 100 {
+		return fmt.Errorf("images presets %q: quality must be between 0 and 100", name)
+	}
+
+	return nil
+}
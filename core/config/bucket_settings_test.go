@@ -0,0 +1,231 @@
+package config
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func withBucketSettingsFile(t *testing.T, content string) {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "buckets.json")
+	assert.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+
+	os.Setenv("BUCKET_SETTINGS_FILE", filename)
+	t.Cleanup(func() {
+		os.Unsetenv("BUCKET_SETTINGS_FILE")
+		_ = LoadBucketSettings()
+	})
+}
+
+func TestBucketSettings(t *testing.T) {
+	t.Run("built-in defaults without a file", func(t *testing.T) {
+		os.Unsetenv("BUCKET_SETTINGS_FILE")
+		assert.NoError(t, LoadBucketSettings())
+
+		settings := BucketSettings("any")
+		assert.Equal(t, entities.DeliveryMode.Proxy, settings.Delivery.Mode)
+		assert.Equal(t, http.StatusTemporaryRedirect, settings.Delivery.RedirectStatus)
+		assert.Equal(t, 5*time.Minute, settings.Delivery.RedirectExpiry.Duration())
+	})
+
+	t.Run("bucket section overlays the default section", func(t *testing.T) {
+		withBucketSettingsFile(t, `{
+			"default": {"delivery": {"redirect_expiry": "2m"}},
+			"buckets": {"videos": {"delivery": {"mode": "redirect", "redirect_status": 302}}}
+		}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		videos := BucketSettings("videos")
+		assert.Equal(t, entities.DeliveryMode.Redirect, videos.Delivery.Mode)
+		assert.Equal(t, http.StatusFound, videos.Delivery.RedirectStatus)
+		assert.Equal(t, 2*time.Minute, videos.Delivery.RedirectExpiry.Duration())
+
+		other := BucketSettings("images")
+		assert.Equal(t, entities.DeliveryMode.Proxy, other.Delivery.Mode)
+		assert.Equal(t, 2*time.Minute, other.Delivery.RedirectExpiry.Duration())
+	})
+
+	t.Run("storage section is per bucket", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"buckets": {"assets": {"storage": {"content_addressed": true}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		assert.True(t, BucketSettings("assets").Storage.ContentAddressed)
+		assert.False(t, BucketSettings("images").Storage.ContentAddressed)
+	})
+
+	t.Run("upload policy", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"buckets": {"avatars": {"upload": {"allowed_types": ["image/*"], "max_size": 1048576}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		avatars := BucketSettings("avatars").Upload
+		assert.Equal(t, []string{"image/*"}, avatars.AllowedTypes)
+		assert.Equal(t, int64(1048576), avatars.MaxSize)
+		assert.Empty(t, BucketSettings("images").Upload.AllowedTypes)
+
+		withBucketSettingsFile(t, `{"default": {"upload": {"min_size": 10, "max_size": 5}}}`)
+		assert.Error(t, LoadBucketSettings())
+	})
+
+	t.Run("key settings", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"buckets": {"uploads": {"keys": {"template": "{yyyy}/{uuid}{ext}", "collision": "reject"}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		assert.Equal(t, entities.KeyCollision.Reject, BucketSettings("uploads").Keys.Collision)
+		assert.Equal(t, "{folder}/{filename}", BucketSettings("images").Keys.Template)
+
+		withBucketSettingsFile(t, `{"default": {"keys": {"template": "{nope}"}}}`)
+		assert.Error(t, LoadBucketSettings())
+
+		withBucketSettingsFile(t, `{"default": {"keys": {"collision": "merge"}}}`)
+		assert.Error(t, LoadBucketSettings())
+	})
+
+	t.Run("scan settings", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"buckets": {"shared": {"scan": {"enabled": true, "on_infected": "quarantine", "on_error": "publish"}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		shared := BucketSettings("shared").Scan
+		assert.True(t, shared.Enabled)
+		assert.Equal(t, entities.ScanAction.Quarantine, shared.OnInfected)
+		assert.Equal(t, entities.ScanAction.Publish, shared.OnError)
+		assert.False(t, BucketSettings("images").Scan.Enabled)
+		assert.Equal(t, entities.ScanAction.Reject, BucketSettings("images").Scan.OnError)
+
+		withBucketSettingsFile(t, `{"default": {"scan": {"on_infected": "publish"}}}`)
+		assert.Error(t, LoadBucketSettings())
+	})
+
+	t.Run("strip settings", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"buckets": {"photos": {"strip": {"enabled": true, "keep_record": true}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		assert.True(t, BucketSettings("photos").Strip.Enabled)
+		assert.True(t, BucketSettings("photos").Strip.KeepRecord)
+		assert.False(t, BucketSettings("videos").Strip.Enabled)
+	})
+
+	t.Run("largest upload", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"default": {"upload": {"max_size": 1000}}, "buckets": {"videos": {"upload": {"max_size": 5000}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+		assert.Equal(t, int64(5000), MaxUploadSize())
+
+		withBucketSettingsFile(t, `{"default": {"upload": {"max_size": 1000}}, "buckets": {"videos": {"upload": {"max_size": 0}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+		assert.Equal(t, EnvUploadSpoolMaxSize(), MaxUploadSize(), "a bucket without a limit")
+	})
+
+	t.Run("encryption settings", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"buckets": {"documents": {"encryption": {"mode": "envelope"}, "delivery": {"mode": "redirect"}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		documents := BucketSettings("documents")
+		assert.Equal(t, entities.EncryptionMode.Envelope, documents.Encryption.Mode)
+		assert.Equal(t, entities.DeliveryMode.ProxyOnly, documents.Delivery.Mode)
+		assert.Equal(t, entities.EncryptionMode.None, BucketSettings("images").Encryption.Mode)
+		assert.True(t, EncryptionEnabled())
+
+		withBucketSettingsFile(t, `{"buckets": {"images": {"strip": {"enabled": true}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+		assert.False(t, EncryptionEnabled())
+
+		withBucketSettingsFile(t, `{"default": {"encryption": {"mode": "rot13"}}}`)
+		assert.Error(t, LoadBucketSettings())
+	})
+
+	t.Run("content types", func(t *testing.T) {
+		withBucketSettingsFile(t, `{
+			"default": {"delivery": {"content_types": {".md": "text/plain"}}},
+			"buckets": {"docs": {"delivery": {"content_types": {"log": "text/plain"}, "charset": ""}}}
+		}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		docs := BucketSettings("docs").Delivery
+		assert.Equal(t, map[string]string{".md": "text/plain", "log": "text/plain"}, docs.ContentTypes)
+		assert.Empty(t, docs.Charset)
+		assert.Equal(t, map[string]string{".md": "text/plain"}, BucketSettings("images").Delivery.ContentTypes)
+		assert.Equal(t, "utf-8", BucketSettings("images").Delivery.Charset)
+
+		withBucketSettingsFile(t, `{"default": {"delivery": {"content_types": {".x": "not a type"}}}}`)
+		assert.Error(t, LoadBucketSettings())
+
+		withBucketSettingsFile(t, `{"default": {"delivery": {"content_types": {".": "text/plain"}}}}`)
+		assert.Error(t, LoadBucketSettings())
+	})
+
+	t.Run("format negotiation", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"buckets": {"photos": {"delivery": {"negotiate_formats": ["avif", "webp"]}}}}`)
+		assert.NoError(t, LoadBucketSettings())
+		assert.Equal(t, []string{"avif", "webp"}, BucketSettings("photos").Delivery.NegotiateFormats)
+		assert.Empty(t, BucketSettings("docs").Delivery.NegotiateFormats)
+
+		withBucketSettingsFile(t, `{"default": {"delivery": {"negotiate_formats": ["jxl"]}}}`)
+		assert.Error(t, LoadBucketSettings())
+
+		withBucketSettingsFile(t, `{"default": {"delivery": {"negotiate_formats": ["webp", "webp"]}}}`)
+		assert.Error(t, LoadBucketSettings())
+	})
+
+	t.Run("image presets", func(t *testing.T) {
+		withBucketSettingsFile(t, `{
+			"default": {"images": {"presets": {"thumb": {"width": 128, "height": 128, "fit": "cover"}}}},
+			"buckets": {"photos": {"images": {"presets": {"card-2x": {"width": 800, "format": "webp"}}, "analyze": true}}}
+		}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		assert.False(t, BucketSettings("docs").Images.Analyze)
+		assert.True(t, BucketSettings("photos").Images.Analyze)
+		assert.Equal(t, entities.ImageDuplicateSettingsEntity{Distance: 5}, BucketSettings("docs").Images.Duplicates)
+
+		presets := BucketSettings("photos").Images.Presets
+		assert.Len(t, presets, 2)
+		assert.Equal(t, entities.ImagePresetEntity{Width: 128, Height: 128, Fit: "cover"}, presets["thumb"])
+		assert.Len(t, BucketSettings("docs").Images.Presets, 1)
+
+		for _, preset := range []string{
+			`"Card": {"width": 10}`,
+			`"card": {"width": 100000}`,
+			`"card": {"width": 10, "fit": "stretch"}`,
+			`"card": {"width": 10, "format": "avif"}`,
+			`"card": {"width": 10, "quality": 101}`,
+		} {
+			withBucketSettingsFile(t, `{"default": {"images": {"presets": {`+preset+`}}}}`)
+			assert.Error(t, LoadBucketSettings(), preset)
+		}
+
+		withBucketSettingsFile(t, `{"default": {"images": {"duplicates": {"reject": true, "distance": 8}}}}`)
+		assert.Error(t, LoadBucketSettings())
+	})
+
+	t.Run("cache settings", func(t *testing.T) {
+		withBucketSettingsFile(t, `{
+			"default": {"cache": {"rules": [{"name": "assets", "buckets": ["static-*"], "keys": ["assets/**"], "cache_control": "public, max-age=600"}]}},
+			"buckets": {"uploads": {"cache": {"immutable_hashed": false}}}
+		}`)
+		assert.NoError(t, LoadBucketSettings())
+
+		assert.True(t, BucketSettings("images").Cache.ImmutableHashed)
+		assert.Len(t, BucketSettings("images").Cache.Rules, 1)
+		assert.False(t, BucketSettings("uploads").Cache.ImmutableHashed)
+		assert.Len(t, BucketSettings("uploads").Cache.Rules, 1)
+
+		withBucketSettingsFile(t, `{"default": {"cache": {"rules": [{"keys": [""]}]}}}`)
+		assert.Error(t, LoadBucketSettings())
+	})
+
+	t.Run("invalid settings fail the load", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"buckets": {"videos": {"delivery": {"mode": "teleport"}}}}`)
+		assert.Error(t, LoadBucketSettings())
+	})
+
+	t.Run("malformed file fails the load", func(t *testing.T) {
+		withBucketSettingsFile(t, `{"buckets": `)
+		assert.Error(t, LoadBucketSettings())
+	})
+}
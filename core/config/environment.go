@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"github.com/RodolfoBonis/rb-cdn/core/entities"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -18,6 +21,28 @@ func GetEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvInt64 parses key as a base-10 integer, falling back to
+// defaultValue when it is unset or malformed.
+func getEnvInt64(key string, defaultValue int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+// getEnvDuration parses key with time.ParseDuration ("90s", "24h"),
+// falling back to defaultValue when it is unset or malformed.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
 func EnvPort() string {
 	return GetEnv("PORT", "8000")
 }
@@ -38,6 +63,21 @@ func EnvMinioSecretKey() string {
 	return GetEnv("MINIO_SECRET_KEY", "")
 }
 
+// EnvMinioPublicHost is the MinIO endpoint as seen from outside the
+// cluster (e.g. "https://minio-api.example.com"). Presigned URLs are
+// signed against it, since SigV4 covers the Host header and a URL
+// signed for the in-cluster service name is useless to a browser.
+// Empty means "sign against MINIO_SERVER".
+func EnvMinioPublicHost() string {
+	return GetEnv("MINIO_PUBLIC_HOST", "")
+}
+
+// EnvMinioRegion pins the region used to sign presigned URLs so
+// presigning never needs a bucket-location round trip.
+func EnvMinioRegion() string {
+	return GetEnv("MINIO_REGION", "us-east-1")
+}
+
 func EnvSentryDSN() string {
 	return GetEnv("SENTRY_DSN", "")
 }
@@ -119,6 +159,260 @@ func EnvCDNPublicURL() string {
 	return GetEnv("CDN_PUBLIC_URL", "https://rb-cdn.rodolfodebonis.com.br/v1")
 }
 
+// EnvTusMaxSize caps the Upload-Length a tus client may declare, in
+// bytes. Advertised to clients through the Tus-Max-Size header.
+func EnvTusMaxSize() int64 {
+	return getEnvInt64("TUS_MAX_SIZE", 10*1024*1024*1024)
+}
+
+// EnvTusExpiration is how long an unfinished tus upload survives
+// without a PATCH before its chunks are garbage collected.
+func EnvTusExpiration() time.Duration {
+	return getEnvDuration("TUS_EXPIRATION", 24*time.Hour)
+}
+
+// EnvUploadPartSize is the size, in bytes, of each part a streamed
+// upload sends to MinIO. Every in-flight part holds one buffer of
+// this size, so memory per upload is bounded by
+// EnvUploadPartSize() * (EnvUploadParallelism() + 1). S3 rejects
+// parts below 5 MiB, which is enforced as the floor.
+func EnvUploadPartSize() int64 {
+	const minPartSize = 5 * 1024 * 1024
+
+	partSize := getEnvInt64("UPLOAD_PART_SIZE", 16*1024*1024)
+	if partSize < minPartSize {
+		return minPartSize
+	}
+
+	return partSize
+}
+
+// EnvUploadParallelism is how many parts of a single streamed upload
+// may be in flight to MinIO at once.
+func EnvUploadParallelism() int {
+	parallelism := getEnvInt64("UPLOAD_PARALLELISM", 4)
+	if parallelism < 1 {
+		return 1
+	}
+
+	return int(parallelism)
+}
+
+// EnvUploadSpoolMaxSize caps a file /upload buffers to disk because
+// the bucket field comes after it, in bytes, when some bucket sets no
+// upload.max_size (see MaxUploadSize).
+func EnvUploadSpoolMaxSize() int64 {
+	return getEnvInt64("UPLOAD_SPOOL_MAX_SIZE", 5*1024*1024*1024)
+}
+
+// EnvPresignDefaultExpiry is the lifetime of a presigned URL when the
+// caller doesn't ask for one.
+func EnvPresignDefaultExpiry() time.Duration {
+	return getEnvDuration("PRESIGN_DEFAULT_EXPIRY", 15*time.Minute)
+}
+
+// EnvPresignMaxExpiry is the longest lifetime a caller may request
+// for a presigned URL. S3 itself refuses anything above 7 days.
+func EnvPresignMaxExpiry() time.Duration {
+	return getEnvDuration("PRESIGN_MAX_EXPIRY", time.Hour)
+}
+
+// EnvPresignMaxUploadSize caps the size, in bytes, a presigned POST
+// policy will accept. Policies always carry a size range so a leaked
+// form can't be used to park arbitrary amounts of data in MinIO.
+func EnvPresignMaxUploadSize() int64 {
+	return getEnvInt64("PRESIGN_MAX_UPLOAD_SIZE", 5*1024*1024*1024)
+}
+
+// EnvBatchMaxFiles caps how many files a single batch upload may carry.
+func EnvBatchMaxFiles() int {
+	return int(getEnvInt64("BATCH_MAX_FILES", 100))
+}
+
+// EnvBatchMaxSize caps the files of one batch upload together, in
+// bytes. They are spooled to temporary files up to this size.
+func EnvBatchMaxSize() int64 {
+	return getEnvInt64("BATCH_MAX_SIZE", 5*1024*1024*1024)
+}
+
+// EnvBatchConcurrency is the number of files of one batch that are
+// written to MinIO at the same time.
+func EnvBatchConcurrency() int {
+	concurrency := getEnvInt64("BATCH_CONCURRENCY", 4)
+	if concurrency < 1 {
+		return 1
+	}
+
+	return int(concurrency)
+}
+
+// EnvFetchMaxSize caps the body of a remote URL imported through
+// /upload/fetch, in bytes.
+func EnvFetchMaxSize() int64 {
+	return getEnvInt64("FETCH_MAX_SIZE", 1024*1024*1024)
+}
+
+// EnvFetchTimeout bounds a whole remote import, body included.
+func EnvFetchTimeout() time.Duration {
+	return getEnvDuration("FETCH_TIMEOUT", 5*time.Minute)
+}
+
+func EnvFetchMaxRedirects() int {
+	return int(getEnvInt64("FETCH_MAX_REDIRECTS", 5))
+}
+
+// EnvFetchJobRetention is how long the state of an async import is
+// kept for polling.
+func EnvFetchJobRetention() time.Duration {
+	return getEnvDuration("FETCH_JOB_RETENTION", 24*time.Hour)
+}
+
+// EnvFetchAllowedCIDRs lists private networks remote imports may
+// reach anyway, comma separated (e.g. "10.20.0.0/16").
+func EnvFetchAllowedCIDRs() []string {
+	var cidrs []string
+	for _, value := range strings.Split(GetEnv("FETCH_ALLOWED_CIDRS", ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			cidrs = append(cidrs, value)
+		}
+	}
+
+	return cidrs
+}
+
+// EnvMimeTypes lists extra extension to media type mappings /cdn
+// resolves Content-Types with, comma separated (e.g.
+// ".glb=model/gltf-binary,.usdz=model/vnd.usdz+zip").
+func EnvMimeTypes() []string {
+	var mappings []string
+	for _, value := range strings.Split(GetEnv("MIME_TYPES", ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			mappings = append(mappings, value)
+		}
+	}
+
+	return mappings
+}
+
+// EnvClamdAddress is the clamd daemon uploads are scanned with:
+// "tcp://host:3310" or "unix:///path/to/clamd.sock". Empty disables
+// scanning; buckets that require it then apply their on_error action.
+func EnvClamdAddress() string {
+	return GetEnv("CLAMD_ADDRESS", "")
+}
+
+// EnvClamdTimeout bounds one scan, from connecting to the verdict.
+func EnvClamdTimeout() time.Duration {
+	return getEnvDuration("CLAMD_TIMEOUT", 2*time.Minute)
+}
+
+// EnvStripMaxSize is the largest image whose metadata is stripped;
+// stripping buffers the whole image, so buckets that strip refuse
+// larger ones.
+func EnvStripMaxSize() int64 {
+	return getEnvInt64("STRIP_MAX_SIZE", 64*1024*1024)
+}
+
+// EnvArchiveMaxSize caps an archive uploaded to /upload/archive, in
+// bytes. Zip archives are spooled to a temporary file up to this size.
+func EnvArchiveMaxSize() int64 {
+	return getEnvInt64("ARCHIVE_MAX_SIZE", 1024*1024*1024)
+}
+
+// EnvArchiveMaxEntries caps the entries of one archive, directories
+// included.
+func EnvArchiveMaxEntries() int {
+	return int(getEnvInt64("ARCHIVE_MAX_ENTRIES", 10000))
+}
+
+// EnvArchiveMaxExpandedSize caps the bytes an archive's files expand
+// to together.
+func EnvArchiveMaxExpandedSize() int64 {
+	return getEnvInt64("ARCHIVE_MAX_EXPANDED_SIZE", 4*1024*1024*1024)
+}
+
+// EnvArchiveMaxRatio caps how many times its own size an archive may
+// expand to; anything beyond is treated as a zip bomb.
+func EnvArchiveMaxRatio() int64 {
+	return getEnvInt64("ARCHIVE_MAX_RATIO", 100)
+}
+
+// EnvKeyringFile is the JSON file holding the master keys encrypted
+// buckets are sealed with (see package keyring). Unset means no keys,
+// which is only valid while no bucket enables encryption.
+func EnvKeyringFile() string {
+	return GetEnv("KEYRING_FILE", "")
+}
+
+// EnvEncryptionJobRetention is how long the state of a re-encryption
+// job is kept for polling.
+func EnvEncryptionJobRetention() time.Duration {
+	return getEnvDuration("ENCRYPTION_JOB_RETENTION", 7*24*time.Hour)
+}
+
+// EnvIdempotencyStore selects where Idempotency-Key responses are
+// kept: "memory" (per replica, the default) or "postgres", which
+// every replica shares through the DB_* connection.
+func EnvIdempotencyStore() string {
+	return GetEnv("IDEMPOTENCY_STORE", "memory")
+}
+
+// EnvIdempotencyTTL is how long a response is replayed for retries
+// carrying the same Idempotency-Key.
+func EnvIdempotencyTTL() time.Duration {
+	return getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+}
+
+// EnvImageMaxDimension caps the width and height an /img variant may
+// ask for, in pixels.
+func EnvImageMaxDimension() int {
+	return int(getEnvInt64("IMAGE_MAX_DIMENSION", 4096))
+}
+
+// EnvImageMaxSourceSize is the largest source image /img transforms,
+// in bytes; transforming buffers the whole source.
+func EnvImageMaxSourceSize() int64 {
+	return getEnvInt64("IMAGE_MAX_SOURCE_SIZE", 32*1024*1024)
+}
+
+// EnvImageMaxPixels is the most pixels a source image may have. A
+// decoded image takes 4 bytes per pixel, whatever its file size.
+func EnvImageMaxPixels() int64 {
+	return getEnvInt64("IMAGE_MAX_PIXELS", 40*1000*1000)
+}
+
+// EnvImageConcurrency is how many images are transformed at once;
+// further requests wait their turn.
+func EnvImageConcurrency() int {
+	concurrency := getEnvInt64("IMAGE_CONCURRENCY", 4)
+	if concurrency < 1 {
+		return 1
+	}
+
+	return int(concurrency)
+}
+
+// EnvImageSigningKey is the HMAC key /img parameters must be signed
+// with (see imaging.Sign). Empty accepts unsigned requests for the
+// bucket's presets only.
+func EnvImageSigningKey() string {
+	return GetEnv("IMAGE_SIGNING_KEY", "")
+}
+
+// EnvImageVariantRetention is how long a derived variant is kept
+// before it is purged and derived again on its next request. Zero
+// keeps variants until their source changes.
+func EnvImageVariantRetention() time.Duration {
+	return getEnvDuration("IMAGE_VARIANT_RETENTION", 30*24*time.Hour)
+}
+
+// EnvImageCatalog selects where image analyses are catalogued besides
+// the objects' metadata: "" (nowhere, the default) or "postgres",
+// the image_analyses table of the DB_* connection.
+func EnvImageCatalog() string {
+	return GetEnv("IMAGE_CATALOG", "")
+}
+
 var osExit = os.Exit
 
 func LoadEnvVars() {
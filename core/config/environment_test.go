@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 func setupEnv(t *testing.T) func() {
@@ -179,3 +180,148 @@ func TestEnvironmentConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestTypedEnvHelpers(t *testing.T) {
+	t.Run("int64 falls back on malformed values", func(t *testing.T) {
+		os.Setenv("TUS_MAX_SIZE", "not-a-number")
+		defer os.Unsetenv("TUS_MAX_SIZE")
+		assert.Equal(t, int64(10*1024*1024*1024), EnvTusMaxSize())
+	})
+
+	t.Run("int64 parses valid values", func(t *testing.T) {
+		os.Setenv("TUS_MAX_SIZE", "1024")
+		defer os.Unsetenv("TUS_MAX_SIZE")
+		assert.Equal(t, int64(1024), EnvTusMaxSize())
+	})
+
+	t.Run("duration parses valid values", func(t *testing.T) {
+		os.Setenv("TUS_EXPIRATION", "90m")
+		defer os.Unsetenv("TUS_EXPIRATION")
+		assert.Equal(t, 90*time.Minute, EnvTusExpiration())
+	})
+
+	t.Run("duration falls back when unset", func(t *testing.T) {
+		os.Unsetenv("TUS_EXPIRATION")
+		assert.Equal(t, 24*time.Hour, EnvTusExpiration())
+	})
+}
+
+func TestUploadStreamingSettings(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		os.Unsetenv("UPLOAD_PART_SIZE")
+		os.Unsetenv("UPLOAD_PARALLELISM")
+		assert.Equal(t, int64(16*1024*1024), EnvUploadPartSize())
+		assert.Equal(t, 4, EnvUploadParallelism())
+	})
+
+	t.Run("part size is floored at the S3 minimum", func(t *testing.T) {
+		os.Setenv("UPLOAD_PART_SIZE", "1024")
+		defer os.Unsetenv("UPLOAD_PART_SIZE")
+		assert.Equal(t, int64(5*1024*1024), EnvUploadPartSize())
+	})
+
+	t.Run("parallelism is at least one", func(t *testing.T) {
+		os.Setenv("UPLOAD_PARALLELISM", "0")
+		defer os.Unsetenv("UPLOAD_PARALLELISM")
+		assert.Equal(t, 1, EnvUploadParallelism())
+	})
+}
+
+func TestPresignSettings(t *testing.T) {
+	os.Unsetenv("PRESIGN_DEFAULT_EXPIRY")
+	os.Unsetenv("PRESIGN_MAX_EXPIRY")
+	os.Unsetenv("PRESIGN_MAX_UPLOAD_SIZE")
+
+	assert.Equal(t, 15*time.Minute, EnvPresignDefaultExpiry())
+	assert.Equal(t, time.Hour, EnvPresignMaxExpiry())
+	assert.Equal(t, int64(5*1024*1024*1024), EnvPresignMaxUploadSize())
+}
+
+func TestBatchSettings(t *testing.T) {
+	os.Unsetenv("BATCH_MAX_FILES")
+	os.Setenv("BATCH_CONCURRENCY", "-3")
+	defer os.Unsetenv("BATCH_CONCURRENCY")
+
+	assert.Equal(t, 100, EnvBatchMaxFiles())
+	assert.Equal(t, int64(5*1024*1024*1024), EnvBatchMaxSize())
+	assert.Equal(t, 1, EnvBatchConcurrency())
+}
+
+func TestFetchSettings(t *testing.T) {
+	os.Unsetenv("FETCH_MAX_SIZE")
+	os.Unsetenv("FETCH_TIMEOUT")
+	os.Setenv("FETCH_ALLOWED_CIDRS", " 10.0.0.0/8, ,192.168.1.0/24 ")
+	defer os.Unsetenv("FETCH_ALLOWED_CIDRS")
+
+	assert.Equal(t, int64(1024*1024*1024), EnvFetchMaxSize())
+	assert.Equal(t, 5*time.Minute, EnvFetchTimeout())
+	assert.Equal(t, 5, EnvFetchMaxRedirects())
+	assert.Equal(t, 24*time.Hour, EnvFetchJobRetention())
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.0/24"}, EnvFetchAllowedCIDRs())
+}
+
+func TestEncryptionSettings(t *testing.T) {
+	os.Unsetenv("KEYRING_FILE")
+	os.Setenv("ENCRYPTION_JOB_RETENTION", "48h")
+	defer os.Unsetenv("ENCRYPTION_JOB_RETENTION")
+
+	assert.Equal(t, "", EnvKeyringFile())
+	assert.Equal(t, 48*time.Hour, EnvEncryptionJobRetention())
+}
+
+func TestMimeTypes(t *testing.T) {
+	os.Setenv("MIME_TYPES", " .glb=model/gltf-binary, ,usdz=model/vnd.usdz+zip ")
+	defer os.Unsetenv("MIME_TYPES")
+
+	assert.Equal(t, []string{".glb=model/gltf-binary", "usdz=model/vnd.usdz+zip"}, EnvMimeTypes())
+}
+
+func TestClamdSettings(t *testing.T) {
+	os.Unsetenv("CLAMD_ADDRESS")
+	os.Setenv("CLAMD_TIMEOUT", "30s")
+	defer os.Unsetenv("CLAMD_TIMEOUT")
+
+	assert.Empty(t, EnvClamdAddress())
+	assert.Equal(t, 30*time.Second, EnvClamdTimeout())
+}
+
+func TestStripSettings(t *testing.T) {
+	os.Setenv("STRIP_MAX_SIZE", "1024")
+	defer os.Unsetenv("STRIP_MAX_SIZE")
+
+	assert.Equal(t, int64(1024), EnvStripMaxSize())
+}
+
+func TestArchiveSettings(t *testing.T) {
+	os.Unsetenv("ARCHIVE_MAX_SIZE")
+	os.Unsetenv("ARCHIVE_MAX_EXPANDED_SIZE")
+	os.Setenv("ARCHIVE_MAX_ENTRIES", "500")
+	os.Setenv("ARCHIVE_MAX_RATIO", "oops")
+	defer os.Unsetenv("ARCHIVE_MAX_ENTRIES")
+	defer os.Unsetenv("ARCHIVE_MAX_RATIO")
+
+	assert.Equal(t, int64(1024*1024*1024), EnvArchiveMaxSize())
+	assert.Equal(t, 500, EnvArchiveMaxEntries())
+	assert.Equal(t, int64(4*1024*1024*1024), EnvArchiveMaxExpandedSize())
+	assert.Equal(t, int64(100), EnvArchiveMaxRatio())
+}
+
+func TestImageSettings(t *testing.T) {
+	os.Unsetenv("IMAGE_MAX_DIMENSION")
+	os.Unsetenv("IMAGE_SIGNING_KEY")
+	os.Unsetenv("IMAGE_CATALOG")
+	os.Setenv("IMAGE_MAX_PIXELS", "1000000")
+	os.Setenv("IMAGE_CONCURRENCY", "0")
+	os.Setenv("IMAGE_VARIANT_RETENTION", "0s")
+	defer os.Unsetenv("IMAGE_MAX_PIXELS")
+	defer os.Unsetenv("IMAGE_CONCURRENCY")
+	defer os.Unsetenv("IMAGE_VARIANT_RETENTION")
+
+	assert.Equal(t, 4096, EnvImageMaxDimension())
+	assert.Equal(t, int64(32*1024*1024), EnvImageMaxSourceSize())
+	assert.Equal(t, int64(1000000), EnvImageMaxPixels())
+	assert.Equal(t, 1, EnvImageConcurrency())
+	assert.Empty(t, EnvImageSigningKey())
+	assert.Equal(t, time.Duration(0), EnvImageVariantRetention())
+	assert.Empty(t, EnvImageCatalog())
+}
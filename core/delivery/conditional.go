@@ -0,0 +1,37 @@
+package delivery
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go"
+)
+
+// ETag is the entity tag an object is served with, quoted: the content
+// digest for a content-addressed pointer, whose own ETag is that of
+// its empty body and would survive a re-upload, else MinIO's.
+func ETag(info minio.ObjectInfo) string {
+	if digest, found := cas.PointerDigest(info.Metadata); found {
+		return `"` + digest + `"`
+	}
+	if info.ETag == "" {
+		return ""
+	}
+
+	return `"` + info.ETag + `"`
+}
+
+// Serve writes content with the validators of the object info
+// describes: ETag and Last-Modified. Conditional requests get their
+// 304 or 412, Range requests their 206 unless If-Range names another
+// version of the object, and HEAD requests the headers alone. Headers
+// the caller set, Content-Type first, are kept.
+func Serve(c *gin.Context, info minio.ObjectInfo, content io.ReadSeeker) {
+	if etag := ETag(info); etag != "" {
+		c.Header("ETag", etag)
+	}
+
+	http.ServeContent(c.Writer, c.Request, "", info.LastModified, content)
+}
@@ -0,0 +1,85 @@
+package delivery
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETag(t *testing.T) {
+	assert.Equal(t, `"abc"`, ETag(minio.ObjectInfo{ETag: "abc"}))
+	assert.Empty(t, ETag(minio.ObjectInfo{}))
+
+	digest := strings.Repeat("ab", 32)
+	pointer := minio.ObjectInfo{
+		ETag:     "d41d8cd98f00b204e9800998ecf8427e",
+		Metadata: http.Header{"X-Amz-Meta-" + cas.DigestMetadata: {digest}},
+	}
+	assert.Equal(t, `"`+digest+`"`, ETag(pointer))
+}
+
+func TestServe(t *testing.T) {
+	modified := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	info := minio.ObjectInfo{ETag: "v2", LastModified: modified}
+	serve := func(method string, headers map[string]string) (int, http.Header, string) {
+		c, recorder := newContext("/cdn/bucket/key.txt")
+		c.Request.Method = method
+		for name, value := range headers {
+			c.Request.Header.Set(name, value)
+		}
+		c.Header("Content-Type", "text/plain")
+
+		Serve(c, info, strings.NewReader("0123456789"))
+		// Bodiless answers are only flushed by the engine.
+		return c.Writer.Status(), recorder.Header(), recorder.Body.String()
+	}
+
+	t.Run("validators", func(t *testing.T) {
+		code, header, body := serve(http.MethodGet, nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, `"v2"`, header.Get("ETag"))
+		assert.Equal(t, modified.Format(http.TimeFormat), header.Get("Last-Modified"))
+		assert.Equal(t, "text/plain", header.Get("Content-Type"))
+		assert.Equal(t, "0123456789", body)
+	})
+
+	t.Run("If-None-Match", func(t *testing.T) {
+		code, _, body := serve(http.MethodGet, map[string]string{"If-None-Match": `"v1", "v2"`})
+		assert.Equal(t, http.StatusNotModified, code)
+		assert.Empty(t, body)
+
+		code, _, _ = serve(http.MethodGet, map[string]string{"If-None-Match": `"v1"`})
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("If-Modified-Since", func(t *testing.T) {
+		code, _, _ := serve(http.MethodGet, map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)})
+		assert.Equal(t, http.StatusNotModified, code)
+
+		code, _, _ = serve(http.MethodGet, map[string]string{"If-Modified-Since": modified.Add(-time.Hour).Format(http.TimeFormat)})
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("If-Range", func(t *testing.T) {
+		code, header, body := serve(http.MethodGet, map[string]string{"Range": "bytes=4-", "If-Range": `"v2"`})
+		assert.Equal(t, http.StatusPartialContent, code)
+		assert.Equal(t, "bytes 4-9/10", header.Get("Content-Range"))
+		assert.Equal(t, "456789", body)
+
+		code, _, body = serve(http.MethodGet, map[string]string{"Range": "bytes=4-", "If-Range": `"v1"`})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "0123456789", body)
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		code, header, body := serve(http.MethodHead, nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "10", header.Get("Content-Length"))
+		assert.Empty(t, body)
+	})
+}
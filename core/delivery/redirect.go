@@ -0,0 +1,50 @@
+// Package delivery holds the download-side decisions shared by the
+// /cdn and /stream handlers.
+package delivery
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/gin-gonic/gin"
+)
+
+// URLSigner is the slice of services.IMinioService a redirect needs.
+type URLSigner interface {
+	GetObjectURL(bucket string, objectName string, expiry time.Duration, reqParams url.Values) (string, *errors.AppError)
+}
+
+// WantsRedirect reports whether the request should be answered with a
+// redirect to MinIO instead of proxying the bytes. The bucket's mode
+// sets the default and ?redirect=true|false overrides it, except in
+// proxy_only buckets, which never redirect.
+func WantsRedirect(c *gin.Context, settings entities.DeliverySettingsEntity) bool {
+	if settings.Mode == entities.DeliveryMode.ProxyOnly {
+		return false
+	}
+
+	requested, err := strconv.ParseBool(c.Query("redirect"))
+	if err != nil {
+		return settings.Mode == entities.DeliveryMode.Redirect
+	}
+
+	return requested
+}
+
+// Redirect answers with a 302/307 to a presigned URL for the object.
+// The caller must have authorised the request already: the URL itself
+// carries the credentials, so it is kept short-lived and marked
+// uncacheable.
+func Redirect(c *gin.Context, signer URLSigner, bucket string, objectName string, settings entities.DeliverySettingsEntity) *errors.AppError {
+	signedURL, appErr := signer.GetObjectURL(bucket, objectName, settings.RedirectExpiry.Duration(), url.Values{})
+	if appErr != nil {
+		return appErr
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(settings.RedirectStatus, signedURL)
+	return nil
+}
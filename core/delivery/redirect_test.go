@@ -0,0 +1,90 @@
+package delivery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSigner struct {
+	expiry time.Duration
+	err    *errors.AppError
+}
+
+func (f *fakeSigner) GetObjectURL(bucket string, objectName string, expiry time.Duration, reqParams url.Values) (string, *errors.AppError) {
+	f.expiry = expiry
+	if f.err != nil {
+		return "", f.err
+	}
+	return "https://minio.example.com/" + bucket + "/" + objectName + "?X-Amz-Signature=abc", nil
+}
+
+func newContext(target string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	return c, recorder
+}
+
+func TestWantsRedirect(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		target string
+		want   bool
+	}{
+		{"proxy default", entities.DeliveryMode.Proxy, "/cdn/b/k", false},
+		{"proxy opt in", entities.DeliveryMode.Proxy, "/cdn/b/k?redirect=true", true},
+		{"redirect default", entities.DeliveryMode.Redirect, "/cdn/b/k", true},
+		{"redirect opt out", entities.DeliveryMode.Redirect, "/cdn/b/k?redirect=0", false},
+		{"proxy only ignores opt in", entities.DeliveryMode.ProxyOnly, "/cdn/b/k?redirect=true", false},
+		{"garbage falls back to mode", entities.DeliveryMode.Redirect, "/cdn/b/k?redirect=maybe", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newContext(tt.target)
+			assert.Equal(t, tt.want, WantsRedirect(c, entities.DeliverySettingsEntity{Mode: tt.mode}))
+		})
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	settings := entities.DeliverySettingsEntity{
+		Mode:           entities.DeliveryMode.Redirect,
+		RedirectStatus: http.StatusFound,
+		RedirectExpiry: types.Duration(time.Minute),
+	}
+
+	t.Run("redirects to the signed URL", func(t *testing.T) {
+		c, recorder := newContext("/cdn/bucket/key.png")
+		signer := &fakeSigner{}
+
+		appErr := Redirect(c, signer, "bucket", "key.png", settings)
+
+		assert.Nil(t, appErr)
+		assert.Equal(t, http.StatusFound, recorder.Code)
+		assert.Equal(t, "https://minio.example.com/bucket/key.png?X-Amz-Signature=abc", recorder.Header().Get("Location"))
+		assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+		assert.Equal(t, time.Minute, signer.expiry)
+	})
+
+	t.Run("returns the signing error untouched", func(t *testing.T) {
+		c, recorder := newContext("/cdn/bucket/key.png")
+		signer := &fakeSigner{err: errors.ServiceError("boom")}
+
+		appErr := Redirect(c, signer, "bucket", "key.png", settings)
+
+		assert.Equal(t, "boom", appErr.Message)
+		assert.Empty(t, recorder.Header().Get("Location"))
+	})
+}
@@ -16,6 +16,14 @@ type appErrorTypes struct {
 	InvalidToken       int
 	InvalidCredentials int
 	Unauthorized       int
+	Integrity          int
+	UnsupportedMedia   int
+	PayloadTooLarge    int
+	Policy             int
+	Conflict           int
+	Precondition       int
+	Infected           int
+	Unavailable        int
 }
 
 var AppError = appErrorTypes{
@@ -32,20 +40,36 @@ var AppError = appErrorTypes{
 	InvalidToken:       1011,
 	InvalidCredentials: 1012,
 	Unauthorized:       1013,
+	Integrity:          1014,
+	UnsupportedMedia:   1015,
+	PayloadTooLarge:    1016,
+	Policy:             1017,
+	Conflict:           1018,
+	Precondition:       1019,
+	Infected:           1020,
+	Unavailable:        1021,
 }
 
 var AppErrorToHTTPCode = map[int]int{
-	AppError.Database:           http.StatusInternalServerError, // Database
-	AppError.Repository:         http.StatusInternalServerError, // Repository
-	AppError.Usecase:            http.StatusInternalServerError, // Usecase
-	AppError.Entity:             http.StatusBadRequest,          // Entity
-	AppError.Model:              http.StatusBadRequest,          // Model
-	AppError.Service:            http.StatusInternalServerError, // Service
-	AppError.Middleware:         http.StatusInternalServerError, // Middleware
-	AppError.Root:               http.StatusInternalServerError, // Root
-	AppError.Environment:        http.StatusInternalServerError, // Environment
-	AppError.NotFound:           http.StatusNotFound,            // NotFound
-	AppError.InvalidToken:       http.StatusUnauthorized,        // InvalidToken
-	AppError.InvalidCredentials: http.StatusUnauthorized,        // InvalidCredentials
-	AppError.Unauthorized:       http.StatusUnauthorized,        // Unauthorized
+	AppError.Database:           http.StatusInternalServerError,   // Database
+	AppError.Repository:         http.StatusInternalServerError,   // Repository
+	AppError.Usecase:            http.StatusInternalServerError,   // Usecase
+	AppError.Entity:             http.StatusBadRequest,            // Entity
+	AppError.Model:              http.StatusBadRequest,            // Model
+	AppError.Service:            http.StatusInternalServerError,   // Service
+	AppError.Middleware:         http.StatusInternalServerError,   // Middleware
+	AppError.Root:               http.StatusInternalServerError,   // Root
+	AppError.Environment:        http.StatusInternalServerError,   // Environment
+	AppError.NotFound:           http.StatusNotFound,              // NotFound
+	AppError.InvalidToken:       http.StatusUnauthorized,          // InvalidToken
+	AppError.InvalidCredentials: http.StatusUnauthorized,          // InvalidCredentials
+	AppError.Unauthorized:       http.StatusUnauthorized,          // Unauthorized
+	AppError.Integrity:          http.StatusBadRequest,            // Integrity
+	AppError.UnsupportedMedia:   http.StatusUnsupportedMediaType,  // UnsupportedMedia
+	AppError.PayloadTooLarge:    http.StatusRequestEntityTooLarge, // PayloadTooLarge
+	AppError.Policy:             http.StatusUnprocessableEntity,   // Policy
+	AppError.Conflict:           http.StatusConflict,              // Conflict
+	AppError.Precondition:       http.StatusPreconditionFailed,    // Precondition
+	AppError.Infected:           http.StatusUnprocessableEntity,   // Infected
+	AppError.Unavailable:        http.StatusServiceUnavailable,    // Unavailable
 }
@@ -0,0 +1,219 @@
+package entities
+
+import "github.com/RodolfoBonis/rb-cdn/core/types"
+
+// BucketSettingsEntity is the per-bucket behaviour loaded from
+// BUCKET_SETTINGS_FILE. Every feature that can be tuned per bucket
+// owns one section.
+type BucketSettingsEntity struct {
+	Delivery   DeliverySettingsEntity   `json:"delivery"`
+	Storage    StorageSettingsEntity    `json:"storage"`
+	Upload     UploadPolicyEntity       `json:"upload"`
+	Keys       KeySettingsEntity        `json:"keys"`
+	Scan       ScanSettingsEntity       `json:"scan"`
+	Strip      StripSettingsEntity      `json:"strip"`
+	Encryption EncryptionSettingsEntity `json:"encryption"`
+	Cache      CacheSettingsEntity      `json:"cache"`
+	Images     ImageSettingsEntity      `json:"images"`
+}
+
+var DeliveryMode = struct {
+	Proxy     string
+	Redirect  string
+	ProxyOnly string
+}{
+	Proxy:     "proxy",
+	Redirect:  "redirect",
+	ProxyOnly: "proxy_only",
+}
+
+// DeliverySettingsEntity controls how /cdn and /stream hand out bytes.
+// In "proxy" mode rb-cdn copies the object to the client and a request
+// may opt into a redirect with ?redirect=true; "redirect" flips that
+// default (?redirect=false opts out); "proxy_only" never redirects.
+type DeliverySettingsEntity struct {
+	Mode           string         `json:"mode"`
+	RedirectStatus int            `json:"redirect_status"`
+	RedirectExpiry types.Duration `json:"redirect_expiry"`
+	// ContentTypes map extensions, with or without the leading dot, to
+	// the Content-Type /cdn serves them with, whatever the object was
+	// stored with (see package mimetype).
+	ContentTypes map[string]string `json:"content_types"`
+	// Charset is added to textual Content-Types that don't name one;
+	// "" leaves them alone.
+	Charset string `json:"charset"`
+	// NegotiateFormats are the image formats, "avif" and "webp", /cdn
+	// may answer a JPEG, PNG or GIF with when the request's Accept
+	// header lists them, in order of preference. A variant is only
+	// served once stored, when it is smaller than the original (see
+	// package imaging for which ones are derived in the background)
+	// and the request isn't redirected. Empty serves originals only.
+	NegotiateFormats []string `json:"negotiate_formats"`
+}
+
+// StorageSettingsEntity controls how uploads are laid out in the
+// bucket. With ContentAddressed set, bodies are deduplicated by
+// SHA-256 and uploaded keys become pointers to the shared blob (see
+// package cas).
+type StorageSettingsEntity struct {
+	ContentAddressed bool `json:"content_addressed"`
+}
+
+// UploadPolicyEntity restricts what may be uploaded to the bucket. The
+// type is the one sniffed from the content, not the client's header.
+// Zero values and empty lists mean "no restriction".
+type UploadPolicyEntity struct {
+	// AllowedTypes accepts exact media types or families ("image/*").
+	AllowedTypes []string `json:"allowed_types"`
+	// AllowedExtensions are matched case-insensitively, with or
+	// without the leading dot.
+	AllowedExtensions []string `json:"allowed_extensions"`
+	MinSize           int64    `json:"min_size"`
+	MaxSize           int64    `json:"max_size"`
+	MaxFilesPerFolder int      `json:"max_files_per_folder"`
+}
+
+var KeyCollision = struct {
+	Overwrite string
+	Rename    string
+	Reject    string
+}{
+	Overwrite: "overwrite",
+	Rename:    "rename",
+	Reject:    "reject",
+}
+
+// KeySettingsEntity controls the key an upload is stored under. See
+// package keys for the template syntax. Collision decides what happens
+// when that key is taken: replace the object, store under "name-1.ext"
+// instead, or refuse with 409.
+type KeySettingsEntity struct {
+	Template  string `json:"template"`
+	Collision string `json:"collision"`
+}
+
+var ScanAction = struct {
+	Reject     string
+	Quarantine string
+	Publish    string
+}{
+	Reject:     "reject",
+	Quarantine: "quarantine",
+	Publish:    "publish",
+}
+
+// ScanSettingsEntity turns on virus scanning of uploads through clamd.
+// OnInfected is "reject" or "quarantine"; OnError, applied when the
+// scan can't complete (daemon down, stream too long), may also be
+// "publish" to fail open. Quarantined uploads are kept under
+// .quarantine/ and never served.
+type ScanSettingsEntity struct {
+	Enabled    bool   `json:"enabled"`
+	OnInfected string `json:"on_infected"`
+	OnError    string `json:"on_error"`
+}
+
+// StripSettingsEntity removes EXIF, XMP and IPTC metadata (GPS
+// position, device serials...) from JPEG, PNG, WebP and HEIF uploads
+// (see package imagemeta). With KeepRecord the removed fields are
+// saved as JSON under .exif/, which is never served.
+type StripSettingsEntity struct {
+	Enabled    bool `json:"enabled"`
+	KeepRecord bool `json:"keep_record"`
+}
+
+var EncryptionMode = struct {
+	None     string
+	SSEC     string
+	Envelope string
+}{
+	None:     "none",
+	SSEC:     "sse-c",
+	Envelope: "envelope",
+}
+
+// EncryptionSettingsEntity encrypts what is stored in the bucket with
+// keys from KEYRING_FILE (see package keyring). "sse-c" has MinIO
+// encrypt each object under a key derived for it, and needs MinIO to
+// be reached over https; "envelope" has rb-cdn encrypt the bytes
+// itself (see package envelope). Either way /cdn and /stream decrypt
+// transparently, range reads included, but MinIO can't serve the
+// bytes on its own: encrypted buckets are delivered in proxy_only
+// mode and refuse presigned URLs.
+type EncryptionSettingsEntity struct {
+	Mode string `json:"mode"`
+}
+
+// CacheSettingsEntity decides the caching headers /cdn and /stream
+// send (see package cachepolicy). The first rule matching the bucket,
+// key and content type wins; a bucket section's rules replace the
+// default section's, so rules meant for every bucket belong in the
+// default section, narrowed with Buckets. Without a match, keys that
+// carry a content hash ("app.3f9c2b1a5d41402a.js") are cached for a year as
+// immutable unless ImmutableHashed is off. A Cache-Control set at
+// upload time takes precedence over either.
+type CacheSettingsEntity struct {
+	Rules           []CacheRuleEntity `json:"rules"`
+	ImmutableHashed bool              `json:"immutable_hashed"`
+}
+
+// CacheRuleEntity matches objects and gives them caching headers.
+// Empty match lists match anything; empty headers aren't sent.
+type CacheRuleEntity struct {
+	// Name identifies the rule in dry runs; rules without one go by
+	// their position.
+	Name string `json:"name"`
+	// Buckets and Keys are globs: "*" and "?" stay within a path
+	// segment, "**" spans segments ("assets/**/*.js").
+	Buckets []string `json:"buckets"`
+	Keys    []string `json:"keys"`
+	// ContentTypes accepts exact media types or families ("image/*").
+	ContentTypes     []string `json:"content_types"`
+	CacheControl     string   `json:"cache_control"`
+	SurrogateControl string   `json:"surrogate_control"`
+	CDNCacheControl  string   `json:"cdn_cache_control"`
+	Vary             []string `json:"vary"`
+	// Expires is how far from the response Expires is set.
+	Expires types.Duration `json:"expires"`
+}
+
+// ImageSettingsEntity declares named image variants, presets, for
+// frontends that want a fixed set of sizes rather than /img's query
+// parameters. Every preset of an uploaded JPEG, PNG, GIF or WebP image
+// is derived in the background; the upload response lists their URLs
+// and a srcset, and /cdn/{bucket}/{key}@{preset} serves them, deriving
+// any that isn't stored yet. A bucket section's presets are added to
+// the default section's.
+type ImageSettingsEntity struct {
+	Presets map[string]ImagePresetEntity `json:"presets"`
+	// Analyze works out the size, dominant colour and BlurHash of
+	// uploaded images and records them on the object (see
+	// ImageAnalysisEntity). It is off by default: decoding holds the
+	// upload's response, and recording means copying the object onto
+	// itself, which versioned buckets keep as a new version.
+	Analyze bool `json:"analyze"`
+	// Duplicates governs near-duplicate images, told apart by their
+	// DHash.
+	Duplicates ImageDuplicateSettingsEntity `json:"duplicates"`
+}
+
+// ImageDuplicateSettingsEntity: with Reject, an image upload looking
+// like another image of the bucket, its hash at most Distance bits
+// away, is refused with 409 and leaves the bucket as it was. Distance,
+// 0-7 and 5 by default, is also what /similar looks within unless
+// asked otherwise.
+type ImageDuplicateSettingsEntity struct {
+	Reject   bool `json:"reject"`
+	Distance int  `json:"distance"`
+}
+
+// ImagePresetEntity describes a preset the way /img's parameters do:
+// zero values keep the source's size and format, Fit defaults to
+// "contain" and Quality, for JPEG, to 80.
+type ImagePresetEntity struct {
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Fit     string `json:"fit"`
+	Format  string `json:"format"`
+	Quality int    `json:"quality"`
+}
@@ -0,0 +1,18 @@
+package entities
+
+// ImageAnalysisEntity is what rb-cdn works out about a raster image so
+// clients can lay it out and paint a placeholder before it loads.
+type ImageAnalysisEntity struct {
+	// Width and Height are the image's size once turned upright by its
+	// EXIF orientation, as browsers display it.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// DominantColor is the most common colour, as "#rrggbb".
+	DominantColor string `json:"dominant_color"`
+	// BlurHash is the image as a BlurHash string (https://blurha.sh).
+	BlurHash string `json:"blurhash"`
+	// DHash is the image's 64-bit difference hash, in hex: images that
+	// look alike have hashes a few bits apart, whatever their size or
+	// encoding.
+	DHash string `json:"dhash"`
+}
@@ -0,0 +1,12 @@
+package entities
+
+// ObjectMetadataEntity is what a client may attach to an object at
+// upload time and read back from /cdn and /meta. Metadata keys are
+// lowercase; values may be any UTF-8 text.
+type ObjectMetadataEntity struct {
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	ContentLanguage    string            `json:"content_language,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+}
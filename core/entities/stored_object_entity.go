@@ -0,0 +1,20 @@
+package entities
+
+// StoredObjectEntity describes an object after it has been written
+// to MinIO by a streaming upload.
+type StoredObjectEntity struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+	// VersionID is only known on versioned buckets.
+	VersionID string `json:"version_id,omitempty"`
+	// ContentType is the type the object was stored with, after the
+	// bucket's upload policy resolved it.
+	ContentType string `json:"content_type,omitempty"`
+	// Digest is the hex SHA-256 of the body. Blob is set when the
+	// bucket is content-addressed: Key is then a pointer and Blob
+	// the object holding the bytes.
+	Digest string `json:"digest,omitempty"`
+	Blob   string `json:"blob,omitempty"`
+}
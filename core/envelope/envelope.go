@@ -0,0 +1,354 @@
+// Package envelope is rb-cdn's own encryption of object bodies. Every
+// object gets a random data key, stored in its metadata wrapped by a
+// keyring key, and its body is encrypted with AES-256-GCM in segments
+// of SegmentSize. Segments are sealed independently, so a range read
+// only fetches and decrypts the segments it overlaps.
+//
+// Segment i is sealed with i as its nonce, the nonce's last byte set on
+// the final segment: segments can't be reordered or dropped, and the
+// body can't be truncated, without failing authentication. Counter
+// nonces are safe because a data key never encrypts two bodies.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// SegmentSize is the plaintext size of every segment but the last.
+	SegmentSize = 64 * 1024
+	// Overhead is what sealing adds to a segment: the GCM tag.
+	Overhead = 16
+	// KeySize is the size of a data key.
+	KeySize = 32
+
+	sealedSegmentSize = SegmentSize + Overhead
+)
+
+// ErrCorrupt is returned for a body or wrapped key that fails to
+// decrypt: tampered with, truncated or sealed under another key.
+var ErrCorrupt = stdErrors.New("envelope: message authentication failed")
+
+// NewDataKey returns a random data key for one object.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey seals dataKey under the master key keyID names, for the
+// object's metadata. The id is authenticated with it, so a wrapped key
+// can't be passed off as sealed under another master key.
+func WrapKey(masterKey []byte, keyID string, dataKey []byte) (string, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, dataKey, []byte(keyID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// UnwrapKey opens a data key WrapKey sealed under keyID.
+func UnwrapKey(masterKey []byte, keyID string, wrapped string) ([]byte, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrCorrupt
+	}
+
+	dataKey, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	return dataKey, nil
+}
+
+// CipherSize is the size of the body plainSize bytes encrypt to. Even
+// an empty body is one (empty) sealed segment.
+func CipherSize(plainSize int64) int64 {
+	segments := (plainSize + SegmentSize - 1) / SegmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	return plainSize + segments*Overhead
+}
+
+// PlainSize is the size of the body cipherSize bytes decrypt to.
+func PlainSize(cipherSize int64) int64 {
+	segments := (cipherSize + sealedSegmentSize - 1) / sealedSegmentSize
+	if plain := cipherSize - segments*Overhead; plain > 0 {
+		return plain
+	}
+	return 0
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(index int64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, uint64(index))
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptReader seals source segment by segment. It reads one segment
+// ahead, since only the next one tells whether the current is final.
+type encryptReader struct {
+	source    io.Reader
+	aead      cipher.AEAD
+	index     int64
+	current   []byte
+	spare     []byte
+	exhausted bool
+	started   bool
+	done      bool
+	sealed    []byte
+	pending   []byte
+}
+
+// NewEncryptReader returns the encrypted body of source under dataKey.
+func NewEncryptReader(source io.Reader, dataKey []byte) (io.Reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptReader{
+		source:  source,
+		aead:    aead,
+		current: make([]byte, SegmentSize),
+		spare:   make([]byte, SegmentSize),
+		sealed:  make([]byte, 0, sealedSegmentSize),
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// seal encrypts the next segment into pending.
+func (r *encryptReader) seal() error {
+	if !r.started {
+		r.started = true
+		n, exhausted, err := readSegment(r.source, r.current)
+		if err != nil {
+			return err
+		}
+		r.current, r.exhausted = r.current[:n], exhausted
+	}
+
+	final := r.exhausted
+	var next []byte
+	if !final {
+		n, exhausted, err := readSegment(r.source, r.spare[:SegmentSize])
+		if err != nil {
+			return err
+		}
+		final = n == 0
+		next, r.exhausted = r.spare[:n], exhausted
+	}
+
+	r.pending = r.aead.Seal(r.sealed[:0], segmentNonce(r.index, final), r.current, nil)
+	r.index++
+	r.done = final
+	r.current, r.spare = next, r.current[:cap(r.current)]
+	return nil
+}
+
+// readSegment fills buffer from source; exhausted reports a short read.
+func readSegment(source io.Reader, buffer []byte) (int, bool, error) {
+	n, err := io.ReadFull(source, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	}
+	return n, false, err
+}
+
+// Reader decrypts a body from a seekable source of its ciphertext. It
+// is an io.ReadSeeker and an io.ReaderAt over the plaintext; reads
+// close to each other reuse the decrypted segment and the source's
+// position, so a sequential read never seeks.
+type Reader struct {
+	mutex        sync.Mutex
+	source       io.ReadSeeker
+	aead         cipher.AEAD
+	cipherSize   int64
+	size         int64
+	segments     int64
+	offset       int64
+	sourceOffset int64
+	segment      int64
+	plain        []byte
+	sealed       []byte
+}
+
+// NewReader decrypts the cipherSize bytes of source under dataKey.
+func NewReader(source io.ReadSeeker, cipherSize int64, dataKey []byte) (*Reader, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if cipherSize < Overhead {
+		return nil, ErrCorrupt
+	}
+
+	return &Reader{
+		source:     source,
+		aead:       aead,
+		cipherSize: cipherSize,
+		size:       PlainSize(cipherSize),
+		segments:   (cipherSize + sealedSegmentSize - 1) / sealedSegmentSize,
+		segment:    -1,
+		plain:      make([]byte, 0, SegmentSize),
+		sealed:     make([]byte, sealedSegmentSize),
+	}, nil
+}
+
+// Size is the size of the plaintext.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	n, err := r.readAt(p, r.offset)
+	r.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *Reader) ReadAt(p []byte, offset int64) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.readAt(p, offset)
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.offset, fmt.Errorf("envelope: invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return r.offset, fmt.Errorf("envelope: negative position %d", offset)
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *Reader) readAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("envelope: negative offset %d", offset)
+	}
+
+	n := 0
+	for n < len(p) {
+		if offset >= r.size {
+			return n, io.EOF
+		}
+
+		index := offset / SegmentSize
+		if err := r.load(index); err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], r.plain[offset-index*SegmentSize:])
+		n += copied
+		offset += int64(copied)
+	}
+
+	return n, nil
+}
+
+// load decrypts segment index, unless it is the one already decrypted.
+func (r *Reader) load(index int64) error {
+	if r.segment == index {
+		return nil
+	}
+
+	start := index * sealedSegmentSize
+	length := r.cipherSize - start
+	if length > sealedSegmentSize {
+		length = sealedSegmentSize
+	}
+
+	if r.sourceOffset != start || r.segment < 0 {
+		if _, err := r.source.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		r.sourceOffset = start
+	}
+
+	r.segment = -1
+	if _, err := io.ReadFull(r.source, r.sealed[:length]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrCorrupt
+		}
+		return err
+	}
+	r.sourceOffset += length
+
+	plain, err := r.aead.Open(r.plain[:0], segmentNonce(index, index == r.segments-1), r.sealed[:length], nil)
+	if err != nil {
+		return ErrCorrupt
+	}
+
+	r.plain = plain
+	r.segment = index
+	return nil
+}
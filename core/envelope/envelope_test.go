@@ -0,0 +1,128 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encrypt(t *testing.T, plain []byte, dataKey []byte) []byte {
+	t.Helper()
+
+	reader, err := NewEncryptReader(bytes.NewReader(plain), dataKey)
+	assert.NoError(t, err)
+
+	sealed, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return sealed
+}
+
+func TestRoundTrip(t *testing.T) {
+	dataKey, err := NewDataKey()
+	assert.NoError(t, err)
+
+	for _, size := range []int{0, 1, SegmentSize - 1, SegmentSize, SegmentSize + 1, 3*SegmentSize + 7} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+
+		sealed := encrypt(t, plain, dataKey)
+		assert.Equal(t, CipherSize(int64(size)), int64(len(sealed)), "size %d", size)
+		assert.Equal(t, int64(size), PlainSize(int64(len(sealed))), "size %d", size)
+
+		reader, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), dataKey)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(size), reader.Size())
+
+		decrypted, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(plain, decrypted), "size %d", size)
+	}
+}
+
+func TestRangeReads(t *testing.T) {
+	dataKey, _ := NewDataKey()
+	plain := make([]byte, 3*SegmentSize+100)
+	_, _ = rand.Read(plain)
+	sealed := encrypt(t, plain, dataKey)
+
+	reader, err := NewReader(bytes.NewReader(sealed), int64(len(sealed)), dataKey)
+	assert.NoError(t, err)
+
+	t.Run("a read across segments", func(t *testing.T) {
+		chunk := make([]byte, SegmentSize+20)
+		n, err := reader.ReadAt(chunk, SegmentSize-10)
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+		assert.Equal(t, plain[SegmentSize-10:2*SegmentSize+10], chunk)
+	})
+
+	t.Run("seek then read to the end", func(t *testing.T) {
+		position, err := reader.Seek(-50, io.SeekEnd)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(plain)-50), position)
+
+		rest, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, plain[len(plain)-50:], rest)
+	})
+
+	t.Run("reads past the end", func(t *testing.T) {
+		chunk := make([]byte, 10)
+		n, err := reader.ReadAt(chunk, int64(len(plain))-4)
+		assert.Equal(t, 4, n)
+		assert.Equal(t, io.EOF, err)
+	})
+}
+
+func TestTampering(t *testing.T) {
+	dataKey, _ := NewDataKey()
+	plain := make([]byte, 2*SegmentSize+1)
+	sealed := encrypt(t, plain, dataKey)
+
+	t.Run("a flipped bit", func(t *testing.T) {
+		tampered := append([]byte(nil), sealed...)
+		tampered[SegmentSize+Overhead+3] ^= 1
+
+		reader, _ := NewReader(bytes.NewReader(tampered), int64(len(tampered)), dataKey)
+		_, err := io.ReadAll(reader)
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+
+	t.Run("a dropped final segment", func(t *testing.T) {
+		truncated := sealed[:2*(SegmentSize+Overhead)]
+
+		reader, _ := NewReader(bytes.NewReader(truncated), int64(len(truncated)), dataKey)
+		_, err := io.ReadAll(reader)
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+
+	t.Run("another data key", func(t *testing.T) {
+		otherKey, _ := NewDataKey()
+
+		reader, _ := NewReader(bytes.NewReader(sealed), int64(len(sealed)), otherKey)
+		_, err := io.ReadAll(reader)
+		assert.ErrorIs(t, err, ErrCorrupt)
+	})
+}
+
+func TestWrapKey(t *testing.T) {
+	master := bytes.Repeat([]byte{1}, KeySize)
+	dataKey, _ := NewDataKey()
+
+	wrapped, err := WrapKey(master, "2026-10", dataKey)
+	assert.NoError(t, err)
+
+	unwrapped, err := UnwrapKey(master, "2026-10", wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	_, err = UnwrapKey(master, "2026-04", wrapped)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	_, err = UnwrapKey(bytes.Repeat([]byte{2}, KeySize), "2026-10", wrapped)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	_, err = UnwrapKey(master, "2026-10", "!!")
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
@@ -121,3 +121,72 @@ func UnauthorizedError() *AppError {
 		"Unauthorized",
 	)
 }
+
+// IntegrityError reports an upload body that doesn't match the
+// checksum the client declared for it.
+func IntegrityError(message string) *AppError {
+	return newAppError(
+		entities.AppError.Integrity,
+		message,
+	)
+}
+
+// UnsupportedMediaError rejects an upload whose type the bucket's
+// policy doesn't accept, or whose content contradicts its name.
+func UnsupportedMediaError(message string) *AppError {
+	return newAppError(
+		entities.AppError.UnsupportedMedia,
+		message,
+	)
+}
+
+func PayloadTooLargeError(message string) *AppError {
+	return newAppError(
+		entities.AppError.PayloadTooLarge,
+		message,
+	)
+}
+
+// PolicyError rejects an upload that breaks a bucket rule other than
+// type or maximum size, such as a minimum size or a folder quota.
+func PolicyError(message string) *AppError {
+	return newAppError(
+		entities.AppError.Policy,
+		message,
+	)
+}
+
+// ConflictError rejects an upload to a key that is already taken in a
+// bucket whose collision policy is "reject", or of an image looking
+// like one of a bucket that rejects near-duplicates.
+func ConflictError(message string) *AppError {
+	return newAppError(
+		entities.AppError.Conflict,
+		message,
+	)
+}
+
+// PreconditionError reports a failed If-Match or If-None-Match.
+func PreconditionError(message string) *AppError {
+	return newAppError(
+		entities.AppError.Precondition,
+		message,
+	)
+}
+
+// InfectedError rejects an upload the virus scanner flagged.
+func InfectedError(message string) *AppError {
+	return newAppError(
+		entities.AppError.Infected,
+		message,
+	)
+}
+
+// UnavailableError reports a dependency that is down, such as the
+// virus scanner, when the request can't be served without it.
+func UnavailableError(message string) *AppError {
+	return newAppError(
+		entities.AppError.Unavailable,
+		message,
+	)
+}
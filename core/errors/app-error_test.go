@@ -1,6 +1,7 @@
 package errors
 
 import (
+	"net/http"
 	"testing"
 
 	"github.com/RodolfoBonis/rb-cdn/core/entities"
@@ -99,6 +100,23 @@ func TestAppError(t *testing.T) {
 		assert.NotEmpty(t, err.StackTrace)
 	})
 
+	t.Run("IntegrityError", func(t *testing.T) {
+		err := IntegrityError("checksum mismatch")
+		assert.Equal(t, entities.AppError.Integrity, err.Error)
+		assert.Equal(t, "checksum mismatch", err.Message)
+		assert.Equal(t, http.StatusBadRequest, err.ToHttpError().StatusCode)
+	})
+
+	t.Run("upload policy errors", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnsupportedMediaType, UnsupportedMediaError("type").ToHttpError().StatusCode)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, PayloadTooLargeError("size").ToHttpError().StatusCode)
+		assert.Equal(t, http.StatusUnprocessableEntity, PolicyError("quota").ToHttpError().StatusCode)
+		assert.Equal(t, http.StatusConflict, ConflictError("taken").ToHttpError().StatusCode)
+		assert.Equal(t, http.StatusPreconditionFailed, PreconditionError("etag").ToHttpError().StatusCode)
+		assert.Equal(t, http.StatusUnprocessableEntity, InfectedError("eicar").ToHttpError().StatusCode)
+		assert.Equal(t, http.StatusServiceUnavailable, UnavailableError("scanner").ToHttpError().StatusCode)
+	})
+
 	t.Run("ToMap", func(t *testing.T) {
 		err := DatabaseError("test error")
 		errMap := err.ToMap()
@@ -0,0 +1,240 @@
+// Package fetch downloads remote URLs on behalf of clients without
+// letting them reach into the network rb-cdn runs in.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrBlockedAddress = errors.New("destination address is not allowed")
+	ErrTooManyHops    = errors.New("too many redirects")
+	ErrTooLarge       = errors.New("remote body exceeds the size limit")
+)
+
+// blockedNetworks are never dialled unless explicitly allowlisted:
+// loopback, RFC 1918, CGNAT, link-local (cloud metadata lives at
+// 169.254.169.254), multicast, and their IPv6 counterparts.
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// NAT64 (64:ff9b::/96, in the last 4 bytes) and 6to4 (2002::/16, in
+// the 4 bytes after the prefix) addresses carry an IPv4 address. They
+// are blocked whenever the IPv4 address they reach is.
+var (
+	nat64Network     = mustParseCIDRs("64:ff9b::/96")[0]
+	sixToFourNetwork = mustParseCIDRs("2002::/16")[0]
+)
+
+// Options bound what a Client will do for a single download.
+type Options struct {
+	Timeout      time.Duration
+	MaxRedirects int
+	// Allowed networks are reachable even when they are blocked by
+	// default, e.g. an internal asset server.
+	Allowed []*net.IPNet
+}
+
+// ParseCIDRs parses a list of CIDR blocks, such as the
+// FETCH_ALLOWED_CIDRS allowlist.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func mustParseCIDRs(values ...string) []*net.IPNet {
+	networks, err := ParseCIDRs(values)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// IsBlocked reports whether ip may not be dialled under allowed.
+func IsBlocked(ip net.IP, allowed []*net.IPNet) bool {
+	if mapped := ip.To4(); mapped != nil {
+		ip = mapped
+	}
+
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	if embedded := embeddedIPv4(ip); embedded != nil {
+		return IsBlocked(embedded, allowed)
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// embeddedIPv4 returns the IPv4 address a NAT64 or 6to4 address
+// reaches, or nil for any other address.
+func embeddedIPv4(ip net.IP) net.IP {
+	if len(ip) != net.IPv6len {
+		return nil
+	}
+
+	switch {
+	case nat64Network.Contains(ip):
+		return net.IPv4(ip[12], ip[13], ip[14], ip[15]).To4()
+	case sixToFourNetwork.Contains(ip):
+		return net.IPv4(ip[2], ip[3], ip[4], ip[5]).To4()
+	}
+	return nil
+}
+
+// NewClient returns an http.Client that only speaks http(s), follows
+// at most options.MaxRedirects redirects and refuses to connect to
+// blocked addresses. The check runs on the address actually being
+// dialled, after DNS resolution, so a hostname that resolves (or
+// later re-resolves) to a private address is refused too. Proxies
+// from the environment are ignored: they would hide the destination.
+func NewClient(options Options) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || IsBlocked(ip, options.Allowed) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+			}
+
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   options.Timeout,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) > options.MaxRedirects {
+				return ErrTooManyHops
+			}
+			return CheckURL(request.URL)
+		},
+	}
+}
+
+// CheckURL rejects anything but absolute http(s) URLs.
+func CheckURL(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", target.Scheme)
+	}
+
+	if target.Hostname() == "" {
+		return fmt.Errorf("URL has no host")
+	}
+
+	return nil
+}
+
+// Get issues a GET for rawURL. The caller must close the body.
+func Get(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckURL(target); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		response.Body.Close()
+		return nil, fmt.Errorf("remote answered %s", response.Status)
+	}
+
+	return response, nil
+}
+
+// LimitReader returns a reader that fails with ErrTooLarge once more
+// than limit bytes have been read, rather than silently truncating
+// like io.LimitReader.
+func LimitReader(reader io.Reader, limit int64) io.Reader {
+	return &limitedReader{reader: reader, remaining: limit}
+}
+
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, ErrTooLarge
+	}
+
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrTooLarge
+	}
+
+	return n, err
+}
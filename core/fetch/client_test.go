@@ -0,0 +1,111 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBlocked(t *testing.T) {
+	allowed, err := ParseCIDRs([]string{"10.1.0.0/16"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"8.8.8.8", false},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"192.168.1.10", true},
+		{"10.2.0.1", true},
+		{"10.1.2.3", false},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"fd00::1", true},
+		{"2001:4860:4860::8888", false},
+		{"64:ff9b::7f00:1", true},
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b::a01:203", false},
+		{"64:ff9b::808:808", false},
+		{"2002:c0a8:10a::1", true},
+		{"2002:0808:0808::1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.blocked, IsBlocked(net.ParseIP(tt.ip), allowed))
+		})
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	_, err := ParseCIDRs([]string{"not-a-cidr"})
+	assert.Error(t, err)
+}
+
+func TestGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("payload"))
+		}
+	}))
+	defer server.Close()
+
+	loopback, _ := ParseCIDRs([]string{"127.0.0.0/8", "::1/128"})
+
+	t.Run("loopback is refused by default", func(t *testing.T) {
+		client := NewClient(Options{Timeout: time.Second, MaxRedirects: 3})
+		_, err := Get(context.Background(), client, server.URL)
+		assert.True(t, errors.Is(err, ErrBlockedAddress))
+	})
+
+	t.Run("allowlisted network is reachable", func(t *testing.T) {
+		client := NewClient(Options{Timeout: time.Second, MaxRedirects: 3, Allowed: loopback})
+		response, err := Get(context.Background(), client, server.URL)
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		assert.Equal(t, "payload", string(body))
+	})
+
+	t.Run("redirect limit", func(t *testing.T) {
+		client := NewClient(Options{Timeout: time.Second, MaxRedirects: 3, Allowed: loopback})
+		_, err := Get(context.Background(), client, server.URL+"/loop")
+		assert.True(t, errors.Is(err, ErrTooManyHops))
+	})
+
+	t.Run("non-2xx is an error", func(t *testing.T) {
+		client := NewClient(Options{Timeout: time.Second, MaxRedirects: 3, Allowed: loopback})
+		_, err := Get(context.Background(), client, server.URL+"/missing")
+		assert.ErrorContains(t, err, "404")
+	})
+
+	t.Run("only http and https", func(t *testing.T) {
+		client := NewClient(Options{Timeout: time.Second})
+		_, err := Get(context.Background(), client, "file:///etc/passwd")
+		assert.ErrorContains(t, err, "unsupported URL scheme")
+	})
+}
+
+func TestLimitReader(t *testing.T) {
+	body, err := io.ReadAll(LimitReader(strings.NewReader("12345"), 5))
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", string(body))
+
+	_, err = io.ReadAll(LimitReader(strings.NewReader("123456"), 5))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+)
+
+// MemoryStore keeps records in the process. Retries that land on
+// another replica are not recognised; use the Postgres store when
+// running more than one.
+type MemoryStore struct {
+	mutex   sync.Mutex
+	records map[memoryKey]*Record
+}
+
+type memoryKey struct {
+	identity string
+	key      string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[memoryKey]*Record{}}
+}
+
+func (s *MemoryStore) Reserve(_ context.Context, identity string, key string, expiresAt time.Time) (*Record, bool, *errors.AppError) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := memoryKey{identity: identity, key: key}
+	if record, found := s.records[id]; found && time.Now().Before(record.ExpiresAt) {
+		held := *record
+		return &held, false, nil
+	}
+
+	s.records[id] = &Record{Identity: identity, Key: key, ExpiresAt: expiresAt}
+	return nil, true, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, record *Record) *errors.AppError {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored := *record
+	s.records[memoryKey{identity: record.Identity, key: record.Key}] = &stored
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, identity string, key string) *errors.AppError {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.records, memoryKey{identity: identity, key: key})
+	return nil
+}
+
+func (s *MemoryStore) Purge(_ context.Context) *errors.AppError {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for id, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return NewMemoryStore() })
+
+	t.Run("purge drops expired records", func(t *testing.T) {
+		ctx := context.Background()
+		store := NewMemoryStore()
+		store.Reserve(ctx, "alice", "old", time.Now().Add(-time.Second))
+		store.Reserve(ctx, "alice", "new", time.Now().Add(time.Minute))
+
+		assert.Nil(t, store.Purge(ctx))
+		assert.Len(t, store.records, 1)
+	})
+}
+
+// testStore checks the contract every Store keeps, on stores newStore
+// builds empty.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	ctx := context.Background()
+
+	t.Run("reserves a free key once", func(t *testing.T) {
+		store := newStore(t)
+
+		held, reserved, appErr := store.Reserve(ctx, "alice", "k1", time.Now().Add(time.Minute))
+		assert.Nil(t, appErr)
+		assert.True(t, reserved)
+		assert.Nil(t, held)
+
+		held, reserved, appErr = store.Reserve(ctx, "alice", "k1", time.Now().Add(time.Minute))
+		assert.Nil(t, appErr)
+		assert.False(t, reserved)
+		assert.False(t, held.Done())
+	})
+
+	t.Run("keys are scoped by identity", func(t *testing.T) {
+		store := newStore(t)
+
+		_, reserved, _ := store.Reserve(ctx, "alice", "k1", time.Now().Add(time.Minute))
+		assert.True(t, reserved)
+		_, reserved, _ = store.Reserve(ctx, "bob", "k1", time.Now().Add(time.Minute))
+		assert.True(t, reserved)
+	})
+
+	t.Run("returns the completed response", func(t *testing.T) {
+		store := newStore(t)
+		store.Reserve(ctx, "alice", "k1", time.Now().Add(time.Minute))
+
+		assert.Nil(t, store.Complete(ctx, &Record{
+			Identity:    "alice",
+			Key:         "k1",
+			Fingerprint: "abc",
+			BodySize:    5,
+			Status:      http.StatusOK,
+			Header:      http.Header{"Content-Type": {"application/json"}},
+			Body:        []byte(`{"ok":true}`),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}))
+
+		held, reserved, _ := store.Reserve(ctx, "alice", "k1", time.Now().Add(time.Minute))
+		assert.False(t, reserved)
+		assert.True(t, held.Done())
+		assert.Equal(t, "abc", held.Fingerprint)
+		assert.Equal(t, int64(5), held.BodySize)
+		assert.Equal(t, "application/json", held.Header.Get("Content-Type"))
+		assert.Equal(t, `{"ok":true}`, string(held.Body))
+	})
+
+	t.Run("released and expired keys are free again", func(t *testing.T) {
+		store := newStore(t)
+
+		store.Reserve(ctx, "alice", "k1", time.Now().Add(time.Minute))
+		assert.Nil(t, store.Release(ctx, "alice", "k1"))
+		_, reserved, _ := store.Reserve(ctx, "alice", "k1", time.Now().Add(time.Minute))
+		assert.True(t, reserved)
+
+		store.Reserve(ctx, "alice", "k2", time.Now().Add(-time.Second))
+		_, reserved, _ = store.Reserve(ctx, "alice", "k2", time.Now().Add(time.Minute))
+		assert.True(t, reserved)
+	})
+}
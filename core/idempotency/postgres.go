@@ -0,0 +1,129 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/jinzhu/gorm"
+)
+
+// PostgresStore keeps records in the idempotency_keys table through
+// the DBService connection, so every replica sees the same keys.
+type PostgresStore struct{}
+
+type idempotencyRow struct {
+	Identity    string    `gorm:"primary_key;type:varchar(255)"`
+	Key         string    `gorm:"column:idempotency_key;primary_key;type:varchar(255)"`
+	Fingerprint string    `gorm:"type:varchar(64);not null;default:''"`
+	BodySize    int64     `gorm:"not null;default:0"`
+	Status      int       `gorm:"not null;default:0"`
+	Header      string    `gorm:"type:text;not null;default:''"`
+	Body        []byte    `gorm:"type:bytea"`
+	ExpiresAt   time.Time `gorm:"not null;index"`
+}
+
+func (idempotencyRow) TableName() string {
+	return "idempotency_keys"
+}
+
+// NewPostgresStore creates the table when it is missing. The DBService
+// connection must already be open.
+func NewPostgresStore() (*PostgresStore, *errors.AppError) {
+	if err := services.Connector.AutoMigrate(&idempotencyRow{}).Error; err != nil {
+		return nil, errors.DatabaseError(err.Error())
+	}
+
+	return &PostgresStore{}, nil
+}
+
+// Reserve inserts the reservation, taking over an expired row in the
+// same statement so two replicas can't both win the key.
+func (s *PostgresStore) Reserve(_ context.Context, identity string, key string, expiresAt time.Time) (*Record, bool, *errors.AppError) {
+	// The row can be released between the insert and the read; one
+	// more attempt then finds it free.
+	for attempt := 0; attempt < 2; attempt++ {
+		result := services.Connector.Exec(`INSERT INTO idempotency_keys (identity, idempotency_key, status, expires_at)
+VALUES (?, ?, 0, ?)
+ON CONFLICT (identity, idempotency_key) DO UPDATE
+SET fingerprint = '', body_size = 0, status = 0, header = '', body = NULL, expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at <= ?`, identity, key, expiresAt, time.Now())
+		if result.Error != nil {
+			return nil, false, errors.DatabaseError(result.Error.Error())
+		}
+		if result.RowsAffected == 1 {
+			return nil, true, nil
+		}
+
+		var row idempotencyRow
+		err := services.Connector.Where("identity = ? AND idempotency_key = ?", identity, key).First(&row).Error
+		if gorm.IsRecordNotFoundError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, false, errors.DatabaseError(err.Error())
+		}
+
+		record := &Record{
+			Identity:    row.Identity,
+			Key:         row.Key,
+			Fingerprint: row.Fingerprint,
+			BodySize:    row.BodySize,
+			Status:      row.Status,
+			Body:        row.Body,
+			ExpiresAt:   row.ExpiresAt,
+		}
+		if row.Header != "" {
+			if err := json.Unmarshal([]byte(row.Header), &record.Header); err != nil {
+				return nil, false, errors.DatabaseError(err.Error())
+			}
+		}
+
+		return record, false, nil
+	}
+
+	return nil, false, errors.DatabaseError("idempotency key changed hands while it was being reserved")
+}
+
+func (s *PostgresStore) Complete(_ context.Context, record *Record) *errors.AppError {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return errors.DatabaseError(err.Error())
+	}
+
+	row := idempotencyRow{
+		Identity:    record.Identity,
+		Key:         record.Key,
+		Fingerprint: record.Fingerprint,
+		BodySize:    record.BodySize,
+		Status:      record.Status,
+		Header:      string(header),
+		Body:        record.Body,
+		ExpiresAt:   record.ExpiresAt,
+	}
+	if err := services.Connector.Save(&row).Error; err != nil {
+		return errors.DatabaseError(err.Error())
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) Release(_ context.Context, identity string, key string) *errors.AppError {
+	err := services.Connector.Where("identity = ? AND idempotency_key = ?", identity, key).Delete(&idempotencyRow{}).Error
+	if err != nil {
+		return errors.DatabaseError(err.Error())
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) Purge(_ context.Context) *errors.AppError {
+	err := services.Connector.Where("expires_at <= ?", time.Now()).Delete(&idempotencyRow{}).Error
+	if err != nil {
+		return errors.DatabaseError(err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSQLiteStore points the DBService connection at an in-memory
+// SQLite database, which speaks the upsert Reserve relies on, for the
+// duration of the test.
+func newSQLiteStore(t *testing.T) *PostgresStore {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	// Every connection to ":memory:" opens a database of its own.
+	db.DB().SetMaxOpenConns(1)
+
+	previous := services.Connector
+	services.Connector = db
+	t.Cleanup(func() {
+		services.Connector = previous
+		db.Close()
+	})
+
+	store, appErr := NewPostgresStore()
+	require.Nil(t, appErr)
+	return store
+}
+
+func TestPostgresStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return newSQLiteStore(t) })
+
+	t.Run("purge drops expired records", func(t *testing.T) {
+		ctx := context.Background()
+		store := newSQLiteStore(t)
+		store.Reserve(ctx, "alice", "old", time.Now().Add(-time.Second))
+		store.Reserve(ctx, "alice", "new", time.Now().Add(time.Minute))
+
+		assert.Nil(t, store.Purge(ctx))
+		var count int
+		require.NoError(t, services.Connector.Model(&idempotencyRow{}).Count(&count).Error)
+		assert.Equal(t, 1, count)
+	})
+}
@@ -0,0 +1,112 @@
+// Package idempotency remembers the responses of mutating requests
+// sent with an Idempotency-Key, so a client retrying over a flaky
+// network gets the first response back instead of a second upload.
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+)
+
+// LockTimeout is how long a key stays reserved by a request that never
+// completes, e.g. because its replica died. It outlasts the server's
+// ten-minute read and write timeouts, so a live request keeps its key.
+const LockTimeout = 15 * time.Minute
+
+// Record is the state of one key. Status stays zero while the request
+// that reserved it is still running. BodySize, the length of that
+// request's body, bounds how much of a retry's is read to compare them.
+type Record struct {
+	Identity    string
+	Key         string
+	Fingerprint string
+	BodySize    int64
+	Status      int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// Done reports whether the response of the first request is stored.
+func (r *Record) Done() bool {
+	return r.Status != 0
+}
+
+// Store keeps records by identity and key. Expired records count as
+// absent.
+type Store interface {
+	// Reserve claims key for identity until expiresAt. When the key
+	// is already held it returns the holder's record and false.
+	Reserve(ctx context.Context, identity string, key string, expiresAt time.Time) (*Record, bool, *errors.AppError)
+	// Complete stores the response of the request that reserved the
+	// key, replacing the reservation.
+	Complete(ctx context.Context, record *Record) *errors.AppError
+	// Release drops a reservation so a retry runs again.
+	Release(ctx context.Context, identity string, key string) *errors.AppError
+	// Purge drops expired records.
+	Purge(ctx context.Context) *errors.AppError
+}
+
+var (
+	defaultStore Store
+	defaultOnce  sync.Once
+)
+
+// Default is the store IDEMPOTENCY_STORE selects, built on first use
+// and shared by every route. A Postgres store it can't set up is
+// fatal, as other boot-time misconfigurations are.
+func Default() Store {
+	defaultOnce.Do(func() {
+		store, appErr := newStore(config.EnvIdempotencyStore())
+		if appErr != nil {
+			logger.Log.Error(appErr.Message, appErr.ToMap())
+			panic(appErr.Message)
+		}
+
+		defaultStore = store
+		StartJanitor(store, time.Hour, logger.Log)
+	})
+
+	return defaultStore
+}
+
+func newStore(kind string) (Store, *errors.AppError) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "postgres":
+		if services.Connector == nil {
+			if appErr := services.OpenConnection(); appErr != nil {
+				return nil, appErr
+			}
+		}
+
+		store, appErr := NewPostgresStore()
+		if appErr != nil {
+			return nil, appErr
+		}
+		return store, nil
+	default:
+		return nil, errors.EnvironmentError("IDEMPOTENCY_STORE must be memory or postgres")
+	}
+}
+
+// StartJanitor purges expired records every interval.
+func StartJanitor(store Store, interval time.Duration, log *logger.CustomLogger) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if appErr := store.Purge(context.Background()); appErr != nil {
+				log.Error(appErr.Message, appErr.ToMap())
+			}
+		}
+	}()
+}
@@ -0,0 +1,330 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// emptyXMP replaces an XMP item's packet.
+const emptyXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`
+
+type isoBox struct {
+	kind        string
+	start, body int
+	end         int
+	// version is the first byte of the body, which full boxes use
+	// for their version.
+	version byte
+}
+
+type heifItem struct {
+	kind        string
+	contentType string
+}
+
+type heifExtent struct {
+	offset int
+	length int
+	// lengthAt is where the extent's length is stored, lengthSize its
+	// width; rewriting it shortens the item without moving bytes.
+	lengthAt, lengthSize int
+}
+
+// stripHEIF blanks the Exif item and the XMP (application/rdf+xml)
+// mime items of a HEIF file in place: each keeps its place in the
+// file, its payload is replaced by an empty block and the rest of its
+// bytes are zeroed. Moving nothing keeps every other offset valid.
+func stripHEIF(data []byte) (*Result, error) {
+	boxes, err := readBoxes(data, 0, len(data))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := findBox(boxes, "meta")
+	if meta == nil {
+		return nil, malformed("heif", "missing meta box")
+	}
+
+	children, err := readBoxes(data, meta.body+4, meta.end)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := readItemInfo(data, findBox(children, "iinf"))
+	if err != nil {
+		return nil, err
+	}
+	locations, err := readItemLocations(data, findBox(children, "iloc"), findBox(children, "idat"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	out := append([]byte(nil), data...)
+	for id, item := range items {
+		isXMP := item.kind == "mime" && item.contentType == "application/rdf+xml"
+		if item.kind != "Exif" && !isXMP {
+			continue
+		}
+
+		extents, found := locations[id]
+		if !found {
+			continue
+		}
+
+		var payload []byte
+		for _, extent := range extents {
+			payload = append(payload, data[extent.offset:extent.offset+extent.length]...)
+		}
+
+		replacement := []byte(emptyXMP)
+		if isXMP {
+			result.Record.XMP = string(payload)
+		} else {
+			// A 4-byte offset to the TIFF header precedes it.
+			if len(payload) >= 4 {
+				if start := 4 + int(binary.BigEndian.Uint32(payload)); start <= len(payload) {
+					result.Record.Fields, result.Record.Orientation = parseTIFF(payload[start:])
+				}
+			}
+			replacement = append([]byte{0, 0, 0, 0}, emptyTIFF()...)
+		}
+
+		blankItem(out, extents, replacement)
+		result.Stripped = true
+	}
+
+	result.Data = out
+	return result, nil
+}
+
+// blankItem zeroes an item's extents and writes replacement into the
+// first one, shortening it to fit when its length field allows.
+func blankItem(data []byte, extents []heifExtent, replacement []byte) {
+	for _, extent := range extents {
+		clear(data[extent.offset : extent.offset+extent.length])
+	}
+
+	first := extents[0]
+	if first.length < len(replacement) || first.lengthSize == 0 {
+		return
+	}
+
+	copy(data[first.offset:], replacement)
+	if len(extents) == 1 {
+		putUint(data[first.lengthAt:], first.lengthSize, uint64(len(replacement)))
+	}
+}
+
+func readBoxes(data []byte, start int, end int) ([]isoBox, error) {
+	var boxes []isoBox
+	for position := start; position < end; {
+		if position+8 > end {
+			return nil, malformed("heif", "truncated box")
+		}
+
+		size := int(binary.BigEndian.Uint32(data[position:]))
+		box := isoBox{kind: string(data[position+4 : position+8]), start: position, body: position + 8}
+		switch size {
+		case 0:
+			size = end - position
+		case 1:
+			if position+16 > end {
+				return nil, malformed("heif", "truncated box")
+			}
+			size = int(binary.BigEndian.Uint64(data[position+8:]))
+			box.body = position + 16
+		}
+
+		box.end = position + size
+		if size < box.body-position || box.end > end {
+			return nil, malformed("heif", "box "+box.kind+" overruns its parent")
+		}
+		if box.body < box.end {
+			box.version = data[box.body]
+		}
+
+		boxes = append(boxes, box)
+		position = box.end
+	}
+
+	return boxes, nil
+}
+
+func findBox(boxes []isoBox, kind string) *isoBox {
+	for i := range boxes {
+		if boxes[i].kind == kind {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// readItemInfo maps item ids to their type from the iinf box.
+func readItemInfo(data []byte, iinf *isoBox) (map[uint32]heifItem, error) {
+	items := map[uint32]heifItem{}
+	if iinf == nil {
+		return items, nil
+	}
+
+	entries := iinf.body + 4 + 2
+	if iinf.version > 0 {
+		entries += 2
+	}
+	if entries > iinf.end {
+		return nil, malformed("heif", "truncated iinf")
+	}
+
+	infes, err := readBoxes(data, entries, iinf.end)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, infe := range infes {
+		if infe.kind != "infe" || infe.version < 2 {
+			continue
+		}
+
+		reader := boxReader{data: data[:infe.end], position: infe.body + 4}
+		var id uint32
+		if infe.version == 2 {
+			id = uint32(reader.uint(2))
+		} else {
+			id = uint32(reader.uint(4))
+		}
+		reader.uint(2) // item_protection_index
+		item := heifItem{kind: string(reader.bytes(4))}
+		reader.cstring() // item_name
+		if item.kind == "mime" {
+			item.contentType = reader.cstring()
+		}
+
+		if reader.failed {
+			return nil, malformed("heif", "truncated infe")
+		}
+		items[id] = item
+	}
+
+	return items, nil
+}
+
+// readItemLocations resolves the iloc box to absolute extents. Items
+// built from other items (construction method 2) are left out.
+func readItemLocations(data []byte, iloc *isoBox, idat *isoBox) (map[uint32][]heifExtent, error) {
+	locations := map[uint32][]heifExtent{}
+	if iloc == nil {
+		return locations, nil
+	}
+
+	version := iloc.version
+	reader := boxReader{data: data[:iloc.end], position: iloc.body + 4}
+	sizes := reader.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0x0F)
+	sizes = reader.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0x0F)
+	}
+
+	var count int
+	if version < 2 {
+		count = int(reader.uint(2))
+	} else {
+		count = int(reader.uint(4))
+	}
+
+	for i := 0; i < count && !reader.failed; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(reader.uint(2))
+		} else {
+			id = uint32(reader.uint(4))
+		}
+
+		method := 0
+		if version == 1 || version == 2 {
+			method = int(reader.uint(2) & 0x0F)
+		}
+		reader.uint(2) // data_reference_index
+		base := int(reader.uint(baseOffsetSize))
+
+		extentCount := int(reader.uint(2))
+		extents := make([]heifExtent, 0, extentCount)
+		for j := 0; j < extentCount && !reader.failed; j++ {
+			if indexSize > 0 {
+				reader.uint(indexSize)
+			}
+			offset := base + int(reader.uint(offsetSize))
+			extent := heifExtent{lengthAt: reader.position, lengthSize: lengthSize}
+			extent.length = int(reader.uint(lengthSize))
+
+			switch method {
+			case 0:
+				extent.offset = offset
+			case 1:
+				if idat == nil {
+					return nil, malformed("heif", "item stored in a missing idat")
+				}
+				extent.offset = idat.body + offset
+			}
+			if extent.length == 0 || extent.offset+extent.length > len(data) {
+				return nil, malformed("heif", "item extent overruns the file")
+			}
+			extents = append(extents, extent)
+		}
+
+		if method <= 1 && len(extents) > 0 {
+			locations[id] = extents
+		}
+	}
+
+	if reader.failed {
+		return nil, malformed("heif", "truncated iloc")
+	}
+
+	return locations, nil
+}
+
+// boxReader reads big-endian fields, recording rather than panicking
+// on a truncated box.
+type boxReader struct {
+	data     []byte
+	position int
+	failed   bool
+}
+
+func (r *boxReader) bytes(n int) []byte {
+	if r.position+n > len(r.data) {
+		r.failed = true
+		return make([]byte, n)
+	}
+	value := r.data[r.position : r.position+n]
+	r.position += n
+	return value
+}
+
+func (r *boxReader) uint(size int) uint64 {
+	var value uint64
+	for _, b := range r.bytes(size) {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func (r *boxReader) cstring() string {
+	end := bytes.IndexByte(r.data[min(r.position, len(r.data)):], 0)
+	if end < 0 {
+		r.failed = true
+		return ""
+	}
+	value := string(r.data[r.position : r.position+end])
+	r.position += end + 1
+	return value
+}
+
+func putUint(data []byte, size int, value uint64) {
+	for i := size - 1; i >= 0; i-- {
+		data[i] = byte(value)
+		value >>= 8
+	}
+}
@@ -0,0 +1,94 @@
+// Package imagemeta removes EXIF, XMP and IPTC metadata from JPEG,
+// PNG, WebP and HEIF (HEIC, AVIF) images without re-encoding their
+// pixels.
+//
+// The one exception is orientation: a JPEG or PNG whose EXIF block
+// says it must be rotated or mirrored to display upright has that
+// applied to its pixels before the block goes, since nothing would be
+// left to tell viewers otherwise. Formats rb-cdn can't encode (WebP,
+// CMYK JPEG) keep a minimal EXIF block carrying just the orientation.
+// HEIF orientation lives in the container's irot/imir properties and
+// is left alone.
+package imagemeta
+
+import (
+	stdErrors "errors"
+	"fmt"
+)
+
+// RecordPrefix is where the metadata stripped from an upload is kept
+// when its bucket asks for it.
+const RecordPrefix = ".exif/"
+
+// ErrMalformed is returned for content that doesn't parse as the
+// image format it claims to be.
+var ErrMalformed = stdErrors.New("malformed image")
+
+// Record is what was removed from an image.
+type Record struct {
+	Format string `json:"format"`
+	// Fields are the EXIF tags worth keeping (camera, dates, GPS...),
+	// named after the EXIF spec; unknown tags are keyed by IFD and
+	// number, e.g. "Exif.0xA420".
+	Fields      map[string]string `json:"fields,omitempty"`
+	Orientation int               `json:"orientation,omitempty"`
+	XMP         string            `json:"xmp,omitempty"`
+	// IPTC is the raw Photoshop resource block or IPTC profile.
+	IPTC []byte `json:"iptc,omitempty"`
+}
+
+// Result is a stripped image.
+type Result struct {
+	Data []byte
+	// Stripped is false when the image carried no metadata; Data is
+	// then the input.
+	Stripped bool
+	// Rotated reports that the orientation was applied to the pixels,
+	// which means they were re-encoded.
+	Rotated bool
+	Record  Record
+}
+
+// RecordKey is where the record of key's stripped metadata is stored.
+func RecordKey(key string) string {
+	return RecordPrefix + key + ".json"
+}
+
+var formats = map[string]func([]byte) (*Result, error){
+	"image/jpeg": stripJPEG,
+	"image/png":  stripPNG,
+	"image/webp": stripWebP,
+	"image/heic": stripHEIF,
+	"image/heif": stripHEIF,
+	"image/avif": stripHEIF,
+}
+
+// Supported reports whether Strip handles contentType.
+func Supported(contentType string) bool {
+	_, found := formats[contentType]
+	return found
+}
+
+// Strip removes the metadata from data, an image of contentType.
+func Strip(data []byte, contentType string) (*Result, error) {
+	strip, found := formats[contentType]
+	if !found {
+		return nil, fmt.Errorf("can't strip metadata from %s", contentType)
+	}
+
+	result, err := strip(data)
+	if err != nil {
+		return nil, err
+	}
+	result.Record.Format = contentType
+
+	if !result.Stripped {
+		result.Data = data
+	}
+
+	return result, nil
+}
+
+func malformed(format string, detail string) error {
+	return fmt.Errorf("%w: %s: %s", ErrMalformed, format, detail)
+}
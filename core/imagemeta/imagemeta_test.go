@@ -0,0 +1,298 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exifTIFF is a big-endian EXIF block with a camera make, an
+// orientation and a GPS latitude.
+func exifTIFF(orientation int) []byte {
+	var b bytes.Buffer
+	w := func(values ...interface{}) {
+		for _, value := range values {
+			binary.Write(&b, binary.BigEndian, value)
+		}
+	}
+
+	w([]byte("MM"), uint16(42), uint32(8))
+	// IFD0 at 8: three entries, data from 50 on.
+	w(uint16(3))
+	w(uint16(0x010F), uint16(2), uint32(6), uint32(50))
+	w(uint16(0x0112), uint16(3), uint32(1), uint16(orientation), uint16(0))
+	w(uint16(0x8825), uint16(4), uint32(1), uint32(56))
+	w(uint32(0))
+	w([]byte("Canon\x00"))
+	// GPS IFD at 56: two entries, latitude at 86.
+	w(uint16(2))
+	w(uint16(0x0001), uint16(2), uint32(2), []byte("N\x00\x00\x00"))
+	w(uint16(0x0002), uint16(5), uint32(3), uint32(86))
+	w(uint32(0))
+	w(uint32(52), uint32(1), uint32(31), uint32(1), uint32(1234), uint32(100))
+
+	return b.Bytes()
+}
+
+func assertRecorded(t *testing.T, record Record, orientation string) {
+	t.Helper()
+	assert.Equal(t, "Canon", record.Fields["Make"])
+	assert.Equal(t, orientation, record.Fields["Orientation"])
+	assert.Equal(t, "N", record.Fields["GPSLatitudeRef"])
+	assert.Equal(t, "52/1 31/1 1234/100", record.Fields["GPSLatitude"])
+}
+
+// halves is 16x8, red on the left and blue on the right.
+func halves() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+			if x >= 8 {
+				img.Set(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func taggedJPEG(t *testing.T, orientation int) []byte {
+	var encoded bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&encoded, halves(), &jpeg.Options{Quality: 100}))
+
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2])
+	out.Write(jpegSegment(markerAPP1, append(append([]byte(nil), exifHeader...), exifTIFF(orientation)...)))
+	out.Write(jpegSegment(markerAPP1, append(append([]byte(nil), xmpHeader...), "<x:xmpmeta/>"...)))
+	out.Write(jpegSegment(markerAPP13, append(append([]byte(nil), photoshopHeader...), "8BIM"...)))
+	out.Write(jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01")))
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestStripJPEG(t *testing.T) {
+	original := taggedJPEG(t, 1)
+
+	result, err := Strip(original, "image/jpeg")
+	assert.NoError(t, err)
+	assert.True(t, result.Stripped)
+	assert.False(t, result.Rotated)
+	assertRecorded(t, result.Record, "1")
+	assert.Equal(t, "<x:xmpmeta/>", result.Record.XMP)
+	assert.NotEmpty(t, result.Record.IPTC)
+
+	assert.NotContains(t, string(result.Data), "Exif\x00\x00")
+	assert.NotContains(t, string(result.Data), "ns.adobe.com")
+	assert.NotContains(t, string(result.Data), "Photoshop")
+	assert.Contains(t, string(result.Data), "ICC_PROFILE", "other APPn segments stay")
+
+	// The scan, and so every pixel, is copied byte for byte.
+	scan := bytes.Index(original, []byte{0xFF, markerSOS})
+	assert.True(t, bytes.HasSuffix(result.Data, original[scan:]))
+}
+
+func TestStripJPEGAppliesOrientation(t *testing.T) {
+	result, err := Strip(taggedJPEG(t, 6), "image/jpeg")
+	assert.NoError(t, err)
+	assert.True(t, result.Rotated)
+	assert.Equal(t, 6, result.Record.Orientation)
+	assert.NotContains(t, string(result.Data), "Exif\x00\x00")
+	assert.Contains(t, string(result.Data), "ICC_PROFILE")
+
+	img, err := jpeg.Decode(bytes.NewReader(result.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 16), img.Bounds())
+
+	// Turned clockwise, the red left half ends up on top.
+	r, _, b, _ := img.At(4, 2).RGBA()
+	assert.Greater(t, r, b)
+	r, _, b, _ = img.At(4, 13).RGBA()
+	assert.Greater(t, b, r)
+}
+
+func TestStripPNG(t *testing.T) {
+	var encoded bytes.Buffer
+	assert.NoError(t, png.Encode(&encoded, halves()))
+
+	// eXIf and an XMP iTXt chunk right after IHDR.
+	headerEnd := len(pngSignature) + 25
+	var tagged bytes.Buffer
+	tagged.Write(encoded.Bytes()[:headerEnd])
+	tagged.Write(pngChunkBytes("eXIf", exifTIFF(8)))
+	tagged.Write(pngChunkBytes("iTXt", []byte(xmpKeyword+"\x00\x00\x00\x00\x00<x:xmpmeta/>")))
+	tagged.Write(pngChunkBytes("tEXt", []byte("Title\x00Holiday")))
+	tagged.Write(encoded.Bytes()[headerEnd:])
+
+	result, err := Strip(tagged.Bytes(), "image/png")
+	assert.NoError(t, err)
+	assert.True(t, result.Stripped)
+	assert.True(t, result.Rotated)
+	assertRecorded(t, result.Record, "8")
+	assert.Equal(t, "<x:xmpmeta/>", result.Record.XMP)
+	assert.NotContains(t, string(result.Data), "eXIf")
+	assert.Contains(t, string(result.Data), "Holiday", "unrelated text chunks stay")
+
+	img, err := png.Decode(bytes.NewReader(result.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 16), img.Bounds())
+
+	// Turned counter-clockwise, the blue right half ends up on top.
+	assert.Equal(t, color.NRGBA{B: 255, A: 255}, color.NRGBAModel.Convert(img.At(0, 0)))
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, color.NRGBAModel.Convert(img.At(7, 15)))
+}
+
+func webpChunk(kind string, payload []byte) []byte {
+	chunk := append([]byte(kind), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func TestStripWebP(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpFlagEXIF | webpFlagXMP | 0x10
+	bitstream := webpChunk("VP8L", []byte{0x2F, 1, 2, 3, 4})
+
+	result, err := Strip(webpFile(webpChunk("VP8X", vp8x), bitstream, webpChunk("EXIF", exifTIFF(1)), webpChunk("XMP ", []byte("<x/>"))), "image/webp")
+	assert.NoError(t, err)
+	assert.True(t, result.Stripped)
+	assertRecorded(t, result.Record, "1")
+	assert.Equal(t, webpFile(webpChunk("VP8X", []byte{0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0}), bitstream), result.Data)
+
+	result, err = Strip(webpFile(webpChunk("VP8X", vp8x), bitstream, webpChunk("EXIF", exifTIFF(3))), "image/webp")
+	assert.NoError(t, err)
+	assert.False(t, result.Rotated)
+	kept := []byte{webpFlagEXIF | webpFlagXMP | 0x10, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	kept[0] &^= webpFlagXMP
+	assert.Equal(t, webpFile(webpChunk("VP8X", kept), bitstream, webpChunk("EXIF", orientationTIFF(3))), result.Data)
+}
+
+func isoBoxBytes(kind string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	return append(append(binary.BigEndian.AppendUint32(nil, uint32(8+len(body))), kind...), body...)
+}
+
+// heifFile lays out ftyp, meta (iinf with an Exif and an XMP item,
+// iloc pointing into mdat) and mdat.
+func heifFile(exif []byte, xmp []byte) []byte {
+	infe := func(id uint16, kind string, extra string) []byte {
+		return isoBoxBytes("infe", []byte{2, 0, 0, 0}, binary.BigEndian.AppendUint16(nil, id), []byte{0, 0}, []byte(kind), []byte("\x00"+extra))
+	}
+	iinf := isoBoxBytes("iinf", []byte{0, 0, 0, 0}, []byte{0, 3},
+		infe(1, "hvc1", ""), infe(2, "Exif", ""), infe(3, "mime", "application/rdf+xml\x00"))
+
+	ftyp := isoBoxBytes("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	iloc := func(exifAt, xmpAt int) []byte {
+		entry := func(id uint16, offset int, length int) []byte {
+			return bytes.Join([][]byte{
+				binary.BigEndian.AppendUint16(nil, id), {0, 0}, {0, 1},
+				binary.BigEndian.AppendUint32(nil, uint32(offset)),
+				binary.BigEndian.AppendUint32(nil, uint32(length)),
+			}, nil)
+		}
+		return isoBoxBytes("iloc", []byte{0, 0, 0, 0}, []byte{0x44, 0x00}, []byte{0, 2}, entry(2, exifAt, len(exif)), entry(3, xmpAt, len(xmp)))
+	}
+
+	meta := isoBoxBytes("meta", []byte{0, 0, 0, 0}, isoBoxBytes("hdlr", make([]byte, 24)), iinf, iloc(0, 0))
+	mdatStart := len(ftyp) + len(meta) + 8
+	meta = isoBoxBytes("meta", []byte{0, 0, 0, 0}, isoBoxBytes("hdlr", make([]byte, 24)), iinf, iloc(mdatStart, mdatStart+len(exif)))
+
+	return bytes.Join([][]byte{ftyp, meta, isoBoxBytes("mdat", exif, xmp)}, nil)
+}
+
+func TestStripHEIF(t *testing.T) {
+	exif := append([]byte{0, 0, 0, 6}, append(append([]byte(nil), exifHeader...), exifTIFF(6)...)...)
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF>GPS 52.5</rdf:RDF></x:xmpmeta>`)
+	original := heifFile(exif, xmp)
+
+	result, err := Strip(original, "image/heic")
+	assert.NoError(t, err)
+	assert.True(t, result.Stripped)
+	assert.False(t, result.Rotated, "HEIF orientation is irot/imir, not EXIF")
+	assertRecorded(t, result.Record, "6")
+	assert.Equal(t, string(xmp), result.Record.XMP)
+
+	assert.Len(t, result.Data, len(original), "nothing moves")
+	assert.NotContains(t, string(result.Data), "Canon")
+	assert.NotContains(t, string(result.Data), "GPS 52.5")
+
+	// The items now hold the empty blocks, and their lengths say so.
+	again, err := Strip(result.Data, "image/heic")
+	assert.NoError(t, err)
+	assert.Empty(t, again.Record.Fields)
+	assert.Equal(t, emptyXMP, again.Record.XMP)
+}
+
+func TestStripWithoutMetadata(t *testing.T) {
+	var encoded bytes.Buffer
+	assert.NoError(t, png.Encode(&encoded, halves()))
+
+	result, err := Strip(encoded.Bytes(), "image/png")
+	assert.NoError(t, err)
+	assert.False(t, result.Stripped)
+	assert.Equal(t, encoded.Bytes(), result.Data)
+}
+
+func TestStripMalformed(t *testing.T) {
+	for contentType, data := range map[string][]byte{
+		"image/jpeg": {0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF},
+		"image/png":  append(append([]byte(nil), pngSignature...), 0, 0, 0, 99),
+		"image/webp": []byte("RIFF\x00\x00\x00\x00WEBPVP8X\xff\x00\x00\x00"),
+		"image/heic": isoBoxBytes("ftyp", []byte("heic")),
+	} {
+		_, err := Strip(data, contentType)
+		assert.ErrorIs(t, err, ErrMalformed, contentType)
+	}
+
+	assert.False(t, Supported("image/gif"))
+	_, err := Strip(nil, "image/gif")
+	assert.Error(t, err)
+}
+
+func TestRecordKey(t *testing.T) {
+	assert.Equal(t, ".exif/photos/a.jpg.json", RecordKey("photos/a.jpg"))
+}
+
+func TestOrientation(t *testing.T) {
+	assert.Equal(t, 6, Orientation(taggedJPEG(t, 6), "image/jpeg"))
+	assert.Equal(t, 1, Orientation(taggedJPEG(t, 1), "image/jpeg"))
+	assert.Equal(t, 1, Orientation(taggedJPEG(t, 42), "image/jpeg"))
+
+	var encoded bytes.Buffer
+	assert.NoError(t, png.Encode(&encoded, halves()))
+	assert.Equal(t, 1, Orientation(encoded.Bytes(), "image/png"))
+	headerEnd := len(pngSignature) + 25
+	tagged := append(append(append([]byte(nil), encoded.Bytes()[:headerEnd]...), pngChunkBytes("eXIf", exifTIFF(3))...), encoded.Bytes()[headerEnd:]...)
+	assert.Equal(t, 3, Orientation(tagged, "image/png"))
+
+	bitstream := webpChunk("VP8L", []byte{0x2F, 1, 2, 3, 4})
+	assert.Equal(t, 8, Orientation(webpFile(webpChunk("VP8X", make([]byte, 10)), bitstream, webpChunk("EXIF", exifTIFF(8))), "image/webp"))
+	assert.Equal(t, 1, Orientation([]byte{0xFF, 0xD8, 0xFF}, "image/jpeg"))
+	assert.Equal(t, 1, Orientation(nil, "image/gif"))
+
+	img := halves()
+	assert.Same(t, img, Orient(img, 1))
+	assert.Equal(t, image.Rect(0, 0, 8, 16), Orient(img, 6).Bounds())
+}
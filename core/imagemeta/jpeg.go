@@ -0,0 +1,153 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+)
+
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP13 = 0xED
+	markerAPP14 = 0xEE
+	markerAPP15 = 0xEF
+
+	// jpegQuality is used for the rare JPEG re-encoded to apply its
+	// orientation.
+	jpegQuality = 95
+)
+
+var (
+	exifHeader        = []byte("Exif\x00\x00")
+	xmpHeader         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	photoshopHeader   = []byte("Photoshop 3.0\x00")
+)
+
+// stripJPEG drops the APP1 EXIF and XMP segments and the APP13
+// Photoshop segment that carries IPTC. Everything from the start of
+// scan on is copied untouched.
+func stripJPEG(data []byte) (*Result, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, malformed("jpeg", "missing start of image")
+	}
+
+	result := &Result{}
+	var out bytes.Buffer
+	out.Write(data[:2])
+
+	// kept are the APPn segments left in place; they are carried over
+	// if the image has to be re-encoded.
+	var kept [][]byte
+
+	position := 2
+	for {
+		if position+2 > len(data) || data[position] != 0xFF {
+			return nil, malformed("jpeg", "truncated before start of scan")
+		}
+
+		marker := data[position+1]
+		if marker == 0xFF {
+			position++
+			continue
+		}
+		if marker == markerSOS || marker == markerEOI {
+			out.Write(data[position:])
+			break
+		}
+
+		if position+4 > len(data) {
+			return nil, malformed("jpeg", "truncated segment")
+		}
+		end := position + 2 + int(binary.BigEndian.Uint16(data[position+2:]))
+		if end > len(data) || end < position+4 {
+			return nil, malformed("jpeg", "segment overruns the file")
+		}
+		segment, payload := data[position:end], data[position+4:end]
+		position = end
+
+		switch {
+		case marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader):
+			result.Record.Fields, result.Record.Orientation = parseTIFF(payload[len(exifHeader):])
+		case marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader):
+			result.Record.XMP = string(payload[len(xmpHeader):])
+		case marker == markerAPP1 && bytes.HasPrefix(payload, xmpExtendedHeader):
+			// The rest of an oversized XMP packet; dropped with it.
+		case marker == markerAPP13 && bytes.HasPrefix(payload, photoshopHeader):
+			result.Record.IPTC = append([]byte(nil), payload...)
+		default:
+			out.Write(segment)
+			if marker >= markerAPP0 && marker <= markerAPP15 {
+				kept = append(kept, segment)
+			}
+			continue
+		}
+		result.Stripped = true
+	}
+
+	result.Data = out.Bytes()
+	if needsOrienting(result.Record.Orientation) {
+		return result, orientJPEG(result, kept)
+	}
+
+	return result, nil
+}
+
+// orientJPEG applies result's orientation to its pixels, keeping the
+// colour profile and other APPn segments of the original. CMYK JPEGs,
+// which the encoder can't write, keep a minimal orientation block
+// instead.
+func orientJPEG(result *Result, kept [][]byte) error {
+	img, err := jpeg.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		return malformed("jpeg", err.Error())
+	}
+
+	if _, cmyk := img.(*image.CMYK); cmyk {
+		result.Data = insertJPEGSegment(result.Data, markerAPP1, append(append([]byte(nil), exifHeader...), orientationTIFF(result.Record.Orientation)...))
+		return nil
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, orient(img, result.Record.Orientation), &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	out.Write([]byte{0xFF, markerSOI})
+	for _, segment := range kept {
+		// The encoder writes plain YCbCr; Adobe's colour transform
+		// flag of the original no longer applies.
+		if segment[1] != markerAPP14 {
+			out.Write(segment)
+		}
+	}
+	out.Write(encoded.Bytes()[2:])
+
+	result.Data = out.Bytes()
+	result.Rotated = true
+	return nil
+}
+
+// insertJPEGSegment adds a segment after the start of image and the
+// JFIF APP0 segment, if there is one.
+func insertJPEGSegment(data []byte, marker byte, payload []byte) []byte {
+	position := 2
+	if len(data) > 6 && data[2] == 0xFF && data[3] == markerAPP0 {
+		position = 4 + int(binary.BigEndian.Uint16(data[4:]))
+	}
+
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	out := make([]byte, 0, len(data)+len(segment)+len(payload))
+	out = append(out, data[:position]...)
+	out = append(out, segment...)
+	out = append(out, payload...)
+	return append(out, data[position:]...)
+}
@@ -0,0 +1,75 @@
+package imagemeta
+
+import (
+	"image"
+	"image/draw"
+)
+
+// orient returns img transformed so it displays upright without an
+// EXIF orientation (2-8: mirror, rotate 180, flip, transpose, rotate
+// 90 clockwise, transverse, rotate 90 counter-clockwise).
+func orient(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	targetWidth, targetHeight := width, height
+	if orientation >= 5 {
+		targetWidth, targetHeight = height, width
+	}
+	target := image.Rect(0, 0, targetWidth, targetHeight)
+
+	// Pixels are moved as opaque byte groups; 16-bit sources keep
+	// their depth.
+	var source, result []byte
+	var sourceStride, resultStride, pixelSize int
+	var oriented image.Image
+
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		from := image.NewNRGBA64(image.Rect(0, 0, width, height))
+		draw.Draw(from, from.Bounds(), img, bounds.Min, draw.Src)
+		to := image.NewNRGBA64(target)
+		source, sourceStride, result, resultStride, pixelSize = from.Pix, from.Stride, to.Pix, to.Stride, 8
+		oriented = to
+	default:
+		from := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(from, from.Bounds(), img, bounds.Min, draw.Src)
+		to := image.NewNRGBA(target)
+		source, sourceStride, result, resultStride, pixelSize = from.Pix, from.Stride, to.Pix, to.Stride, 4
+		oriented = to
+	}
+
+	for y := 0; y < targetHeight; y++ {
+		for x := 0; x < targetWidth; x++ {
+			sx, sy := sourcePoint(orientation, x, y, width, height)
+			from := sy*sourceStride + sx*pixelSize
+			to := y*resultStride + x*pixelSize
+			copy(result[to:to+pixelSize], source[from:from+pixelSize])
+		}
+	}
+
+	return oriented
+}
+
+// sourcePoint maps a pixel of the upright image back to the stored
+// one, which is width x height.
+func sourcePoint(orientation int, x int, y int, width int, height int) (int, int) {
+	switch orientation {
+	case 2:
+		return width - 1 - x, y
+	case 3:
+		return width - 1 - x, height - 1 - y
+	case 4:
+		return x, height - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return y, height - 1 - x
+	case 7:
+		return width - 1 - y, height - 1 - x
+	case 8:
+		return width - 1 - y, x
+	default:
+		return x, y
+	}
+}
@@ -0,0 +1,109 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// Orientation returns the EXIF orientation of data, an image of
+// contentType, or 1 (upright) when it carries none or can't be read.
+// Unlike Strip it only walks the headers up to the pixels.
+func Orientation(data []byte, contentType string) int {
+	var exif []byte
+	switch contentType {
+	case "image/jpeg":
+		exif = jpegEXIF(data)
+	case "image/png":
+		exif = pngEXIF(data)
+	case "image/webp":
+		exif = webpEXIF(data)
+	}
+
+	if _, orientation := parseTIFF(exif); needsOrienting(orientation) {
+		return orientation
+	}
+	return 1
+}
+
+// Orient returns img turned the way an EXIF orientation of 2-8 says
+// it displays; any other orientation returns img unchanged.
+func Orient(img image.Image, orientation int) image.Image {
+	if !needsOrienting(orientation) {
+		return img
+	}
+	return orient(img, orientation)
+}
+
+func jpegEXIF(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil
+	}
+
+	for position := 2; position+4 <= len(data) && data[position] == 0xFF; {
+		marker := data[position+1]
+		if marker == 0xFF {
+			position++
+			continue
+		}
+		if marker == markerSOS || marker == markerEOI {
+			return nil
+		}
+
+		end := position + 2 + int(binary.BigEndian.Uint16(data[position+2:]))
+		if end > len(data) || end < position+4 {
+			return nil
+		}
+		if payload := data[position+4 : end]; marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+			return payload[len(exifHeader):]
+		}
+		position = end
+	}
+
+	return nil
+}
+
+func pngEXIF(data []byte) []byte {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil
+	}
+
+	for position := len(pngSignature); position+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[position:]))
+		end := position + 12 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+
+		switch string(data[position+4 : position+8]) {
+		case "eXIf":
+			return data[position+8 : position+8+length]
+		case "IDAT", "IEND":
+			return nil
+		}
+		position = end
+	}
+
+	return nil
+}
+
+func webpEXIF(data []byte) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil
+	}
+
+	for position := 12; position+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[position+4:]))
+		end := position + 8 + size
+		if size < 0 || end > len(data) {
+			return nil
+		}
+
+		if string(data[position:position+4]) == "EXIF" {
+			return bytes.TrimPrefix(data[position+8:end], exifHeader)
+		}
+		position = end + size%2
+	}
+
+	return nil
+}
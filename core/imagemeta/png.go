@@ -0,0 +1,173 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/png"
+	"strings"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// xmpKeyword is the text chunk keyword XMP is stored under;
+// rawProfilePrefix starts the keywords ImageMagick stores EXIF, IPTC
+// and XMP under.
+const (
+	xmpKeyword       = "XML:com.adobe.xmp"
+	rawProfilePrefix = "Raw profile type "
+)
+
+// pngCarriedChunks are the ancillary chunks still valid after the
+// pixels were re-encoded.
+var pngCarriedChunks = map[string]bool{
+	"cHRM": true, "gAMA": true, "iCCP": true, "sRGB": true, "pHYs": true,
+	"tEXt": true, "zTXt": true, "iTXt": true, "tIME": true,
+}
+
+type pngChunk struct {
+	kind string
+	data []byte
+	raw  []byte
+}
+
+// stripPNG drops the eXIf chunk and the text chunks holding XMP or
+// ImageMagick's raw EXIF/IPTC profiles.
+func stripPNG(data []byte) (*Result, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, malformed("png", "missing signature")
+	}
+
+	result := &Result{}
+	var out bytes.Buffer
+	out.Write(pngSignature)
+
+	var kept []pngChunk
+	position := len(pngSignature)
+	for position < len(data) {
+		if position+12 > len(data) {
+			return nil, malformed("png", "truncated chunk")
+		}
+
+		length := int(binary.BigEndian.Uint32(data[position:]))
+		end := position + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, malformed("png", "chunk overruns the file")
+		}
+
+		chunk := pngChunk{
+			kind: string(data[position+4 : position+8]),
+			data: data[position+8 : position+8+length],
+			raw:  data[position:end],
+		}
+		position = end
+
+		keyword, text := pngText(chunk)
+		switch {
+		case chunk.kind == "eXIf":
+			result.Record.Fields, result.Record.Orientation = parseTIFF(chunk.data)
+		case keyword == xmpKeyword:
+			result.Record.XMP = text
+		case strings.HasPrefix(keyword, rawProfilePrefix):
+			if strings.Contains(keyword, "iptc") {
+				result.Record.IPTC = append([]byte(nil), chunk.data...)
+			}
+		default:
+			out.Write(chunk.raw)
+			kept = append(kept, chunk)
+			if chunk.kind == "IEND" {
+				position = len(data)
+			}
+			continue
+		}
+		result.Stripped = true
+	}
+
+	result.Data = out.Bytes()
+	if needsOrienting(result.Record.Orientation) {
+		return result, orientPNG(result, kept)
+	}
+
+	return result, nil
+}
+
+// pngText returns the keyword of a text chunk and, for uncompressed
+// ones, its text.
+func pngText(chunk pngChunk) (string, string) {
+	if chunk.kind != "tEXt" && chunk.kind != "zTXt" && chunk.kind != "iTXt" {
+		return "", ""
+	}
+
+	keyword, rest, found := bytes.Cut(chunk.data, []byte{0})
+	if !found {
+		return "", ""
+	}
+
+	switch chunk.kind {
+	case "tEXt":
+		return string(keyword), string(rest)
+	case "iTXt":
+		// compression flag, method, language\0, translated keyword\0
+		if len(rest) >= 2 && rest[0] == 0 {
+			if _, afterLanguage, ok := bytes.Cut(rest[2:], []byte{0}); ok {
+				if _, text, ok := bytes.Cut(afterLanguage, []byte{0}); ok {
+					return string(keyword), string(text)
+				}
+			}
+		}
+	}
+
+	return string(keyword), ""
+}
+
+// orientPNG re-encodes result with its orientation applied. PNG is
+// lossless, so only the chunk layout changes; colour and text chunks
+// of the original are carried over.
+func orientPNG(result *Result, kept []pngChunk) error {
+	img, err := png.Decode(bytes.NewReader(result.Data))
+	if err != nil {
+		return malformed("png", err.Error())
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, orient(img, result.Record.Orientation)); err != nil {
+		return err
+	}
+
+	// The encoder's output starts with the signature and IHDR, after
+	// which the carried chunks may go.
+	headerEnd := len(pngSignature) + 12 + int(binary.BigEndian.Uint32(encoded.Bytes()[len(pngSignature):]))
+
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:headerEnd])
+	for _, chunk := range kept {
+		if !pngCarriedChunks[chunk.kind] {
+			continue
+		}
+		if chunk.kind == "pHYs" && result.Record.Orientation >= 5 && len(chunk.data) == 9 {
+			out.Write(transposedPHYs(chunk.data))
+			continue
+		}
+		out.Write(chunk.raw)
+	}
+	out.Write(encoded.Bytes()[headerEnd:])
+
+	result.Data = out.Bytes()
+	result.Rotated = true
+	return nil
+}
+
+// transposedPHYs swaps the horizontal and vertical pixel density for
+// an image turned by 90 degrees.
+func transposedPHYs(data []byte) []byte {
+	swapped := append(append(append([]byte(nil), data[4:8]...), data[0:4]...), data[8])
+	return pngChunkBytes("pHYs", swapped)
+}
+
+func pngChunkBytes(kind string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
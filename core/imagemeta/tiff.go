@@ -0,0 +1,236 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	tagOrientation = 0x0112
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+	tagInteropIFD  = 0xA005
+	tagMakerNote   = 0x927C
+
+	// Longer values and tags with more entries are left out of the
+	// record; they are maker blobs and thumbnails, not fields.
+	maxRecordedBytes  = 256
+	maxRecordedValues = 16
+)
+
+var ifd0Tags = map[uint16]string{
+	0x010E: "ImageDescription",
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013B: "Artist",
+	0x8298: "Copyright",
+}
+
+var exifTags = map[uint16]string{
+	0x829A: "ExposureTime",
+	0x829D: "FNumber",
+	0x8827: "ISOSpeedRatings",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9010: "OffsetTime",
+	0x920A: "FocalLength",
+	0x9286: "UserComment",
+	0xA420: "ImageUniqueID",
+	0xA430: "CameraOwnerName",
+	0xA431: "BodySerialNumber",
+	0xA433: "LensMake",
+	0xA434: "LensModel",
+	0xA435: "LensSerialNumber",
+}
+
+var gpsTags = map[uint16]string{
+	0x0001: "GPSLatitudeRef",
+	0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude",
+	0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude",
+	0x0007: "GPSTimeStamp",
+	0x0011: "GPSImgDirection",
+	0x001D: "GPSDateStamp",
+}
+
+// typeSizes is the size of one value of each TIFF field type.
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+type tiffReader struct {
+	data    []byte
+	order   binary.ByteOrder
+	fields  map[string]string
+	visited map[uint32]bool
+
+	orientation int
+}
+
+// parseTIFF reads the fields worth recording from an EXIF TIFF block
+// and its orientation. Damaged blocks yield what could be read; the
+// block is removed either way.
+func parseTIFF(data []byte) (map[string]string, int) {
+	reader := &tiffReader{data: data, fields: map[string]string{}, visited: map[uint32]bool{}}
+	if len(data) < 8 {
+		return reader.fields, 0
+	}
+
+	switch string(data[:2]) {
+	case "II":
+		reader.order = binary.LittleEndian
+	case "MM":
+		reader.order = binary.BigEndian
+	default:
+		return reader.fields, 0
+	}
+
+	reader.readIFD(reader.order.Uint32(data[4:]), "IFD0", ifd0Tags)
+	return reader.fields, reader.orientation
+}
+
+func (r *tiffReader) readIFD(offset uint32, name string, names map[uint16]string) {
+	if r.visited[offset] || int(offset)+2 > len(r.data) {
+		return
+	}
+	r.visited[offset] = true
+
+	count := int(r.order.Uint16(r.data[offset:]))
+	for i := 0; i < count; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(r.data) {
+			return
+		}
+
+		tag := r.order.Uint16(r.data[entry:])
+		fieldType := r.order.Uint16(r.data[entry+2:])
+		valueCount := int(r.order.Uint32(r.data[entry+4:]))
+
+		size, known := typeSizes[fieldType]
+		if !known || valueCount <= 0 || valueCount > len(r.data) {
+			continue
+		}
+
+		value := r.data[entry+8 : entry+12]
+		if total := size * valueCount; total > 4 {
+			start := int(r.order.Uint32(value))
+			if start < 0 || start+total > len(r.data) {
+				continue
+			}
+			value = r.data[start : start+total]
+		} else {
+			value = value[:total]
+		}
+
+		switch tag {
+		case tagExifIFD:
+			r.readIFD(r.order.Uint32(value), "Exif", exifTags)
+			continue
+		case tagGPSIFD:
+			if name == "IFD0" {
+				r.readIFD(r.order.Uint32(value), "GPS", gpsTags)
+			}
+			continue
+		case tagInteropIFD, tagMakerNote:
+			continue
+		}
+
+		if tag == tagOrientation && name == "IFD0" && fieldType == 3 {
+			r.orientation = int(r.order.Uint16(value))
+		}
+
+		formatted, ok := r.format(fieldType, size, value)
+		if !ok {
+			continue
+		}
+
+		key, found := names[tag]
+		if !found {
+			key = fmt.Sprintf("%s.0x%04X", name, tag)
+		}
+		r.fields[key] = formatted
+	}
+}
+
+func (r *tiffReader) format(fieldType uint16, size int, value []byte) (string, bool) {
+	switch fieldType {
+	case 2:
+		text := strings.TrimRight(string(value), "\x00 ")
+		return text, len(text) <= maxRecordedBytes
+	case 1, 6, 7:
+		if len(value) > maxRecordedBytes {
+			return "", false
+		}
+		if text := strings.TrimRight(string(value), "\x00 "); isPrintable(text) {
+			return text, true
+		}
+		return hex.EncodeToString(value), true
+	}
+
+	count := len(value) / size
+	if count > maxRecordedValues {
+		return "", false
+	}
+
+	values := make([]string, count)
+	for i := range values {
+		item := value[i*size : (i+1)*size]
+		switch fieldType {
+		case 3:
+			values[i] = strconv.Itoa(int(r.order.Uint16(item)))
+		case 8:
+			values[i] = strconv.Itoa(int(int16(r.order.Uint16(item))))
+		case 4:
+			values[i] = strconv.FormatUint(uint64(r.order.Uint32(item)), 10)
+		case 9:
+			values[i] = strconv.Itoa(int(int32(r.order.Uint32(item))))
+		case 5:
+			values[i] = fmt.Sprintf("%d/%d", r.order.Uint32(item), r.order.Uint32(item[4:]))
+		case 10:
+			values[i] = fmt.Sprintf("%d/%d", int32(r.order.Uint32(item)), int32(r.order.Uint32(item[4:])))
+		default:
+			return "", false
+		}
+	}
+
+	return strings.Join(values, " "), true
+}
+
+func isPrintable(text string) bool {
+	for _, r := range text {
+		if r < 0x20 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// orientationTIFF is an EXIF TIFF block holding nothing but the
+// orientation, for images whose orientation can't be applied.
+func orientationTIFF(orientation int) []byte {
+	block := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // header, IFD0 at 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, // Orientation, SHORT, 1 value
+		0x00, 0x00, 0x00, 0x00, // value
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	binary.BigEndian.PutUint16(block[18:], uint16(orientation))
+	return block
+}
+
+// emptyTIFF is an EXIF TIFF block without entries.
+func emptyTIFF() []byte {
+	return []byte{'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+}
+
+// needsOrienting reports an orientation other than "upright".
+func needsOrienting(orientation int) bool {
+	return orientation >= 2 && orientation <= 8
+}
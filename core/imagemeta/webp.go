@@ -0,0 +1,87 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// VP8X feature flags.
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebP drops the EXIF and XMP chunks and clears their VP8X
+// flags. WebP can't be re-encoded here, so an orientation other than
+// upright survives as a minimal EXIF chunk.
+func stripWebP(data []byte) (*Result, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, malformed("webp", "missing RIFF/WEBP header")
+	}
+
+	result := &Result{}
+	var chunks [][]byte
+	vp8x := -1
+
+	position := 12
+	for position < len(data) {
+		if position+8 > len(data) {
+			return nil, malformed("webp", "truncated chunk")
+		}
+
+		kind := string(data[position : position+4])
+		size := int(binary.LittleEndian.Uint32(data[position+4:]))
+		end := position + 8 + size + size%2
+		if size < 0 || end > len(data) {
+			// Some encoders omit the final padding byte.
+			if position+8+size != len(data) {
+				return nil, malformed("webp", "chunk overruns the file")
+			}
+			end = len(data)
+		}
+		payload := data[position+8 : position+8+size]
+		chunk := data[position:end]
+		position = end
+
+		switch kind {
+		case "EXIF":
+			result.Record.Fields, result.Record.Orientation = parseTIFF(bytes.TrimPrefix(payload, exifHeader))
+		case "XMP ":
+			result.Record.XMP = string(payload)
+		default:
+			if kind == "VP8X" {
+				vp8x = len(chunks)
+			}
+			chunks = append(chunks, append([]byte(nil), chunk...))
+			continue
+		}
+		result.Stripped = true
+	}
+
+	if !result.Stripped {
+		return result, nil
+	}
+
+	if vp8x >= 0 && len(chunks[vp8x]) > 8 {
+		chunks[vp8x][8] &^= webpFlagEXIF | webpFlagXMP
+	}
+
+	if needsOrienting(result.Record.Orientation) && vp8x >= 0 {
+		exif := orientationTIFF(result.Record.Orientation)
+		chunk := append([]byte("EXIF"), binary.LittleEndian.AppendUint32(nil, uint32(len(exif)))...)
+		chunks = append(chunks, append(chunk, exif...))
+		chunks[vp8x][8] |= webpFlagEXIF
+	}
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	out.Write([]byte{0, 0, 0, 0})
+	out.WriteString("WEBP")
+	for _, chunk := range chunks {
+		out.Write(chunk)
+	}
+
+	result.Data = out.Bytes()
+	binary.LittleEndian.PutUint32(result.Data[4:], uint32(len(result.Data)-8))
+	return result, nil
+}
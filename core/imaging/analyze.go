@@ -0,0 +1,133 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+
+	"github.com/RodolfoBonis/rb-cdn/core/blurhash"
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+)
+
+// Analyses are recorded on objects as user metadata.
+const (
+	WidthMetadata    = "Image-Width"
+	HeightMetadata   = "Image-Height"
+	ColorMetadata    = "Image-Color"
+	BlurHashMetadata = "Image-Blurhash"
+	DHashMetadata    = "Image-Dhash"
+)
+
+// thumbnailSize bounds the thumbnail colours and BlurHashes are worked
+// out from: they only carry a few dozen pixels' worth of detail.
+const thumbnailSize = 32
+
+// Analyze works out data's upright size, dominant colour, BlurHash and
+// DHash.
+// Sources with more than maxPixels pixels are refused before being
+// decoded.
+func Analyze(data []byte, maxPixels int64) (*entities.ImageAnalysisEntity, error) {
+	img, _, err := decode(data, maxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	crop, thumbnailWidth, thumbnailHeight := Params{Width: thumbnailSize, Height: thumbnailSize, Fit: FitContain}.layout(width, height)
+	thumbnail := resample(img, crop, thumbnailWidth, thumbnailHeight)
+
+	// Transparent images are shown over whatever the page has; their
+	// placeholder assumes white, like their JPEG variants.
+	xComponents, yComponents := 4, 3
+	if height > width {
+		xComponents, yComponents = 3, 4
+	}
+	hash, err := blurhash.Encode(flatten(thumbnail), xComponents, yComponents)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.ImageAnalysisEntity{
+		Width:         width,
+		Height:        height,
+		DominantColor: dominantColor(thumbnail),
+		BlurHash:      hash,
+		DHash:         FormatHash(DHash(img)),
+	}, nil
+}
+
+// dominantColor returns the average of the most common of img's
+// colours, binned to 4 bits a channel. Mostly transparent pixels don't
+// count, unless there is nothing else.
+func dominantColor(img *image.RGBA) string {
+	type bin struct {
+		count   int
+		r, g, b int
+	}
+
+	tally := func(img *image.RGBA, minAlpha uint8) *bin {
+		bins := map[int]*bin{}
+		var best *bin
+		for i := 0; i+3 < len(img.Pix); i += 4 {
+			r, g, b, a := img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]
+			if a < minAlpha || a == 0 {
+				continue
+			}
+			// Pixels are premultiplied.
+			r, g, b = uint8(int(r)*255/int(a)), uint8(int(g)*255/int(a)), uint8(int(b)*255/int(a))
+
+			index := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			entry := bins[index]
+			if entry == nil {
+				entry = &bin{}
+				bins[index] = entry
+			}
+			entry.count++
+			entry.r, entry.g, entry.b = entry.r+int(r), entry.g+int(g), entry.b+int(b)
+			if best == nil || entry.count > best.count {
+				best = entry
+			}
+		}
+		return best
+	}
+
+	best := tally(img, 128)
+	if best == nil {
+		best = tally(flatten(img), 0)
+	}
+	if best == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
+
+// AnalysisMetadata is analysis as the user metadata recording it.
+func AnalysisMetadata(analysis entities.ImageAnalysisEntity) map[string]string {
+	return map[string]string{
+		WidthMetadata:    strconv.Itoa(analysis.Width),
+		HeightMetadata:   strconv.Itoa(analysis.Height),
+		ColorMetadata:    analysis.DominantColor,
+		BlurHashMetadata: analysis.BlurHash,
+		DHashMetadata:    analysis.DHash,
+	}
+}
+
+// AnalysisFromMetadata reads back the analysis recorded on an object,
+// or returns nil for one never analysed.
+func AnalysisFromMetadata(metadata http.Header) *entities.ImageAnalysisEntity {
+	hash := metadata.Get("X-Amz-Meta-" + BlurHashMetadata)
+	if hash == "" {
+		return nil
+	}
+
+	width, _ := strconv.Atoi(metadata.Get("X-Amz-Meta-" + WidthMetadata))
+	height, _ := strconv.Atoi(metadata.Get("X-Amz-Meta-" + HeightMetadata))
+	return &entities.ImageAnalysisEntity{
+		Width:         width,
+		Height:        height,
+		DominantColor: metadata.Get("X-Amz-Meta-" + ColorMetadata),
+		BlurHash:      hash,
+		DHash:         metadata.Get("X-Amz-Meta-" + DHashMetadata),
+	}
+}
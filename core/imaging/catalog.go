@@ -0,0 +1,121 @@
+package imaging
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+)
+
+// Catalog keeps the analyses recorded on images where they can be
+// queried across a bucket, which object metadata can't be. The
+// metadata stays the source of truth: the catalog is written after
+// it, and rebuilt by analysing the bucket again.
+type Catalog interface {
+	Save(bucket string, key string, analysis entities.ImageAnalysisEntity) *errors.AppError
+	Remove(bucket string, key string) *errors.AppError
+}
+
+var (
+	defaultCatalog     Catalog
+	defaultCatalogOnce sync.Once
+)
+
+// DefaultCatalog is the catalog IMAGE_CATALOG selects, nil when none
+// is, built on first use. A Postgres catalog it can't set up is
+// fatal, as other boot-time misconfigurations are.
+func DefaultCatalog() Catalog {
+	defaultCatalogOnce.Do(func() {
+		catalog, appErr := newCatalog(config.EnvImageCatalog())
+		if appErr != nil {
+			logger.Log.Error(appErr.Message, appErr.ToMap())
+			panic(appErr.Message)
+		}
+
+		defaultCatalog = catalog
+	})
+
+	return defaultCatalog
+}
+
+func newCatalog(kind string) (Catalog, *errors.AppError) {
+	switch kind {
+	case "":
+		return nil, nil
+	case "postgres":
+		if services.Connector == nil {
+			if appErr := services.OpenConnection(); appErr != nil {
+				return nil, appErr
+			}
+		}
+
+		catalog, appErr := NewPostgresCatalog()
+		if appErr != nil {
+			return nil, appErr
+		}
+		return catalog, nil
+	default:
+		return nil, errors.EnvironmentError("IMAGE_CATALOG must be empty or postgres")
+	}
+}
+
+// PostgresCatalog keeps analyses in the image_analyses table through
+// the DBService connection, one row per object.
+type PostgresCatalog struct{}
+
+type imageAnalysisRow struct {
+	Bucket        string    `gorm:"primary_key;type:varchar(255)"`
+	Key           string    `gorm:"column:object_key;primary_key;type:varchar(1024)"`
+	Width         int       `gorm:"not null"`
+	Height        int       `gorm:"not null"`
+	DominantColor string    `gorm:"type:varchar(7);not null"`
+	BlurHash      string    `gorm:"column:blurhash;type:varchar(255);not null"`
+	DHash         string    `gorm:"column:dhash;type:varchar(16);not null;index"`
+	UpdatedAt     time.Time `gorm:"not null"`
+}
+
+func (imageAnalysisRow) TableName() string {
+	return "image_analyses"
+}
+
+// NewPostgresCatalog creates the table when it is missing. The
+// DBService connection must already be open.
+func NewPostgresCatalog() (*PostgresCatalog, *errors.AppError) {
+	if err := services.Connector.AutoMigrate(&imageAnalysisRow{}).Error; err != nil {
+		return nil, errors.DatabaseError(err.Error())
+	}
+
+	return &PostgresCatalog{}, nil
+}
+
+// Save inserts the analysis of key, or replaces the one catalogued.
+func (c *PostgresCatalog) Save(bucket string, key string, analysis entities.ImageAnalysisEntity) *errors.AppError {
+	row := imageAnalysisRow{
+		Bucket:        bucket,
+		Key:           key,
+		Width:         analysis.Width,
+		Height:        analysis.Height,
+		DominantColor: analysis.DominantColor,
+		BlurHash:      analysis.BlurHash,
+		DHash:         analysis.DHash,
+		UpdatedAt:     time.Now().UTC(),
+	}
+	if err := services.Connector.Save(&row).Error; err != nil {
+		return errors.DatabaseError(err.Error())
+	}
+
+	return nil
+}
+
+func (c *PostgresCatalog) Remove(bucket string, key string) *errors.AppError {
+	err := services.Connector.Where("bucket = ? AND object_key = ?", bucket, key).Delete(&imageAnalysisRow{}).Error
+	if err != nil {
+		return errors.DatabaseError(err.Error())
+	}
+
+	return nil
+}
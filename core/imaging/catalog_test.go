@@ -0,0 +1,64 @@
+package imaging
+
+import (
+	"testing"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSQLiteCatalog points the DBService connection at an in-memory
+// SQLite database for the duration of the test.
+func newSQLiteCatalog(t *testing.T) *PostgresCatalog {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	// Every connection to ":memory:" opens a database of its own.
+	db.DB().SetMaxOpenConns(1)
+
+	previous := services.Connector
+	services.Connector = db
+	t.Cleanup(func() {
+		services.Connector = previous
+		db.Close()
+	})
+
+	catalog, appErr := NewPostgresCatalog()
+	require.Nil(t, appErr)
+	return catalog
+}
+
+func TestPostgresCatalog(t *testing.T) {
+	catalog := newSQLiteCatalog(t)
+	analysis := entities.ImageAnalysisEntity{Width: 640, Height: 480, DominantColor: "#336699", BlurHash: "LEHV6nWB2yk8", DHash: "f0e1d2c3b4a59687"}
+
+	assert.Nil(t, catalog.Save("media", "photos/a.jpg", analysis))
+	analysis.Width = 320
+	assert.Nil(t, catalog.Save("media", "photos/a.jpg", analysis), "saving again replaces the row")
+	assert.Nil(t, catalog.Save("other", "photos/a.jpg", analysis))
+
+	var rows []imageAnalysisRow
+	require.NoError(t, services.Connector.Where("bucket = ?", "media").Find(&rows).Error)
+	require.Len(t, rows, 1)
+	assert.Equal(t, 320, rows[0].Width)
+	assert.Equal(t, "f0e1d2c3b4a59687", rows[0].DHash)
+
+	assert.Nil(t, catalog.Remove("media", "photos/a.jpg"))
+	var count int
+	require.NoError(t, services.Connector.Model(&imageAnalysisRow{}).Count(&count).Error)
+	assert.Equal(t, 1, count, "only the other bucket's row is left")
+}
+
+func TestNewCatalog(t *testing.T) {
+	catalog, appErr := newCatalog("")
+	assert.Nil(t, appErr)
+	assert.Nil(t, catalog)
+
+	_, appErr = newCatalog("redis")
+	assert.NotNil(t, appErr)
+}
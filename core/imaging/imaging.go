@@ -0,0 +1,258 @@
+// Package imaging derives image variants: it resizes, crops, rotates
+// and re-encodes JPEG, PNG, GIF and WebP images in pure Go, after
+// turning them upright according to their EXIF orientation.
+//
+// Variants are described by Params, parsed from the query string of an
+// /img request and bounded so a caller can't make the service allocate
+// arbitrary amounts of memory. When a signing key is configured the
+// query must also carry an HMAC of its canonical form (see Sign).
+package imaging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	stdErrors "errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+)
+
+// VariantPrefix is where derived variants are cached, one folder per
+// source key.
+const VariantPrefix = ".variants/"
+
+// DefaultQuality is the JPEG quality of variants that don't ask for
+// one.
+const DefaultQuality = 80
+
+// Fit modes: how an image is made to fit a width and a height.
+const (
+	// FitContain scales the image to fit inside the box, keeping its
+	// aspect ratio.
+	FitContain = "contain"
+	// FitCover scales the image to fill the box, keeping its aspect
+	// ratio, and crops what overflows around the centre.
+	FitCover = "cover"
+	// FitFill stretches the image to the box.
+	FitFill = "fill"
+)
+
+var (
+	// ErrInvalid is returned for parameters out of bounds or unknown.
+	ErrInvalid = stdErrors.New("invalid image parameters")
+	// ErrSignature is returned when a signing key is configured and
+	// the signature is missing or doesn't match.
+	ErrSignature = stdErrors.New("invalid image signature")
+	// ErrUnsigned is returned, without a signing key, for parameters
+	// that aren't one of the bucket's presets.
+	ErrUnsigned = stdErrors.New("image parameters must match a preset of the bucket unless IMAGE_SIGNING_KEY is set")
+	// ErrTooLarge is returned for sources over the pixel limit.
+	ErrTooLarge = stdErrors.New("image too large to transform")
+	// ErrUnsupported is returned for sources that aren't a still JPEG,
+	// PNG, GIF or WebP image.
+	ErrUnsupported = stdErrors.New("unsupported image format")
+)
+
+// formats are the encodings a variant can be written in, by the name
+// package image registers them under.
+var formats = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// ContentType returns the media type of format, or "" for one
+// variants can't be written in.
+func ContentType(format string) string {
+	return formats[format]
+}
+
+// Decodable reports whether sources of contentType can be transformed:
+// the formats variants are written in. Animated WebP is only told
+// apart once decoded.
+func Decodable(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, candidate := range formats {
+		if candidate == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// Params describe a variant. Zero values mean "as the source": no
+// resizing, the source's format, no rotation.
+type Params struct {
+	Width  int
+	Height int
+	Fit    string
+	Format string
+	// Quality only applies to JPEG; WebP variants are lossless.
+	Quality int
+	// Rotate turns the image clockwise, after its EXIF orientation was
+	// applied: 0, 90, 180 or 270.
+	Rotate int
+	// Signature is the sig parameter, checked by Verify.
+	Signature string
+}
+
+// PresetParams returns the parameters of a bucket's named preset.
+func PresetParams(preset entities.ImagePresetEntity) Params {
+	params := Params{
+		Width:   preset.Width,
+		Height:  preset.Height,
+		Fit:     preset.Fit,
+		Format:  preset.Format,
+		Quality: preset.Quality,
+	}
+	if params.Fit == "" {
+		params.Fit = FitContain
+	}
+	return params
+}
+
+// Size returns the size of the variant params derive from a width x
+// height source.
+func (p Params) Size(width int, height int) (int, int) {
+	_, variantWidth, variantHeight := p.layout(width, height)
+	return variantWidth, variantHeight
+}
+
+// ParseParams reads w, h, fit, fmt, q, rot and sig from query. Widths
+// and heights go up to maxDimension; other parameters are ignored.
+func ParseParams(query url.Values, maxDimension int) (Params, error) {
+	params := Params{Fit: FitContain, Signature: query.Get("sig")}
+
+	integer := func(name string, low int, high int) (int, error) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < low || number > high {
+			return 0, fmt.Errorf("%w: %s must be between %d and %d", ErrInvalid, name, low, high)
+		}
+		return number, nil
+	}
+
+	var err error
+	if params.Width, err = integer("w", 1, maxDimension); err != nil {
+		return Params{}, err
+	}
+	if params.Height, err = integer("h", 1, maxDimension); err != nil {
+		return Params{}, err
+	}
+	if params.Quality, err = integer("q", 1, 100); err != nil {
+		return Params{}, err
+	}
+	if params.Rotate, err = integer("rot", 0, 270); err != nil || params.Rotate%90 != 0 {
+		return Params{}, fmt.Errorf("%w: rot must be 0, 90, 180 or 270", ErrInvalid)
+	}
+
+	if fit := query.Get("fit"); fit != "" {
+		if fit != FitContain && fit != FitCover && fit != FitFill {
+			return Params{}, fmt.Errorf("%w: fit must be contain, cover or fill", ErrInvalid)
+		}
+		params.Fit = fit
+	}
+
+	if format := strings.ToLower(query.Get("fmt")); format != "" {
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if ContentType(format) == "" {
+			return Params{}, fmt.Errorf("%w: fmt must be jpeg, png, gif or webp", ErrInvalid)
+		}
+		params.Format = format
+	}
+
+	return params, nil
+}
+
+// Canonical is the normal form of params, which signatures cover and
+// variants are cached under: the parameters set, defaults left out,
+// sorted by name. "fit=cover&h=200&w=200" is one. The quality is left
+// out unless the variant may be a JPEG, as it changes no other format.
+func (p Params) Canonical() string {
+	values := url.Values{}
+	set := func(name string, value int) {
+		if value != 0 {
+			values.Set(name, strconv.Itoa(value))
+		}
+	}
+
+	set("w", p.Width)
+	set("h", p.Height)
+	if p.Format == "" || p.Format == "jpeg" {
+		set("q", p.Quality)
+	}
+	set("rot", p.Rotate)
+	if p.Fit != "" && p.Fit != FitContain {
+		values.Set("fit", p.Fit)
+	}
+	if p.Format != "" {
+		values.Set("fmt", p.Format)
+	}
+
+	return values.Encode()
+}
+
+// Sign returns the sig parameter for a variant of bucket/key: the
+// unpadded base64url HMAC-SHA256, under signingKey, of
+// "<bucket>/<key>?<canonical params>".
+func Sign(signingKey []byte, bucket string, key string, params Params) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(bucket + "/" + key + "?" + params.Canonical()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of params. Without a signing key nothing
+// can be signed, so only the source re-encoded and the bucket's presets
+// are allowed: any other parameters would let every reader fill the
+// bucket with variants.
+func Verify(signingKey []byte, bucket string, key string, params Params, presets map[string]entities.ImagePresetEntity) error {
+	if len(signingKey) == 0 {
+		canonical := params.Canonical()
+		if canonical == "" {
+			return nil
+		}
+		for _, preset := range presets {
+			if PresetParams(preset).Canonical() == canonical {
+				return nil
+			}
+		}
+		return ErrUnsigned
+	}
+
+	expected := Sign(signingKey, bucket, key, params)
+	if !hmac.Equal([]byte(expected), []byte(params.Signature)) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// VariantKey is where the variant params describe of key is cached.
+// version identifies the source's content, its ETag as /cdn sends it
+// (quoted), so a source overwritten with other content gets new
+// variants; the stale ones are left to the janitor. Jobs storing
+// variants of their own lay them out the same way: VariantPrefix, the
+// hex SHA-256 of key, "/", the hex SHA-256 of version, "?" and the
+// canonical params ("fmt=avif").
+func VariantKey(key string, version string, params Params) string {
+	variant := sha256.Sum256([]byte(version + "?" + params.Canonical()))
+	return VariantFolder(key) + hex.EncodeToString(variant[:])
+}
+
+// VariantFolder is the folder every variant of key is cached in,
+// whatever the version of key it was derived from.
+func VariantFolder(key string) string {
+	folder := sha256.Sum256([]byte(key))
+	return VariantPrefix + hex.EncodeToString(folder[:]) + "/"
+}
@@ -0,0 +1,317 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func TestParseParams(t *testing.T) {
+	query, _ := url.ParseQuery("w=200&h=100&fit=cover&fmt=JPG&q=70&rot=90&sig=abc&token=x")
+	params, err := ParseParams(query, 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, Params{Width: 200, Height: 100, Fit: FitCover, Format: "jpeg", Quality: 70, Rotate: 90, Signature: "abc"}, params)
+
+	params, err = ParseParams(url.Values{}, 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, Params{Fit: FitContain}, params)
+
+	for _, raw := range []string{"w=0", "w=4097", "h=-1", "w=abc", "q=101", "rot=45", "rot=360", "fit=stretch", "fmt=bmp"} {
+		query, _ := url.ParseQuery(raw)
+		_, err := ParseParams(query, 4096)
+		assert.ErrorIs(t, err, ErrInvalid, raw)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	assert.Equal(t, "", Params{Fit: FitContain}.Canonical())
+	assert.Equal(t, "fit=cover&fmt=jpeg&h=100&q=70&rot=270&w=200",
+		Params{Width: 200, Height: 100, Fit: FitCover, Format: "jpeg", Quality: 70, Rotate: 270, Signature: "ignored"}.Canonical())
+
+	// The quality only matters to JPEG variants.
+	assert.Equal(t, "fmt=webp&w=200", Params{Width: 200, Format: "webp", Quality: 70}.Canonical())
+	assert.Equal(t, "q=70&w=200", Params{Width: 200, Quality: 70}.Canonical(), "the source may be a JPEG")
+}
+
+func TestSignature(t *testing.T) {
+	key := []byte("secret")
+	params := Params{Width: 200, Fit: FitContain}
+
+	params.Signature = Sign(key, "photos", "a/b.jpg", params)
+	assert.NoError(t, Verify(key, "photos", "a/b.jpg", params, nil))
+	assert.ErrorIs(t, Verify(key, "photos", "a/c.jpg", params, nil), ErrSignature)
+	assert.ErrorIs(t, Verify(key, "photos", "a/b.jpg", Params{Width: 300, Signature: params.Signature}, nil), ErrSignature)
+	assert.ErrorIs(t, Verify(key, "photos", "a/b.jpg", Params{Width: 200}, nil), ErrSignature)
+}
+
+func TestVerifyWithoutKey(t *testing.T) {
+	presets := map[string]entities.ImagePresetEntity{
+		"thumb": {Width: 320, Format: "webp"},
+	}
+
+	assert.NoError(t, Verify(nil, "photos", "a/b.jpg", Params{Fit: FitContain}, presets), "the source re-encoded")
+	assert.NoError(t, Verify(nil, "photos", "a/b.jpg", Params{Width: 320, Fit: FitContain, Format: "webp"}, presets))
+	assert.NoError(t, Verify(nil, "photos", "a/b.jpg", Params{Width: 320, Format: "webp", Quality: 50}, presets), "quality means nothing to WebP")
+	assert.ErrorIs(t, Verify(nil, "photos", "a/b.jpg", Params{Width: 321, Format: "webp"}, presets), ErrUnsigned)
+	assert.ErrorIs(t, Verify(nil, "photos", "a/b.jpg", Params{Width: 320}, nil), ErrUnsigned)
+}
+
+func TestVariantKey(t *testing.T) {
+	key := VariantKey("a/b.jpg", `"etag"`, Params{Width: 200, Fit: FitContain, Signature: "x"})
+	assert.Regexp(t, `^\.variants/[0-9a-f]{64}/[0-9a-f]{64}$`, key)
+
+	assert.Equal(t, key, VariantKey("a/b.jpg", `"etag"`, Params{Width: 200}), "same canonical form")
+	assert.NotEqual(t, key, VariantKey("a/b.jpg", `"other"`, Params{Width: 200}))
+	assert.NotEqual(t, key, VariantKey("a/b.jpg", `"etag"`, Params{Width: 201}))
+	assert.Equal(t, path.Dir(key), path.Dir(VariantKey("a/b.jpg", `"other"`, Params{Height: 5})), "one folder per key")
+	assert.True(t, strings.HasPrefix(key, VariantFolder("a/b.jpg")))
+}
+
+func TestNegotiate(t *testing.T) {
+	chrome := "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+	assert.Equal(t, []string{"avif", "webp"}, Negotiate(chrome, []string{"avif", "webp"}))
+	assert.Equal(t, []string{"webp", "avif"}, Negotiate(chrome, []string{"webp", "avif"}), "the bucket's preference wins")
+	assert.Equal(t, []string{"webp"}, Negotiate("image/webp;q=0.9, image/avif;q=0", []string{"avif", "webp"}))
+	assert.Empty(t, Negotiate("image/*,*/*", []string{"avif", "webp"}))
+	assert.Empty(t, Negotiate("", []string{"webp"}))
+
+	assert.True(t, NegotiableSource("image/png"))
+	assert.True(t, NegotiableSource("image/jpeg; charset=binary"))
+	assert.False(t, NegotiableSource("image/webp"))
+	assert.False(t, NegotiableSource("image/svg+xml"))
+
+	assert.True(t, Derivable("image/png", "webp"))
+	assert.True(t, Derivable("image/gif", "webp"))
+	assert.False(t, Derivable("image/jpeg", "webp"))
+	assert.False(t, Derivable("image/png", "avif"))
+}
+
+func TestLayout(t *testing.T) {
+	for name, test := range map[string]struct {
+		params        Params
+		crop          image.Rectangle
+		width, height int
+	}{
+		"original":           {Params{}, image.Rect(0, 0, 400, 200), 400, 200},
+		"width":              {Params{Width: 100}, image.Rect(0, 0, 400, 200), 100, 50},
+		"height":             {Params{Height: 50}, image.Rect(0, 0, 400, 200), 100, 50},
+		"no upscaling":       {Params{Width: 800}, image.Rect(0, 0, 400, 200), 400, 200},
+		"contain":            {Params{Width: 100, Height: 100, Fit: FitContain}, image.Rect(0, 0, 400, 200), 100, 50},
+		"fill":               {Params{Width: 100, Height: 100, Fit: FitFill}, image.Rect(0, 0, 400, 200), 100, 100},
+		"cover":              {Params{Width: 100, Height: 100, Fit: FitCover}, image.Rect(100, 0, 300, 200), 100, 100},
+		"cover beyond size":  {Params{Width: 1000, Height: 1000, Fit: FitCover}, image.Rect(100, 0, 300, 200), 200, 200},
+		"cover tall":         {Params{Width: 100, Height: 400, Fit: FitCover}, image.Rect(175, 0, 225, 200), 50, 200},
+		"at least one pixel": {Params{Width: 1}, image.Rect(0, 0, 400, 200), 1, 1},
+	} {
+		crop, width, height := test.params.layout(400, 200)
+		assert.Equal(t, test.crop, crop, name)
+		assert.Equal(t, test.width, width, name)
+		assert.Equal(t, test.height, height, name)
+	}
+}
+
+func TestPresetParams(t *testing.T) {
+	params := PresetParams(entities.ImagePresetEntity{Width: 320, Format: "webp"})
+	assert.Equal(t, Params{Width: 320, Fit: FitContain, Format: "webp"}, params)
+	assert.Equal(t, "fmt=webp&w=320", params.Canonical())
+
+	width, height := params.Size(1200, 800)
+	assert.Equal(t, 320, width)
+	assert.Equal(t, 213, height)
+
+	width, height = params.Size(200, 100)
+	assert.Equal(t, 200, width, "presets don't scale up")
+	assert.Equal(t, 100, height)
+
+	assert.True(t, Decodable("image/png"))
+	assert.True(t, Decodable("image/webp"))
+	assert.False(t, Decodable("image/avif"))
+	assert.False(t, Decodable("application/pdf"))
+}
+
+func TestResampleAverages(t *testing.T) {
+	// Black and white columns shrink to grey.
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{A: 255})
+			if x%2 == 1 {
+				img.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+	}
+
+	result := resample(img, img.Bounds(), 2, 1)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), result.Bounds())
+	for x := 0; x < 2; x++ {
+		pixel := result.RGBAAt(x, 0)
+		assert.InDelta(t, 128, int(pixel.R), 16, "averaged, not sampled")
+		assert.Equal(t, uint8(255), pixel.A)
+	}
+
+	// Transparent pixels don't darken their opaque neighbours.
+	img = image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	result = resample(img, img.Bounds(), 1, 1)
+	pixel := color.NRGBAModel.Convert(result.At(0, 0)).(color.NRGBA)
+	assert.Equal(t, uint8(255), pixel.R)
+	assert.InDelta(t, 128, int(pixel.A), 1)
+}
+
+// halves is 40x20, red on the left and blue on the right.
+func halves() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+			if x >= 20 {
+				img.SetNRGBA(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestTransform(t *testing.T) {
+	var source bytes.Buffer
+	assert.NoError(t, png.Encode(&source, halves()))
+
+	result, err := Transform(source.Bytes(), Params{Width: 10, Format: "jpeg"}, 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", result.ContentType)
+	img, err := jpeg.Decode(bytes.NewReader(result.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 5), img.Bounds())
+
+	// Turned clockwise, the red half ends up on top.
+	result, err = Transform(source.Bytes(), Params{Rotate: 90, Format: "webp"}, 1<<20)
+	assert.NoError(t, err)
+	img, err = webp.Decode(bytes.NewReader(result.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, img.At(10, 5))
+	assert.Equal(t, color.NRGBA{B: 255, A: 255}, img.At(10, 35))
+
+	// The source's format is kept.
+	var still bytes.Buffer
+	assert.NoError(t, gif.Encode(&still, halves(), nil))
+	result, err = Transform(still.Bytes(), Params{Height: 10, Fit: FitContain}, 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, "gif", result.Format)
+	assert.Equal(t, 20, result.Width)
+
+	_, err = Transform(source.Bytes(), Params{}, 799)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = Transform([]byte("not an image"), Params{}, 1<<20)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestAnalyze(t *testing.T) {
+	// Three quarters red, the rest transparent.
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 30; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var source bytes.Buffer
+	assert.NoError(t, png.Encode(&source, img))
+
+	analysis, err := Analyze(source.Bytes(), 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, 40, analysis.Width)
+	assert.Equal(t, 20, analysis.Height)
+	assert.Equal(t, "#ff0000", analysis.DominantColor)
+	assert.Len(t, analysis.BlurHash, 28, "4x3 components")
+
+	header := http.Header{}
+	for key, value := range AnalysisMetadata(*analysis) {
+		header.Set("X-Amz-Meta-"+key, value)
+	}
+	assert.Equal(t, analysis, AnalysisFromMetadata(header))
+	assert.Nil(t, AnalysisFromMetadata(http.Header{}))
+
+	// Nothing opaque: the placeholder is what shows through.
+	source.Reset()
+	assert.NoError(t, png.Encode(&source, image.NewNRGBA(image.Rect(0, 0, 10, 30))))
+	analysis, err = Analyze(source.Bytes(), 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, "#ffffff", analysis.DominantColor)
+	assert.Len(t, analysis.BlurHash, 28, "3x4 components")
+
+	_, err = Analyze(source.Bytes(), 299)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestDHash(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 90, 60))
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 90; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8((x * y) % 251), G: uint8(x * 2), B: uint8(y * 3), A: 255})
+		}
+	}
+	var source bytes.Buffer
+	assert.NoError(t, png.Encode(&source, img))
+	original, err := Analyze(source.Bytes(), 1<<20)
+	assert.NoError(t, err)
+
+	// Smaller and re-encoded, it still hashes alike.
+	variant, err := Transform(source.Bytes(), Params{Width: 45, Format: "jpeg", Quality: 60}, 1<<20)
+	assert.NoError(t, err)
+	reencoded, err := Analyze(variant.Data, 1<<20)
+	assert.NoError(t, err)
+
+	a, err := ParseHash(original.DHash)
+	assert.NoError(t, err)
+	b, err := ParseHash(reencoded.DHash)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, Distance(a, b), 4)
+
+	// Turned upside down, it doesn't.
+	flipped, err := Transform(source.Bytes(), Params{Rotate: 180, Format: "png"}, 1<<20)
+	assert.NoError(t, err)
+	other, err := Analyze(flipped.Data, 1<<20)
+	assert.NoError(t, err)
+	c, _ := ParseHash(other.DHash)
+	assert.Greater(t, Distance(a, c), MaxDistance)
+
+	_, err = ParseHash("xyz")
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestIndexKeys(t *testing.T) {
+	hash := uint64(0x0123456789abcdef)
+	entries := indexKeys("memes/cat.jpg", hash)
+	assert.Len(t, entries, 8)
+	assert.Equal(t, ".similar/0/01/0123456789abcdef/memes/cat.jpg", entries[0])
+	assert.Equal(t, ".similar/7/ef/0123456789abcdef/memes/cat.jpg", entries[7])
+
+	for band, entry := range entries {
+		assert.True(t, strings.HasPrefix(entry, bandPrefix(band, hash)))
+		parsed, key, ok := parseIndexKey(band, entry)
+		assert.True(t, ok)
+		assert.Equal(t, hash, parsed)
+		assert.Equal(t, "memes/cat.jpg", key)
+	}
+
+	// A hash a bit away from another shares the bands it didn't touch.
+	assert.Equal(t, bandPrefix(0, hash), bandPrefix(0, hash^1))
+	assert.NotEqual(t, bandPrefix(7, hash), bandPrefix(7, hash^1))
+
+	_, _, ok := parseIndexKey(0, ".similar/0/01/0123456789abcdef/")
+	assert.False(t, ok)
+	_, _, ok = parseIndexKey(1, entries[0])
+	assert.False(t, ok)
+}
@@ -0,0 +1,67 @@
+package imaging
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// negotiable are the formats an original may be swapped for, by media
+// type.
+var negotiable = map[string]string{
+	"avif": "image/avif",
+	"webp": "image/webp",
+}
+
+// negotiableSources are the media types of originals worth swapping.
+var negotiableSources = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// NegotiableSource reports whether an original of contentType may be
+// answered with a variant in a modern format.
+func NegotiableSource(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return negotiableSources[mediaType]
+}
+
+// Negotiate returns the formats of offered, kept in their order of
+// preference, that accept lists with a non-zero quality. Wildcards
+// don't count: browsers that decode AVIF or WebP name them, and those
+// that don't send "image/*" as well.
+func Negotiate(accept string, offered []string) []string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if quality, found := params["q"]; found {
+			if value, err := strconv.ParseFloat(quality, 64); err != nil || value <= 0 {
+				continue
+			}
+		}
+		accepted[mediaType] = true
+	}
+
+	var formats []string
+	for _, format := range offered {
+		if accepted[negotiable[format]] {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// Derivable reports whether a format variant of an original of
+// contentType is derived, in the background, when none was stored and
+// a request asks for it. Only WebP from PNG and GIF is: WebP variants
+// are lossless, which makes them larger than a JPEG photo, and AVIF
+// can't be written in pure Go. Those are served when a batch job
+// stored them under VariantKey.
+func Derivable(contentType string, format string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return format == "webp" && (mediaType == "image/png" || mediaType == "image/gif")
+}
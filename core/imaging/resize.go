@@ -0,0 +1,148 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// layout returns the part of a width x height source a variant is
+// made from and the variant's size. Images are never scaled up: a box
+// larger than the source shrinks to it, keeping the box's proportions
+// for cover and fill.
+func (p Params) layout(width int, height int) (image.Rectangle, int, int) {
+	source := image.Rect(0, 0, width, height)
+	boxWidth, boxHeight := float64(p.Width), float64(p.Height)
+	scaled := func(size int, scale float64) int {
+		return max(int(math.Round(float64(size)*scale)), 1)
+	}
+
+	switch {
+	case p.Width == 0 && p.Height == 0:
+		return source, width, height
+	case p.Height == 0:
+		scale := min(boxWidth/float64(width), 1)
+		return source, scaled(width, scale), scaled(height, scale)
+	case p.Width == 0:
+		scale := min(boxHeight/float64(height), 1)
+		return source, scaled(width, scale), scaled(height, scale)
+	}
+
+	switch p.Fit {
+	case FitFill:
+		return source, min(p.Width, width), min(p.Height, height)
+	case FitCover:
+		scale := max(boxWidth/float64(width), boxHeight/float64(height))
+		targetWidth, targetHeight := p.Width, p.Height
+		if scale > 1 {
+			targetWidth, targetHeight = scaled(p.Width, 1/scale), scaled(p.Height, 1/scale)
+			scale = 1
+		}
+		cropWidth := min(scaled(targetWidth, 1/scale), width)
+		cropHeight := min(scaled(targetHeight, 1/scale), height)
+		crop := image.Rect(0, 0, cropWidth, cropHeight).Add(image.Pt((width-cropWidth)/2, (height-cropHeight)/2))
+		return crop, targetWidth, targetHeight
+	default:
+		scale := min(boxWidth/float64(width), boxHeight/float64(height), 1)
+		return source, scaled(width, scale), scaled(height, scale)
+	}
+}
+
+// contribution is the source pixels one output pixel is averaged from,
+// starting at first.
+type contribution struct {
+	first   int
+	weights []float32
+}
+
+// contributions weighs source pixels for each of size output pixels
+// with a triangle filter stretched over the scale factor, so shrinking
+// averages every source pixel instead of skipping most of them.
+func contributions(sourceSize int, size int) []contribution {
+	scale := float64(sourceSize) / float64(size)
+	radius := max(scale, 1)
+
+	result := make([]contribution, size)
+	for i := range result {
+		center := (float64(i) + 0.5) * scale
+		first := max(int(math.Floor(center-radius)), 0)
+		last := min(int(math.Ceil(center+radius)), sourceSize)
+
+		weights := make([]float32, 0, last-first)
+		total := float32(0)
+		for j := first; j < last; j++ {
+			weight := float32(1 - math.Abs(float64(j)+0.5-center)/radius)
+			weight = max(weight, 0)
+			weights = append(weights, weight)
+			total += weight
+		}
+		if total == 0 {
+			// A source pixel exactly between outputs; take the nearest.
+			first, weights, total = min(int(center), sourceSize-1), []float32{1}, 1
+		}
+		for j := range weights {
+			weights[j] /= total
+		}
+
+		result[i] = contribution{first: first, weights: weights}
+	}
+
+	return result
+}
+
+// resample returns the crop part of img scaled to width x height.
+// Colours are averaged premultiplied, so transparent pixels don't
+// bleed their colour into the edges around them.
+func resample(img image.Image, crop image.Rectangle, width int, height int) *image.RGBA {
+	source := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(source, source.Bounds(), img, crop.Min.Add(img.Bounds().Min), draw.Src)
+	if crop.Dx() == width && crop.Dy() == height {
+		return source
+	}
+
+	// Rows first, into floats, then columns into the result.
+	columns := contributions(crop.Dx(), width)
+	rows := make([]float32, 4*width*crop.Dy())
+	for y := 0; y < crop.Dy(); y++ {
+		line := source.Pix[y*source.Stride:]
+		for x, column := range columns {
+			var sum [4]float32
+			for i, weight := range column.weights {
+				pixel := line[4*(column.first+i):]
+				sum[0] += weight * float32(pixel[0])
+				sum[1] += weight * float32(pixel[1])
+				sum[2] += weight * float32(pixel[2])
+				sum[3] += weight * float32(pixel[3])
+			}
+			copy(rows[4*(y*width+x):], sum[:])
+		}
+	}
+
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, row := range contributions(crop.Dy(), height) {
+		for x := 0; x < width; x++ {
+			var sum [4]float32
+			for i, weight := range row.weights {
+				pixel := rows[4*((row.first+i)*width+x):]
+				sum[0] += weight * pixel[0]
+				sum[1] += weight * pixel[1]
+				sum[2] += weight * pixel[2]
+				sum[3] += weight * pixel[3]
+			}
+
+			out := result.Pix[y*result.Stride+4*x:]
+			alpha := clamp(sum[3])
+			for c := 0; c < 3; c++ {
+				// Rounding may leave a premultiplied channel above alpha.
+				out[c] = min(clamp(sum[c]), alpha)
+			}
+			out[3] = alpha
+		}
+	}
+
+	return result
+}
+
+func clamp(value float32) uint8 {
+	return uint8(min(max(value+0.5, 0), 255))
+}
@@ -0,0 +1,93 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// SimilarPrefix is where the index of image hashes is kept. Each
+// analysed image has one empty object per band of its DHash:
+// SimilarPrefix, the band's number, "/", the band in hex, "/", the
+// whole hash in hex, "/" and the image's key.
+const SimilarPrefix = ".similar/"
+
+// MaxDistance is the largest Hamming distance near-duplicates can be
+// looked up within. Hashes are indexed by their eight bytes, and two
+// hashes fewer than eight bits apart share at least one of them, so a
+// lookup only lists the images sharing a byte with the hash.
+const MaxDistance = 7
+
+const bands = 8
+
+// DHash returns the difference hash of img: it is shrunk to 9x8 grey
+// pixels and each bit tells whether a pixel is brighter than its right
+// neighbour. Resizing, re-encoding and small edits flip few bits.
+func DHash(img image.Image) uint64 {
+	bounds := img.Bounds()
+	small := flatten(resample(img, image.Rect(0, 0, bounds.Dx(), bounds.Dy()), 9, 8))
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luma(small, x, y) > luma(small, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func luma(img *image.RGBA, x int, y int) int {
+	offset := img.PixOffset(x, y)
+	return 299*int(img.Pix[offset]) + 587*int(img.Pix[offset+1]) + 114*int(img.Pix[offset+2])
+}
+
+// Distance is the number of bits a and b differ in.
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// FormatHash writes hash as the 16 hex digits it is recorded as.
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash reads a hash written by FormatHash.
+func ParseHash(value string) (uint64, error) {
+	if len(value) != 16 {
+		return 0, fmt.Errorf("%w: hash must be 16 hex digits", ErrInvalid)
+	}
+	return strconv.ParseUint(value, 16, 64)
+}
+
+// indexKeys are the index entries of key, whose image hashes to hash.
+func indexKeys(key string, hash uint64) []string {
+	entries := make([]string, bands)
+	for band := range entries {
+		entries[band] = bandPrefix(band, hash) + FormatHash(hash) + "/" + key
+	}
+	return entries
+}
+
+// bandPrefix is where the entries sharing hash's band are listed.
+func bandPrefix(band int, hash uint64) string {
+	return fmt.Sprintf("%s%d/%02x/", SimilarPrefix, band, byte(hash>>(8*(bands-1-band))))
+}
+
+// parseIndexKey splits an index entry into the hash and key it names.
+func parseIndexKey(band int, entry string) (uint64, string, bool) {
+	rest, found := strings.CutPrefix(entry, fmt.Sprintf("%s%d/", SimilarPrefix, band))
+	if !found || len(rest) < 3+17 {
+		return 0, "", false
+	}
+
+	hash, err := ParseHash(rest[3:19])
+	if err != nil || rest[19] != '/' || rest[20:] == "" {
+		return 0, "", false
+	}
+	return hash, rest[20:], true
+}
@@ -0,0 +1,455 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/delivery"
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/minio/minio-go"
+)
+
+var (
+	slotsOnce sync.Once
+	// slots bounds the transforms running at once across handlers;
+	// each holds a decoded image in memory.
+	slots chan struct{}
+)
+
+func transformSlots() chan struct{} {
+	slotsOnce.Do(func() {
+		slots = make(chan struct{}, config.EnvImageConcurrency())
+	})
+	return slots
+}
+
+// Store keeps variants in their source's bucket, under VariantPrefix.
+// Variants written there by anything else, a batch job pre-generating
+// AVIF for instance, are served like derived ones.
+type Store struct {
+	minioService services.IMinioService
+	log          *logger.CustomLogger
+	// catalog also keeps recorded analyses, when IMAGE_CATALOG
+	// names one.
+	catalog Catalog
+	// deriving holds the variants being derived in the background, by
+	// bucket and key, so a burst of requests derives each once.
+	deriving sync.Map
+}
+
+func NewStore(minioService services.IMinioService, log *logger.CustomLogger) *Store {
+	return &Store{minioService: minioService, log: log, catalog: DefaultCatalog()}
+}
+
+// Variant is a variant opened for serving.
+type Variant struct {
+	io.ReadSeeker
+	ContentType string
+	// Cached is false when the variant was derived for this request.
+	Cached bool
+	closer io.Closer
+}
+
+// Close releases the stored variant Variant was read from, if any.
+func (v *Variant) Close() error {
+	if v.closer == nil {
+		return nil
+	}
+	return v.closer.Close()
+}
+
+// Source stats key, returning its info and the key its bytes are
+// stored under, which is the blob's for a content-addressed pointer.
+// The info is nil without an error when key doesn't exist.
+func (s *Store) Source(bucket string, key string) (*minio.ObjectInfo, string, *errors.AppError) {
+	info, appErr := s.minioService.LookupObject(bucket, key)
+	if appErr != nil || info == nil {
+		return nil, "", appErr
+	}
+
+	if digest, found := cas.PointerDigest(info.Metadata); found && config.BucketSettings(bucket).Storage.ContentAddressed {
+		return info, cas.BlobKey(digest), nil
+	}
+	return info, key, nil
+}
+
+// Get opens the variant stored under variantKey, deriving it from the
+// source stored under storedName when there is none yet.
+func (s *Store) Get(ctx context.Context, bucket string, storedName string, variantKey string, params Params) (*Variant, *errors.AppError) {
+	stored, appErr := s.Lookup(bucket, variantKey)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if stored != nil {
+		object, appErr := s.Open(bucket, variantKey)
+		if appErr != nil {
+			return nil, appErr
+		}
+		return &Variant{ReadSeeker: object, ContentType: stored.ContentType, Cached: true, closer: object}, nil
+	}
+
+	derived, appErr := s.Derive(ctx, bucket, storedName, variantKey, params)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return &Variant{ReadSeeker: bytes.NewReader(derived.Data), ContentType: derived.ContentType}, nil
+}
+
+// DerivePresets derives every preset of key that isn't stored yet, one
+// after the other, in the background. Failures are logged; a missing
+// preset is derived when it is first requested.
+func (s *Store) DerivePresets(bucket string, key string, presets map[string]entities.ImagePresetEntity) {
+	go func() {
+		info, storedName, appErr := s.Source(bucket, key)
+		if appErr != nil || info == nil {
+			if appErr != nil {
+				s.log.Error(appErr.Message, appErr.ToMap())
+			}
+			return
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(presets)) {
+			params := PresetParams(presets[name])
+			variantKey := VariantKey(key, delivery.ETag(*info), params)
+
+			stored, appErr := s.Lookup(bucket, variantKey)
+			if appErr == nil && stored == nil {
+				_, appErr = s.Derive(context.Background(), bucket, storedName, variantKey, params)
+			}
+			if appErr != nil {
+				s.log.Error(fmt.Sprintf("preset %s of %s/%s: %s", name, bucket, key, appErr.Message), appErr.ToMap())
+			}
+		}
+	}()
+}
+
+// RemoveVariants drops every variant cached for key. A derivation
+// still running in the background may store one more afterwards; it
+// is keyed by a version key no longer has, so it is never served and
+// the janitor collects it.
+func (s *Store) RemoveVariants(bucket string, key string) *errors.AppError {
+	variants, appErr := s.minioService.ListObjects(bucket, VariantFolder(key))
+	if appErr != nil {
+		return appErr
+	}
+
+	for _, variant := range variants {
+		if appErr := s.minioService.RemoveObject(bucket, variant.Key); appErr != nil {
+			return appErr
+		}
+	}
+	return nil
+}
+
+// Lookup stats the variant stored under variantKey, returning nil
+// without an error when there is none.
+func (s *Store) Lookup(bucket string, variantKey string) (*minio.ObjectInfo, *errors.AppError) {
+	return s.minioService.LookupObject(bucket, variantKey)
+}
+
+// Open opens the variant stored under variantKey.
+func (s *Store) Open(bucket string, variantKey string) (services.Object, *errors.AppError) {
+	return s.minioService.GetObject(bucket, variantKey, minio.GetObjectOptions{})
+}
+
+// Derive transforms the source stored under storedName and keeps the
+// variant under variantKey. Sources over IMAGE_MAX_SOURCE_SIZE are
+// refused; failing to keep the variant is logged, not reported, and
+// it is derived again on the next request.
+func (s *Store) Derive(ctx context.Context, bucket string, storedName string, variantKey string, params Params) (*Result, *errors.AppError) {
+	release, appErr := acquire(ctx)
+	if appErr != nil {
+		return nil, appErr
+	}
+	defer release()
+
+	source, appErr := s.read(bucket, storedName)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	variant, err := Transform(source, params, config.EnvImageMaxPixels())
+	if err != nil {
+		return nil, imageError(err)
+	}
+
+	_, appErr = s.minioService.PutObject(bucket, variantKey, bytes.NewReader(variant.Data), int64(len(variant.Data)),
+		minio.PutObjectOptions{ContentType: variant.ContentType})
+	if appErr != nil {
+		s.log.Error(appErr.Message, appErr.ToMap())
+	}
+
+	return variant, nil
+}
+
+// DeriveInBackground derives the variant under variantKey in the
+// background, unless it is already being derived, so the request
+// asking for it isn't held up. Failures are logged; the next request
+// tries again.
+func (s *Store) DeriveInBackground(bucket string, storedName string, variantKey string, params Params) {
+	id := bucket + "/" + variantKey
+	if _, running := s.deriving.LoadOrStore(id, true); running {
+		return
+	}
+
+	go func() {
+		defer s.deriving.Delete(id)
+		if _, appErr := s.Derive(context.Background(), bucket, storedName, variantKey, params); appErr != nil {
+			s.log.Error(fmt.Sprintf("variant %s/%s: %s", bucket, variantKey, appErr.Message), appErr.ToMap())
+		}
+	}()
+}
+
+// Analyze analyses the image stored under key and records the analysis
+// on it (see Record). It returns nil without an error when key doesn't
+// exist or isn't an image.
+func (s *Store) Analyze(ctx context.Context, bucket string, key string) (*entities.ImageAnalysisEntity, *errors.AppError) {
+	info, storedName, appErr := s.Source(bucket, key)
+	if appErr != nil || info == nil || !Decodable(info.ContentType) {
+		return nil, appErr
+	}
+
+	analysis, appErr := s.Inspect(ctx, bucket, storedName)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if appErr := s.Record(bucket, key, *analysis); appErr != nil {
+		return nil, appErr
+	}
+	return analysis, nil
+}
+
+// Inspect analyses the image stored under storedName, recording
+// nothing.
+func (s *Store) Inspect(ctx context.Context, bucket string, storedName string) (*entities.ImageAnalysisEntity, *errors.AppError) {
+	release, appErr := acquire(ctx)
+	if appErr != nil {
+		return nil, appErr
+	}
+	defer release()
+
+	source, appErr := s.read(bucket, storedName)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	analysis, err := Analyze(source, config.EnvImageMaxPixels())
+	if err != nil {
+		return nil, imageError(err)
+	}
+	return analysis, nil
+}
+
+// Record records analysis on key as user metadata, adds key to the
+// index Similar looks hashes up in, and catalogues it. Failing to
+// index or catalog is only logged: key is then left out of lookups, or
+// of the catalog, until analysed again.
+func (s *Store) Record(bucket string, key string, analysis entities.ImageAnalysisEntity) *errors.AppError {
+	if appErr := s.minioService.SetObjectMetadata(bucket, key, AnalysisMetadata(analysis)); appErr != nil {
+		return appErr
+	}
+
+	hash, err := ParseHash(analysis.DHash)
+	if err != nil {
+		return errors.UsecaseError(err.Error())
+	}
+	for _, entry := range indexKeys(key, hash) {
+		if _, appErr := s.minioService.PutObject(bucket, entry, bytes.NewReader(nil), 0, minio.PutObjectOptions{}); appErr != nil {
+			s.log.Error(appErr.Message, appErr.ToMap())
+		}
+	}
+
+	if s.catalog != nil {
+		if appErr := s.catalog.Save(bucket, key, analysis); appErr != nil {
+			s.log.Error(appErr.Message, appErr.ToMap())
+		}
+	}
+	return nil
+}
+
+// Recatalog brings key's catalog row in line with what is stored under
+// key now, e.g. once an upload was rolled back: the analysis the
+// object carries, or no row when it carries none or is gone.
+func (s *Store) Recatalog(bucket string, key string) *errors.AppError {
+	if s.catalog == nil {
+		return nil
+	}
+
+	info, appErr := s.minioService.LookupObject(bucket, key)
+	if appErr != nil {
+		return appErr
+	}
+	if info != nil {
+		if analysis := AnalysisFromMetadata(info.Metadata); analysis != nil {
+			return s.catalog.Save(bucket, key, *analysis)
+		}
+	}
+	return s.catalog.Remove(bucket, key)
+}
+
+// Match is an image Similar found.
+type Match struct {
+	Key      string
+	Distance int
+}
+
+// Similar returns the images of bucket whose hash is within distance
+// bits of hash, closest first, leaving except out. distance is capped
+// at MaxDistance. Index entries of images deleted, or overwritten with
+// another image, since are dropped on the way.
+func (s *Store) Similar(bucket string, hash uint64, distance int, except string) ([]Match, *errors.AppError) {
+	distance = min(max(distance, 0), MaxDistance)
+
+	type candidate struct {
+		key  string
+		hash uint64
+	}
+	candidates := map[candidate]bool{}
+	for band := 0; band < bands; band++ {
+		entries, appErr := s.minioService.ListObjects(bucket, bandPrefix(band, hash))
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		for _, entry := range entries {
+			entryHash, key, ok := parseIndexKey(band, entry.Key)
+			if ok && key != except && Distance(hash, entryHash) <= distance {
+				candidates[candidate{key: key, hash: entryHash}] = true
+			}
+		}
+	}
+
+	var matches []Match
+	for candidate := range candidates {
+		info, appErr := s.minioService.LookupObject(bucket, candidate.key)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		if info == nil || info.Metadata.Get("X-Amz-Meta-"+DHashMetadata) != FormatHash(candidate.hash) {
+			s.forget(bucket, candidate.key, candidate.hash)
+			continue
+		}
+		matches = append(matches, Match{Key: candidate.key, Distance: Distance(hash, candidate.hash)})
+	}
+
+	slices.SortFunc(matches, func(a Match, b Match) int {
+		if a.Distance != b.Distance {
+			return a.Distance - b.Distance
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return matches, nil
+}
+
+// forget drops the index entries of key hashing to hash.
+func (s *Store) forget(bucket string, key string, hash uint64) {
+	for _, entry := range indexKeys(key, hash) {
+		if appErr := s.minioService.RemoveObject(bucket, entry); appErr != nil {
+			s.log.Error(appErr.Message, appErr.ToMap())
+		}
+	}
+}
+
+// acquire takes one of the transform slots, for as long as ctx allows,
+// and returns how to give it back.
+func acquire(ctx context.Context) (func(), *errors.AppError) {
+	select {
+	case transformSlots() <- struct{}{}:
+		return func() { <-transformSlots() }, nil
+	case <-ctx.Done():
+		return nil, errors.UnavailableError(ctx.Err().Error())
+	}
+}
+
+// read reads the source stored under storedName, refusing sources
+// over IMAGE_MAX_SOURCE_SIZE.
+func (s *Store) read(bucket string, storedName string) ([]byte, *errors.AppError) {
+	object, appErr := s.minioService.GetObject(bucket, storedName, minio.GetObjectOptions{})
+	if appErr != nil {
+		return nil, appErr
+	}
+	defer object.Close()
+
+	maxSize := config.EnvImageMaxSourceSize()
+	source, err := io.ReadAll(io.LimitReader(object, maxSize+1))
+	if err != nil {
+		return nil, errors.ServiceError(err.Error())
+	}
+	if int64(len(source)) > maxSize {
+		return nil, errors.PayloadTooLargeError(fmt.Sprintf("source image exceeds %d bytes", maxSize))
+	}
+	return source, nil
+}
+
+// imageError maps a decoding or encoding failure to its AppError.
+func imageError(err error) *errors.AppError {
+	switch {
+	case stdErrors.Is(err, ErrTooLarge):
+		return errors.PayloadTooLargeError(err.Error())
+	case stdErrors.Is(err, ErrUnsupported):
+		return errors.UnsupportedMediaError(err.Error())
+	default:
+		return errors.UsecaseError(err.Error())
+	}
+}
+
+// Purge drops variants older than IMAGE_VARIANT_RETENTION from every
+// bucket. Popular ones are derived again on their next request;
+// variants of deleted or overwritten sources go for good.
+func (s *Store) Purge() {
+	retention := config.EnvImageVariantRetention()
+	if retention <= 0 {
+		return
+	}
+
+	buckets, appErr := s.minioService.ListBuckets()
+	if appErr != nil {
+		s.log.Error(appErr.Message, appErr.ToMap())
+		return
+	}
+
+	deadline := time.Now().Add(-retention)
+	for _, bucket := range buckets {
+		objects, appErr := s.minioService.ListObjects(bucket.Name, VariantPrefix)
+		if appErr != nil {
+			s.log.Error(appErr.Message, appErr.ToMap())
+			continue
+		}
+
+		for _, object := range objects {
+			if object.LastModified.After(deadline) {
+				continue
+			}
+			if appErr := s.minioService.RemoveObject(bucket.Name, object.Key); appErr != nil {
+				s.log.Error(appErr.Message, appErr.ToMap())
+			}
+		}
+	}
+}
+
+// StartJanitor runs Purge every interval until the process exits.
+func (s *Store) StartJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.Purge()
+		}
+	}()
+}
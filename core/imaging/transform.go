@@ -0,0 +1,115 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/RodolfoBonis/rb-cdn/core/imagemeta"
+	"github.com/RodolfoBonis/rb-cdn/core/webp"
+	_ "golang.org/x/image/webp"
+)
+
+// rotations are the EXIF orientations that turn an upright image
+// clockwise by Params.Rotate degrees.
+var rotations = map[int]int{90: 6, 180: 3, 270: 8}
+
+// Result is an encoded variant.
+type Result struct {
+	Data        []byte
+	Format      string
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Transform derives the variant params describe from data. Sources
+// with more than maxPixels pixels are refused before being decoded.
+// Animated GIFs keep their first frame only.
+func Transform(data []byte, params Params, maxPixels int64) (*Result, error) {
+	img, format, err := decode(data, maxPixels)
+	if err != nil {
+		return nil, err
+	}
+	img = imagemeta.Orient(img, rotations[params.Rotate])
+
+	crop, width, height := params.layout(img.Bounds().Dx(), img.Bounds().Dy())
+	variant := resample(img, crop, width, height)
+
+	if params.Format != "" {
+		format = params.Format
+	}
+	encoded, err := encode(variant, format, params.Quality)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Data:        encoded,
+		Format:      format,
+		ContentType: ContentType(format),
+		Width:       width,
+		Height:      height,
+	}, nil
+}
+
+// decode decodes data and turns it upright by its EXIF orientation.
+// Sources with more than maxPixels pixels are refused before being
+// decoded.
+func decode(data []byte, maxPixels int64) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || ContentType(format) == "" {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, "", fmt.Errorf("%w: %dx%d", ErrTooLarge, config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	return imagemeta.Orient(img, imagemeta.Orientation(data, ContentType(format))), format, nil
+}
+
+// flatten draws img onto white, for outputs without alpha.
+func flatten(img image.Image) *image.RGBA {
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
+
+func encode(img *image.RGBA, format string, quality int) ([]byte, error) {
+	var encoded bytes.Buffer
+	var err error
+
+	switch format {
+	case "jpeg":
+		if quality == 0 {
+			quality = DefaultQuality
+		}
+		// JPEG has no alpha; transparent areas turn white rather than
+		// black.
+		err = jpeg.Encode(&encoded, flatten(img), &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(&encoded, img)
+	case "gif":
+		err = gif.Encode(&encoded, img, nil)
+	case "webp":
+		err = webp.Encode(&encoded, img)
+	default:
+		return nil, fmt.Errorf("%w: can't encode %s", ErrUnsupported, format)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s variant: %w", format, err)
+	}
+	return encoded.Bytes(), nil
+}
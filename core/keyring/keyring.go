@@ -0,0 +1,180 @@
+// Package keyring holds the master keys encrypted buckets are sealed
+// with, read from KEYRING_FILE:
+//
+//	{
+//	  "active": "2026-10",
+//	  "keys": {
+//	    "2026-04": "<base64 of 32 random bytes>",
+//	    "2026-10": "<base64 of 32 random bytes>"
+//	  }
+//	}
+//
+// New objects are sealed under the active key. To rotate, add a key,
+// make it active and run a re-encryption job on every encrypted
+// bucket; the old key has to stay in the file until the jobs are
+// done, since it is the only way to read what it sealed. Master keys
+// never leave rb-cdn: MinIO only sees the keys Derive makes from them,
+// and envelope-encrypted objects carry their data key wrapped by one.
+package keyring
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+)
+
+// KeySize is the size of a master key: AES-256.
+const KeySize = 32
+
+// validID keeps key ids safe to store in object metadata.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Keyring is a set of master keys by id, one of them active.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+type keyringDocument struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// Parse decodes a keyring file. An empty keyring is valid; otherwise
+// every key must be 32 bytes of base64 and the active one must exist.
+func Parse(content []byte) (*Keyring, error) {
+	var document keyringDocument
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+
+	ring := &Keyring{active: document.Active, keys: map[string][]byte{}}
+	for id, encoded := range document.Keys {
+		if !validID.MatchString(id) {
+			return nil, fmt.Errorf("keyring: key id %q must be 1 to 64 letters, digits, dots, dashes or underscores", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("keyring: key %q must be %d bytes of base64", id, KeySize)
+		}
+		ring.keys[id] = key
+	}
+
+	if _, found := ring.keys[ring.active]; !found && len(ring.keys) > 0 {
+		return nil, fmt.Errorf("keyring: active key %q is not in the keyring", ring.active)
+	}
+
+	return ring, nil
+}
+
+// Load reads the keyring in filename; no filename gives an empty one.
+func Load(filename string) (*Keyring, error) {
+	if filename == "" {
+		return &Keyring{keys: map[string][]byte{}}, nil
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+
+	return Parse(content)
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLoaded bool
+	defaultRing   *Keyring
+)
+
+// LoadDefault (re)reads KEYRING_FILE. It runs at boot, after the bucket
+// settings, so an unreadable keyring or a bucket encrypted without any
+// key stops the deploy.
+func LoadDefault() error {
+	ring, err := Load(config.EnvKeyringFile())
+	if err != nil {
+		return err
+	}
+
+	if ring.Empty() && config.EncryptionEnabled() {
+		return fmt.Errorf("keyring: bucket settings enable encryption but KEYRING_FILE holds no keys")
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultRing = ring
+	defaultLoaded = true
+	return nil
+}
+
+// Default returns the keyring loaded from KEYRING_FILE.
+func Default() *Keyring {
+	defaultMu.RLock()
+	loaded := defaultLoaded
+	ring := defaultRing
+	defaultMu.RUnlock()
+
+	if !loaded {
+		if err := LoadDefault(); err != nil {
+			panic(err)
+		}
+		return Default()
+	}
+
+	return ring
+}
+
+// Empty reports whether the keyring holds no key.
+func (k *Keyring) Empty() bool {
+	return len(k.keys) == 0
+}
+
+// Active returns the key new objects are sealed under; found is false
+// on an empty keyring.
+func (k *Keyring) Active() (string, []byte, bool) {
+	key, found := k.keys[k.active]
+	return k.active, key, found
+}
+
+// Key returns the key id names.
+func (k *Keyring) Key(id string) ([]byte, bool) {
+	key, found := k.keys[id]
+	return key, found
+}
+
+// IDs lists the key ids, the active one first and the rest sorted.
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		if id != k.active {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	if _, found := k.keys[k.active]; found {
+		ids = append([]string{k.active}, ids...)
+	}
+
+	return ids
+}
+
+// Derive makes a key for one use of a master key: HMAC-SHA256 of
+// purpose and subject, so keys derived for different objects, or for
+// the same object in different roles, are unrelated.
+func Derive(masterKey []byte, purpose string, subject string) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(subject))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,73 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodedKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, KeySize))
+}
+
+func TestParse(t *testing.T) {
+	t.Run("active key first", func(t *testing.T) {
+		ring, err := Parse([]byte(`{"active": "b", "keys": {"c": "` + encodedKey(3) + `", "a": "` + encodedKey(1) + `", "b": "` + encodedKey(2) + `"}}`))
+		assert.NoError(t, err)
+
+		id, key, found := ring.Active()
+		assert.True(t, found)
+		assert.Equal(t, "b", id)
+		assert.Equal(t, bytes.Repeat([]byte{2}, KeySize), key)
+		assert.Equal(t, []string{"b", "a", "c"}, ring.IDs())
+
+		_, found = ring.Key("a")
+		assert.True(t, found)
+		_, found = ring.Key("z")
+		assert.False(t, found)
+	})
+
+	t.Run("empty keyring", func(t *testing.T) {
+		ring, err := Parse([]byte(`{}`))
+		assert.NoError(t, err)
+		assert.True(t, ring.Empty())
+		_, _, found := ring.Active()
+		assert.False(t, found)
+		assert.Empty(t, ring.IDs())
+	})
+
+	t.Run("invalid keyrings", func(t *testing.T) {
+		for _, content := range []string{
+			`{"active": "a", "keys": {"a": "c2hvcnQ="}}`,
+			`{"active": "a", "keys": {"a": "not base64"}}`,
+			`{"active": "b", "keys": {"a": "` + encodedKey(1) + `"}}`,
+			`{"active": "a b", "keys": {"a b": "` + encodedKey(1) + `"}}`,
+			`{"keys": `,
+		} {
+			_, err := Parse([]byte(content))
+			assert.Error(t, err, content)
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	ring, err := Load("")
+	assert.NoError(t, err)
+	assert.True(t, ring.Empty())
+
+	_, err = Load("/nonexistent/keyring.json")
+	assert.Error(t, err)
+}
+
+func TestDerive(t *testing.T) {
+	master := bytes.Repeat([]byte{1}, KeySize)
+
+	key := Derive(master, "sse-c", "bucket/a.pdf")
+	assert.Len(t, key, KeySize)
+	assert.Equal(t, key, Derive(master, "sse-c", "bucket/a.pdf"))
+	assert.NotEqual(t, key, Derive(master, "sse-c", "bucket/b.pdf"))
+	assert.NotEqual(t, key, Derive(master, "other", "bucket/a.pdf"))
+	assert.NotEqual(t, key, Derive(bytes.Repeat([]byte{2}, KeySize), "sse-c", "bucket/a.pdf"))
+}
@@ -0,0 +1,40 @@
+package keys
+
+import "strings"
+
+// privatePrefixes hold rb-cdn's own objects: content-addressed blobs,
+// tus and fetch state, quarantined uploads, the metadata stripped from
+// images, re-encryption jobs, derived image variants and the index of
+// image hashes near-duplicates are looked up in. Uploads may
+// not write there and nothing under them is served.
+var privatePrefixes = []string{".cas/", ".tus/", ".fetch/", ".quarantine/", ".exif/", ".encryption/", ".variants/", ".similar/"}
+
+// PrivatePrefix returns the private prefix key falls under, or "".
+func PrivatePrefix(key string) string {
+	for _, prefix := range privatePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return prefix
+		}
+	}
+
+	return ""
+}
+
+// Private reports whether key is one of rb-cdn's own objects.
+func Private(key string) bool {
+	return PrivatePrefix(key) != ""
+}
+
+// OverlapsPrivate reports whether keys starting with prefix can land
+// under a private prefix: prefix is inside one (".cas/sha256/"), or
+// one starts with it ("." or ".ca"). The empty prefix overlaps them
+// all.
+func OverlapsPrivate(prefix string) bool {
+	for _, private := range privatePrefixes {
+		if strings.HasPrefix(prefix, private) || strings.HasPrefix(private, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
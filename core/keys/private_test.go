@@ -0,0 +1,27 @@
+package keys
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivate(t *testing.T) {
+	assert.Equal(t, ".cas/", PrivatePrefix(".cas/sha256/ab/ab12"))
+	assert.True(t, Private(".quarantine/x"))
+	assert.True(t, Private(".tus/abc.info"))
+	assert.True(t, Private(".variants/ab/cd"))
+	assert.True(t, Private(".similar/0/ab/0123456789abcdef/photo.jpg"))
+	assert.False(t, Private("photos/.cas/x.jpg"))
+	assert.False(t, Private(".well-known/x"))
+	assert.Empty(t, PrivatePrefix("photos/a.jpg"))
+}
+
+func TestOverlapsPrivate(t *testing.T) {
+	for _, prefix := range []string{"", ".", ".ca", ".cas", ".cas/", ".cas/sha256/", ".simi", ".encryption/jobs/"} {
+		assert.True(t, OverlapsPrivate(prefix), prefix)
+	}
+	for _, prefix := range []string{"photos/", ".well-known/", ".casual/", "a.cas/", "x"} {
+		assert.False(t, OverlapsPrivate(prefix), prefix)
+	}
+}
@@ -0,0 +1,191 @@
+// Package keys builds and validates the object keys uploads are
+// stored under.
+package keys
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultTemplate reproduces the historical folder/filename layout.
+const DefaultTemplate = "{folder}/{filename}"
+
+// MaxLength is the longest key S3 accepts, in bytes.
+const MaxLength = 1024
+
+// Vars are the values a template can refer to.
+type Vars struct {
+	Folder   string
+	Filename string
+	// Digest is the hex SHA-256 of the content; only known once the
+	// body has been read.
+	Digest string
+	ID     string
+	Time   time.Time
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-zA-Z]+)(?:\(([a-zA-Z]+)\))?\}`)
+
+// variables maps placeholder names to their value.
+var variables = map[string]func(Vars) string{
+	"folder":   func(v Vars) string { return v.Folder },
+	"filename": func(v Vars) string { return v.Filename },
+	"name":     func(v Vars) string { return strings.TrimSuffix(v.Filename, path.Ext(v.Filename)) },
+	"ext":      func(v Vars) string { return strings.ToLower(path.Ext(v.Filename)) },
+	"uuid":     func(v Vars) string { return v.ID },
+	"hash":     func(v Vars) string { return v.Digest },
+	"shortHash": func(v Vars) string {
+		if len(v.Digest) < 12 {
+			return v.Digest
+		}
+		return v.Digest[:12]
+	},
+	"yyyy": func(v Vars) string { return v.Time.UTC().Format("2006") },
+	"mm":   func(v Vars) string { return v.Time.UTC().Format("01") },
+	"dd":   func(v Vars) string { return v.Time.UTC().Format("02") },
+}
+
+// functions transform a variable: {slug(name)}.
+var functions = map[string]func(string) string{
+	"slug":  Slug,
+	"lower": strings.ToLower,
+}
+
+// digestVariables can only be rendered once the content was hashed.
+var digestVariables = map[string]bool{"hash": true, "shortHash": true}
+
+// Template is a parsed key template such as
+// "{yyyy}/{mm}/{uuid}{ext}" or "{folder}/{slug(name)}-{shortHash}{ext}".
+type Template struct {
+	source      string
+	needsDigest bool
+}
+
+// Parse validates source; every placeholder must name a known
+// variable and, optionally, a known function applied to it.
+func Parse(source string) (Template, error) {
+	if source == "" {
+		source = DefaultTemplate
+	}
+
+	template := Template{source: source}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(source, -1) {
+		function, variable := match[1], match[2]
+		if variable == "" {
+			function, variable = "", match[1]
+		}
+
+		if _, found := variables[variable]; !found {
+			return template, fmt.Errorf("unknown key template variable %q", variable)
+		}
+		if _, found := functions[function]; function != "" && !found {
+			return template, fmt.Errorf("unknown key template function %q", function)
+		}
+
+		template.needsDigest = template.needsDigest || digestVariables[variable]
+	}
+
+	if remaining := placeholderPattern.ReplaceAllString(source, ""); strings.ContainsAny(remaining, "{}") {
+		return template, fmt.Errorf("malformed key template %q", source)
+	}
+
+	return template, nil
+}
+
+// NeedsDigest reports whether the key depends on the content hash.
+func (t Template) NeedsDigest() bool {
+	return t.needsDigest
+}
+
+// Render expands the template and normalizes the result. Empty
+// segments, such as the one an empty {folder} leaves, are dropped.
+func (t Template) Render(vars Vars) (string, error) {
+	rendered := placeholderPattern.ReplaceAllStringFunc(t.source, func(placeholder string) string {
+		match := placeholderPattern.FindStringSubmatch(placeholder)
+		if match[2] == "" {
+			return variables[match[1]](vars)
+		}
+		return functions[match[1]](variables[match[2]](vars))
+	})
+
+	segments := strings.Split(rendered, "/")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if segment != "" {
+			kept = append(kept, segment)
+		}
+	}
+
+	return Normalize(strings.Join(kept, "/"))
+}
+
+// Normalize rejects keys that are unsafe or unusable: empty, too long,
+// not UTF-8, with control characters, backslashes or "." and ".."
+// segments, or absolute.
+func Normalize(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("object key is empty")
+	}
+
+	if len(key) > MaxLength {
+		return "", fmt.Errorf("object key is longer than %d bytes", MaxLength)
+	}
+
+	if !utf8.ValidString(key) {
+		return "", fmt.Errorf("object key is not valid UTF-8")
+	}
+
+	for _, r := range key {
+		if r < 0x20 || r == 0x7f || r == '\\' {
+			return "", fmt.Errorf("object key contains a forbidden character %q", r)
+		}
+	}
+
+	if strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("object key must not start with /")
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("object key must not contain %q segments", segment)
+		}
+	}
+
+	return key, nil
+}
+
+// Slug lowercases value and reduces it to ASCII letters, digits and
+// single dashes.
+func Slug(value string) string {
+	var builder strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(value) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			builder.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && builder.Len() > 0 {
+			builder.WriteByte('-')
+			dash = true
+		}
+	}
+
+	slug := strings.TrimSuffix(builder.String(), "-")
+	if slug == "" {
+		return "file"
+	}
+
+	return slug
+}
+
+// Renamed returns the attempt-th alternative to key used when the
+// original is taken: "photo.jpg" becomes "photo-1.jpg".
+func Renamed(key string, attempt int) string {
+	extension := path.Ext(key)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(key, extension), attempt, extension)
+}
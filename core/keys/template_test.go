@@ -0,0 +1,98 @@
+package keys
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	template, err := Parse("")
+	assert.NoError(t, err)
+	assert.False(t, template.NeedsDigest())
+
+	template, err = Parse("{folder}/{slug(name)}-{shortHash}{ext}")
+	assert.NoError(t, err)
+	assert.True(t, template.NeedsDigest())
+
+	_, err = Parse("{nope}")
+	assert.ErrorContains(t, err, "unknown key template variable")
+
+	_, err = Parse("{upper(name)}")
+	assert.ErrorContains(t, err, "unknown key template function")
+
+	_, err = Parse("{folder/{name}")
+	assert.ErrorContains(t, err, "malformed")
+}
+
+func TestRender(t *testing.T) {
+	vars := Vars{
+		Folder:   "photos",
+		Filename: "My Holiday Photo.JPG",
+		Digest:   "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		ID:       "0b1c",
+		Time:     time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{DefaultTemplate, "photos/My Holiday Photo.JPG"},
+		{"{yyyy}/{mm}/{dd}/{uuid}{ext}", "2024/03/09/0b1c.jpg"},
+		{"{folder}/{slug(name)}-{shortHash}{ext}", "photos/my-holiday-photo-e3b0c44298fc.jpg"},
+		{"{hash}", vars.Digest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			template, err := Parse(tt.template)
+			assert.NoError(t, err)
+
+			key, err := template.Render(vars)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, key)
+		})
+	}
+
+	t.Run("empty folder leaves no empty segment", func(t *testing.T) {
+		template, _ := Parse(DefaultTemplate)
+		key, err := template.Render(Vars{Filename: "a.txt"})
+		assert.NoError(t, err)
+		assert.Equal(t, "a.txt", key)
+	})
+
+	t.Run("traversal in a variable is rejected", func(t *testing.T) {
+		template, _ := Parse(DefaultTemplate)
+		_, err := template.Render(Vars{Folder: "../secrets", Filename: "a.txt"})
+		assert.Error(t, err)
+	})
+}
+
+func TestNormalize(t *testing.T) {
+	valid := []string{"a.txt", "photos/2024/a b.jpg", "ünïcode/ok.png"}
+	for _, key := range valid {
+		_, err := Normalize(key)
+		assert.NoError(t, err, key)
+	}
+
+	invalid := []string{"", "/abs", "a/../b", "./a", "a\x00b", "tab\tkey", `win\path`, "\xff", strings.Repeat("a", MaxLength+1)}
+	for _, key := range invalid {
+		_, err := Normalize(key)
+		assert.Error(t, err, key)
+	}
+}
+
+func TestSlug(t *testing.T) {
+	assert.Equal(t, "hello-world", Slug("  Hello, World!  "))
+	assert.Equal(t, "caf", Slug("Café"))
+	assert.Equal(t, "file", Slug("***"))
+}
+
+func TestRenamed(t *testing.T) {
+	assert.Equal(t, "photos/cat-1.jpg", Renamed("photos/cat.jpg", 1))
+	assert.Equal(t, "photos/README-2", Renamed("photos/README", 2))
+	assert.Equal(t, "v1.2/README-3", Renamed("v1.2/README", 3))
+}
@@ -10,6 +10,42 @@ import (
 	"strings"
 )
 
+// bodylessEndpoints never have their bodies logged: they carry binary
+// payloads or are hit too often for the body to be worth the noise.
+var bodylessEndpoints = types.Array{"/metrics", "/v1/health_check", "/ready", "/version", "/v1/upload", "/v1/stream", "/v1/cdn"}
+
+// streamedContentTypes are request bodies handlers consume as a
+// stream. Reading one into memory for the log would defeat the
+// streaming and, for multi-gigabyte uploads, exhaust the pod.
+var streamedContentTypes = []string{"multipart/form-data", "application/offset+octet-stream", "application/octet-stream"}
+
+// ShouldCaptureRequestBody reports whether the request body may be
+// buffered for logging. Callers must skip HandleRequestBody when it
+// returns false so the handler still sees the untouched stream.
+func ShouldCaptureRequestBody(req *http.Request) bool {
+	if req.Body == nil || isBodylessEndpoint(req) {
+		return false
+	}
+
+	contentType := req.Header.Get("Content-Type")
+	for _, streamed := range streamedContentTypes {
+		if strings.HasPrefix(contentType, streamed) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isBodylessEndpoint(req *http.Request) bool {
+	for _, endpoint := range bodylessEndpoints {
+		if strings.Contains(req.URL.String(), endpoint.(string)) {
+			return true
+		}
+	}
+	return false
+}
+
 func HandleRequestBody(req *http.Request) string {
 	var requestBodyBytes []byte
 	if req.Body == nil {
@@ -26,14 +62,9 @@ func HandleResponseBody(rw gin.ResponseWriter) *BodyLogWriter {
 }
 
 func FormatRequestAndResponse(rw gin.ResponseWriter, req *http.Request, responseBody string, requestId string, requestBody string) string {
-
-	endpointsList := types.Array{"/metrics", "/v1/health_check", "/ready", "/version", "/v1/upload", "/v1/stream", "/v1/cdn"}
-
-	for _, endpoint := range endpointsList {
-		if strings.Contains(req.URL.String(), endpoint.(string)) {
-			return fmt.Sprintf("[Request ID: %s], Status: [%d], Method: [%s], Url: %s",
-				requestId, rw.Status(), req.Method, req.URL.String())
-		}
+	if isBodylessEndpoint(req) {
+		return fmt.Sprintf("[Request ID: %s], Status: [%d], Method: [%s], Url: %s",
+			requestId, rw.Status(), req.Method, req.URL.String())
 	}
 
 	return fmt.Sprintf("[Request ID: %s], Status: [%d], Method: [%s], Url: %s Request Body: %s Response Body: %s",
@@ -181,3 +181,31 @@ func TestFormatRequestAndResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestShouldCaptureRequestBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		body        io.Reader
+		want        bool
+	}{
+		{name: "json body", url: "/api/test", contentType: "application/json", body: strings.NewReader("{}"), want: true},
+		{name: "nil body", url: "/api/test", contentType: "application/json", body: nil, want: false},
+		{name: "upload endpoint", url: "/v1/upload/", contentType: "application/json", body: strings.NewReader("{}"), want: false},
+		{name: "multipart body", url: "/api/test", contentType: "multipart/form-data; boundary=x", body: strings.NewReader("--x--"), want: false},
+		{name: "tus chunk", url: "/api/test", contentType: "application/offset+octet-stream", body: strings.NewReader("data"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.url, tt.body)
+			if tt.body == nil {
+				req.Body = nil
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+
+			assert.Equal(t, tt.want, ShouldCaptureRequestBody(req))
+		})
+	}
+}
@@ -9,9 +9,9 @@ import (
 func Cors() gin.HandlerFunc {
 	config := cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"POST", "OPTIONS", "GET", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "accept", "origin", "Cache-Control", "X-Requested-With", "X-Api-Key"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"POST", "OPTIONS", "GET", "HEAD", "PUT", "DELETE"},
+		AllowHeaders:     []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "accept", "origin", "Cache-Control", "X-Requested-With", "X-Api-Key", "Range", "If-Range", "If-None-Match", "If-Modified-Since"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}
@@ -0,0 +1,315 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/idempotency"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	maxIdempotentResponseSize = 1 << 20
+	// maxIdempotentDrain is how much of a body the handler left unread
+	// is still hashed to complete the fingerprint, e.g. a multipart
+	// body's closing boundary. Requests refused before their body was
+	// read are not remembered. A retry's body may also be this much
+	// longer than the first one's, e.g. for a longer boundary.
+	maxIdempotentDrain = 1 << 20
+)
+
+// Idempotency replays the first response to a request carrying an
+// Idempotency-Key for every retry with the same key from the same
+// caller, for ttl. The key is bound to the request's fingerprint (its
+// method, path, query and body, or the body's parts for multipart
+// forms): a retry with a different one, or any retry while the first
+// request is still running, gets 409. Requests without the header
+// pass through, and 5xx responses are not kept so
+// the retry runs again. It must run after the auth middlewares.
+func Idempotency(store idempotency.Store, ttl time.Duration, log *logger.CustomLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		identity := RequestIdentity(c.Request)
+		body := newFingerprintBody(c.Request)
+		defer body.stop()
+		c.Request.Body = body
+
+		held, reserved, appErr := store.Reserve(ctx, identity, key, time.Now().Add(idempotency.LockTimeout))
+		if appErr != nil {
+			log.Error(appErr.Message, appErr.ToMap())
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Idempotency keys are unavailable, retry later"})
+			return
+		}
+
+		if !reserved {
+			if !held.Done() {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+				return
+			}
+
+			// A retry is hashed no further than the first request's
+			// body could stretch; one longer is another request.
+			fingerprint, complete := body.finish(held.BodySize + maxIdempotentDrain)
+			if !complete || fingerprint != held.Fingerprint {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Idempotency-Key was already used with a different request"})
+				return
+			}
+
+			replay(c, held)
+			return
+		}
+
+		// A panicking handler never answered; its retry runs again.
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if appErr := store.Release(ctx, identity, key); appErr != nil {
+					log.Error(appErr.Message, appErr.ToMap())
+				}
+				panic(recovered)
+			}
+		}()
+
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		fingerprint, complete := body.finish(maxIdempotentDrain)
+		status := writer.Status()
+		if status >= http.StatusInternalServerError || writer.overflow || !complete {
+			if appErr := store.Release(ctx, identity, key); appErr != nil {
+				log.Error(appErr.Message, appErr.ToMap())
+			}
+			return
+		}
+
+		appErr = store.Complete(ctx, &idempotency.Record{
+			Identity:    identity,
+			Key:         key,
+			Fingerprint: fingerprint,
+			BodySize:    body.size,
+			Status:      status,
+			Header:      replayableHeader(writer.Header()),
+			Body:        writer.body.Bytes(),
+			ExpiresAt:   time.Now().Add(ttl),
+		})
+		if appErr != nil {
+			log.Error(appErr.Message, appErr.ToMap())
+		}
+	}
+}
+
+func replay(c *gin.Context, record *idempotency.Record) {
+	for name, values := range record.Header {
+		for _, value := range values {
+			c.Writer.Header().Add(name, value)
+		}
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Status(record.Status)
+	_, _ = c.Writer.Write(record.Body)
+	c.Abort()
+}
+
+// replayableHeader keeps the headers a replay should repeat; the ones
+// other middlewares or the server set on every response are left out.
+func replayableHeader(header http.Header) http.Header {
+	kept := http.Header{}
+	for name, values := range header {
+		if name == "Date" || name == "Content-Length" || name == "Vary" || strings.HasPrefix(name, "Access-Control-") {
+			continue
+		}
+		kept[name] = append([]string(nil), values...)
+	}
+
+	return kept
+}
+
+// RequestIdentity names the caller of request: the token's
+// subject, which stays the same across refreshed tokens, or a hash of
+// the credentials when there is none. The token was already verified
+// by the auth middleware, so its claims are only decoded here.
+func RequestIdentity(request *http.Request) string {
+	authorization := request.Header.Get("Authorization")
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if parts := strings.Split(token, "."); len(parts) == 3 {
+		var claims struct {
+			Subject string `json:"sub"`
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err == nil && json.Unmarshal(payload, &claims) == nil && claims.Subject != "" {
+			return "sub:" + claims.Subject
+		}
+	}
+
+	sum := sha256.Sum256([]byte(authorization + "\n" + request.Header.Get("X-Api-Key")))
+	return "credentials:" + hex.EncodeToString(sum[:])
+}
+
+// fingerprintBody hashes the request line and the body as the handler
+// reads it, so the body is still streamed once. A multipart/form-data
+// body is also parsed as it goes by, and its parts are hashed instead
+// of its bytes: the boundary changes on every send, also on a retry.
+type fingerprintBody struct {
+	io.ReadCloser
+	hash  hash.Hash
+	size  int64
+	eof   bool
+	parts *partsFingerprint
+}
+
+// partsFingerprint hashes the name, filename, type and content digest
+// of every part of the multipart body written to it.
+type partsFingerprint struct {
+	writer *io.PipeWriter
+	hash   hash.Hash
+	err    error
+	done   chan struct{}
+}
+
+func newFingerprintBody(request *http.Request) *fingerprintBody {
+	body := &fingerprintBody{ReadCloser: request.Body, hash: sha256.New()}
+	if body.ReadCloser == nil {
+		body.ReadCloser = http.NoBody
+	}
+	requestLine := request.Method + " " + request.URL.Path + "?" + request.URL.RawQuery + "\n"
+	_, _ = io.WriteString(body.hash, requestLine)
+
+	mediaType, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err == nil && mediaType == "multipart/form-data" && params["boundary"] != "" {
+		reader, writer := io.Pipe()
+		body.parts = &partsFingerprint{writer: writer, hash: sha256.New(), done: make(chan struct{})}
+		_, _ = io.WriteString(body.parts.hash, requestLine)
+		go body.parts.run(multipart.NewReader(reader, params["boundary"]), reader)
+	}
+
+	return body
+}
+
+func (p *partsFingerprint) run(form *multipart.Reader, reader *io.PipeReader) {
+	defer close(p.done)
+	// Whatever follows the closing boundary, or a part that doesn't
+	// parse, is still consumed so the handler's reads never block.
+	defer io.Copy(io.Discard, reader)
+
+	for {
+		part, err := form.NextRawPart()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			p.err = err
+			return
+		}
+
+		digest := sha256.New()
+		if _, err := io.Copy(digest, part); err != nil {
+			p.err = err
+			return
+		}
+		fmt.Fprintf(p.hash, "%q %q %q %x\n", part.FormName(), part.FileName(), part.Header.Get("Content-Type"), digest.Sum(nil))
+	}
+}
+
+func (b *fingerprintBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	b.size += int64(n)
+	if b.parts != nil && n > 0 {
+		_, _ = b.parts.writer.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+
+	return n, err
+}
+
+// finish hashes what is left of the body, at most limit bytes (no
+// limit when negative), and reports whether the whole body was hashed.
+// A multipart body that parsed is fingerprinted by its parts.
+func (b *fingerprintBody) finish(limit int64) (string, bool) {
+	if !b.eof {
+		rest := io.Reader(b)
+		if limit >= 0 {
+			// The byte past limit tells a body that ends right at
+			// the limit from a longer one.
+			rest = io.LimitReader(b, limit+1)
+		}
+		_, _ = io.Copy(io.Discard, rest)
+	}
+
+	sum := b.hash
+	if b.stop() {
+		sum = b.parts.hash
+	}
+
+	return hex.EncodeToString(sum.Sum(nil)), b.eof
+}
+
+// stop ends the parsing of a multipart body and reports whether the
+// parts read form the whole body. It is safe to call more than once.
+func (b *fingerprintBody) stop() bool {
+	if b.parts == nil {
+		return false
+	}
+	_ = b.parts.writer.Close()
+	<-b.parts.done
+
+	return b.eof && b.parts.err == nil
+}
+
+// capturingWriter keeps a copy of the response for replays, up to
+// maxIdempotentResponseSize.
+type capturingWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(data string) (int, error) {
+	w.capture([]byte(data))
+	return w.ResponseWriter.WriteString(data)
+}
+
+func (w *capturingWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > maxIdempotentResponseSize {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
@@ -24,7 +24,10 @@ func (m *MonitoringMiddleware) SentryMiddleware() gin.HandlerFunc {
 
 func (m *MonitoringMiddleware) LogMiddleware(ctx *gin.Context) {
 	var responseBody = logger.HandleResponseBody(ctx.Writer)
-	var requestBody = logger.HandleRequestBody(ctx.Request)
+	var requestBody string
+	if logger.ShouldCaptureRequestBody(ctx.Request) {
+		requestBody = logger.HandleRequestBody(ctx.Request)
+	}
 	requestId := uuid.NewString()
 
 	if hub := sentrygin.GetHubFromContext(ctx); hub != nil {
@@ -0,0 +1,283 @@
+// Package mimetype decides the Content-Type objects are served with.
+// What an object was stored with is trusted first, unless it only
+// says "binary" or "text"; the extension comes next, through a
+// registry operators can extend with MIME_TYPES, and the content
+// itself last.
+package mimetype
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/sniff"
+)
+
+// builtins are the types browsers are strict about, or that
+// mime.TypeByExtension only knows when the host has a mime.types file.
+var builtins = map[string]string{
+	".avif":        "image/avif",
+	".bmp":         "image/bmp",
+	".css":         "text/css",
+	".csv":         "text/csv",
+	".doc":         "application/msword",
+	".docx":        "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".eot":         "application/vnd.ms-fontobject",
+	".epub":        "application/epub+zip",
+	".gif":         "image/gif",
+	".gz":          "application/gzip",
+	".heic":        "image/heic",
+	".htm":         "text/html",
+	".html":        "text/html",
+	".ico":         "image/vnd.microsoft.icon",
+	".ics":         "text/calendar",
+	".jpeg":        "image/jpeg",
+	".jpg":         "image/jpeg",
+	".js":          "text/javascript",
+	".json":        "application/json",
+	".jsonld":      "application/ld+json",
+	".m3u8":        "application/vnd.apple.mpegurl",
+	".m4a":         "audio/mp4",
+	".manifest":    "text/cache-manifest",
+	".map":         "application/json",
+	".md":          "text/markdown",
+	".mjs":         "text/javascript",
+	".mov":         "video/quicktime",
+	".mp3":         "audio/mpeg",
+	".mp4":         "video/mp4",
+	".mpd":         "application/dash+xml",
+	".odp":         "application/vnd.oasis.opendocument.presentation",
+	".ods":         "application/vnd.oasis.opendocument.spreadsheet",
+	".odt":         "application/vnd.oasis.opendocument.text",
+	".oga":         "audio/ogg",
+	".ogg":         "audio/ogg",
+	".ogv":         "video/ogg",
+	".otf":         "font/otf",
+	".pdf":         "application/pdf",
+	".png":         "image/png",
+	".ppt":         "application/vnd.ms-powerpoint",
+	".pptx":        "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".rss":         "application/rss+xml",
+	".svg":         "image/svg+xml",
+	".tar":         "application/x-tar",
+	".tif":         "image/tiff",
+	".tiff":        "image/tiff",
+	".ts":          "video/mp2t",
+	".ttf":         "font/ttf",
+	".txt":         "text/plain",
+	".vtt":         "text/vtt",
+	".wasm":        "application/wasm",
+	".wav":         "audio/wav",
+	".weba":        "audio/webm",
+	".webm":        "video/webm",
+	".webmanifest": "application/manifest+json",
+	".webp":        "image/webp",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".xls":         "application/vnd.ms-excel",
+	".xlsx":        "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".xml":         "application/xml",
+	".zip":         "application/zip",
+}
+
+// Registry maps file extensions to media types. It is safe for
+// concurrent use.
+type Registry struct {
+	mutex sync.RWMutex
+	types map[string]string
+}
+
+// Default is the registry /cdn resolves extensions with.
+var Default = NewRegistry()
+
+// NewRegistry returns a registry holding the built-in types.
+func NewRegistry() *Registry {
+	registry := &Registry{types: make(map[string]string, len(builtins))}
+	for extension, mediaType := range builtins {
+		registry.types[extension] = mediaType
+	}
+	return registry
+}
+
+// Register maps extension, with or without its leading dot, to
+// mediaType, replacing any previous mapping.
+func (r *Registry) Register(extension string, mediaType string) error {
+	extension = NormalizeExtension(extension)
+	if extension == "." {
+		return fmt.Errorf("mime types: empty extension")
+	}
+	if _, _, err := mime.ParseMediaType(mediaType); err != nil {
+		return fmt.Errorf("mime types: %s: %w", extension, err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.types[extension] = mediaType
+	return nil
+}
+
+// Lookup returns the media type filename's extension maps to, falling
+// back to the host's mime.types; "" when the extension is unknown.
+func (r *Registry) Lookup(filename string) string {
+	extension := strings.ToLower(filepath.Ext(filename))
+	if extension == "" {
+		return ""
+	}
+
+	r.mutex.RLock()
+	mediaType, found := r.types[extension]
+	r.mutex.RUnlock()
+	if found {
+		return mediaType
+	}
+
+	return mime.TypeByExtension(extension)
+}
+
+// LoadDefault adds the MIME_TYPES entries to Default. It runs at boot
+// so a malformed entry stops the deploy.
+func LoadDefault() error {
+	for _, entry := range config.EnvMimeTypes() {
+		extension, mediaType, found := strings.Cut(entry, "=")
+		if !found {
+			return fmt.Errorf("mime types: %q is not extension=type", entry)
+		}
+		if err := Default.Register(strings.TrimSpace(extension), strings.TrimSpace(mediaType)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NormalizeExtension lowercases extension and gives it a leading dot.
+func NormalizeExtension(extension string) string {
+	return "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(extension)), ".")
+}
+
+// Options carry what Resolve needs beyond the object itself.
+type Options struct {
+	// Overrides map extensions to the type to serve them with,
+	// whatever the object was stored with.
+	Overrides map[string]string
+	// Charset is added to textual types that don't name one; empty
+	// leaves them alone.
+	Charset string
+	// Head returns the object's leading bytes. It is only called when
+	// nothing else tells the type.
+	Head func() []byte
+}
+
+// Resolve returns the Content-Type to serve filename with, stored
+// being the one it was stored with: the bucket's override for its
+// extension, else stored unless it is generic or a container format
+// the extension narrows down (an .svg stored as text/xml, a .docx as
+// application/zip), else the registry's type for the extension, else
+// the sniffed type. Textual types get options.Charset.
+func (r *Registry) Resolve(filename string, stored string, options Options) string {
+	return withCharset(r.resolve(filename, stored, options), options.Charset)
+}
+
+func (r *Registry) resolve(filename string, stored string, options Options) string {
+	extension := strings.ToLower(filepath.Ext(filename))
+	for key, mediaType := range options.Overrides {
+		if extension != "" && NormalizeExtension(key) == extension {
+			return mediaType
+		}
+	}
+
+	byExtension := r.Lookup(filename)
+	if !sniff.IsGeneric(sniff.MediaType(stored)) && !refines(byExtension, stored) {
+		return stored
+	}
+	if byExtension != "" {
+		// Same type as stored: keep stored, it may name a charset.
+		if sniff.MediaType(byExtension) == sniff.MediaType(stored) {
+			return stored
+		}
+		return byExtension
+	}
+
+	if options.Head != nil {
+		if detected := sniff.Detect(options.Head()); detected != sniff.OctetStream {
+			return detected
+		}
+	}
+
+	if stored != "" {
+		return stored
+	}
+	return sniff.OctetStream
+}
+
+// zipFormats are zip archives under another name; sniffing can't tell
+// them from a plain zip.
+var zipFormats = []string{
+	"application/vnd.openxmlformats-officedocument.",
+	"application/vnd.oasis.opendocument.",
+	"application/java-archive",
+}
+
+// refines reports whether specific is a more precise name for content
+// stored as general: an XML, JSON or zip based format.
+func refines(specific string, general string) bool {
+	specific, general = sniff.MediaType(specific), sniff.MediaType(general)
+	if specific == "" || specific == general {
+		return false
+	}
+
+	switch general {
+	case "text/xml", "application/xml":
+		return strings.HasSuffix(specific, "+xml")
+	case "application/json":
+		return strings.HasSuffix(specific, "+json")
+	case "application/zip":
+		if strings.HasSuffix(specific, "+zip") {
+			return true
+		}
+		for _, prefix := range zipFormats {
+			if strings.HasPrefix(specific, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// withCharset adds charset to a textual contentType without one.
+func withCharset(contentType string, charset string) string {
+	if charset == "" || !textual(sniff.MediaType(contentType)) {
+		return contentType
+	}
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	if _, found := params["charset"]; found {
+		return contentType
+	}
+
+	return contentType + "; charset=" + charset
+}
+
+// textual reports whether mediaType is text a browser has to decode.
+func textual(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+xml"), strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml", "application/x-javascript", "application/ecmascript":
+		return true
+	}
+
+	return false
+}
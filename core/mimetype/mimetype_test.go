@@ -0,0 +1,78 @@
+package mimetype
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	assert.Equal(t, "image/svg+xml", registry.Lookup("logo.SVG"))
+	assert.Equal(t, "font/woff2", registry.Lookup("fonts/inter.woff2"))
+	assert.Empty(t, registry.Lookup("README"))
+	assert.Empty(t, registry.Lookup("model.rbglb"))
+
+	assert.NoError(t, registry.Register("RBGLB", "model/gltf-binary"))
+	assert.Equal(t, "model/gltf-binary", registry.Lookup("model.rbglb"))
+
+	assert.Error(t, registry.Register(".", "text/plain"))
+	assert.Error(t, registry.Register(".x", "not a type"))
+}
+
+func TestLoadDefault(t *testing.T) {
+	os.Setenv("MIME_TYPES", ".usdz=model/vnd.usdz+zip")
+	defer os.Unsetenv("MIME_TYPES")
+
+	assert.NoError(t, LoadDefault())
+	assert.Equal(t, "model/vnd.usdz+zip", Default.Lookup("scene.usdz"))
+
+	os.Setenv("MIME_TYPES", ".usdz")
+	assert.Error(t, LoadDefault())
+}
+
+func TestResolve(t *testing.T) {
+	registry := NewRegistry()
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	options := Options{Charset: "utf-8"}
+
+	tests := []struct {
+		name     string
+		filename string
+		stored   string
+		options  Options
+		want     string
+	}{
+		{"stored type wins", "photo.jpg", "image/webp", options, "image/webp"},
+		{"stored charset is kept", "notes.txt", "text/plain; charset=iso-8859-1", options, "text/plain; charset=iso-8859-1"},
+		{"generic stored type falls back to the extension", "app.js", "text/plain", options, "text/javascript; charset=utf-8"},
+		{"octet-stream falls back to the extension", "font.woff2", "application/octet-stream", options, "font/woff2"},
+		{"xml stored for svg", "logo.svg", "text/xml", options, "image/svg+xml; charset=utf-8"},
+		{"zip stored for docx", "report.docx", "application/zip", options, "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"zip stored for zip", "bundle.zip", "application/zip", options, "application/zip"},
+		{"override beats everything", "notes.MD", "text/markdown", Options{Overrides: map[string]string{"md": "text/plain"}}, "text/plain"},
+		{"no charset configured", "style.css", "", Options{}, "text/css"},
+		{"sniffed without an extension", "blob", "", Options{Head: func() []byte { return png }}, "image/png"},
+		{"nothing known", "blob", "", Options{Head: func() []byte { return []byte{0, 1, 2} }}, "application/octet-stream"},
+		{"generic stored type is the last resort", "blob", "text/plain", options, "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, registry.Resolve(tt.filename, tt.stored, tt.options))
+		})
+	}
+}
+
+func TestResolveSniffsLazily(t *testing.T) {
+	sniffed := false
+	head := func() []byte {
+		sniffed = true
+		return nil
+	}
+
+	NewRegistry().Resolve("photo.png", "", Options{Head: head})
+	assert.False(t, sniffed)
+}
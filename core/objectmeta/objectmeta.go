@@ -0,0 +1,276 @@
+// Package objectmeta validates the metadata clients attach to uploads
+// and maps it to and from what MinIO stores.
+//
+// User metadata travels as x-meta-<name> form fields or X-Meta-<Name>
+// headers and is stored as x-amz-meta-<name>. Values outside printable
+// ASCII are stored as RFC 2047 encoded words, as S3 does, and decoded
+// again on the way out. The MinIO client in use can't set S3 object
+// tags, so tags are kept in the "tags" metadata entry, in the
+// k1=v1&k2=v2 form S3 uses for x-amz-tagging.
+package objectmeta
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/minio/minio-go"
+)
+
+const (
+	// FieldPrefix introduces user metadata in form fields and headers.
+	FieldPrefix = "x-meta-"
+	// MaxUserMetadataSize is S3's limit on user metadata, keys and
+	// encoded values together, tags included.
+	MaxUserMetadataSize = 2048
+	MaxTags             = 10
+	maxTagKeyLength     = 128
+	maxTagValueLength   = 256
+
+	tagsKey         = "tags"
+	storedKeyPrefix = "X-Amz-Meta-"
+)
+
+// reservedKeys are metadata entries rb-cdn writes itself; a client
+// must not be able to forge them, scan verdicts in particular.
+var reservedKeys = map[string]bool{
+	tagsKey:                true,
+	"cas-digest":           true,
+	"scan-status":          true,
+	"scan-signature":       true,
+	"quarantined-filename": true,
+	"encryption":           true,
+	"encryption-key-id":    true,
+	"encryption-key":       true,
+	"image-width":          true,
+	"image-height":         true,
+	"image-color":          true,
+	"image-blurhash":       true,
+	"image-dhash":          true,
+}
+
+var wordDecoder = mime.WordDecoder{}
+
+// FromRequest collects the metadata of an upload from its plain form
+// fields and its headers; a form field wins over a header of the same
+// meaning. Cache-Control, Content-Disposition and Content-Language are
+// only read from fields: as headers they describe the upload request
+// itself, and browsers set some of them on their own.
+func FromRequest(fields map[string]string, header http.Header) (entities.ObjectMetadataEntity, error) {
+	object := entities.ObjectMetadataEntity{
+		CacheControl:       fields["cache_control"],
+		ContentDisposition: fields["content_disposition"],
+		ContentLanguage:    fields["content_language"],
+		Metadata:           map[string]string{},
+	}
+
+	for name, values := range header {
+		if key, found := strings.CutPrefix(strings.ToLower(name), FieldPrefix); found && len(values) > 0 {
+			object.Metadata[key] = values[0]
+		}
+	}
+	for name, value := range fields {
+		if key, found := strings.CutPrefix(strings.ToLower(name), FieldPrefix); found {
+			object.Metadata[key] = value
+		}
+	}
+
+	if encoded := firstNonEmpty(fields[tagsKey], header.Get("X-Tags")); encoded != "" {
+		tags, err := ParseTags(encoded)
+		if err != nil {
+			return object, err
+		}
+		object.Tags = tags
+	}
+
+	return object, Validate(object)
+}
+
+// ParseTags reads tags in the k1=v1&k2=v2 form.
+func ParseTags(encoded string) (map[string]string, error) {
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("tags must be url-encoded key=value pairs joined by &")
+	}
+
+	tags := make(map[string]string, len(values))
+	for key, value := range values {
+		if len(value) != 1 {
+			return nil, fmt.Errorf("tag %q is given more than once", key)
+		}
+		tags[key] = value[0]
+	}
+
+	return tags, nil
+}
+
+// Validate enforces S3's limits and refuses values that can't be
+// stored as headers.
+func Validate(object entities.ObjectMetadataEntity) error {
+	for name, value := range map[string]string{
+		"cache_control":       object.CacheControl,
+		"content_disposition": object.ContentDisposition,
+		"content_language":    object.ContentLanguage,
+	} {
+		if !isHeaderValue(value) {
+			return fmt.Errorf("%s may only contain printable ASCII", name)
+		}
+	}
+
+	for key := range object.Metadata {
+		if !isMetadataKey(key) {
+			return fmt.Errorf("metadata key %q may only use a-z, 0-9 and -", key)
+		}
+		if reservedKeys[strings.ToLower(key)] {
+			return fmt.Errorf("metadata key %q is reserved", key)
+		}
+	}
+
+	if len(object.Tags) > MaxTags {
+		return fmt.Errorf("at most %d tags are allowed", MaxTags)
+	}
+	for key, value := range object.Tags {
+		if key == "" || len(key) > maxTagKeyLength || len(value) > maxTagValueLength {
+			return fmt.Errorf("tag keys must be 1-%d and values 0-%d characters long", maxTagKeyLength, maxTagValueLength)
+		}
+	}
+
+	size := 0
+	for key, value := range UserMetadata(object) {
+		size += len(key) + len(value)
+	}
+	if size > MaxUserMetadataSize {
+		return fmt.Errorf("metadata and tags exceed %d bytes", MaxUserMetadataSize)
+	}
+
+	return nil
+}
+
+// UserMetadata is object's metadata and tags as they go into
+// minio.PutObjectOptions.UserMetadata.
+func UserMetadata(object entities.ObjectMetadataEntity) map[string]string {
+	metadata := make(map[string]string, len(object.Metadata)+1)
+	for key, value := range object.Metadata {
+		metadata[strings.ToLower(key)] = encodeValue(value)
+	}
+
+	if len(object.Tags) > 0 {
+		metadata[tagsKey] = EncodeTags(object.Tags)
+	}
+
+	return metadata
+}
+
+// EncodeTags renders tags in the k1=v1&k2=v2 form, sorted by key.
+func EncodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// FromObject reads the metadata back from a stat of the object.
+func FromObject(info minio.ObjectInfo) entities.ObjectMetadataEntity {
+	object := entities.ObjectMetadataEntity{
+		CacheControl:       info.Metadata.Get("Cache-Control"),
+		ContentDisposition: info.Metadata.Get("Content-Disposition"),
+		ContentLanguage:    info.Metadata.Get("Content-Language"),
+		Metadata:           map[string]string{},
+	}
+
+	for name, values := range info.Metadata {
+		key, found := strings.CutPrefix(http.CanonicalHeaderKey(name), storedKeyPrefix)
+		if !found || len(values) == 0 {
+			continue
+		}
+
+		key = strings.ToLower(key)
+		switch {
+		case key == tagsKey:
+			object.Tags, _ = ParseTags(values[0])
+		case !reservedKeys[key]:
+			object.Metadata[key] = decodeValue(values[0])
+		}
+	}
+
+	return object
+}
+
+// SetHeaders echoes object onto a response: the standard headers as
+// themselves, user metadata as X-Meta-<Name> and tags as X-Tags.
+func SetHeaders(header http.Header, object entities.ObjectMetadataEntity) {
+	for name, value := range map[string]string{
+		"Cache-Control":       object.CacheControl,
+		"Content-Disposition": object.ContentDisposition,
+		"Content-Language":    object.ContentLanguage,
+	} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+
+	keys := make([]string, 0, len(object.Metadata))
+	for key := range object.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		header.Set(FieldPrefix+key, encodeValue(object.Metadata[key]))
+	}
+
+	if len(object.Tags) > 0 {
+		header.Set("X-Tags", EncodeTags(object.Tags))
+	}
+}
+
+func encodeValue(value string) string {
+	for _, r := range value {
+		if r < 0x20 || r > 0x7e {
+			return mime.QEncoding.Encode("utf-8", value)
+		}
+	}
+	return value
+}
+
+func decodeValue(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func isMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHeaderValue(value string) bool {
+	for _, r := range value {
+		if r < 0x20 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
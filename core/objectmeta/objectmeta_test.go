@@ -0,0 +1,98 @@
+package objectmeta
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromRequest(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Meta-Uploader-Id", "42")
+	header.Set("X-Meta-Alt-Text", "from header")
+	header.Set("X-Tags", "env=prod")
+	header.Set("Cache-Control", "no-cache")
+
+	object, err := FromRequest(map[string]string{
+		"bucket":              "photos",
+		"x-meta-alt-text":     "A beach at night",
+		"tags":                "env=dev&team=web",
+		"content_disposition": `attachment; filename="beach.jpg"`,
+	}, header)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"uploader-id": "42", "alt-text": "A beach at night"}, object.Metadata)
+	assert.Equal(t, map[string]string{"env": "dev", "team": "web"}, object.Tags)
+	assert.Equal(t, `attachment; filename="beach.jpg"`, object.ContentDisposition)
+	assert.Empty(t, object.CacheControl, "the request's own Cache-Control must not be stored")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		object entities.ObjectMetadataEntity
+		err    string
+	}{
+		{"valid", entities.ObjectMetadataEntity{Metadata: map[string]string{"alt": "Praia à noite"}}, ""},
+		{"bad key", entities.ObjectMetadataEntity{Metadata: map[string]string{"Alt_Text": "x"}}, "may only use"},
+		{"reserved key", entities.ObjectMetadataEntity{Metadata: map[string]string{"cas-digest": "x"}}, "reserved"},
+		{"forged scan verdict", entities.ObjectMetadataEntity{Metadata: map[string]string{"scan-status": "clean"}}, "reserved"},
+		{"forged image analysis", entities.ObjectMetadataEntity{Metadata: map[string]string{"image-blurhash": "x"}}, "reserved"},
+		{"control char", entities.ObjectMetadataEntity{CacheControl: "max-age=60\r\nX-Evil: 1"}, "printable ASCII"},
+		{"too large", entities.ObjectMetadataEntity{Metadata: map[string]string{"notes": strings.Repeat("a", MaxUserMetadataSize)}}, "exceed"},
+		{"too many tags", entities.ObjectMetadataEntity{Tags: manyTags(MaxTags + 1)}, "at most"},
+		{"long tag value", entities.ObjectMetadataEntity{Tags: map[string]string{"k": strings.Repeat("v", 257)}}, "tag keys"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.object)
+			if test.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestParseTagsRejectsDuplicates(t *testing.T) {
+	_, err := ParseTags("a=1&a=2")
+	assert.ErrorContains(t, err, "more than once")
+}
+
+func TestRoundTrip(t *testing.T) {
+	object := entities.ObjectMetadataEntity{
+		CacheControl: "public, max-age=3600",
+		Metadata:     map[string]string{"alt-text": "Praia à noite", "uploader-id": "42"},
+		Tags:         map[string]string{"env": "prod"},
+	}
+
+	stored := http.Header{}
+	stored.Set("Cache-Control", object.CacheControl)
+	stored.Set("X-Amz-Meta-Cas-Digest", "abc")
+	for key, value := range UserMetadata(object) {
+		stored.Set("X-Amz-Meta-"+key, value)
+	}
+
+	assert.Equal(t, object, FromObject(minio.ObjectInfo{Metadata: stored}))
+
+	echoed := http.Header{}
+	SetHeaders(echoed, object)
+	assert.Equal(t, "public, max-age=3600", echoed.Get("Cache-Control"))
+	assert.Equal(t, "42", echoed.Get("X-Meta-Uploader-Id"))
+	assert.Equal(t, "=?utf-8?q?Praia_=C3=A0_noite?=", echoed.Get("X-Meta-Alt-Text"))
+	assert.Equal(t, "env=prod", echoed.Get("X-Tags"))
+}
+
+func manyTags(n int) map[string]string {
+	tags := map[string]string{}
+	for i := 0; i < n; i++ {
+		tags[strings.Repeat("k", i+1)] = "v"
+	}
+	return tags
+}
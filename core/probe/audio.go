@@ -0,0 +1,196 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+var wavCodecs = map[uint16]string{
+	0x0001: "pcm",
+	0x0003: "pcm_float",
+	0x0006: "alaw",
+	0x0007: "ulaw",
+	0x0055: "mp3",
+	0xfffe: "pcm",
+}
+
+// wav walks the chunks of a RIFF WAVE file: fmt carries the format and
+// byte rate, data the length of the samples.
+func wav(r io.ReaderAt, size int64) Facts {
+	var facts Facts
+	byteRate := uint64(0)
+
+	for offset := int64(12); offset+8 <= size; {
+		header := readAt(r, offset, 8)
+		if len(header) < 8 {
+			break
+		}
+		chunkSize := int64(binary.LittleEndian.Uint32(header[4:]))
+
+		switch string(header[:4]) {
+		case "fmt ":
+			format := readAt(r, offset+8, 16)
+			if len(format) < 16 {
+				return facts
+			}
+			facts.AudioCodec = wavCodecs[binary.LittleEndian.Uint16(format)]
+			byteRate = uint64(binary.LittleEndian.Uint32(format[8:]))
+		case "data":
+			facts.Duration = seconds(uint64(min(chunkSize, size-offset-8)), byteRate)
+			return facts
+		}
+
+		// Chunks are padded to an even length.
+		offset += 8 + chunkSize + chunkSize%2
+	}
+
+	return facts
+}
+
+// flac reads the STREAMINFO block, which FLAC requires to come first.
+func flac(r io.ReaderAt) Facts {
+	info := readAt(r, 8, 18)
+	if len(info) < 18 {
+		return Facts{}
+	}
+
+	// 20 bits of sample rate, 3 of channels, 5 of bits per sample and
+	// 36 of total samples, starting 10 bytes in.
+	bits := binary.BigEndian.Uint64(info[10:])
+	rate := bits >> 44
+	samples := bits & (1<<36 - 1)
+
+	return Facts{AudioCodec: "flac", Duration: seconds(samples, rate)}
+}
+
+// oggTail is how much of the end of an Ogg file is searched for its
+// last page, whose granule position gives the duration.
+const oggTail = 64 << 10
+
+// ogg reads the codec from the first packet and the duration from the
+// granule position of the last page.
+func ogg(r io.ReaderAt, size int64) Facts {
+	page := readAt(r, 0, 27+255)
+	if len(page) < 27 {
+		return Facts{}
+	}
+	segments := int(page[26])
+	if len(page) < 27+segments {
+		return Facts{}
+	}
+	packet := readAt(r, int64(27+segments), 64)
+
+	var facts Facts
+	rate := uint64(0)
+	skip := uint64(0)
+	switch {
+	case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 12:
+		// Opus granules always count 48 kHz samples, minus the
+		// pre-skip the encoder added.
+		facts.AudioCodec = "opus"
+		rate = 48000
+		skip = uint64(binary.LittleEndian.Uint16(packet[10:]))
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 16:
+		facts.AudioCodec = "vorbis"
+		rate = uint64(binary.LittleEndian.Uint32(packet[12:]))
+	case bytes.HasPrefix(packet, []byte("\x7fFLAC")):
+		facts.AudioCodec = "flac"
+	case bytes.HasPrefix(packet, []byte("\x80theora")):
+		facts.VideoCodec = "theora"
+	}
+
+	if rate == 0 {
+		return facts
+	}
+
+	start := max(size-oggTail, 0)
+	tail := readAt(r, start, int(size-start))
+	last := bytes.LastIndex(tail, []byte("OggS"))
+	if last < 0 || len(tail) < last+14 {
+		return facts
+	}
+
+	granule := binary.LittleEndian.Uint64(tail[last+6:])
+	if granule > skip {
+		facts.Duration = seconds(granule-skip, rate)
+	}
+
+	return facts
+}
+
+// MPEG audio layer III tables: bitrates in kbit/s for MPEG-1 and for
+// MPEG-2 and 2.5, sample rates in Hz keyed by the header's version
+// bits (3 is MPEG-1, 2 MPEG-2, 0 MPEG-2.5).
+var (
+	mp3Bitrates = [2][16]uint64{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	}
+	mp3SampleRates = map[byte][3]uint64{
+		3: {44100, 48000, 32000},
+		2: {22050, 24000, 16000},
+		0: {11025, 12000, 8000},
+	}
+)
+
+// mp3 reads the first MPEG layer III frame after any ID3v2 tag. A
+// Xing, Info or VBRI header gives the frame count of a variable bitrate
+// file; without one the bitrate is taken as constant.
+func mp3(r io.ReaderAt, size int64) Facts {
+	offset := int64(0)
+	if id3 := readAt(r, 0, 10); len(id3) == 10 && string(id3[:3]) == "ID3" {
+		// The tag size is syncsafe: 7 bits per byte.
+		offset = 10 + (int64(id3[6])<<21 | int64(id3[7])<<14 | int64(id3[8])<<7 | int64(id3[9]))
+	}
+
+	frame := readAt(r, offset, 64)
+	if len(frame) < 4 || frame[0] != 0xff || frame[1]&0xe0 != 0xe0 {
+		return Facts{}
+	}
+
+	version := frame[1] >> 3 & 0x03
+	layer := frame[1] >> 1 & 0x03
+	rates, known := mp3SampleRates[version]
+	rateIndex := frame[2] >> 2 & 0x03
+	if !known || layer != 1 || rateIndex == 3 {
+		return Facts{}
+	}
+
+	// The Xing header follows the side information, whose length
+	// depends on the version and on the frame being mono.
+	mono := frame[3]>>6 == 3
+	table, samplesPerFrame, sideInfo := 1, uint64(576), 17
+	if mono {
+		sideInfo = 9
+	}
+	if version == 3 {
+		table, samplesPerFrame, sideInfo = 0, 1152, 32
+		if mono {
+			sideInfo = 17
+		}
+	}
+
+	rate := rates[rateIndex]
+	facts := Facts{AudioCodec: "mp3"}
+
+	if xing := 4 + sideInfo; len(frame) >= xing+12 {
+		tag := string(frame[xing : xing+4])
+		if (tag == "Xing" || tag == "Info") && frame[xing+7]&0x01 != 0 {
+			frames := uint64(binary.BigEndian.Uint32(frame[xing+8:]))
+			facts.Duration = seconds(frames*samplesPerFrame, rate)
+			return facts
+		}
+	}
+
+	if len(frame) >= 4+32+18 && string(frame[36:40]) == "VBRI" {
+		frames := uint64(binary.BigEndian.Uint32(frame[36+14:]))
+		facts.Duration = seconds(frames*samplesPerFrame, rate)
+		return facts
+	}
+
+	bitrate := mp3Bitrates[table][frame[2]>>4] * 1000
+	facts.Duration = seconds(uint64(size-offset)*8, bitrate)
+
+	return facts
+}
@@ -0,0 +1,77 @@
+package probe
+
+import (
+	"encoding/binary"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+// decodeConfig reads the dimensions of the formats the standard
+// library decodes.
+func decodeConfig(r io.ReaderAt, size int64) Facts {
+	config, _, err := image.DecodeConfig(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return Facts{}
+	}
+
+	return Facts{Width: config.Width, Height: config.Height}
+}
+
+func bmp(head []byte) Facts {
+	if len(head) < 26 {
+		return Facts{}
+	}
+
+	width := int32(binary.LittleEndian.Uint32(head[18:]))
+	height := int32(binary.LittleEndian.Uint32(head[22:]))
+	// A negative height marks a top-down bitmap.
+	if height < 0 {
+		height = -height
+	}
+
+	return Facts{Width: int(width), Height: int(height)}
+}
+
+// webp reads the canvas of an extended WebP, or the frame size of a
+// simple lossy or lossless one.
+func webp(r io.ReaderAt) Facts {
+	chunk := readAt(r, 12, 18)
+	if len(chunk) < 8 {
+		return Facts{}
+	}
+
+	data := chunk[8:]
+	switch string(chunk[:4]) {
+	case "VP8X":
+		if len(data) < 10 {
+			return Facts{}
+		}
+		return Facts{
+			Width:  int(uint32(data[4])|uint32(data[5])<<8|uint32(data[6])<<16) + 1,
+			Height: int(uint32(data[7])|uint32(data[8])<<8|uint32(data[9])<<16) + 1,
+		}
+	case "VP8 ":
+		// Frame tag, start code, then 14-bit dimensions.
+		if len(data) < 10 || data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+			return Facts{}
+		}
+		return Facts{
+			Width:  int(binary.LittleEndian.Uint16(data[6:]) & 0x3fff),
+			Height: int(binary.LittleEndian.Uint16(data[8:]) & 0x3fff),
+		}
+	case "VP8L":
+		if len(data) < 5 || data[0] != 0x2f {
+			return Facts{}
+		}
+		bits := binary.LittleEndian.Uint32(data[1:])
+		return Facts{
+			Width:  int(bits&0x3fff) + 1,
+			Height: int(bits>>14&0x3fff) + 1,
+		}
+	}
+
+	return Facts{}
+}
@@ -0,0 +1,203 @@
+package probe
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// isoCodecs names the sample entry types of MP4, MOV and 3GP tracks.
+var isoCodecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp08": "vp8",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"mp4a": "aac",
+	"Opus": "opus",
+	"fLaC": "flac",
+	"alac": "alac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	".mp3": "mp3",
+}
+
+// isoBMFF probes the ISO base media file format: MP4, MOV and 3GP
+// through their moov box, HEIF and AVIF images through their meta box.
+func isoBMFF(r io.ReaderAt, size int64) Facts {
+	var facts Facts
+
+	for offset := int64(0); offset < size; {
+		header := readAt(r, offset, 16)
+		if len(header) < 8 {
+			break
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if len(header) < 16 {
+				return facts
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			break
+		}
+
+		switch boxType := string(header[4:8]); boxType {
+		case "moov", "meta":
+			if boxSize-headerSize > maxBoxSize {
+				return facts
+			}
+			payload := readAt(r, offset+headerSize, int(boxSize-headerSize))
+			if boxType == "moov" {
+				movie(payload, &facts)
+			} else {
+				itemProperties(payload, &facts)
+			}
+		}
+
+		offset += boxSize
+	}
+
+	return facts
+}
+
+// children calls fn for each box in data.
+func children(data []byte, fn func(boxType string, payload []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return
+		}
+
+		fn(string(data[4:8]), data[headerSize:size])
+		data = data[size:]
+	}
+}
+
+func movie(moov []byte, facts *Facts) {
+	children(moov, func(boxType string, payload []byte) {
+		switch boxType {
+		case "mvhd":
+			facts.Duration = movieDuration(payload)
+		case "trak":
+			track(payload, facts)
+		}
+	})
+}
+
+// movieDuration reads mvhd, whose field widths depend on its version.
+func movieDuration(mvhd []byte) time.Duration {
+	if len(mvhd) < 20 {
+		return 0
+	}
+
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0
+		}
+		return seconds(binary.BigEndian.Uint64(mvhd[24:]), uint64(binary.BigEndian.Uint32(mvhd[20:])))
+	}
+
+	return seconds(uint64(binary.BigEndian.Uint32(mvhd[16:])), uint64(binary.BigEndian.Uint32(mvhd[12:])))
+}
+
+// track reads a trak's handler and first sample entry; the first video
+// and the first audio track found are the ones reported.
+func track(trak []byte, facts *Facts) {
+	handler := ""
+	var entry []byte
+
+	var walk func(data []byte)
+	walk = func(data []byte) {
+		children(data, func(boxType string, payload []byte) {
+			switch boxType {
+			case "mdia", "minf", "stbl":
+				walk(payload)
+			case "hdlr":
+				if len(payload) >= 12 {
+					handler = string(payload[8:12])
+				}
+			case "stsd":
+				// Version, flags and entry count precede the entries.
+				if len(payload) >= 16 {
+					entry = payload[8:]
+				}
+			}
+		})
+	}
+	walk(trak)
+
+	if len(entry) < 8 {
+		return
+	}
+	codec := codecName(isoCodecs, string(entry[4:8]))
+
+	switch handler {
+	case "vide":
+		if facts.VideoCodec != "" {
+			return
+		}
+		facts.VideoCodec = codec
+		// A visual sample entry keeps its dimensions after 24 bytes of
+		// reserved and pre-defined fields.
+		if len(entry) >= 36 {
+			facts.Width = int(binary.BigEndian.Uint16(entry[32:]))
+			facts.Height = int(binary.BigEndian.Uint16(entry[34:]))
+		}
+	case "soun":
+		if facts.AudioCodec == "" {
+			facts.AudioCodec = codec
+		}
+	}
+}
+
+// itemProperties reads the largest ispe property of a HEIF meta box;
+// the smaller ones belong to thumbnails and grid tiles.
+func itemProperties(meta []byte, facts *Facts) {
+	// meta is a full box: version and flags come first.
+	if len(meta) < 4 {
+		return
+	}
+
+	children(meta[4:], func(boxType string, iprp []byte) {
+		if boxType != "iprp" {
+			return
+		}
+		children(iprp, func(boxType string, ipco []byte) {
+			if boxType != "ipco" {
+				return
+			}
+			children(ipco, func(boxType string, ispe []byte) {
+				if boxType != "ispe" || len(ispe) < 12 {
+					return
+				}
+				width := int(binary.BigEndian.Uint32(ispe[4:]))
+				height := int(binary.BigEndian.Uint32(ispe[8:]))
+				if width*height > facts.Width*facts.Height {
+					facts.Width, facts.Height = width, height
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,199 @@
+package probe
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// Matroska element IDs, with their length marker bits kept.
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549a966
+	ebmlTimecodeScale = 0x2ad7b1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654ae6b
+	ebmlTrackEntry    = 0xae
+	ebmlTrackType     = 0x83
+	ebmlCodecID       = 0x86
+	ebmlVideo         = 0xe0
+	ebmlPixelWidth    = 0xb0
+	ebmlPixelHeight   = 0xba
+	ebmlCluster       = 0x1f43b675
+)
+
+// matroskaFront is how much of a Matroska file is read: muxers put
+// the Info and Tracks elements ahead of the first cluster.
+const matroskaFront = 1 << 20
+
+var matroskaCodecs = map[string]string{
+	"V_VP8":            "vp8",
+	"V_VP9":            "vp9",
+	"V_AV1":            "av1",
+	"V_MPEG4/ISO/AVC":  "h264",
+	"V_MPEGH/ISO/HEVC": "hevc",
+	"V_THEORA":         "theora",
+	"A_OPUS":           "opus",
+	"A_VORBIS":         "vorbis",
+	"A_AAC":            "aac",
+	"A_FLAC":           "flac",
+	"A_MPEG/L3":        "mp3",
+	"A_AC3":            "ac3",
+	"A_EAC3":           "eac3",
+	"A_PCM/INT/LIT":    "pcm",
+	"A_PCM/FLOAT/IEEE": "pcm_float",
+}
+
+// matroska probes Matroska and WebM files.
+func matroska(r io.ReaderAt, size int64) Facts {
+	front := readAt(r, 0, int(min(size, matroskaFront)))
+
+	var facts Facts
+	scale := uint64(time.Millisecond)
+	duration := 0.0
+
+	var walk func(data []byte)
+	walk = func(data []byte) {
+		for len(data) > 0 {
+			id, payload, rest, ok := ebmlElement(data)
+			if !ok {
+				return
+			}
+			data = rest
+
+			switch id {
+			case ebmlSegment, ebmlInfo, ebmlTracks:
+				walk(payload)
+			case ebmlCluster:
+				return
+			case ebmlTimecodeScale:
+				scale = ebmlUint(payload)
+			case ebmlDuration:
+				duration = ebmlFloat(payload)
+			case ebmlTrackEntry:
+				matroskaTrack(payload, &facts)
+			}
+		}
+	}
+	walk(front)
+
+	facts.Duration = time.Duration(duration * float64(scale))
+	return facts
+}
+
+func matroskaTrack(entry []byte, facts *Facts) {
+	trackType := uint64(0)
+	codec := ""
+	width, height := 0, 0
+
+	for len(entry) > 0 {
+		id, payload, rest, ok := ebmlElement(entry)
+		if !ok {
+			break
+		}
+		entry = rest
+
+		switch id {
+		case ebmlTrackType:
+			trackType = ebmlUint(payload)
+		case ebmlCodecID:
+			codec = codecName(matroskaCodecs, string(payload))
+		case ebmlVideo:
+			for len(payload) > 0 {
+				id, value, rest, ok := ebmlElement(payload)
+				if !ok {
+					break
+				}
+				payload = rest
+				switch id {
+				case ebmlPixelWidth:
+					width = int(ebmlUint(value))
+				case ebmlPixelHeight:
+					height = int(ebmlUint(value))
+				}
+			}
+		}
+	}
+
+	switch {
+	case trackType == 1 && facts.VideoCodec == "":
+		facts.VideoCodec = codec
+		facts.Width, facts.Height = width, height
+	case trackType == 2 && facts.AudioCodec == "":
+		facts.AudioCodec = codec
+	}
+}
+
+// ebmlElement splits the element at the start of data into its ID and
+// payload. An unknown size, as live-muxed segments have, or one past
+// the data read runs to the end of data.
+func ebmlElement(data []byte) (uint64, []byte, []byte, bool) {
+	id, idLength := ebmlVint(data, true)
+	if idLength == 0 {
+		return 0, nil, nil, false
+	}
+
+	size, sizeLength := ebmlVint(data[idLength:], false)
+	if sizeLength == 0 {
+		return 0, nil, nil, false
+	}
+
+	start := idLength + sizeLength
+	end := uint64(len(data))
+	if size < end-uint64(start) {
+		end = uint64(start) + size
+	}
+
+	return id, data[start:end], data[end:], true
+}
+
+// ebmlVint reads a variable-length integer. IDs keep their length
+// marker; sizes drop it, and all value bits set means "unknown".
+func ebmlVint(data []byte, keepMarker bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || length > len(data) {
+		return 0, 0
+	}
+
+	value := uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xff >> length)
+	}
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+
+	if !keepMarker && value == 1<<(7*length)-1 {
+		return math.MaxUint64, length
+	}
+
+	return value, length
+}
+
+func ebmlUint(data []byte) uint64 {
+	value := uint64(0)
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+
+	return 0
+}
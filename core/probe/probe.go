@@ -0,0 +1,168 @@
+// Package probe reads the facts clients usually ask about right after
+// an upload from a stored media file: the dimensions of an image or
+// video, and the duration and codecs of audio and video. Only the
+// container headers are parsed, nothing is decoded.
+package probe
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+)
+
+// headSize is how much of a file is read to tell its format.
+const headSize = 64
+
+// maxBoxSize caps the container metadata read into memory: an MP4
+// moov box or a HEIF meta box.
+const maxBoxSize = 32 << 20
+
+// Facts are what Inspect could find out; fields it couldn't are left
+// zero.
+type Facts struct {
+	Width      int
+	Height     int
+	Duration   time.Duration
+	VideoCodec string
+	AudioCodec string
+}
+
+// Inspect probes the size bytes of r, which is expected to be an
+// image, audio or video file. Unknown formats and malformed headers
+// give empty Facts rather than an error: probing is best effort and
+// never fails an upload.
+func Inspect(r io.ReaderAt, size int64) Facts {
+	source := newBlockReader(r, size)
+
+	head := make([]byte, headSize)
+	n, _ := source.ReadAt(head, 0)
+	head = head[:n]
+
+	var facts Facts
+	switch {
+	case bytes.HasPrefix(head, []byte("\x89PNG")), bytes.HasPrefix(head, []byte("\xff\xd8")),
+		bytes.HasPrefix(head, []byte("GIF8")):
+		facts = decodeConfig(source, size)
+	case bytes.HasPrefix(head, []byte("BM")):
+		facts = bmp(head)
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		facts = webp(source)
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		facts = wav(source, size)
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		facts = isoBMFF(source, size)
+	case bytes.HasPrefix(head, []byte("\x1a\x45\xdf\xa3")):
+		facts = matroska(source, size)
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		facts = flac(source)
+	case bytes.HasPrefix(head, []byte("OggS")):
+		facts = ogg(source, size)
+	case bytes.HasPrefix(head, []byte("ID3")), len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0:
+		facts = mp3(source, size)
+	}
+
+	return facts
+}
+
+// seconds converts a count of units at rate units per second.
+func seconds(units uint64, rate uint64) time.Duration {
+	if rate == 0 {
+		return 0
+	}
+
+	whole := units / rate
+	rest := units % rate
+	return time.Duration(whole)*time.Second + time.Duration(rest*uint64(time.Second)/rate)
+}
+
+func readAt(r io.ReaderAt, offset int64, length int) []byte {
+	buffer := make([]byte, length)
+	n, _ := r.ReadAt(buffer, offset)
+	return buffer[:n]
+}
+
+// codecName maps a container's codec identifier to the name clients
+// know it by, falling back to the identifier itself.
+func codecName(names map[string]string, id string) string {
+	if name, found := names[id]; found {
+		return name
+	}
+
+	return strings.ToLower(strings.TrimSpace(id))
+}
+
+// blockReader serves reads from whole blocks of the underlying reader
+// and keeps them. Each ReadAt of a MinIO object is its own ranged GET,
+// so the many small reads of header parsing would otherwise each cost
+// a round trip.
+type blockReader struct {
+	reader io.ReaderAt
+	size   int64
+	blocks map[int64][]byte
+}
+
+const (
+	blockSize = 64 << 10
+	maxBlocks = 1024
+)
+
+func newBlockReader(r io.ReaderAt, size int64) *blockReader {
+	return &blockReader{reader: r, size: size, blocks: map[int64][]byte{}}
+}
+
+func (b *blockReader) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, io.EOF
+	}
+
+	// Large reads, such as a whole moov box, go straight through.
+	if len(p) > 2*blockSize {
+		return b.reader.ReadAt(p, offset)
+	}
+
+	read := 0
+	for read < len(p) {
+		position := offset + int64(read)
+		if position >= b.size {
+			return read, io.EOF
+		}
+
+		block, err := b.block(position / blockSize)
+		within := int(position % blockSize)
+		if within >= len(block) {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return read, err
+		}
+		read += copy(p[read:], block[within:])
+	}
+
+	return read, nil
+}
+
+func (b *blockReader) block(index int64) ([]byte, error) {
+	if block, found := b.blocks[index]; found {
+		return block, nil
+	}
+
+	length := int64(blockSize)
+	if remaining := b.size - index*blockSize; remaining < length {
+		length = remaining
+	}
+
+	block := make([]byte, length)
+	n, err := b.reader.ReadAt(block, index*blockSize)
+	block = block[:n]
+	if err == io.EOF && int64(n) == length {
+		err = nil
+	}
+
+	if len(b.blocks) >= maxBlocks {
+		b.blocks = map[int64][]byte{}
+	}
+	b.blocks[index] = block
+
+	return block, err
+}
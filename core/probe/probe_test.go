@@ -0,0 +1,217 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func inspect(data []byte) Facts {
+	return Inspect(bytes.NewReader(data), int64(len(data)))
+}
+
+func be16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func le16(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+func le32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func isoBox(boxType string, payload ...[]byte) []byte {
+	body := join(payload...)
+	return join(be32(uint32(8+len(body))), []byte(boxType), body)
+}
+
+func TestInspectImages(t *testing.T) {
+	picture := image.NewRGBA(image.Rect(0, 0, 40, 30))
+
+	var encoded bytes.Buffer
+	assert.NoError(t, png.Encode(&encoded, picture))
+	assert.Equal(t, Facts{Width: 40, Height: 30}, inspect(encoded.Bytes()))
+
+	encoded.Reset()
+	assert.NoError(t, jpeg.Encode(&encoded, picture, nil))
+	assert.Equal(t, Facts{Width: 40, Height: 30}, inspect(encoded.Bytes()))
+
+	encoded.Reset()
+	assert.NoError(t, gif.Encode(&encoded, picture, nil))
+	assert.Equal(t, Facts{Width: 40, Height: 30}, inspect(encoded.Bytes()))
+
+	bitmap := join([]byte("BM"), make([]byte, 16), le32(300), le32(uint32(0x100000000-200)), make([]byte, 40))
+	assert.Equal(t, Facts{Width: 300, Height: 200}, inspect(bitmap))
+}
+
+func TestInspectWebP(t *testing.T) {
+	riff := func(chunk string, data []byte) []byte {
+		body := join([]byte("WEBP"), []byte(chunk), le32(uint32(len(data))), data)
+		return join([]byte("RIFF"), le32(uint32(len(body))), body)
+	}
+
+	extended := riff("VP8X", []byte{0, 0, 0, 0, 0x7f, 0x07, 0, 0x37, 0x04, 0})
+	assert.Equal(t, Facts{Width: 1920, Height: 1080}, inspect(extended))
+
+	lossy := riff("VP8 ", join([]byte{0, 0, 0, 0x9d, 0x01, 0x2a}, le16(640), le16(480)))
+	assert.Equal(t, Facts{Width: 640, Height: 480}, inspect(lossy))
+
+	bits := uint32(99) | uint32(49)<<14
+	lossless := riff("VP8L", join([]byte{0x2f}, le32(bits), []byte{0}))
+	assert.Equal(t, Facts{Width: 100, Height: 50}, inspect(lossless))
+}
+
+func TestInspectISOBMFF(t *testing.T) {
+	t.Run("mp4 with a video and an audio track", func(t *testing.T) {
+		videoEntry := join(be32(86), []byte("avc1"), make([]byte, 24), be16(1280), be16(720), make([]byte, 50))
+		audioEntry := join(be32(36), []byte("mp4a"), make([]byte, 28))
+		trak := func(handler string, entry []byte) []byte {
+			return isoBox("trak", isoBox("mdia",
+				isoBox("hdlr", make([]byte, 8), []byte(handler), make([]byte, 12)),
+				isoBox("minf", isoBox("stbl", isoBox("stsd", make([]byte, 4), be32(1), entry))),
+			))
+		}
+
+		// mvhd v0: 12.5 s at a timescale of 1000.
+		mvhd := isoBox("mvhd", make([]byte, 12), be32(1000), be32(12500), make([]byte, 80))
+		file := join(
+			isoBox("ftyp", []byte("isom"), be32(512)),
+			isoBox("mdat", make([]byte, 4096)),
+			isoBox("moov", mvhd, trak("vide", videoEntry), trak("soun", audioEntry)),
+		)
+
+		assert.Equal(t, Facts{
+			Width:      1280,
+			Height:     720,
+			Duration:   12500 * time.Millisecond,
+			VideoCodec: "h264",
+			AudioCodec: "aac",
+		}, inspect(file))
+	})
+
+	t.Run("heif keeps the largest image size", func(t *testing.T) {
+		ispe := func(width, height uint32) []byte {
+			return isoBox("ispe", make([]byte, 4), be32(width), be32(height))
+		}
+		file := join(
+			isoBox("ftyp", []byte("heic"), be32(0)),
+			isoBox("meta", make([]byte, 4), isoBox("iprp", isoBox("ipco", ispe(320, 240), ispe(4032, 3024)))),
+		)
+
+		assert.Equal(t, Facts{Width: 4032, Height: 3024}, inspect(file))
+	})
+}
+
+func ebml(id uint32, payload ...[]byte) []byte {
+	body := join(payload...)
+	idBytes := be32(id)
+	for len(idBytes) > 1 && idBytes[0] == 0 {
+		idBytes = idBytes[1:]
+	}
+	// Eight-byte sizes keep the fixture simple.
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(body)))
+	size[0] = 0x01
+	return join(idBytes, size, body)
+}
+
+func TestInspectMatroska(t *testing.T) {
+	duration := binary.BigEndian.AppendUint64(nil, math.Float64bits(5000))
+	file := join(
+		ebml(0x1a45dfa3, ebml(0x4282, []byte("webm"))),
+		// A live-muxed segment of unknown size.
+		[]byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		ebml(ebmlInfo, ebml(ebmlTimecodeScale, []byte{0x0f, 0x42, 0x40}), ebml(ebmlDuration, duration)),
+		ebml(ebmlTracks,
+			ebml(ebmlTrackEntry, ebml(ebmlTrackType, []byte{1}), ebml(ebmlCodecID, []byte("V_VP9")),
+				ebml(ebmlVideo, ebml(ebmlPixelWidth, be16(640)), ebml(ebmlPixelHeight, be16(360)))),
+			ebml(ebmlTrackEntry, ebml(ebmlTrackType, []byte{2}), ebml(ebmlCodecID, []byte("A_OPUS"))),
+		),
+		ebml(ebmlCluster, make([]byte, 64)),
+	)
+
+	assert.Equal(t, Facts{
+		Width:      640,
+		Height:     360,
+		Duration:   5 * time.Second,
+		VideoCodec: "vp9",
+		AudioCodec: "opus",
+	}, inspect(file))
+}
+
+func TestInspectAudio(t *testing.T) {
+	t.Run("wav", func(t *testing.T) {
+		format := join(le16(1), le16(2), le32(44100), le32(176400), le16(4), le16(16))
+		body := join([]byte("WAVE"),
+			[]byte("fmt "), le32(16), format,
+			[]byte("data"), le32(352800), make([]byte, 352800))
+		file := join([]byte("RIFF"), le32(uint32(len(body))), body)
+
+		assert.Equal(t, Facts{AudioCodec: "pcm", Duration: 2 * time.Second}, inspect(file))
+	})
+
+	t.Run("flac", func(t *testing.T) {
+		info := binary.BigEndian.AppendUint64(nil, 44100<<44|1<<41|15<<36|441000)
+		file := join([]byte("fLaC"), []byte{0x80, 0, 0, 34}, make([]byte, 10), info, make([]byte, 16))
+
+		assert.Equal(t, Facts{AudioCodec: "flac", Duration: 10 * time.Second}, inspect(file))
+	})
+
+	t.Run("ogg opus", func(t *testing.T) {
+		page := func(granule uint64, packet []byte) []byte {
+			header := join([]byte("OggS"), []byte{0, 0}, binary.LittleEndian.AppendUint64(nil, granule), make([]byte, 12))
+			return join(header, []byte{1, byte(len(packet))}, packet)
+		}
+		head := join([]byte("OpusHead"), []byte{1, 2}, le16(312), le32(48000), make([]byte, 3))
+		file := join(page(0, head), page(0, []byte("OpusTags")), make([]byte, 1000), page(3*48000+312, make([]byte, 100)))
+
+		assert.Equal(t, Facts{AudioCodec: "opus", Duration: 3 * time.Second}, inspect(file))
+	})
+
+	t.Run("mp3 at a constant bitrate", func(t *testing.T) {
+		// MPEG-1 layer III, 128 kbit/s, 44.1 kHz, stereo.
+		file := join([]byte{0xff, 0xfb, 0x90, 0x00}, make([]byte, 159996))
+
+		assert.Equal(t, Facts{AudioCodec: "mp3", Duration: 10 * time.Second}, inspect(file))
+	})
+
+	t.Run("mp3 with an ID3 tag and a Xing header", func(t *testing.T) {
+		tag := join([]byte("ID3"), []byte{4, 0, 0}, []byte{0, 0, 0x01, 0x00}, make([]byte, 128))
+		frame := join([]byte{0xff, 0xfb, 0x90, 0x00}, make([]byte, 32), []byte("Xing"), be32(1), be32(441), make([]byte, 400))
+		file := join(tag, frame, make([]byte, 10000))
+
+		assert.Equal(t, Facts{AudioCodec: "mp3", Duration: 441 * 1152 * time.Second / 44100}, inspect(file))
+	})
+}
+
+func TestInspectUnknown(t *testing.T) {
+	assert.Equal(t, Facts{}, inspect([]byte("plain text, not media")))
+	assert.Equal(t, Facts{}, inspect(nil))
+	assert.Equal(t, Facts{}, inspect(isoBox("ftyp", []byte("isom"))[:6]))
+}
+
+// countingReaderAt counts the reads that reach the underlying reader.
+type countingReaderAt struct {
+	*bytes.Reader
+	reads int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	c.reads++
+	return c.Reader.ReadAt(p, offset)
+}
+
+func TestInspectReadsWholeBlocks(t *testing.T) {
+	var encoded bytes.Buffer
+	assert.NoError(t, png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8))))
+
+	source := &countingReaderAt{Reader: bytes.NewReader(encoded.Bytes())}
+	assert.Equal(t, Facts{Width: 8, Height: 8}, Inspect(source, source.Size()))
+	assert.Equal(t, 1, source.reads)
+}
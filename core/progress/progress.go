@@ -0,0 +1,331 @@
+// Package progress is the in-process pub/sub uploads and jobs publish
+// their progress to, and /progress streams to clients as Server-Sent
+// Events. Nothing is shared between replicas: a client follows the
+// progress of a request on the replica that serves it.
+package progress
+
+import (
+	stdErrors "errors"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Stages an upload goes through. Receiving covers the body streaming
+// into MinIO; the rest follow once it is stored.
+var Stage = struct {
+	Receiving  string
+	Verifying  string
+	Storing    string
+	Processing string
+	Done       string
+	Failed     string
+}{
+	Receiving:  "receiving",
+	Verifying:  "verifying",
+	Storing:    "storing",
+	Processing: "processing",
+	Done:       "done",
+	Failed:     "failed",
+}
+
+// ErrInUse is returned by Start for an id another upload is still
+// reporting under.
+var ErrInUse = stdErrors.New("progress id is in use")
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+const (
+	// publishInterval spaces out byte count events; stage changes
+	// and the final event are published at once.
+	publishInterval = 200 * time.Millisecond
+	// retention is how long a finished upload's last event stays
+	// available to clients that subscribe late.
+	retention = 5 * time.Minute
+	// idleTimeout drops trackers nobody has reported to in a while,
+	// such as a tus upload the client abandoned.
+	idleTimeout = time.Hour
+)
+
+// Event is the state of one upload at a point in time. Byte counts are
+// cumulative, so a subscriber that misses events loses nothing.
+type Event struct {
+	ID            string `json:"id"`
+	Stage         string `json:"stage"`
+	BytesReceived int64  `json:"bytes_received"`
+	BytesWritten  int64  `json:"bytes_written"`
+	// TotalBytes is what the client announced, when it did.
+	TotalBytes int64       `json:"total_bytes,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Final reports whether no event follows this one.
+func (e Event) Final() bool {
+	return e.Stage == Stage.Done || e.Stage == Stage.Failed
+}
+
+// ValidID reports whether id can name an upload: 1 to 64 letters,
+// digits, dashes or underscores.
+func ValidID(id string) bool {
+	return validID.MatchString(id)
+}
+
+// Hub routes events from trackers to subscribers.
+type Hub struct {
+	mutex    sync.Mutex
+	trackers map[string]*Tracker
+}
+
+// Default is the hub every upload in the process reports to.
+var Default = NewHub()
+
+func NewHub() *Hub {
+	return &Hub{trackers: map[string]*Tracker{}}
+}
+
+// Start opens a tracker for a new upload by owner. An id still in use
+// by an unfinished upload is refused with ErrInUse; a finished one is
+// taken over.
+func (h *Hub) Start(id string, owner string, total int64) (*Tracker, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if current, found := h.trackers[id]; found && !current.event.Final() {
+		return nil, ErrInUse
+	}
+
+	return h.open(id, owner, total, 0), nil
+}
+
+// Continue picks up the tracker of an upload that arrives over several
+// requests, such as tus PATCHes, or opens one with offset bytes already
+// received. An upload another owner is still reporting under is left
+// alone and the request goes untracked: nil is returned.
+func (h *Hub) Continue(id string, owner string, total int64, offset int64) *Tracker {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if current, found := h.trackers[id]; found && !current.event.Final() {
+		if current.owner != owner {
+			return nil
+		}
+		return current
+	}
+
+	return h.open(id, owner, total, offset)
+}
+
+func (h *Hub) open(id string, owner string, total int64, offset int64) *Tracker {
+	tracker := &Tracker{
+		hub:         h,
+		owner:       owner,
+		event:       Event{ID: id, Stage: Stage.Receiving, BytesReceived: offset, TotalBytes: total},
+		updatedAt:   time.Now(),
+		subscribers: map[chan Event]struct{}{},
+	}
+	if current, found := h.trackers[id]; found {
+		current.closeSubscribers()
+	}
+	h.trackers[id] = tracker
+
+	return tracker
+}
+
+// Subscribe returns the upload's latest event and a channel of the
+// ones that follow, closed after the final event or by cancel. Only
+// owner may follow an upload; found is false otherwise, as it is for
+// unknown ids.
+func (h *Hub) Subscribe(id string, owner string) (Event, <-chan Event, func(), bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	tracker, found := h.trackers[id]
+	if !found || tracker.owner != owner {
+		return Event{}, nil, nil, false
+	}
+
+	// A buffer of one that publish keeps at the latest event: a slow
+	// subscriber skips to the current state instead of blocking the
+	// upload.
+	events := make(chan Event, 1)
+	if tracker.event.Final() {
+		close(events)
+		return tracker.event, events, func() {}, true
+	}
+
+	tracker.subscribers[events] = struct{}{}
+	cancel := func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		if _, subscribed := tracker.subscribers[events]; subscribed {
+			delete(tracker.subscribers, events)
+			close(events)
+		}
+	}
+
+	return tracker.event, events, cancel, true
+}
+
+// Purge drops finished trackers past their retention and idle ones.
+func (h *Hub) Purge() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	for id, tracker := range h.trackers {
+		finished := tracker.event.Final() && now.Sub(tracker.updatedAt) > retention
+		if finished || now.Sub(tracker.updatedAt) > idleTimeout {
+			tracker.closeSubscribers()
+			delete(h.trackers, id)
+		}
+	}
+}
+
+// StartJanitor purges the hub every interval.
+func (h *Hub) StartJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.Purge()
+		}
+	}()
+}
+
+// Tracker publishes the progress of one upload. Its methods are safe
+// for concurrent use and do nothing on a nil Tracker, so code paths
+// that aren't tracked can report all the same.
+type Tracker struct {
+	hub         *Hub
+	owner       string
+	event       Event
+	updatedAt   time.Time
+	publishedAt time.Time
+	subscribers map[chan Event]struct{}
+}
+
+// ID is the id clients follow the upload under.
+func (t *Tracker) ID() string {
+	if t == nil {
+		return ""
+	}
+
+	t.hub.mutex.Lock()
+	defer t.hub.mutex.Unlock()
+
+	return t.event.ID
+}
+
+// Received counts n more bytes of the body as received.
+func (t *Tracker) Received(n int64) {
+	t.update(false, func(event *Event) { event.BytesReceived += n })
+}
+
+// Written counts n more bytes as handed to MinIO.
+func (t *Tracker) Written(n int64) {
+	t.update(false, func(event *Event) { event.BytesWritten += n })
+}
+
+// SetTotal records the size of the body once it is known.
+func (t *Tracker) SetTotal(total int64) {
+	t.update(false, func(event *Event) { event.TotalBytes = total })
+}
+
+// SetStage moves the upload on to stage.
+func (t *Tracker) SetStage(stage string) {
+	t.update(true, func(event *Event) { event.Stage = stage })
+}
+
+// Done ends the upload with the response its client got.
+func (t *Tracker) Done(result interface{}) {
+	t.update(true, func(event *Event) {
+		event.Stage = Stage.Done
+		event.Result = result
+	})
+}
+
+// Fail ends the upload with message.
+func (t *Tracker) Fail(message string) {
+	t.update(true, func(event *Event) {
+		event.Stage = Stage.Failed
+		event.Error = message
+	})
+}
+
+// CountReceived counts what is read from body as received.
+func (t *Tracker) CountReceived(body io.Reader) io.Reader {
+	if t == nil {
+		return body
+	}
+
+	return &countingReader{reader: body, count: t.Received}
+}
+
+// CountWritten counts what is read from body as written to MinIO.
+func (t *Tracker) CountWritten(body io.Reader) io.Reader {
+	if t == nil {
+		return body
+	}
+
+	return &countingReader{reader: body, count: t.Written}
+}
+
+// update applies change and publishes the result, at once when urgent
+// and otherwise at most every publishInterval. A finished upload takes
+// no more changes.
+func (t *Tracker) update(urgent bool, change func(*Event)) {
+	if t == nil {
+		return
+	}
+
+	t.hub.mutex.Lock()
+	defer t.hub.mutex.Unlock()
+
+	if t.event.Final() {
+		return
+	}
+
+	change(&t.event)
+	t.updatedAt = time.Now()
+	if !urgent && t.updatedAt.Sub(t.publishedAt) < publishInterval {
+		return
+	}
+	t.publishedAt = t.updatedAt
+
+	for events := range t.subscribers {
+		select {
+		case <-events:
+		default:
+		}
+		events <- t.event
+	}
+
+	if t.event.Final() {
+		t.closeSubscribers()
+	}
+}
+
+// closeSubscribers ends every subscription; the hub's mutex is held.
+func (t *Tracker) closeSubscribers() {
+	for events := range t.subscribers {
+		delete(t.subscribers, events)
+		close(events)
+	}
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  func(int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.count(int64(n))
+	}
+
+	return n, err
+}
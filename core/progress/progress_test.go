@@ -0,0 +1,124 @@
+package progress
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub(t *testing.T) {
+	t.Run("subscribers follow an upload to its end", func(t *testing.T) {
+		hub := NewHub()
+		tracker, err := hub.Start("up1", "alice", 10)
+		assert.NoError(t, err)
+
+		current, events, cancel, found := hub.Subscribe("up1", "alice")
+		assert.True(t, found)
+		defer cancel()
+		assert.Equal(t, Stage.Receiving, current.Stage)
+		assert.Equal(t, int64(10), current.TotalBytes)
+
+		tracker.SetStage(Stage.Processing)
+		event := <-events
+		assert.Equal(t, Stage.Processing, event.Stage)
+
+		tracker.Done("stored")
+		event = <-events
+		assert.True(t, event.Final())
+		assert.Equal(t, "stored", event.Result)
+
+		_, open := <-events
+		assert.False(t, open)
+	})
+
+	t.Run("byte counts reach slow subscribers as the latest state", func(t *testing.T) {
+		hub := NewHub()
+		tracker, _ := hub.Start("up1", "alice", 0)
+		_, events, cancel, _ := hub.Subscribe("up1", "alice")
+		defer cancel()
+
+		_, _ = io.Copy(io.Discard, tracker.CountReceived(strings.NewReader("abcd")))
+		_, _ = io.Copy(io.Discard, tracker.CountWritten(strings.NewReader("ab")))
+		tracker.Received(100)
+		tracker.SetStage(Stage.Verifying)
+
+		event := <-events
+		assert.Equal(t, Stage.Verifying, event.Stage)
+		assert.Equal(t, int64(104), event.BytesReceived)
+		assert.Equal(t, int64(2), event.BytesWritten)
+	})
+
+	t.Run("only the owner can subscribe", func(t *testing.T) {
+		hub := NewHub()
+		hub.Start("up1", "alice", 0)
+
+		_, _, _, found := hub.Subscribe("up1", "bob")
+		assert.False(t, found)
+		_, _, _, found = hub.Subscribe("missing", "alice")
+		assert.False(t, found)
+	})
+
+	t.Run("an id in use is refused until its upload ends", func(t *testing.T) {
+		hub := NewHub()
+		tracker, _ := hub.Start("up1", "alice", 0)
+
+		_, err := hub.Start("up1", "bob", 0)
+		assert.ErrorIs(t, err, ErrInUse)
+
+		tracker.Fail("boom")
+		_, err = hub.Start("up1", "bob", 0)
+		assert.NoError(t, err)
+	})
+
+	t.Run("late subscribers get the final event", func(t *testing.T) {
+		hub := NewHub()
+		tracker, _ := hub.Start("up1", "alice", 0)
+		tracker.Fail("boom")
+		tracker.Done("ignored")
+
+		current, events, _, found := hub.Subscribe("up1", "alice")
+		assert.True(t, found)
+		assert.Equal(t, Stage.Failed, current.Stage)
+		assert.Equal(t, "boom", current.Error)
+		_, open := <-events
+		assert.False(t, open)
+	})
+
+	t.Run("continue picks up an unfinished upload", func(t *testing.T) {
+		hub := NewHub()
+		first := hub.Continue("tus1", "alice", 100, 40)
+		first.Received(10)
+
+		again := hub.Continue("tus1", "alice", 100, 50)
+		assert.Same(t, first, again)
+		assert.Nil(t, hub.Continue("tus1", "bob", 100, 50))
+	})
+
+	t.Run("purge drops idle trackers", func(t *testing.T) {
+		hub := NewHub()
+		tracker, _ := hub.Start("up1", "alice", 0)
+		tracker.updatedAt = time.Now().Add(-2 * idleTimeout)
+
+		hub.Purge()
+
+		_, _, _, found := hub.Subscribe("up1", "alice")
+		assert.False(t, found)
+	})
+
+	t.Run("a nil tracker ignores reports", func(t *testing.T) {
+		var tracker *Tracker
+		tracker.Received(10)
+		tracker.Done(nil)
+		assert.Equal(t, "", tracker.ID())
+	})
+}
+
+func TestValidID(t *testing.T) {
+	assert.True(t, ValidID("3f1c-upload_1"))
+	assert.False(t, ValidID(""))
+	assert.False(t, ValidID("../etc"))
+	assert.False(t, ValidID(strings.Repeat("a", 65)))
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/envelope"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/keyring"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/encrypt"
+)
+
+// Metadata entries of an encrypted object: its mode, the keyring key
+// it is sealed under and, for envelope encryption, its wrapped data
+// key. Under SSE-C MinIO only shows them to requests carrying the key.
+const (
+	EncryptionMetadata      = "Encryption"
+	EncryptionKeyIDMetadata = "Encryption-Key-Id"
+	EncryptionKeyMetadata   = "Encryption-Key"
+)
+
+// encryptionStagingPrefix holds the copies Reencrypt writes before
+// they replace the original.
+const encryptionStagingPrefix = ".encryption/staging/"
+
+// ssecPurpose separates SSE-C keys from anything else derived from the
+// same master key.
+const ssecPurpose = "rb-cdn sse-c"
+
+// Object is an object being read: the *minio.Object itself, or a
+// decrypting view of it for envelope-encrypted objects.
+type Object interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+	Stat() (minio.ObjectInfo, error)
+}
+
+// objectSeal is how one object's bytes are encrypted. A nil seal means
+// they aren't.
+type objectSeal struct {
+	mode       string
+	keyID      string
+	sse        encrypt.ServerSide
+	dataKey    []byte
+	wrappedKey string
+}
+
+// sealFor returns the seal a new object written to bucket under
+// objectName gets: the bucket's mode, under the active keyring key.
+func sealFor(bucket string, objectName string) (*objectSeal, error) {
+	mode := config.BucketSettings(bucket).Encryption.Mode
+	if mode == entities.EncryptionMode.None {
+		return nil, nil
+	}
+
+	keyID, masterKey, found := keyring.Default().Active()
+	if !found {
+		return nil, fmt.Errorf("bucket %s is encrypted but KEYRING_FILE holds no keys", bucket)
+	}
+
+	if mode == entities.EncryptionMode.SSEC {
+		return ssecSeal(keyID, masterKey, bucket, objectName)
+	}
+
+	dataKey, err := envelope.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := envelope.WrapKey(masterKey, keyID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectSeal{mode: mode, keyID: keyID, dataKey: dataKey, wrappedKey: wrappedKey}, nil
+}
+
+// ssecSeal derives the SSE-C key of bucket/objectName from masterKey.
+// Every object gets its own key, so MinIO never sees the same key
+// twice and never sees a master key.
+func ssecSeal(keyID string, masterKey []byte, bucket string, objectName string) (*objectSeal, error) {
+	sse, err := encrypt.NewSSEC(keyring.Derive(masterKey, ssecPurpose, bucket+"/"+objectName))
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectSeal{mode: entities.EncryptionMode.SSEC, keyID: keyID, sse: sse}, nil
+}
+
+// readSeals lists the seals to try, in order, to read an existing
+// object: an SSE-C object can't even be stated without its key, and
+// the key it was written under may not be the active one anymore. In
+// SSE-C buckets the keys go first; elsewhere plain comes first, and
+// the keys are only tried for objects written while the bucket was
+// SSE-C.
+func readSeals(bucket string, objectName string) []*objectSeal {
+	ring := keyring.Default()
+	ids := ring.IDs()
+
+	seals := make([]*objectSeal, 0, len(ids)+1)
+	for _, id := range ids {
+		masterKey, _ := ring.Key(id)
+		if seal, err := ssecSeal(id, masterKey, bucket, objectName); err == nil {
+			seals = append(seals, seal)
+		}
+	}
+
+	if config.BucketSettings(bucket).Encryption.Mode == entities.EncryptionMode.SSEC {
+		return append(seals, nil)
+	}
+
+	return append([]*objectSeal{nil}, seals...)
+}
+
+// statSealed stats bucket/objectName trying each of readSeals, and
+// returns the info along with the seal the object was written under.
+// For envelope objects the info reports the size of the plaintext.
+func statSealed(client *minio.Client, bucket string, objectName string) (*minio.ObjectInfo, *objectSeal, error) {
+	var firstErr error
+	for _, seal := range readSeals(bucket, objectName) {
+		info, err := client.StatObject(bucket, objectName, minio.StatObjectOptions{
+			GetObjectOptions: minio.GetObjectOptions{ServerSideEncryption: seal.serverSide()},
+		})
+		if err == nil {
+			if info.Metadata.Get("X-Amz-Meta-"+EncryptionMetadata) != entities.EncryptionMode.Envelope {
+				return &info, seal, nil
+			}
+			seal, err = openEnvelope(info.Metadata)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s/%s: %w", bucket, objectName, err)
+			}
+			info.Size = envelope.PlainSize(info.Size)
+			return &info, seal, nil
+		}
+
+		// A missing object is missing whatever the key.
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil, err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return nil, nil, firstErr
+}
+
+// openEnvelope unwraps the data key an envelope object's metadata
+// carries.
+func openEnvelope(metadata http.Header) (*objectSeal, error) {
+	keyID := metadata.Get("X-Amz-Meta-" + EncryptionKeyIDMetadata)
+	masterKey, found := keyring.Default().Key(keyID)
+	if !found {
+		return nil, fmt.Errorf("sealed under key %q, which KEYRING_FILE doesn't hold", keyID)
+	}
+
+	wrappedKey := metadata.Get("X-Amz-Meta-" + EncryptionKeyMetadata)
+	dataKey, err := envelope.UnwrapKey(masterKey, keyID, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectSeal{mode: entities.EncryptionMode.Envelope, keyID: keyID, dataKey: dataKey, wrappedKey: wrappedKey}, nil
+}
+
+func (seal *objectSeal) serverSide() encrypt.ServerSide {
+	if seal == nil {
+		return nil
+	}
+	return seal.sse
+}
+
+func (seal *objectSeal) envelope() bool {
+	return seal != nil && seal.mode == entities.EncryptionMode.Envelope
+}
+
+// same reports whether seal and other encrypt under the same mode and
+// keyring key.
+func (seal *objectSeal) same(other *objectSeal) bool {
+	if seal == nil || other == nil {
+		return seal == other
+	}
+	return seal.mode == other.mode && seal.keyID == other.keyID
+}
+
+// options returns options with seal's encryption and metadata in
+// place of whatever encryption metadata they carried.
+func (seal *objectSeal) options(options minio.PutObjectOptions) minio.PutObjectOptions {
+	metadata := make(map[string]string, len(options.UserMetadata)+3)
+	for key, value := range options.UserMetadata {
+		switch http.CanonicalHeaderKey(strings.TrimPrefix(strings.ToLower(key), "x-amz-meta-")) {
+		case EncryptionMetadata, EncryptionKeyIDMetadata, EncryptionKeyMetadata:
+		default:
+			metadata[key] = value
+		}
+	}
+
+	options.UserMetadata = metadata
+	options.ServerSideEncryption = seal.serverSide()
+	if seal == nil {
+		return options
+	}
+
+	metadata[EncryptionMetadata] = seal.mode
+	metadata[EncryptionKeyIDMetadata] = seal.keyID
+	if seal.envelope() {
+		metadata[EncryptionKeyMetadata] = seal.wrappedKey
+	}
+
+	return options
+}
+
+// encrypt returns what to store for a body read from reader.
+func (seal *objectSeal) encrypt(reader io.Reader) (io.Reader, error) {
+	if !seal.envelope() {
+		return reader, nil
+	}
+	return envelope.NewEncryptReader(reader, seal.dataKey)
+}
+
+// storedSize maps the size of a body to the size stored for it; -1,
+// an unknown size, stays unknown.
+func (seal *objectSeal) storedSize(size int64) int64 {
+	if !seal.envelope() || size < 0 {
+		return size
+	}
+	return envelope.CipherSize(size)
+}
+
+// bodySize maps a stored size back to the size of the body.
+func (seal *objectSeal) bodySize(size int64) int64 {
+	if !seal.envelope() {
+		return size
+	}
+	return envelope.PlainSize(size)
+}
+
+// optionsFromInfo rebuilds the put options an object was stored with
+// from its stat, for rewrites that must keep its metadata.
+func optionsFromInfo(info minio.ObjectInfo) minio.PutObjectOptions {
+	options := minio.PutObjectOptions{
+		ContentType:        info.ContentType,
+		ContentEncoding:    info.Metadata.Get("Content-Encoding"),
+		ContentDisposition: info.Metadata.Get("Content-Disposition"),
+		ContentLanguage:    info.Metadata.Get("Content-Language"),
+		CacheControl:       info.Metadata.Get("Cache-Control"),
+		UserMetadata:       map[string]string{},
+	}
+
+	for name, values := range info.Metadata {
+		key, found := strings.CutPrefix(http.CanonicalHeaderKey(name), "X-Amz-Meta-")
+		if found && len(values) > 0 {
+			options.UserMetadata[key] = values[0]
+		}
+	}
+
+	return options
+}
+
+// Reencrypt rewrites objectName under the bucket's encryption mode and
+// the active keyring key, and reports whether it had to. Moving between
+// SSE-C keys, or in or out of SSE-C, is a server-side copy; an envelope
+// object only gets its data key wrapped again. Anything else streams
+// the object through rb-cdn into a staging copy first, so a failure
+// leaves the original as it was. An object replaced while it is being
+// rewritten is left alone.
+func (service *MinioService) Reencrypt(bucket string, objectName string) (bool, *errors.AppError) {
+	client, appError := service.startMinioService()
+	if appError != nil {
+		return false, appError
+	}
+
+	info, current, err := statSealed(client, bucket, objectName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, errors.ServiceError(err.Error())
+	}
+
+	target, err := sealFor(bucket, objectName)
+	if err != nil {
+		return false, errors.ServiceError(err.Error())
+	}
+
+	if current.same(target) {
+		return false, nil
+	}
+
+	switch {
+	case current.envelope() && target.envelope():
+		_, masterKey, _ := keyring.Default().Active()
+		wrappedKey, err := envelope.WrapKey(masterKey, target.keyID, current.dataKey)
+		if err != nil {
+			return false, errors.ServiceError(err.Error())
+		}
+		target.dataKey, target.wrappedKey = current.dataKey, wrappedKey
+
+		metadata := putObjectMetadata(target.options(optionsFromInfo(*info)))
+		return true, copySealed(client, bucket, objectName, nil, objectName, nil, metadata, info.ETag)
+	case !current.envelope() && !target.envelope():
+		metadata := putObjectMetadata(target.options(optionsFromInfo(*info)))
+		return true, copySealed(client, bucket, objectName, current, objectName, target, metadata, info.ETag)
+	}
+
+	return service.rewrite(bucket, objectName, *info)
+}
+
+// rewrite re-encrypts objectName by reading it back and storing it
+// again, through a staging copy under encryptionStagingPrefix.
+func (service *MinioService) rewrite(bucket string, objectName string, info minio.ObjectInfo) (bool, *errors.AppError) {
+	object, appErr := service.GetObject(bucket, objectName, minio.GetObjectOptions{})
+	if appErr != nil {
+		return false, appErr
+	}
+	defer object.Close()
+
+	staging := encryptionStagingPrefix + uuid.NewString()
+	if _, appErr := service.UploadObjectStream(context.Background(), bucket, staging, object, optionsFromInfo(info)); appErr != nil {
+		return false, appErr
+	}
+	defer service.RemoveObject(bucket, staging)
+
+	latest, appErr := service.LookupObject(bucket, objectName)
+	if appErr != nil {
+		return false, appErr
+	}
+	if latest == nil || latest.ETag != info.ETag {
+		return false, nil
+	}
+
+	if appErr := service.CopyObject(bucket, staging, objectName, nil); appErr != nil {
+		return false, appErr
+	}
+
+	return true, nil
+}
+
+// envelopeObject decrypts an envelope object on the fly.
+type envelopeObject struct {
+	*envelope.Reader
+	object *minio.Object
+	info   minio.ObjectInfo
+}
+
+func (o *envelopeObject) Stat() (minio.ObjectInfo, error) {
+	return o.info, nil
+}
+
+func (o *envelopeObject) Close() error {
+	return o.object.Close()
+}
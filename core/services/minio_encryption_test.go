@@ -0,0 +1,96 @@
+package services
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/envelope"
+	"github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectSealOptions(t *testing.T) {
+	options := minio.PutObjectOptions{
+		ContentType: "application/pdf",
+		UserMetadata: map[string]string{
+			"Owner":                 "alice",
+			"X-Amz-Meta-Encryption": "sse-c",
+			"encryption-key-id":     "old",
+			EncryptionKeyMetadata:   "stale",
+		},
+	}
+
+	t.Run("a seal replaces the encryption metadata", func(t *testing.T) {
+		seal := &objectSeal{mode: entities.EncryptionMode.Envelope, keyID: "2026-10", wrappedKey: "wrapped"}
+
+		sealed := seal.options(options)
+		assert.Equal(t, "application/pdf", sealed.ContentType)
+		assert.Nil(t, sealed.ServerSideEncryption)
+		assert.Equal(t, map[string]string{
+			"Owner":                 "alice",
+			EncryptionMetadata:      "envelope",
+			EncryptionKeyIDMetadata: "2026-10",
+			EncryptionKeyMetadata:   "wrapped",
+		}, sealed.UserMetadata)
+	})
+
+	t.Run("an SSE-C seal sets the customer key", func(t *testing.T) {
+		seal, err := ssecSeal("2026-10", bytes.Repeat([]byte{1}, 32), "documents", "a.pdf")
+		assert.NoError(t, err)
+
+		sealed := seal.options(options)
+		assert.NotNil(t, sealed.ServerSideEncryption)
+		assert.Equal(t, "sse-c", sealed.UserMetadata[EncryptionMetadata])
+		assert.NotContains(t, sealed.UserMetadata, EncryptionKeyMetadata)
+	})
+
+	t.Run("no seal strips it", func(t *testing.T) {
+		var seal *objectSeal
+
+		plain := seal.options(options)
+		assert.Nil(t, plain.ServerSideEncryption)
+		assert.Equal(t, map[string]string{"Owner": "alice"}, plain.UserMetadata)
+	})
+}
+
+func TestObjectSealSizes(t *testing.T) {
+	var plain *objectSeal
+	assert.Equal(t, int64(100), plain.storedSize(100))
+	assert.Equal(t, int64(100), plain.bodySize(100))
+
+	sealed := &objectSeal{mode: entities.EncryptionMode.Envelope}
+	assert.Equal(t, envelope.CipherSize(100), sealed.storedSize(100))
+	assert.Equal(t, int64(100), sealed.bodySize(envelope.CipherSize(100)))
+	assert.Equal(t, int64(-1), sealed.storedSize(-1))
+}
+
+func TestObjectSealSame(t *testing.T) {
+	var plain *objectSeal
+	current := &objectSeal{mode: entities.EncryptionMode.SSEC, keyID: "2026-04"}
+
+	assert.True(t, plain.same(nil))
+	assert.False(t, plain.same(current))
+	assert.False(t, current.same(nil))
+	assert.True(t, current.same(&objectSeal{mode: entities.EncryptionMode.SSEC, keyID: "2026-04"}))
+	assert.False(t, current.same(&objectSeal{mode: entities.EncryptionMode.SSEC, keyID: "2026-10"}))
+	assert.False(t, current.same(&objectSeal{mode: entities.EncryptionMode.Envelope, keyID: "2026-04"}))
+}
+
+func TestOptionsFromInfo(t *testing.T) {
+	info := minio.ObjectInfo{
+		ContentType: "image/png",
+		Metadata: http.Header{
+			"Cache-Control":         {"max-age=60"},
+			"X-Amz-Meta-Owner":      {"alice"},
+			"X-Amz-Meta-Encryption": {"sse-c"},
+			"X-Amz-Server-Side-Encryption-Customer-Algorithm": {"AES256"},
+		},
+	}
+
+	options := optionsFromInfo(info)
+	assert.Equal(t, "image/png", options.ContentType)
+	assert.Equal(t, "max-age=60", options.CacheControl)
+	assert.Equal(t, map[string]string{"Owner": "alice", "Encryption": "sse-c"}, options.UserMetadata)
+}
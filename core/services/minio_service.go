@@ -1,42 +1,95 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"github.com/RodolfoBonis/rb-cdn/core/config"
 	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/envelope"
 	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/keyring"
 	"github.com/minio/minio-go"
+	"io"
 	"net/url"
 	"strings"
 	"time"
 )
 
 type MinioService struct {
-	host      string
-	accessId  string
-	secretKey string
+	host       string
+	publicHost string
+	region     string
+	accessId   string
+	secretKey  string
 }
 
 type IMinioService interface {
 	startMinioService() (*minio.Client, *errors.AppError)
 	UploadObject(bucket string, file entities.FileEntity, options minio.PutObjectOptions) (string, *errors.AppError)
-	GetObject(bucket string, objectName string, options minio.GetObjectOptions) (*minio.Object, *errors.AppError)
-	GetObjectURL(bucket string, objectName string) (string, *errors.AppError)
+	UploadObjectStream(ctx context.Context, bucket string, objectName string, reader io.Reader, options minio.PutObjectOptions) (*entities.StoredObjectEntity, *errors.AppError)
+	PutObject(bucket string, objectName string, reader io.Reader, size int64, options minio.PutObjectOptions) (int64, *errors.AppError)
+	RemoveObject(bucket string, objectName string) *errors.AppError
+	CopyObject(bucket string, source string, destination string, options *minio.PutObjectOptions) *errors.AppError
+	SetObjectMetadata(bucket string, objectName string, metadata map[string]string) *errors.AppError
+	ObjectExists(bucket string, objectName string) (bool, *errors.AppError)
+	LookupObject(bucket string, objectName string) (*minio.ObjectInfo, *errors.AppError)
+	ListObjects(bucket string, prefix string) ([]minio.ObjectInfo, *errors.AppError)
+	WalkObjects(bucket string, prefix string, visit func(object minio.ObjectInfo) bool) *errors.AppError
+	GetObject(bucket string, objectName string, options minio.GetObjectOptions) (Object, *errors.AppError)
+	GetObjectURL(bucket string, objectName string, expiry time.Duration, reqParams url.Values) (string, *errors.AppError)
+	PresignObject(method string, bucket string, objectName string, expiry time.Duration) (string, *errors.AppError)
+	PresignPostPolicy(policy *minio.PostPolicy) (string, map[string]string, *errors.AppError)
 	GetObjectInfo(bucket string, objectName string) (*minio.ObjectInfo, *errors.AppError)
 	ListBuckets() ([]minio.BucketInfo, *errors.AppError)
+	Reencrypt(bucket string, objectName string) (bool, *errors.AppError)
 }
 
 func NewMinioService() IMinioService {
 	return &MinioService{
-		host:      config.EnvMinioHost(),
-		accessId:  config.EnvMinioAccessId(),
-		secretKey: config.EnvMinioSecretKey(),
+		host:       config.EnvMinioHost(),
+		publicHost: config.EnvMinioPublicHost(),
+		region:     config.EnvMinioRegion(),
+		accessId:   config.EnvMinioAccessId(),
+		secretKey:  config.EnvMinioSecretKey(),
 	}
 }
 
 func (service *MinioService) startMinioService() (*minio.Client, *errors.AppError) {
-	// Parse the host to extract hostname and determine if it uses SSL
-	minioHost := service.host
+	minioHost, useSSL := parseMinioEndpoint(service.host)
+
+	client, err := minio.New(minioHost, service.accessId, service.secretKey, useSSL)
+
+	if err != nil {
+		return nil, errors.ServiceError(err.Error())
+	}
+
+	return client, nil
+}
+
+// startPresignClient returns the client presigned URLs are built
+// with: bound to MINIO_PUBLIC_HOST when set, and with the region
+// pinned so signing stays a purely local computation.
+func (service *MinioService) startPresignClient() (*minio.Client, *errors.AppError) {
+	host := service.host
+	if service.publicHost != "" {
+		host = service.publicHost
+	}
+
+	minioHost, useSSL := parseMinioEndpoint(host)
+
+	client, err := minio.NewWithRegion(minioHost, service.accessId, service.secretKey, useSSL, service.region)
+
+	if err != nil {
+		return nil, errors.ServiceError(err.Error())
+	}
+
+	return client, nil
+}
+
+// parseMinioEndpoint splits an optional http:// or https:// scheme off
+// host; a bare host defaults to SSL.
+func parseMinioEndpoint(host string) (string, bool) {
+	minioHost := host
 	useSSL := true
 
 	// Check if the host starts with http:// or https://
@@ -48,13 +101,7 @@ func (service *MinioService) startMinioService() (*minio.Client, *errors.AppErro
 		useSSL = true
 	}
 
-	client, err := minio.New(minioHost, service.accessId, service.secretKey, useSSL)
-
-	if err != nil {
-		return nil, errors.ServiceError(err.Error())
-	}
-
-	return client, nil
+	return minioHost, useSSL
 }
 
 func (service *MinioService) checkIfBucketExists(bucket string) bool {
@@ -87,12 +134,22 @@ func (service *MinioService) UploadObject(bucket string, file entities.FileEntit
 		return "", appError
 	}
 
-	_, err := client.PutObject(
+	seal, err := sealFor(bucket, file.Name)
+	if err != nil {
+		return "", errors.ServiceError(err.Error())
+	}
+
+	body, err := seal.encrypt(file.File)
+	if err != nil {
+		return "", errors.ServiceError(err.Error())
+	}
+
+	_, err = client.PutObject(
 		bucket,
 		file.Name,
-		file.File,
-		file.Size,
-		options,
+		body,
+		seal.storedSize(file.Size),
+		seal.options(options),
 	)
 
 	if err != nil {
@@ -102,29 +159,335 @@ func (service *MinioService) UploadObject(bucket string, file entities.FileEntit
 	return fmt.Sprintf("%s/%s", bucket, file.Name), nil
 }
 
-func (service *MinioService) GetObject(bucket string, objectName string, options minio.GetObjectOptions) (*minio.Object, *errors.AppError) {
+// UploadObjectStream is the streaming variant of UploadObject: it
+// reads the body once, in UPLOAD_PART_SIZE parts, and ships up to
+// UPLOAD_PARALLELISM of them to MinIO concurrently, so the size never
+// has to be known up front and memory stays bounded regardless of
+// how large the upload is. A cancelled ctx or a failing reader aborts
+// the multipart upload. In encrypted buckets the body is sealed on the
+// way (see sealFor); the returned size is still the body's.
+func (service *MinioService) UploadObjectStream(ctx context.Context, bucket string, objectName string, reader io.Reader, options minio.PutObjectOptions) (*entities.StoredObjectEntity, *errors.AppError) {
+	bucketExists := service.checkIfBucketExists(bucket)
+
+	if !bucketExists {
+		return nil, errors.ServiceError("Bucket does not exist")
+	}
+
 	client, appError := service.startMinioService()
 
 	if appError != nil {
 		return nil, appError
 	}
 
-	object, err := client.GetObject(bucket, objectName, options)
+	seal, err := sealFor(bucket, objectName)
+	if err != nil {
+		return nil, errors.ServiceError(err.Error())
+	}
+
+	body, err := seal.encrypt(reader)
+	if err != nil {
+		return nil, errors.ServiceError(err.Error())
+	}
+
+	stored, err := streamToMultipart(
+		ctx,
+		&minio.Core{Client: client},
+		bucket,
+		objectName,
+		body,
+		seal.options(options),
+		config.EnvUploadPartSize(),
+		config.EnvUploadParallelism(),
+	)
+
 	if err != nil {
 		return nil, errors.ServiceError(err.Error())
 	}
 
-	return object, nil
+	stored.Size = seal.bodySize(stored.Size)
+	return stored, nil
+}
+
+// PutObject writes an arbitrary reader under objectName. Unlike
+// UploadObject it doesn't require a multipart.File, so callers that
+// assemble content themselves (tus chunks, generated state files)
+// can store it directly. size may be -1 when the length is unknown.
+func (service *MinioService) PutObject(bucket string, objectName string, reader io.Reader, size int64, options minio.PutObjectOptions) (int64, *errors.AppError) {
+	client, appError := service.startMinioService()
+	if appError != nil {
+		return 0, appError
+	}
+
+	seal, err := sealFor(bucket, objectName)
+	if err != nil {
+		return 0, errors.ServiceError(err.Error())
+	}
+
+	body, err := seal.encrypt(reader)
+	if err != nil {
+		return 0, errors.ServiceError(err.Error())
+	}
+
+	written, err := client.PutObject(bucket, objectName, body, seal.storedSize(size), seal.options(options))
+	if err != nil {
+		return seal.bodySize(written), errors.ServiceError(err.Error())
+	}
+
+	return seal.bodySize(written), nil
 }
 
-func (service *MinioService) GetObjectURL(bucket string, objectName string) (string, *errors.AppError) {
+// RemoveObject deletes objectName from bucket. Removing an object
+// that doesn't exist is not an error (S3 semantics).
+func (service *MinioService) RemoveObject(bucket string, objectName string) *errors.AppError {
 	client, appError := service.startMinioService()
+	if appError != nil {
+		return appError
+	}
+
+	if err := client.RemoveObject(bucket, objectName); err != nil {
+		return errors.ServiceError(err.Error())
+	}
+
+	return nil
+}
+
+// CopyObject copies source over destination server-side. With nil
+// options the source's metadata is kept; otherwise options' content
+// type and metadata replace it. Objects beyond 5 GiB are copied part
+// by part.
+//
+// In an SSE-C bucket MinIO re-encrypts the copy under destination's
+// own key. An envelope-encrypted source is copied as is, keeping its
+// data key, since only rb-cdn could re-encrypt it.
+func (service *MinioService) CopyObject(bucket string, source string, destination string, options *minio.PutObjectOptions) *errors.AppError {
+	client, appError := service.startMinioService()
+	if appError != nil {
+		return appError
+	}
+
+	if keyring.Default().Empty() {
+		var metadata map[string]string
+		if options != nil {
+			metadata = putObjectMetadata(*options)
+		}
+		return copySealed(client, bucket, source, nil, destination, nil, metadata, "")
+	}
+
+	info, sourceSeal, err := statSealed(client, bucket, source)
+	if err != nil {
+		return errors.ServiceError(err.Error())
+	}
+
+	destinationSeal := sourceSeal
+	if !sourceSeal.envelope() {
+		destinationSeal, err = sealFor(bucket, destination)
+		if err != nil {
+			return errors.ServiceError(err.Error())
+		}
+		if destinationSeal.envelope() {
+			destinationSeal = nil
+		}
+	}
+
+	// The source's metadata names its own key; a copy under another
+	// one needs it rewritten.
+	if options == nil && !sourceSeal.same(destinationSeal) {
+		kept := optionsFromInfo(*info)
+		options = &kept
+	}
+
+	var metadata map[string]string
+	if options != nil {
+		metadata = putObjectMetadata(destinationSeal.options(*options))
+	}
+
+	return copySealed(client, bucket, source, sourceSeal, destination, destinationSeal, metadata, "")
+}
+
+// copySealed copies source, sealed under sourceSeal, to destination
+// under destinationSeal. A non-empty etag makes the copy conditional
+// on the source not having changed.
+func copySealed(client *minio.Client, bucket string, source string, sourceSeal *objectSeal, destination string, destinationSeal *objectSeal, metadata map[string]string, etag string) *errors.AppError {
+	destinationInfo, err := minio.NewDestinationInfo(bucket, destination, destinationSeal.serverSide(), metadata)
+	if err != nil {
+		return errors.ServiceError(err.Error())
+	}
+
+	sourceInfo := minio.NewSourceInfo(bucket, source, sourceSeal.serverSide())
+	if etag != "" {
+		if err := sourceInfo.SetMatchETagCond(etag); err != nil {
+			return errors.ServiceError(err.Error())
+		}
+	}
+
+	if err := client.ComposeObject(destinationInfo, []minio.SourceInfo{sourceInfo}); err != nil {
+		return errors.ServiceError(err.Error())
+	}
+
+	return nil
+}
+
+// SetObjectMetadata adds metadata to objectName's user metadata by
+// copying the object onto itself, keeping its body, encryption and
+// other metadata. An object replaced in the meantime is left alone.
+func (service *MinioService) SetObjectMetadata(bucket string, objectName string, metadata map[string]string) *errors.AppError {
+	client, appError := service.startMinioService()
+	if appError != nil {
+		return appError
+	}
+
+	info, seal, err := statSealed(client, bucket, objectName)
+	if err != nil {
+		return errors.ServiceError(err.Error())
+	}
+
+	options := optionsFromInfo(*info)
+	for key, value := range metadata {
+		options.UserMetadata[key] = value
+	}
+	updated := putObjectMetadata(seal.options(options))
+
+	// An envelope object's ciphertext is copied as is, data key and
+	// all; MinIO only sees SSE-C.
+	if seal.envelope() {
+		return copySealed(client, bucket, objectName, nil, objectName, nil, updated, info.ETag)
+	}
+	return copySealed(client, bucket, objectName, seal, objectName, seal, updated, info.ETag)
+}
+
+// ObjectExists reports whether objectName is in bucket. Unlike
+// GetObjectInfo it tells a missing key apart from a failing MinIO.
+func (service *MinioService) ObjectExists(bucket string, objectName string) (bool, *errors.AppError) {
+	info, appError := service.LookupObject(bucket, objectName)
+	return info != nil, appError
+}
+
+// LookupObject stats objectName, returning nil without an error when
+// it doesn't exist.
+func (service *MinioService) LookupObject(bucket string, objectName string) (*minio.ObjectInfo, *errors.AppError) {
+	client, appError := service.startMinioService()
+	if appError != nil {
+		return nil, appError
+	}
+
+	info, _, err := statSealed(client, bucket, objectName)
+	if err == nil {
+		return info, nil
+	}
+
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, nil
+	}
+
+	return nil, errors.ServiceError(err.Error())
+}
+
+// ListObjects returns every object under prefix, recursively. The
+// listing is drained eagerly, so callers should keep prefixes narrow.
+// Sizes are the stored ones: envelope-encrypted objects list slightly
+// larger than their content.
+func (service *MinioService) ListObjects(bucket string, prefix string) ([]minio.ObjectInfo, *errors.AppError) {
+	client, appError := service.startMinioService()
+	if appError != nil {
+		return nil, appError
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	var objects []minio.ObjectInfo
+	for object := range client.ListObjectsV2(bucket, prefix, true, doneCh) {
+		if object.Err != nil {
+			return nil, errors.ServiceError(object.Err.Error())
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+// WalkObjects calls visit with every object under prefix, recursively
+// and in key order, as the listing comes in, until visit returns
+// false. Unlike ListObjects it holds one page of the listing at a time.
+func (service *MinioService) WalkObjects(bucket string, prefix string, visit func(object minio.ObjectInfo) bool) *errors.AppError {
+	client, appError := service.startMinioService()
+	if appError != nil {
+		return appError
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	for object := range client.ListObjectsV2(bucket, prefix, true, doneCh) {
+		if object.Err != nil {
+			return errors.ServiceError(object.Err.Error())
+		}
+		if !visit(object) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// GetObject opens objectName for reading. Encrypted objects are
+// decrypted transparently, seeks and ranges included; telling how one
+// is encrypted takes a stat, skipped while the keyring is empty since
+// nothing can be encrypted then. Options' range is ignored for
+// envelope-encrypted objects: seek instead.
+func (service *MinioService) GetObject(bucket string, objectName string, options minio.GetObjectOptions) (Object, *errors.AppError) {
+	client, appError := service.startMinioService()
+
+	if appError != nil {
+		return nil, appError
+	}
+
+	if keyring.Default().Empty() {
+		object, err := client.GetObject(bucket, objectName, options)
+		if err != nil {
+			return nil, errors.ServiceError(err.Error())
+		}
+		return object, nil
+	}
+
+	info, seal, err := statSealed(client, bucket, objectName)
+	if err != nil {
+		return nil, errors.ServiceError(err.Error())
+	}
+
+	if !seal.envelope() {
+		options.ServerSideEncryption = seal.serverSide()
+		object, err := client.GetObject(bucket, objectName, options)
+		if err != nil {
+			return nil, errors.ServiceError(err.Error())
+		}
+		return object, nil
+	}
+
+	object, err := client.GetObject(bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.ServiceError(err.Error())
+	}
+
+	reader, err := envelope.NewReader(object, envelope.CipherSize(info.Size), seal.dataKey)
+	if err != nil {
+		object.Close()
+		return nil, errors.ServiceError(err.Error())
+	}
+
+	return &envelopeObject{Reader: reader, object: object, info: *info}, nil
+}
+
+// GetObjectURL presigns a GET for bucket/objectName valid for expiry.
+// reqParams may carry S3 response overrides such as
+// response-content-disposition.
+func (service *MinioService) GetObjectURL(bucket string, objectName string, expiry time.Duration, reqParams url.Values) (string, *errors.AppError) {
+	client, appError := service.startPresignClient()
 	if appError != nil {
 		return "", appError
 	}
 
-	reqParams := make(url.Values)
-	presignedURL, err := client.PresignedGetObject(bucket, objectName, time.Hour, reqParams)
+	presignedURL, err := client.PresignedGetObject(bucket, objectName, expiry, reqParams)
 	if err != nil {
 		return "", errors.ServiceError(err.Error())
 	}
@@ -132,6 +495,40 @@ func (service *MinioService) GetObjectURL(bucket string, objectName string) (str
 	return presignedURL.String(), nil
 }
 
+// PresignObject signs a GET, HEAD or PUT for bucket/objectName that
+// anyone holding the URL may perform until expiry (max 7 days, an S3
+// limit). The URL talks to MinIO directly, bypassing rb-cdn.
+func (service *MinioService) PresignObject(method string, bucket string, objectName string, expiry time.Duration) (string, *errors.AppError) {
+	client, appError := service.startPresignClient()
+	if appError != nil {
+		return "", appError
+	}
+
+	presignedURL, err := client.Presign(method, bucket, objectName, expiry, make(url.Values))
+	if err != nil {
+		return "", errors.ServiceError(err.Error())
+	}
+
+	return presignedURL.String(), nil
+}
+
+// PresignPostPolicy signs a browser form upload. Unlike a presigned
+// PUT, the policy can pin the content type, the accepted size range
+// and a key prefix, and MinIO enforces them on upload.
+func (service *MinioService) PresignPostPolicy(policy *minio.PostPolicy) (string, map[string]string, *errors.AppError) {
+	client, appError := service.startPresignClient()
+	if appError != nil {
+		return "", nil, appError
+	}
+
+	postURL, formData, err := client.PresignedPostPolicy(policy)
+	if err != nil {
+		return "", nil, errors.ServiceError(err.Error())
+	}
+
+	return postURL.String(), formData, nil
+}
+
 // ListBuckets returns every bucket the configured MinIO credentials
 // can see. Used at boot to declare per-bucket capability scopes
 // against the management API. Failure is fatal — the service must
@@ -155,10 +552,10 @@ func (service *MinioService) GetObjectInfo(bucket string, objectName string) (*m
 		return nil, appError
 	}
 
-	objectInfo, err := client.StatObject(bucket, objectName, minio.StatObjectOptions{})
+	objectInfo, _, err := statSealed(client, bucket, objectName)
 	if err != nil {
 		return nil, errors.ServiceError(err.Error())
 	}
 
-	return &objectInfo, nil
+	return objectInfo, nil
 }
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/encrypt"
+)
+
+// maxMultipartParts is the S3 limit on parts per multipart upload.
+const maxMultipartParts = 10000
+
+// multipartClient is the slice of minio.Core a streamed upload needs.
+// Narrowed so the part scheduling can be exercised without a MinIO.
+type multipartClient interface {
+	PutObject(bucket, object string, data io.Reader, size int64, md5Base64, sha256Hex string, metadata map[string]string, sse encrypt.ServerSide) (minio.ObjectInfo, error)
+	NewMultipartUpload(bucket, object string, opts minio.PutObjectOptions) (string, error)
+	PutObjectPart(bucket, object, uploadID string, partID int, data io.Reader, size int64, md5Base64, sha256Hex string, sse encrypt.ServerSide) (minio.ObjectPart, error)
+	CompleteMultipartUpload(bucket, object, uploadID string, parts []minio.CompletePart) (string, error)
+	AbortMultipartUpload(bucket, object, uploadID string) error
+}
+
+// partBuffers recycles part-sized buffers across uploads. Buffers of
+// a different size (UPLOAD_PART_SIZE changed between deploys of a
+// test) are simply dropped.
+var partBuffers sync.Pool
+
+func acquirePartBuffer(size int64) []byte {
+	if buffer, ok := partBuffers.Get().(*[]byte); ok && int64(cap(*buffer)) == size {
+		return (*buffer)[:size]
+	}
+	return make([]byte, size)
+}
+
+func releasePartBuffer(buffer []byte) {
+	buffer = buffer[:cap(buffer)]
+	partBuffers.Put(&buffer)
+}
+
+// streamToMultipart copies reader into bucket/objectName holding at
+// most parallelism+1 buffers of partSize in memory. Bodies that fit in
+// a single part are sent as one plain PUT. Any read, upload or context
+// error aborts the multipart session so MinIO doesn't keep orphaned
+// parts around.
+func streamToMultipart(ctx context.Context, client multipartClient, bucket string, objectName string, reader io.Reader, options minio.PutObjectOptions, partSize int64, parallelism int) (*entities.StoredObjectEntity, error) {
+	buffer := acquirePartBuffer(partSize)
+	n, readErr := io.ReadFull(reader, buffer)
+	if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+		defer releasePartBuffer(buffer)
+		info, err := client.PutObject(bucket, objectName, bytes.NewReader(buffer[:n]), int64(n), "", "", putObjectMetadata(options), options.ServerSideEncryption)
+		if err != nil {
+			return nil, err
+		}
+		return &entities.StoredObjectEntity{Bucket: bucket, Key: objectName, ETag: info.ETag, Size: int64(n)}, nil
+	}
+	if readErr != nil {
+		releasePartBuffer(buffer)
+		return nil, readErr
+	}
+
+	uploadID, err := client.NewMultipartUpload(bucket, objectName, options)
+	if err != nil {
+		releasePartBuffer(buffer)
+		return nil, err
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		parts    []minio.CompletePart
+		firstErr error
+		total    int64
+	)
+	slots := make(chan struct{}, parallelism)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	for partNumber := 1; ; partNumber++ {
+		data := buffer[:n]
+		total += int64(n)
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(partNumber int, data []byte) {
+			defer wg.Done()
+			defer func() {
+				releasePartBuffer(data)
+				<-slots
+			}()
+
+			part, err := client.PutObjectPart(bucket, objectName, uploadID, partNumber, bytes.NewReader(data), int64(len(data)), "", "", options.ServerSideEncryption)
+			if err != nil {
+				fail(err)
+				return
+			}
+
+			mu.Lock()
+			parts = append(parts, minio.CompletePart{PartNumber: partNumber, ETag: part.ETag})
+			mu.Unlock()
+		}(partNumber, data)
+
+		if readErr == io.ErrUnexpectedEOF || failed() {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			fail(err)
+			break
+		}
+		if partNumber == maxMultipartParts {
+			fail(fmt.Errorf("upload exceeds %d parts of %d bytes", maxMultipartParts, partSize))
+			break
+		}
+
+		buffer = acquirePartBuffer(partSize)
+		n, readErr = io.ReadFull(reader, buffer)
+		if readErr == io.EOF {
+			releasePartBuffer(buffer)
+			break
+		}
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			releasePartBuffer(buffer)
+			fail(readErr)
+			break
+		}
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		// Best effort: the original error is what the caller needs to
+		// see, an abort failure only leaves parts for MinIO's own
+		// stale-upload cleanup.
+		_ = client.AbortMultipartUpload(bucket, objectName, uploadID)
+		return nil, firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+
+	etag, err := client.CompleteMultipartUpload(bucket, objectName, uploadID, parts)
+	if err != nil {
+		_ = client.AbortMultipartUpload(bucket, objectName, uploadID)
+		return nil, err
+	}
+
+	return &entities.StoredObjectEntity{Bucket: bucket, Key: objectName, ETag: etag, Size: total}, nil
+}
+
+// putObjectMetadata flattens PutObjectOptions into the metadata map
+// minio.Core.PutObject expects; it maps the standard keys back onto
+// their dedicated headers and everything else to x-amz-meta-*.
+func putObjectMetadata(options minio.PutObjectOptions) map[string]string {
+	metadata := make(map[string]string, len(options.UserMetadata)+6)
+	for key, value := range options.UserMetadata {
+		metadata[key] = value
+	}
+
+	standard := map[string]string{
+		"Content-Type":        options.ContentType,
+		"Content-Encoding":    options.ContentEncoding,
+		"Content-Disposition": options.ContentDisposition,
+		"Content-Language":    options.ContentLanguage,
+		"Cache-Control":       options.CacheControl,
+	}
+	for key, value := range standard {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+
+	return metadata
+}
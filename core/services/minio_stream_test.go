@@ -0,0 +1,177 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/encrypt"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMultipartClient records what streamToMultipart sends and
+// reassembles the parts so tests can compare the stored bytes.
+type fakeMultipartClient struct {
+	mu        sync.Mutex
+	single    []byte
+	metadata  map[string]string
+	parts     map[int][]byte
+	completed []minio.CompletePart
+	aborted   bool
+	failPart  int
+	inFlight  atomic.Int32
+	maxFlight atomic.Int32
+}
+
+func newFakeMultipartClient() *fakeMultipartClient {
+	return &fakeMultipartClient{parts: map[int][]byte{}}
+}
+
+func (f *fakeMultipartClient) PutObject(bucket, object string, data io.Reader, size int64, md5Base64, sha256Hex string, metadata map[string]string, sse encrypt.ServerSide) (minio.ObjectInfo, error) {
+	body, _ := io.ReadAll(data)
+	f.single = body
+	f.metadata = metadata
+	return minio.ObjectInfo{ETag: "single-etag", Size: size}, nil
+}
+
+func (f *fakeMultipartClient) NewMultipartUpload(bucket, object string, opts minio.PutObjectOptions) (string, error) {
+	return "upload-id", nil
+}
+
+func (f *fakeMultipartClient) PutObjectPart(bucket, object, uploadID string, partID int, data io.Reader, size int64, md5Base64, sha256Hex string, sse encrypt.ServerSide) (minio.ObjectPart, error) {
+	current := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for {
+		seen := f.maxFlight.Load()
+		if current <= seen || f.maxFlight.CompareAndSwap(seen, current) {
+			break
+		}
+	}
+
+	if partID == f.failPart {
+		return minio.ObjectPart{}, errors.New("part rejected")
+	}
+
+	body, _ := io.ReadAll(data)
+	f.mu.Lock()
+	f.parts[partID] = body
+	f.mu.Unlock()
+	return minio.ObjectPart{PartNumber: partID, ETag: fmt.Sprintf("etag-%d", partID)}, nil
+}
+
+func (f *fakeMultipartClient) CompleteMultipartUpload(bucket, object, uploadID string, parts []minio.CompletePart) (string, error) {
+	f.completed = parts
+	return "multipart-etag", nil
+}
+
+func (f *fakeMultipartClient) AbortMultipartUpload(bucket, object, uploadID string) error {
+	f.aborted = true
+	return nil
+}
+
+func (f *fakeMultipartClient) assembled() []byte {
+	var out []byte
+	for _, part := range f.completed {
+		out = append(out, f.parts[part.PartNumber]...)
+	}
+	return out
+}
+
+// failingReader yields its payload and then a non-EOF error, like a
+// client connection dropping mid-upload.
+type failingReader struct {
+	payload io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.payload.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestStreamToMultipart(t *testing.T) {
+	const partSize = 16
+
+	t.Run("small body is sent as a single put", func(t *testing.T) {
+		client := newFakeMultipartClient()
+		payload := []byte("tiny")
+
+		stored, err := streamToMultipart(context.Background(), client, "bucket", "key", bytes.NewReader(payload), minio.PutObjectOptions{ContentType: "text/plain"}, partSize, 2)
+
+		assert.NoError(t, err)
+		assert.Equal(t, payload, client.single)
+		assert.Equal(t, "text/plain", client.metadata["Content-Type"])
+		assert.Equal(t, "single-etag", stored.ETag)
+		assert.Equal(t, int64(len(payload)), stored.Size)
+		assert.Empty(t, client.parts)
+	})
+
+	t.Run("large body is split into ordered parts", func(t *testing.T) {
+		client := newFakeMultipartClient()
+		payload := bytes.Repeat([]byte("0123456789"), 10)
+
+		stored, err := streamToMultipart(context.Background(), client, "bucket", "key", bytes.NewReader(payload), minio.PutObjectOptions{}, partSize, 3)
+
+		assert.NoError(t, err)
+		assert.Equal(t, payload, client.assembled())
+		assert.Len(t, client.completed, 7)
+		for i, part := range client.completed {
+			assert.Equal(t, i+1, part.PartNumber)
+		}
+		assert.Equal(t, "multipart-etag", stored.ETag)
+		assert.Equal(t, int64(len(payload)), stored.Size)
+		assert.LessOrEqual(t, client.maxFlight.Load(), int32(3))
+		assert.False(t, client.aborted)
+	})
+
+	t.Run("body that is an exact multiple of the part size", func(t *testing.T) {
+		client := newFakeMultipartClient()
+		payload := bytes.Repeat([]byte("x"), partSize*2)
+
+		stored, err := streamToMultipart(context.Background(), client, "bucket", "key", bytes.NewReader(payload), minio.PutObjectOptions{}, partSize, 1)
+
+		assert.NoError(t, err)
+		assert.Len(t, client.completed, 2)
+		assert.Equal(t, int64(len(payload)), stored.Size)
+	})
+
+	t.Run("reader failure aborts the upload", func(t *testing.T) {
+		client := newFakeMultipartClient()
+		reader := &failingReader{payload: bytes.NewReader(bytes.Repeat([]byte("x"), partSize*3+4))}
+
+		_, err := streamToMultipart(context.Background(), client, "bucket", "key", reader, minio.PutObjectOptions{}, partSize, 2)
+
+		assert.EqualError(t, err, "connection reset")
+		assert.True(t, client.aborted)
+		assert.Nil(t, client.completed)
+	})
+
+	t.Run("part failure aborts the upload", func(t *testing.T) {
+		client := newFakeMultipartClient()
+		client.failPart = 2
+
+		_, err := streamToMultipart(context.Background(), client, "bucket", "key", bytes.NewReader(bytes.Repeat([]byte("x"), partSize*4)), minio.PutObjectOptions{}, partSize, 2)
+
+		assert.EqualError(t, err, "part rejected")
+		assert.True(t, client.aborted)
+	})
+
+	t.Run("cancelled context aborts the upload", func(t *testing.T) {
+		client := newFakeMultipartClient()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := streamToMultipart(ctx, client, "bucket", "key", bytes.NewReader(bytes.Repeat([]byte("x"), partSize*4)), minio.PutObjectOptions{}, partSize, 2)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.True(t, client.aborted)
+	})
+}
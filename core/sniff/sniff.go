@@ -0,0 +1,131 @@
+// Package sniff identifies file types from their leading bytes, so
+// upload rules don't have to trust client-declared types.
+package sniff
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// HeadSize is how many leading bytes Detect looks at.
+const HeadSize = 512
+
+const (
+	Executable   = "application/x-executable"
+	OctetStream  = "application/octet-stream"
+	textFallback = "text/plain"
+)
+
+// signatures cover formats http.DetectContentType doesn't know, most
+// importantly executables, which it reports as octet-stream.
+var signatures = []struct {
+	offset    int
+	magic     []byte
+	mediaType string
+}{
+	{0, []byte("MZ"), "application/vnd.microsoft.portable-executable"},
+	{0, []byte("\x7fELF"), Executable},
+	{0, []byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{0, []byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{0, []byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("#!"), "text/x-shellscript"},
+	{4, []byte("ftypqt  "), "video/quicktime"},
+	{4, []byte("ftypheic"), "image/heic"},
+	{4, []byte("ftypheix"), "image/heic"},
+	{4, []byte("ftypavif"), "image/avif"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+}
+
+// executables are the detected types a policy never lets through
+// under another type's extension.
+var executables = map[string]bool{
+	"application/vnd.microsoft.portable-executable": true,
+	Executable:                  true,
+	"application/x-mach-binary": true,
+	"text/x-shellscript":        true,
+}
+
+// Detect returns the media type of a file starting with head, without
+// parameters. Unknown binary content is application/octet-stream.
+func Detect(head []byte) string {
+	for _, signature := range signatures {
+		if len(head) >= signature.offset && bytes.HasPrefix(head[signature.offset:], signature.magic) {
+			return signature.mediaType
+		}
+	}
+
+	return MediaType(http.DetectContentType(head))
+}
+
+// MediaType strips parameters from a Content-Type value and lowercases
+// it: "Text/HTML; charset=utf-8" becomes "text/html".
+func MediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+}
+
+func IsExecutable(mediaType string) bool {
+	return executables[mediaType]
+}
+
+// IsGeneric reports whether mediaType says nothing about the content
+// beyond "binary" or "text".
+func IsGeneric(mediaType string) bool {
+	return mediaType == OctetStream || mediaType == textFallback || mediaType == ""
+}
+
+// ExtensionType returns the media type filename's extension implies,
+// or "" when the extension is unknown.
+func ExtensionType(filename string) string {
+	return MediaType(mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))))
+}
+
+// Compatible reports whether content detected as detected may be
+// stored under a name whose extension implies declared. Only the top
+// level type has to agree (a .jpg holding a PNG is fine, one holding
+// an executable is not); an unknown extension or a generic detection
+// can't contradict anything.
+func Compatible(declared string, detected string) bool {
+	if declared == "" {
+		return true
+	}
+
+	if IsExecutable(detected) {
+		return IsExecutable(declared)
+	}
+
+	if IsGeneric(detected) {
+		return true
+	}
+
+	return topLevel(declared) == topLevel(detected)
+}
+
+func topLevel(mediaType string) string {
+	return strings.SplitN(mediaType, "/", 2)[0]
+}
+
+// MatchType reports whether mediaType is one of patterns. Patterns may
+// end in "/*" to accept a whole family; an empty list accepts
+// anything.
+func MatchType(mediaType string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	mediaType = MediaType(mediaType)
+	for _, pattern := range patterns {
+		pattern = MediaType(pattern)
+		if pattern == mediaType {
+			return true
+		}
+		if family, found := strings.CutSuffix(pattern, "/*"); found && strings.HasPrefix(mediaType, family+"/") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package sniff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "image/jpeg"},
+		{"windows executable", []byte("MZ\x90\x00\x03\x00\x00\x00"), "application/vnd.microsoft.portable-executable"},
+		{"elf", []byte("\x7fELF\x02\x01\x01"), Executable},
+		{"shell script", []byte("#!/bin/sh\nrm -rf /"), "text/x-shellscript"},
+		{"quicktime", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00"), "video/quicktime"},
+		{"plain text", []byte("hello world"), "text/plain"},
+		{"empty", nil, "text/plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Detect(tt.head))
+		})
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	assert.True(t, Compatible("image/jpeg", "image/png"))
+	assert.True(t, Compatible("image/jpeg", OctetStream))
+	assert.True(t, Compatible("", "video/mp4"))
+	assert.False(t, Compatible("image/jpeg", Executable))
+	assert.True(t, Compatible("", "application/vnd.microsoft.portable-executable"))
+	assert.False(t, Compatible("text/plain", "text/x-shellscript"))
+	assert.False(t, Compatible("image/png", "video/mp4"))
+}
+
+func TestExtensionType(t *testing.T) {
+	assert.Equal(t, "image/jpeg", ExtensionType("photo.JPG"))
+	assert.Equal(t, "", ExtensionType("README"))
+}
+
+func TestMatchType(t *testing.T) {
+	assert.True(t, MatchType("image/png", nil))
+	assert.True(t, MatchType("image/png; charset=binary", []string{"image/png"}))
+	assert.True(t, MatchType("IMAGE/JPEG", []string{"image/*"}))
+	assert.False(t, MatchType("text/html", []string{"image/*", "video/mp4"}))
+	assert.False(t, MatchType("", []string{"image/*"}))
+}
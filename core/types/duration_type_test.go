@@ -0,0 +1,35 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuration(t *testing.T) {
+	t.Run("parses duration strings", func(t *testing.T) {
+		var d Duration
+		assert.NoError(t, json.Unmarshal([]byte(`"5m"`), &d))
+		assert.Equal(t, 5*time.Minute, d.Duration())
+	})
+
+	t.Run("parses seconds", func(t *testing.T) {
+		var d Duration
+		assert.NoError(t, json.Unmarshal([]byte(`90`), &d))
+		assert.Equal(t, 90*time.Second, d.Duration())
+	})
+
+	t.Run("rejects garbage", func(t *testing.T) {
+		var d Duration
+		assert.Error(t, json.Unmarshal([]byte(`"soon"`), &d))
+		assert.Error(t, json.Unmarshal([]byte(`true`), &d))
+	})
+
+	t.Run("marshals as a duration string", func(t *testing.T) {
+		data, err := json.Marshal(Duration(90 * time.Second))
+		assert.NoError(t, err)
+		assert.Equal(t, `"1m30s"`, string(data))
+	})
+}
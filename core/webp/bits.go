@@ -0,0 +1,28 @@
+package webp
+
+// bitWriter writes the VP8L bitstream, least significant bit first.
+type bitWriter struct {
+	data   []byte
+	buffer uint64
+	count  uint
+}
+
+// write appends the n (at most 32) low bits of value.
+func (w *bitWriter) write(value uint32, n uint) {
+	w.buffer |= uint64(value&(1<<n-1)) << w.count
+	w.count += n
+	for w.count >= 8 {
+		w.data = append(w.data, byte(w.buffer))
+		w.buffer >>= 8
+		w.count -= 8
+	}
+}
+
+// bytes flushes the pending bits, zero padded, and returns the stream.
+func (w *bitWriter) bytes() []byte {
+	if w.count > 0 {
+		w.data = append(w.data, byte(w.buffer))
+		w.buffer, w.count = 0, 0
+	}
+	return w.data
+}
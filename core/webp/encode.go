@@ -0,0 +1,411 @@
+package webp
+
+import (
+	"image"
+	"image/draw"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// predictorBits sizes the blocks a predictor mode is picked for.
+	predictorBits = 4
+	// encoderCacheBits sizes the color cache of the main image.
+	encoderCacheBits = 10
+	maxCopyLength    = 4096
+	// matchWindow bounds how far back copies look; distances must stay
+	// codable with 40 distance symbols.
+	matchWindow = 1 << 18
+	matchChain  = 16
+	minMatch    = 3
+)
+
+// argbPixels returns img as non-premultiplied ARGB words, and whether
+// any pixel isn't opaque.
+func argbPixels(img image.Image) ([]uint32, bool) {
+	bounds := img.Bounds()
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+	}
+
+	width, height := bounds.Dx(), bounds.Dy()
+	pixels := make([]uint32, width*height)
+	alpha := false
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride:]
+		for x := 0; x < width; x++ {
+			p := row[4*x:]
+			pixels[y*width+x] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+			alpha = alpha || p[3] != 0xff
+		}
+	}
+
+	return pixels, alpha
+}
+
+// encodeVP8L encodes img as a lossless VP8L bitstream.
+func encodeVP8L(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return nil, ErrTooLarge
+	}
+
+	pixels, alpha := argbPixels(img)
+
+	w := &bitWriter{}
+	w.write(vp8lSignature, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if alpha {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3)
+
+	if palette := colorPalette(pixels); palette != nil {
+		pixels, width = writeColorIndexing(w, pixels, width, height, palette)
+	} else {
+		writeSubtractGreen(w, pixels)
+		writePredictor(w, pixels, width, height)
+	}
+	w.write(0, 1)
+
+	writeImageStream(w, pixels, width, true)
+	return w.bytes(), nil
+}
+
+// colorPalette returns the image's colors, sorted, when there are no
+// more than 256 of them.
+func colorPalette(pixels []uint32) []uint32 {
+	seen := map[uint32]bool{}
+	for _, argb := range pixels {
+		if !seen[argb] {
+			if len(seen) == 256 {
+				return nil
+			}
+			seen[argb] = true
+		}
+	}
+
+	palette := make([]uint32, 0, len(seen))
+	for argb := range seen {
+		palette = append(palette, argb)
+	}
+	sort.Slice(palette, func(i, j int) bool { return palette[i] < palette[j] })
+	return palette
+}
+
+// writeColorIndexing replaces pixels by their palette index, bundling
+// up to 8 of them per pixel for small palettes, and returns the packed
+// image and its width.
+func writeColorIndexing(w *bitWriter, pixels []uint32, width int, height int, palette []uint32) ([]uint32, int) {
+	w.write(1, 1)
+	w.write(transformColorIndexing, 2)
+	w.write(uint32(len(palette)-1), 8)
+
+	deltas := make([]uint32, len(palette))
+	deltas[0] = palette[0]
+	for i := 1; i < len(palette); i++ {
+		deltas[i] = subtractPixels(palette[i], palette[i-1])
+	}
+	writeImageStream(w, deltas, len(palette), false)
+
+	index := make(map[uint32]uint32, len(palette))
+	for i, argb := range palette {
+		index[argb] = uint32(i)
+	}
+
+	widthBits := uint(0)
+	switch {
+	case len(palette) <= 2:
+		widthBits = 3
+	case len(palette) <= 4:
+		widthBits = 2
+	case len(palette) <= 16:
+		widthBits = 1
+	}
+	bitsPerPixel := uint(8) >> widthBits
+
+	packedWidth := subsampled(width, widthBits)
+	packed := make([]uint32, packedWidth*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			shift := uint(x&(1<<widthBits-1)) * bitsPerPixel
+			packed[y*packedWidth+x>>widthBits] |= index[pixels[y*width+x]] << (8 + shift)
+		}
+	}
+	for i := range packed {
+		packed[i] |= 0xff000000
+	}
+
+	return packed, packedWidth
+}
+
+func writeSubtractGreen(w *bitWriter, pixels []uint32) {
+	w.write(1, 1)
+	w.write(transformSubtractGreen, 2)
+
+	for i, argb := range pixels {
+		green := (argb >> 8) & 0xff
+		redBlue := (argb&0xff00ff - (green<<16 | green) + 0x01000100) & 0xff00ff
+		pixels[i] = argb&0xff00ff00 | redBlue
+	}
+}
+
+// writePredictor picks, for every block, the predictor mode leaving
+// the smallest residuals and replaces pixels by their residuals.
+func writePredictor(w *bitWriter, pixels []uint32, width int, height int) {
+	w.write(1, 1)
+	w.write(transformPredictor, 2)
+	w.write(predictorBits-2, 3)
+
+	blocksWide, blocksHigh := subsampled(width, predictorBits), subsampled(height, predictorBits)
+	modes := make([]uint32, blocksWide*blocksHigh)
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			best, bestCost := 0, -1
+			for mode := 0; mode < numPredictors; mode++ {
+				cost := 0
+				forBlock(bx, by, width, height, func(x, y int) {
+					cost += residualCost(subtractPixels(pixels[y*width+x], predictFor(mode, pixels, x, y, width)))
+				})
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[by*blocksWide+bx] = 0xff000000 | uint32(best)<<8
+		}
+	}
+	writeImageStream(w, modes, blocksWide, false)
+
+	// Residuals are computed against the original neighbours, which
+	// is what the decoder will have rebuilt by then: walk backwards so
+	// none is overwritten before it served as a neighbour.
+	for y := height - 1; y >= 0; y-- {
+		for x := width - 1; x >= 0; x-- {
+			mode := int(modes[(y>>predictorBits)*blocksWide+(x>>predictorBits)]>>8) & 0xf
+			pixels[y*width+x] = subtractPixels(pixels[y*width+x], predictFor(mode, pixels, x, y, width))
+		}
+	}
+}
+
+// predictFor applies the border rules: the first pixel predicts black,
+// the rest of the first row from the left, the first column from the
+// top.
+func predictFor(mode int, pixels []uint32, x int, y int, width int) uint32 {
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return pixels[x-1]
+	case x == 0:
+		return pixels[(y-1)*width]
+	}
+	return predict(mode, pixels, y*width+x, width)
+}
+
+func forBlock(bx int, by int, width int, height int, visit func(x, y int)) {
+	for y := by << predictorBits; y < min((by+1)<<predictorBits, height); y++ {
+		for x := bx << predictorBits; x < min((bx+1)<<predictorBits, width); x++ {
+			visit(x, y)
+		}
+	}
+}
+
+// residualCost estimates how well a residual compresses: how far each
+// channel is from zero, wrapping around.
+func residualCost(residual uint32) int {
+	cost := 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		cost += abs(int(int8(residual >> shift)))
+	}
+	return cost
+}
+
+// Token kinds of the entropy coded image.
+const (
+	tokenLiteral = iota
+	tokenCache
+	tokenCopy
+)
+
+type token struct {
+	kind  int
+	value uint32
+	// length and distance code of copies.
+	length   int
+	distance int
+}
+
+// writeImageStream entropy codes pixels: LZ77 copies, a color cache
+// for the main image, and a single group of prefix codes.
+func writeImageStream(w *bitWriter, pixels []uint32, width int, main bool) {
+	cacheBits := uint(0)
+	if main {
+		cacheBits = encoderCacheBits
+		w.write(1, 1)
+		w.write(uint32(cacheBits), 4)
+		// No meta prefix codes.
+		w.write(0, 1)
+	} else {
+		w.write(0, 1)
+	}
+
+	// Tokens are produced twice, for the histograms then for writing,
+	// rather than kept: a large image would hold one per pixel.
+	greenSize := numLiteralCodes + numLengthCodes
+	if cacheBits > 0 {
+		greenSize += 1 << cacheBits
+	}
+	histograms := [5][]uint32{
+		make([]uint32, greenSize),
+		make([]uint32, numLiteralCodes),
+		make([]uint32, numLiteralCodes),
+		make([]uint32, numLiteralCodes),
+		make([]uint32, numDistanceCode),
+	}
+	tokenize(pixels, width, cacheBits, func(t token) {
+		switch t.kind {
+		case tokenLiteral:
+			histograms[0][(t.value>>8)&0xff]++
+			histograms[1][(t.value>>16)&0xff]++
+			histograms[2][t.value&0xff]++
+			histograms[3][t.value>>24]++
+		case tokenCache:
+			histograms[0][numLiteralCodes+numLengthCodes+int(t.value)]++
+		case tokenCopy:
+			lengthCode, _, _ := prefixEncode(t.length)
+			distanceCode, _, _ := prefixEncode(t.distance)
+			histograms[0][numLiteralCodes+lengthCode]++
+			histograms[4][distanceCode]++
+		}
+	})
+
+	var codes [5]*encoderCode
+	for i, histogram := range histograms {
+		codes[i] = newEncoderCode(w, histogram)
+	}
+
+	tokenize(pixels, width, cacheBits, func(t token) {
+		switch t.kind {
+		case tokenLiteral:
+			codes[0].write(w, int(t.value>>8)&0xff)
+			codes[1].write(w, int(t.value>>16)&0xff)
+			codes[2].write(w, int(t.value)&0xff)
+			codes[3].write(w, int(t.value>>24))
+		case tokenCache:
+			codes[0].write(w, numLiteralCodes+numLengthCodes+int(t.value))
+		case tokenCopy:
+			lengthCode, lengthBits, lengthExtra := prefixEncode(t.length)
+			codes[0].write(w, numLiteralCodes+lengthCode)
+			w.write(lengthExtra, lengthBits)
+			distanceCode, distanceBits, distanceExtra := prefixEncode(t.distance)
+			codes[4].write(w, distanceCode)
+			w.write(distanceExtra, distanceBits)
+		}
+	})
+}
+
+// prefixEncode splits value (at least 1) into its prefix code and
+// extra bits.
+func prefixEncode(value int) (int, uint, uint32) {
+	if value <= 4 {
+		return value - 1, 0, 0
+	}
+
+	value--
+	highest := uint(bits.Len(uint(value))) - 1
+	second := (value >> (highest - 1)) & 1
+	extraBits := highest - 1
+	return int(2*highest) + second, extraBits, uint32(value) & (1<<extraBits - 1)
+}
+
+// tokenize turns pixels into literals, cache hits and copies found
+// through hash chains over pixel pairs, handing each to emit.
+func tokenize(pixels []uint32, width int, cacheBits uint, emit func(token)) {
+	var cache []uint32
+	var cached []bool
+	if cacheBits > 0 {
+		cache = make([]uint32, 1<<cacheBits)
+		cached = make([]bool, 1<<cacheBits)
+	}
+	remember := func(argb uint32) {
+		if cache != nil {
+			index := cacheIndex(argb, cacheBits)
+			cache[index], cached[index] = argb, true
+		}
+	}
+
+	// planes maps distances to the shortest plane code for them.
+	planes := map[int]int{}
+	for code := planeCodes; code >= 1; code-- {
+		planes[planeDistance(width, code)] = code
+	}
+
+	const hashBits = 16
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	previous := make([]int32, len(pixels))
+	hash := func(position int) uint32 {
+		return (pixels[position]*0x1e35a7bd ^ pixels[position+1]*0x9e3779b1) >> (32 - hashBits)
+	}
+	insert := func(position int) {
+		if position+1 < len(pixels) {
+			h := hash(position)
+			previous[position] = head[h]
+			head[h] = int32(position)
+		}
+	}
+
+	for position := 0; position < len(pixels); {
+		bestLength, bestDistance := 0, 0
+		if position+1 < len(pixels) {
+			candidate := int(head[hash(position)])
+			for chain := 0; candidate >= 0 && chain < matchChain && position-candidate <= matchWindow; chain++ {
+				length := 0
+				limit := min(maxCopyLength, len(pixels)-position)
+				for length < limit && pixels[candidate+length] == pixels[position+length] {
+					length++
+				}
+				if length > bestLength {
+					bestLength, bestDistance = length, position-candidate
+				}
+				candidate = int(previous[candidate])
+			}
+		}
+
+		if bestLength >= minMatch {
+			distance := bestDistance + planeCodes
+			if code, found := planes[bestDistance]; found {
+				distance = code
+			}
+			emit(token{kind: tokenCopy, length: bestLength, distance: distance})
+			for end := position + bestLength; position < end; position++ {
+				remember(pixels[position])
+				insert(position)
+			}
+			continue
+		}
+
+		argb := pixels[position]
+		if cache != nil {
+			index := cacheIndex(argb, cacheBits)
+			if cached[index] && cache[index] == argb {
+				emit(token{kind: tokenCache, value: index})
+				insert(position)
+				position++
+				continue
+			}
+		}
+		emit(token{kind: tokenLiteral, value: argb})
+		remember(argb)
+		insert(position)
+		position++
+	}
+}
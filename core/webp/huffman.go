@@ -0,0 +1,287 @@
+package webp
+
+import "container/heap"
+
+const (
+	maxCodeLength           = 15
+	maxCodeLengthCodeLength = 7
+	numCodeLengthCodes      = 19
+)
+
+// codeLengthCodeOrder is the order the code length code's own lengths
+// are stored in.
+var codeLengthCodeOrder = [numCodeLengthCodes]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// canonicalCodes assigns the canonical code of every used symbol,
+// bit-reversed so it can be written least significant bit first.
+func canonicalCodes(lengths []uint8) []uint32 {
+	var count [maxCodeLength + 1]uint32
+	for _, length := range lengths {
+		count[length]++
+	}
+	count[0] = 0
+
+	var next [maxCodeLength + 1]uint32
+	code := uint32(0)
+	for length := 1; length <= maxCodeLength; length++ {
+		code = (code + count[length-1]) << 1
+		next[length] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		codes[symbol] = reverseBits(next[length], uint(length))
+		next[length]++
+	}
+
+	return codes
+}
+
+func reverseBits(value uint32, n uint) uint32 {
+	reversed := uint32(0)
+	for i := uint(0); i < n; i++ {
+		reversed = reversed<<1 | value&1
+		value >>= 1
+	}
+	return reversed
+}
+
+// encoderCode is how an encoder writes one alphabet.
+type encoderCode struct {
+	lengths []uint8
+	codes   []uint32
+}
+
+func (c *encoderCode) write(w *bitWriter, symbol int) {
+	w.write(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+// newEncoderCode builds a length limited prefix code for histogram and
+// writes it to w. A code with a single used symbol is written as such
+// and then costs no bits per symbol.
+func newEncoderCode(w *bitWriter, histogram []uint32) *encoderCode {
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		writeSimpleCode(w, used)
+		code := &encoderCode{lengths: make([]uint8, len(histogram)), codes: make([]uint32, len(histogram))}
+		if len(used) == 2 {
+			code.lengths[used[0]], code.lengths[used[1]] = 1, 1
+			code.codes[used[1]] = 1
+		}
+		return code
+	}
+
+	lengths := buildLengths(histogram, maxCodeLength)
+	writeCodeLengths(w, lengths)
+
+	if len(used) == 1 {
+		return &encoderCode{lengths: make([]uint8, len(histogram)), codes: make([]uint32, len(histogram))}
+	}
+	return &encoderCode{lengths: lengths, codes: canonicalCodes(lengths)}
+}
+
+func writeSimpleCode(w *bitWriter, symbols []int) {
+	if len(symbols) == 0 {
+		symbols = []int{0}
+	}
+
+	w.write(1, 1)
+	w.write(uint32(len(symbols)-1), 1)
+	if symbols[0] < 2 {
+		w.write(0, 1)
+		w.write(uint32(symbols[0]), 1)
+	} else {
+		w.write(1, 1)
+		w.write(uint32(symbols[0]), 8)
+	}
+	if len(symbols) == 2 {
+		w.write(uint32(symbols[1]), 8)
+	}
+}
+
+// writeCodeLengths writes lengths as a normal code: runs of zeros and
+// repeats folded into codes 16, 17 and 18, themselves prefix coded.
+func writeCodeLengths(w *bitWriter, lengths []uint8) {
+	type token struct{ symbol, extra, extraBits int }
+	var tokens []token
+
+	for i := 0; i < len(lengths); {
+		length := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == length {
+			run++
+		}
+		i += run
+
+		if length == 0 {
+			for run >= 3 {
+				if run >= 11 {
+					n := min(run, 138)
+					tokens = append(tokens, token{18, n - 11, 7})
+					run -= n
+				} else {
+					n := min(run, 10)
+					tokens = append(tokens, token{17, n - 3, 3})
+					run -= n
+				}
+			}
+			for ; run > 0; run-- {
+				tokens = append(tokens, token{0, 0, 0})
+			}
+			continue
+		}
+
+		tokens = append(tokens, token{int(length), 0, 0})
+		run--
+		for run >= 3 {
+			n := min(run, 6)
+			tokens = append(tokens, token{16, n - 3, 2})
+			run -= n
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, token{int(length), 0, 0})
+		}
+	}
+
+	histogram := make([]uint32, numCodeLengthCodes)
+	for _, t := range tokens {
+		histogram[t.symbol]++
+	}
+	codeLengthLengths := buildLengths(histogram, maxCodeLengthCodeLength)
+
+	used := 0
+	for _, length := range codeLengthLengths {
+		if length > 0 {
+			used++
+		}
+	}
+	if used == 1 {
+		// A lone code length symbol costs no bits, but must still
+		// be written with a non-zero length.
+		for symbol, count := range histogram {
+			if count > 0 {
+				codeLengthLengths[symbol] = 1
+			}
+		}
+	}
+
+	count := numCodeLengthCodes
+	for count > 4 && codeLengthLengths[codeLengthCodeOrder[count-1]] == 0 {
+		count--
+	}
+
+	w.write(0, 1)
+	w.write(uint32(count-4), 4)
+	for i := 0; i < count; i++ {
+		w.write(uint32(codeLengthLengths[codeLengthCodeOrder[i]]), 3)
+	}
+	// No max_symbol: every length is written.
+	w.write(0, 1)
+
+	codes := canonicalCodes(codeLengthLengths)
+	for _, t := range tokens {
+		if used > 1 {
+			w.write(codes[t.symbol], uint(codeLengthLengths[t.symbol]))
+		}
+		if t.extraBits > 0 {
+			w.write(uint32(t.extra), uint(t.extraBits))
+		}
+	}
+}
+
+type huffmanNode struct {
+	count       uint64
+	symbol      int
+	left, right *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	node := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return node
+}
+
+// buildLengths returns Huffman code lengths for histogram, none longer
+// than maxLength: counts are halved until the tree is shallow enough.
+// A single used symbol gets length 1.
+func buildLengths(histogram []uint32, maxLength int) []uint8 {
+	counts := make([]uint64, len(histogram))
+	for symbol, count := range histogram {
+		counts[symbol] = uint64(count)
+	}
+
+	for {
+		lengths, deepest := huffmanLengths(counts)
+		if deepest <= maxLength {
+			return lengths
+		}
+		for symbol, count := range counts {
+			if count > 0 {
+				counts[symbol] = (count + 1) / 2
+			}
+		}
+	}
+}
+
+func huffmanLengths(counts []uint64) ([]uint8, int) {
+	lengths := make([]uint8, len(counts))
+
+	nodes := huffmanHeap{}
+	for symbol, count := range counts {
+		if count > 0 {
+			nodes = append(nodes, &huffmanNode{count: count, symbol: symbol})
+		}
+	}
+	switch len(nodes) {
+	case 0:
+		return lengths, 0
+	case 1:
+		lengths[nodes[0].symbol] = 1
+		return lengths, 1
+	}
+
+	heap.Init(&nodes)
+	next := len(counts)
+	for nodes.Len() > 1 {
+		a := heap.Pop(&nodes).(*huffmanNode)
+		b := heap.Pop(&nodes).(*huffmanNode)
+		heap.Push(&nodes, &huffmanNode{count: a.count + b.count, symbol: next, left: a, right: b})
+		next++
+	}
+
+	deepest := 0
+	var walk func(node *huffmanNode, depth int)
+	walk = func(node *huffmanNode, depth int) {
+		if node.left == nil {
+			lengths[node.symbol] = uint8(depth)
+			deepest = max(deepest, depth)
+			return
+		}
+		walk(node.left, depth+1)
+		walk(node.right, depth+1)
+	}
+	walk(nodes[0], 0)
+
+	return lengths, deepest
+}
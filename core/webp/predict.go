@@ -0,0 +1,112 @@
+package webp
+
+// numPredictors are the predictor modes 0 to 13; 14 and 15 predict
+// like 0.
+const numPredictors = 14
+
+// predict returns what mode predicts for the pixel at position, from
+// its left (L), top (T), top-left (TL) and top-right (TR) neighbours.
+// The rightmost pixel's TR is the leftmost one of its own row, which
+// is where position-width+1 lands anyway.
+func predict(mode int, pixels []uint32, position int, width int) uint32 {
+	switch mode {
+	case 0, 14, 15:
+		return 0xff000000
+	case 1:
+		return pixels[position-1]
+	case 2:
+		return pixels[position-width]
+	case 3:
+		return pixels[position-width+1]
+	case 4:
+		return pixels[position-width-1]
+	}
+
+	left, top := pixels[position-1], pixels[position-width]
+	topLeft, topRight := pixels[position-width-1], pixels[position-width+1]
+	switch mode {
+	case 5:
+		return average2(average2(left, topRight), top)
+	case 6:
+		return average2(left, topLeft)
+	case 7:
+		return average2(left, top)
+	case 8:
+		return average2(topLeft, top)
+	case 9:
+		return average2(top, topRight)
+	case 10:
+		return average2(average2(left, topLeft), average2(top, topRight))
+	case 11:
+		return selectPixel(left, top, topLeft)
+	case 12:
+		return clampAddSubtractFull(left, top, topLeft)
+	case 13:
+		return clampAddSubtractHalf(average2(left, top), topLeft)
+	}
+
+	return 0xff000000
+}
+
+func subtractPixels(a uint32, b uint32) uint32 {
+	alphaGreen := (0x00ff00ff + a&0xff00ff00 - b&0xff00ff00) & 0xff00ff00
+	redBlue := (0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff) & 0x00ff00ff
+	return alphaGreen | redBlue
+}
+
+func average2(a uint32, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+func channel(argb uint32, shift uint) int {
+	return int(argb>>shift) & 0xff
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// selectPixel returns whichever of left and top is closer to the
+// gradient estimate left + top - topLeft.
+func selectPixel(left uint32, top uint32, topLeft uint32) uint32 {
+	distanceToLeft, distanceToTop := 0, 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		distanceToLeft += abs(channel(top, shift) - channel(topLeft, shift))
+		distanceToTop += abs(channel(left, shift) - channel(topLeft, shift))
+	}
+
+	if distanceToLeft < distanceToTop {
+		return left
+	}
+	return top
+}
+
+func clamp(v int) uint32 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 255:
+		return 255
+	}
+	return uint32(v)
+}
+
+func clampAddSubtractFull(a uint32, b uint32, c uint32) uint32 {
+	result := uint32(0)
+	for shift := uint(0); shift < 32; shift += 8 {
+		result |= clamp(channel(a, shift)+channel(b, shift)-channel(c, shift)) << shift
+	}
+	return result
+}
+
+func clampAddSubtractHalf(a uint32, b uint32) uint32 {
+	result := uint32(0)
+	for shift := uint(0); shift < 32; shift += 8 {
+		ca := channel(a, shift)
+		result |= clamp(ca+(ca-channel(b, shift))/2) << shift
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+package webp
+
+const (
+	vp8lSignature   = 0x2f
+	numLiteralCodes = 256
+	numLengthCodes  = 24
+	numDistanceCode = 40
+	// planeCodes are the distance codes naming a 2D neighbourhood.
+	planeCodes = 120
+)
+
+const (
+	transformPredictor = iota
+	transformColor
+	transformSubtractGreen
+	transformColorIndexing
+)
+
+// codeToPlane maps the distance codes 1 to 120 onto neighbours: the
+// high nibble is the row above, the low one 8 minus the column offset.
+var codeToPlane = [planeCodes]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+func subsampled(size int, bits uint) int {
+	return (size + 1<<bits - 1) >> bits
+}
+
+func cacheIndex(argb uint32, bits uint) uint32 {
+	return (0x1e35a7bd * argb) >> (32 - bits)
+}
+
+func planeDistance(width int, code int) int {
+	if code > planeCodes {
+		return code - planeCodes
+	}
+
+	plane := codeToPlane[code-1]
+	distance := int(plane>>4)*width + 8 - int(plane&0xf)
+	return max(distance, 1)
+}
@@ -0,0 +1,42 @@
+// Package webp writes lossless WebP (VP8L) images in pure Go.
+// Decoding, lossy and lossless alike, is left to
+// golang.org/x/image/webp, which registers the format with package
+// image.
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+)
+
+// ErrTooLarge is returned for images WebP can't hold: over 16384
+// pixels on a side.
+var ErrTooLarge = errors.New("webp: image too large")
+
+// Encode writes img as a lossless WebP image.
+func Encode(w io.Writer, img image.Image) error {
+	bitstream, err := encodeVP8L(img)
+	if err != nil {
+		return err
+	}
+
+	padding := len(bitstream) % 2
+	var header [20]byte
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(bitstream)+padding))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(bitstream)))
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(bitstream); err != nil {
+		return err
+	}
+	if padding == 1 {
+		_, err = w.Write([]byte{0})
+	}
+	return err
+}
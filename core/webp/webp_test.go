@@ -0,0 +1,126 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	xwebp "golang.org/x/image/webp"
+)
+
+func roundTrip(t *testing.T, img *image.NRGBA) {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	assert.NoError(t, Encode(&encoded, img))
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(encoded.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "webp", format)
+	assert.Equal(t, img.Bounds().Dx(), config.Width)
+	assert.Equal(t, img.Bounds().Dy(), config.Height)
+
+	decoded, format, err := image.Decode(bytes.NewReader(encoded.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "webp", format)
+	if assert.IsType(t, &image.NRGBA{}, decoded) {
+		assert.Equal(t, img.Pix, decoded.(*image.NRGBA).Pix)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	t.Run("photo-like gradient", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 67, 45))
+		for y := 0; y < 45; y++ {
+			for x := 0; x < 67; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{uint8(3 * x), uint8(5 * y), uint8(x*y + random.Intn(8)), 255})
+			}
+		}
+		roundTrip(t, img)
+	})
+
+	t.Run("noise with alpha", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+		random.Read(img.Pix)
+		roundTrip(t, img)
+	})
+
+	t.Run("repeated pattern", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 300, 40))
+		tile := make([]byte, 4*37)
+		random.Read(tile)
+		for i := range img.Pix {
+			img.Pix[i] = tile[i%len(tile)]
+		}
+		roundTrip(t, img)
+	})
+
+	for _, colors := range []int{1, 2, 3, 11, 200} {
+		t.Run("palette", func(t *testing.T) {
+			palette := make([]color.NRGBA, colors)
+			for i := range palette {
+				palette[i] = color.NRGBA{uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256))}
+			}
+			img := image.NewNRGBA(image.Rect(0, 0, 29, 13))
+			for y := 0; y < 13; y++ {
+				for x := 0; x < 29; x++ {
+					img.SetNRGBA(x, y, palette[random.Intn(colors)])
+				}
+			}
+			roundTrip(t, img)
+		})
+	}
+
+	t.Run("single pixel", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+		img.SetNRGBA(0, 0, color.NRGBA{1, 2, 3, 4})
+		roundTrip(t, img)
+	})
+}
+
+func TestEncodeConvertsAndOffsets(t *testing.T) {
+	gray := image.NewGray(image.Rect(10, 10, 20, 14))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 7)
+	}
+
+	var encoded bytes.Buffer
+	assert.NoError(t, Encode(&encoded, gray))
+	decoded, err := xwebp.Decode(&encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 4), decoded.Bounds())
+	assert.Equal(t, color.NRGBA{21, 21, 21, 255}, decoded.At(3, 0))
+}
+
+func TestCodeToPlane(t *testing.T) {
+	seen := map[uint8]bool{}
+	for _, plane := range codeToPlane {
+		assert.False(t, seen[plane], "duplicate %#x", plane)
+		seen[plane] = true
+		if plane>>4 == 0 {
+			assert.Less(t, int(plane&0xf), 8, "same row offsets point left")
+		}
+	}
+
+	assert.Equal(t, 100, planeDistance(100, 1), "code 1 is the pixel above")
+	assert.Equal(t, 1, planeDistance(100, 2), "code 2 is the pixel to the left")
+	assert.Equal(t, 5, planeDistance(100, 125))
+}
+
+func TestPrefixEncode(t *testing.T) {
+	for value := 1; value < 5000; value++ {
+		code, extraBits, extra := prefixEncode(value)
+		assert.Less(t, extra, uint32(1)<<extraBits)
+		if code < 4 {
+			assert.Equal(t, value, code+1)
+			continue
+		}
+		assert.Equal(t, uint(code-2)>>1, extraBits)
+		assert.Equal(t, value, (2+code&1)<<extraBits+int(extra)+1)
+	}
+}
@@ -0,0 +1,7 @@
+package di
+
+import "github.com/RodolfoBonis/rb-cdn/features/cache/domain/usecases"
+
+func CacheInjection() *usecases.CacheHandler {
+	return usecases.NewCacheHandler()
+}
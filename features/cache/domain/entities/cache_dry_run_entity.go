@@ -0,0 +1,16 @@
+package entities
+
+// CacheDryRunEntity shows what the bucket's cache rules give an
+// object: which rule matched and the headers /cdn would send.
+type CacheDryRunEntity struct {
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	ContentType string `json:"content_type"`
+	// Rule is the matched rule's name, "rules[i]" for an unnamed one,
+	// "immutable_hashed" for the content-hashed key default; empty
+	// when nothing matched.
+	Rule string `json:"rule,omitempty"`
+	// RuleIndex is the matched rule's position in the bucket's rules.
+	RuleIndex *int              `json:"rule_index,omitempty"`
+	Headers   map[string]string `json:"headers"`
+}
@@ -0,0 +1,83 @@
+package usecases
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/cachepolicy"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/mimetype"
+	"github.com/RodolfoBonis/rb-cdn/features/cache/domain/entities"
+	"github.com/gin-gonic/gin"
+)
+
+type CacheHandler struct{}
+
+func NewCacheHandler() *CacheHandler {
+	return &CacheHandler{}
+}
+
+// DryRun godoc
+// @Summary Dry-run the cache rules
+// @Description Shows which of the bucket's cache rules matches a key, and the caching headers /cdn would send for it. Nothing is read from the bucket: the key need not exist, and its content type is content_type or, without it, the one its extension resolves to. A Cache-Control set on the object at upload time would take precedence over the rule's.
+// @Tags cache
+// @Produce json
+// @Param bucket path string true "Bucket name"
+// @Param objectPath path string true "Object key"
+// @Param content_type query string false "Content type to match the rules with"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.CacheDryRunEntity
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Router /cache/{bucket}/{objectPath} [get]
+func (uc *CacheHandler) DryRun(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	bucketName := c.Param("bucket")
+	key := strings.TrimPrefix(c.Param("objectPath"), "/")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid object path"})
+		return
+	}
+
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucketName, "read") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No read permission for bucket: %s", bucketName),
+		})
+		return
+	}
+
+	settings := config.BucketSettings(bucketName)
+	contentType := c.Query("content_type")
+	if contentType == "" {
+		contentType = mimetype.Default.Resolve(key, "", mimetype.Options{
+			Overrides: settings.Delivery.ContentTypes,
+			Charset:   settings.Delivery.Charset,
+		})
+	}
+
+	match := cachepolicy.Evaluate(settings.Cache, bucketName, key, contentType)
+	response := entities.CacheDryRunEntity{
+		Bucket:      bucketName,
+		Key:         key,
+		ContentType: contentType,
+		Rule:        match.Rule,
+		Headers:     map[string]string{},
+	}
+	if match.Index >= 0 {
+		response.RuleIndex = &match.Index
+	}
+	for name, values := range match.Headers(time.Now()) {
+		response.Headers[name] = strings.Join(values, ", ")
+	}
+
+	c.JSON(http.StatusOK, response)
+}
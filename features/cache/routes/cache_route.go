@@ -0,0 +1,14 @@
+package routes
+
+import (
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/features/cache/di"
+	"github.com/gin-gonic/gin"
+)
+
+func InjectRoutes(route *gin.RouterGroup, authClient *rbauth.Client) {
+	var uc = di.CacheInjection()
+
+	cacheRoute := route.Group("/cache")
+	cacheRoute.GET("/:bucket/*objectPath", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "read"), uc.DryRun)
+}
@@ -0,0 +1,18 @@
+package di
+
+import (
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/encryption/domain/usecases"
+)
+
+func EncryptionInjection() *usecases.EncryptionHandler {
+	minioService := services.NewMinioService()
+
+	handler := usecases.NewEncryptionHandler(minioService, logger.Log)
+	handler.StartJanitor(time.Hour)
+
+	return handler
+}
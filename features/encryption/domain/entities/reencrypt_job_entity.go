@@ -0,0 +1,43 @@
+package entities
+
+import "time"
+
+type ReencryptRequestEntity struct {
+	// Prefix narrows the job to the keys under it; empty covers the
+	// whole bucket.
+	Prefix string `json:"prefix"`
+}
+
+var ReencryptJobStatus = struct {
+	Running   string
+	Succeeded string
+	Failed    string
+}{
+	Running:   "running",
+	Succeeded: "succeeded",
+	Failed:    "failed",
+}
+
+// ReencryptJobEntity is the state of a re-encryption job. It is
+// persisted as JSON in the bucket, like fetch jobs, so any replica can
+// answer a status poll. A job that couldn't rewrite every object ends
+// "failed"; running it again picks up what is left.
+type ReencryptJobEntity struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix,omitempty"`
+	// Mode and KeyID are what the objects are moved to: the bucket's
+	// encryption mode and the active keyring key.
+	Mode      string `json:"mode"`
+	KeyID     string `json:"key_id,omitempty"`
+	Scanned   int    `json:"scanned"`
+	Rewritten int    `json:"rewritten"`
+	Failed    int    `json:"failed"`
+	// Error is why the job couldn't run at all.
+	Error string `json:"error,omitempty"`
+	// Errors holds the first failures, by key.
+	Errors    map[string]string `json:"errors,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
@@ -0,0 +1,302 @@
+package usecases
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/keyring"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/encryption/domain/entities"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go"
+)
+
+const (
+	// encryptionPrefix holds job state and the staging copies of
+	// objects being rewritten; jobs never rewrite it themselves.
+	encryptionPrefix = ".encryption/"
+	jobStatePrefix   = encryptionPrefix + "jobs/"
+	// saveInterval is how many objects a job goes through between
+	// saves of its state.
+	saveInterval = 100
+	// maxReportedErrors bounds the failures a job keeps by key.
+	maxReportedErrors = 20
+)
+
+// EncryptionHandler runs re-encryption jobs: after a key rotation, or
+// a change of a bucket's encryption mode, they move every object onto
+// the bucket's mode and the active keyring key (see
+// services.IMinioService.Reencrypt).
+type EncryptionHandler struct {
+	minioService services.IMinioService
+	log          *logger.CustomLogger
+}
+
+func NewEncryptionHandler(minioService services.IMinioService, log *logger.CustomLogger) *EncryptionHandler {
+	return &EncryptionHandler{minioService: minioService, log: log}
+}
+
+// Reencrypt godoc
+// @Summary Re-encrypt a bucket
+// @Description Starts a job that rewrites every object in bucket, or under prefix, that isn't encrypted with the bucket's encryption mode and the active keyring key: run it after adding a key to the keyring and making it active, and only drop the old key once the job succeeded. Objects written before encryption was turned on get encrypted, and turning it off and running a job decrypts them. rb-cdn's own objects are skipped, except the blobs of content-addressed buckets. Answers 202 with the job to poll at the Location header.
+// @Tags encryption
+// @Accept json
+// @Produce json
+// @Param bucket path string true "Bucket name"
+// @Param request body entities.ReencryptRequestEntity false "What to re-encrypt"
+// @Param Authorization header string true "Bearer token"
+// @Success 202 {object} entities.ReencryptJobEntity
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 500 {object} errors.HttpError
+// @Router /encryption/{bucket}/reencrypt [post]
+func (uc *EncryptionHandler) Reencrypt(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	bucketName := c.Param("bucket")
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucketName, "write") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No write permission for bucket: %s", bucketName),
+		})
+		return
+	}
+
+	// The body is optional: no body re-encrypts the whole bucket.
+	var request entities.ReencryptRequestEntity
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if keyring.Default().Empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "KEYRING_FILE holds no keys: nothing is encrypted"})
+		return
+	}
+
+	now := time.Now().UTC()
+	job := &entities.ReencryptJobEntity{
+		ID:        strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Status:    entities.ReencryptJobStatus.Running,
+		Bucket:    bucketName,
+		Prefix:    strings.TrimPrefix(request.Prefix, "/"),
+		Mode:      config.BucketSettings(bucketName).Encryption.Mode,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if job.Mode != coreEntities.EncryptionMode.None {
+		job.KeyID, _, _ = keyring.Default().Active()
+	}
+
+	if appErr := uc.saveJob(job); appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		c.JSON(http.StatusInternalServerError, appErr)
+		return
+	}
+
+	// The job outlives the request. If this replica dies before it
+	// finishes, the job stays running until the janitor drops it, and
+	// a new one picks up what is left.
+	go uc.run(job)
+
+	c.Header("Location", fmt.Sprintf("%s/encryption/%s/jobs/%s", config.EnvCDNPublicURL(), job.Bucket, job.ID))
+	c.JSON(http.StatusAccepted, job)
+}
+
+// Status godoc
+// @Summary Poll a re-encryption job
+// @Description Returns the state of a job started through POST /encryption/{bucket}/reencrypt.
+// @Tags encryption
+// @Produce json
+// @Param bucket path string true "Bucket name"
+// @Param id path string true "Job id"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.ReencryptJobEntity
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Router /encryption/{bucket}/jobs/{id} [get]
+func (uc *EncryptionHandler) Status(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	bucketName := c.Param("bucket")
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucketName, "write") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No write permission for bucket: %s", bucketName),
+		})
+		return
+	}
+
+	job, appErr := uc.loadJob(bucketName, c.Param("id"))
+	if appErr != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// run re-encrypts the objects of job one by one, saving its state
+// every saveInterval objects and once it is over. A failing object
+// doesn't stop the job; it ends "failed" instead.
+func (uc *EncryptionHandler) run(job *entities.ReencryptJobEntity) {
+	objects, appErr := uc.minioService.ListObjects(job.Bucket, job.Prefix)
+	if appErr != nil {
+		job.Status = entities.ReencryptJobStatus.Failed
+		job.Error = appErr.Message
+		uc.finish(job)
+		return
+	}
+
+	for _, object := range objects {
+		// rb-cdn's own objects are left as they are, this job's state
+		// among them, except content-addressed blobs: they hold the
+		// content of every key pointing to them.
+		if prefix := keys.PrivatePrefix(object.Key); prefix != "" && prefix != cas.Prefix {
+			continue
+		}
+
+		job.Scanned++
+		rewritten, appErr := uc.minioService.Reencrypt(job.Bucket, object.Key)
+		switch {
+		case appErr != nil:
+			job.Failed++
+			if len(job.Errors) < maxReportedErrors {
+				if job.Errors == nil {
+					job.Errors = map[string]string{}
+				}
+				job.Errors[object.Key] = appErr.Message
+			}
+		case rewritten:
+			job.Rewritten++
+		}
+
+		if job.Scanned%saveInterval == 0 {
+			job.UpdatedAt = time.Now().UTC()
+			if appErr := uc.saveJob(job); appErr != nil {
+				uc.log.Error(appErr.Message, appErr.ToMap())
+			}
+		}
+	}
+
+	job.Status = entities.ReencryptJobStatus.Succeeded
+	if job.Failed > 0 {
+		job.Status = entities.ReencryptJobStatus.Failed
+	}
+	uc.finish(job)
+}
+
+// finish saves job's final state and logs its outcome.
+func (uc *EncryptionHandler) finish(job *entities.ReencryptJobEntity) {
+	job.UpdatedAt = time.Now().UTC()
+	if appErr := uc.saveJob(job); appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+	}
+
+	uc.log.Info(fmt.Sprintf("Re-encryption of %s %s", job.Bucket, job.Status), map[string]interface{}{
+		"job":       job.ID,
+		"scanned":   job.Scanned,
+		"rewritten": job.Rewritten,
+		"failed":    job.Failed,
+	})
+}
+
+// PurgeFinished drops job state, and staging copies a dead replica
+// left behind, older than ENCRYPTION_JOB_RETENTION from every bucket.
+func (uc *EncryptionHandler) PurgeFinished() {
+	buckets, appErr := uc.minioService.ListBuckets()
+	if appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		return
+	}
+
+	deadline := time.Now().Add(-config.EnvEncryptionJobRetention())
+	for _, bucket := range buckets {
+		objects, appErr := uc.minioService.ListObjects(bucket.Name, encryptionPrefix)
+		if appErr != nil {
+			uc.log.Error(appErr.Message, appErr.ToMap())
+			continue
+		}
+
+		for _, object := range objects {
+			if object.LastModified.After(deadline) {
+				continue
+			}
+			if appErr := uc.minioService.RemoveObject(bucket.Name, object.Key); appErr != nil {
+				uc.log.Error(appErr.Message, appErr.ToMap())
+			}
+		}
+	}
+}
+
+// StartJanitor runs PurgeFinished every interval until the process
+// exits.
+func (uc *EncryptionHandler) StartJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			uc.PurgeFinished()
+		}
+	}()
+}
+
+func jobObject(id string) string {
+	return fmt.Sprintf("%s%s.json", jobStatePrefix, id)
+}
+
+func (uc *EncryptionHandler) saveJob(job *entities.ReencryptJobEntity) *errors.AppError {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return errors.UsecaseError(err.Error())
+	}
+
+	_, appErr := uc.minioService.PutObject(
+		job.Bucket,
+		jobObject(job.ID),
+		bytes.NewReader(payload),
+		int64(len(payload)),
+		minio.PutObjectOptions{ContentType: "application/json"},
+	)
+	return appErr
+}
+
+func (uc *EncryptionHandler) loadJob(bucketName string, id string) (*entities.ReencryptJobEntity, *errors.AppError) {
+	object, appErr := uc.minioService.GetObject(bucketName, jobObject(id), minio.GetObjectOptions{})
+	if appErr != nil {
+		return nil, appErr
+	}
+	defer object.Close()
+
+	var job entities.ReencryptJobEntity
+	if err := json.NewDecoder(object).Decode(&job); err != nil {
+		return nil, errors.NotFoundError()
+	}
+
+	if job.ID != id || job.Bucket != bucketName {
+		return nil, errors.NotFoundError()
+	}
+
+	return &job, nil
+}
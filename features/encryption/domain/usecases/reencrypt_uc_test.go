@@ -0,0 +1,65 @@
+package usecases
+
+import (
+	"io"
+	"testing"
+
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/encryption/domain/entities"
+	"github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitLogger()
+}
+
+// fakeMinio lists a fixed set of keys and records which ones were
+// re-encrypted. Job state is accepted and dropped.
+type fakeMinio struct {
+	services.IMinioService
+	objects     []string
+	reencrypted []string
+}
+
+func (f *fakeMinio) ListObjects(bucket string, prefix string) ([]minio.ObjectInfo, *errors.AppError) {
+	var objects []minio.ObjectInfo
+	for _, key := range f.objects {
+		objects = append(objects, minio.ObjectInfo{Key: key})
+	}
+	return objects, nil
+}
+
+func (f *fakeMinio) Reencrypt(bucket string, objectName string) (bool, *errors.AppError) {
+	f.reencrypted = append(f.reencrypted, objectName)
+	return true, nil
+}
+
+func (f *fakeMinio) PutObject(bucket string, objectName string, reader io.Reader, size int64, options minio.PutObjectOptions) (int64, *errors.AppError) {
+	return size, nil
+}
+
+func TestRunSkipsPrivateObjectsButBlobs(t *testing.T) {
+	minioService := &fakeMinio{objects: []string{
+		"docs/a.pdf",
+		".cas/sha256/ab/abcd",
+		".cas/refs/abcd/ZG9jcy9hLnBkZg",
+		".tus/upload.json",
+		".fetch/job.json",
+		".quarantine/b.exe",
+		".exif/docs/a.jpg",
+		".encryption/jobs/old.json",
+		".variants/ab/cd",
+		".similar/00/ab",
+	}}
+	handler := NewEncryptionHandler(minioService, logger.Log)
+
+	job := &entities.ReencryptJobEntity{ID: "job", Bucket: "media"}
+	handler.run(job)
+
+	assert.Equal(t, []string{"docs/a.pdf", ".cas/sha256/ab/abcd", ".cas/refs/abcd/ZG9jcy9hLnBkZg"}, minioService.reencrypted)
+	assert.Equal(t, 3, job.Scanned)
+	assert.Equal(t, entities.ReencryptJobStatus.Succeeded, job.Status)
+}
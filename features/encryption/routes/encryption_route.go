@@ -0,0 +1,19 @@
+package routes
+
+import (
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/idempotency"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/middlewares"
+	"github.com/RodolfoBonis/rb-cdn/features/encryption/di"
+	"github.com/gin-gonic/gin"
+)
+
+func InjectRoutes(route *gin.RouterGroup, authClient *rbauth.Client) {
+	var uc = di.EncryptionInjection()
+
+	encryptionRoute := route.Group("/encryption")
+	encryptionRoute.POST("/:bucket/reencrypt", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), middlewares.Idempotency(idempotency.Default(), config.EnvIdempotencyTTL(), logger.Log), uc.Reencrypt)
+	encryptionRoute.GET("/:bucket/jobs/:id", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), uc.Status)
+}
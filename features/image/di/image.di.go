@@ -0,0 +1,19 @@
+package di
+
+import (
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/image/domain/usecases"
+)
+
+func ImageInjection() *usecases.ImageHandler {
+	minioService := services.NewMinioService()
+
+	variants := imaging.NewStore(minioService, logger.Log)
+	variants.StartJanitor(time.Hour)
+
+	return usecases.NewImageHandler(minioService, variants, logger.Log)
+}
@@ -0,0 +1,54 @@
+package entities
+
+import "time"
+
+type AnalyzeRequestEntity struct {
+	// Prefix narrows the job to the keys under it; empty covers the
+	// whole bucket.
+	Prefix string `json:"prefix"`
+	// Force analyses images again even if they already carry an
+	// analysis.
+	Force bool `json:"force"`
+	// StartAfter skips the keys up to and including it, e.g. the
+	// LastKey of a job that stopped halfway.
+	StartAfter string `json:"start_after"`
+}
+
+var AnalyzeJobStatus = struct {
+	Running   string
+	Succeeded string
+	Failed    string
+}{
+	Running:   "running",
+	Succeeded: "succeeded",
+	Failed:    "failed",
+}
+
+// AnalyzeJobEntity is the state of a backfill job analysing the images
+// stored before uploads were analysed. It is persisted as JSON in the
+// bucket, like re-encryption jobs, so any replica can answer a status
+// poll. Running a job again picks up the images that are left, from
+// LastKey on when started after it.
+type AnalyzeJobEntity struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	Bucket     string `json:"bucket"`
+	Prefix     string `json:"prefix,omitempty"`
+	Force      bool   `json:"force,omitempty"`
+	StartAfter string `json:"start_after,omitempty"`
+	// LastKey is the last key the job went through, saved with its
+	// state; keys are gone through in order.
+	LastKey string `json:"last_key,omitempty"`
+	// Scanned counts the keys looked at, Analyzed the images analysed
+	// and Skipped the other files and images analysed already.
+	Scanned  int `json:"scanned"`
+	Analyzed int `json:"analyzed"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+	// Error is why the job couldn't run at all.
+	Error string `json:"error,omitempty"`
+	// Errors holds the first failures, by key.
+	Errors    map[string]string `json:"errors,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
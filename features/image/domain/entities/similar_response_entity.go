@@ -0,0 +1,17 @@
+package entities
+
+// SimilarResponseEntity lists the near-duplicates of an image.
+type SimilarResponseEntity struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// DHash is the image's hash and Distance how many bits other
+	// images' hashes could differ from it by.
+	DHash    string               `json:"dhash"`
+	Distance int                  `json:"distance"`
+	Matches  []SimilarMatchEntity `json:"matches"`
+}
+
+type SimilarMatchEntity struct {
+	Key      string `json:"key"`
+	Distance int    `json:"distance"`
+}
@@ -0,0 +1,277 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/RodolfoBonis/rb-cdn/features/image/domain/entities"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go"
+)
+
+const (
+	// jobStatePrefix holds analysis job state. It sits with the
+	// variants, so the variant janitor drops it after
+	// IMAGE_VARIANT_RETENTION.
+	jobStatePrefix = imaging.VariantPrefix + "jobs/"
+	// saveInterval is how many objects a job goes through between
+	// saves of its state.
+	saveInterval = 100
+	// maxReportedErrors bounds the failures a job keeps by key.
+	maxReportedErrors = 20
+)
+
+// Analyze godoc
+// @Summary Analyse a bucket's images
+// @Description Starts a job that works out the upright width and height, dominant colour, BlurHash and DHash of every JPEG, PNG, GIF and WebP image in bucket, or under prefix, and records them on the object, in the catalog IMAGE_CATALOG names if any, and the DHash in the index /similar looks near-duplicates up in, as uploads are: run it, or the analyze command, for images stored before their bucket analysed uploads. Images analysed already are skipped unless force is set; keys up to start_after are skipped, so a job that stopped is resumed from its last_key. Answers 202 with the job to poll at the Location header.
+// @Tags Media
+// @Accept json
+// @Produce json
+// @Param bucket path string true "Bucket name"
+// @Param request body entities.AnalyzeRequestEntity false "What to analyse"
+// @Param Authorization header string true "Bearer token"
+// @Success 202 {object} entities.AnalyzeJobEntity
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 500 {object} errors.HttpError
+// @Router /images/{bucket}/analyze [post]
+func (uc *ImageHandler) Analyze(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	bucketName := c.Param("bucket")
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucketName, "write") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No write permission for bucket: %s", bucketName),
+		})
+		return
+	}
+
+	// The body is optional: no body analyses the whole bucket.
+	var request entities.AnalyzeRequestEntity
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job := newJob(bucketName, request)
+	if appErr := uc.saveJob(job); appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		c.JSON(http.StatusInternalServerError, appErr)
+		return
+	}
+
+	// The job outlives the request. If this replica dies before it
+	// finishes, the job stays running until the janitor drops it, and
+	// a new one started after its last key picks up what is left. The
+	// answer is a copy: run keeps updating job.
+	accepted := *job
+	accepted.Errors = maps.Clone(job.Errors)
+	go uc.run(job)
+
+	c.Header("Location", fmt.Sprintf("%s/images/%s/jobs/%s", config.EnvCDNPublicURL(), job.Bucket, job.ID))
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// Backfill runs an analysis job over bucket in the foreground, as the
+// analyze command does for images stored before their bucket analysed
+// uploads, and returns its final state. The job's state is saved as
+// for jobs started through the API, so it can be polled meanwhile.
+func (uc *ImageHandler) Backfill(bucketName string, request entities.AnalyzeRequestEntity) (*entities.AnalyzeJobEntity, *errors.AppError) {
+	job := newJob(bucketName, request)
+	if appErr := uc.saveJob(job); appErr != nil {
+		return nil, appErr
+	}
+
+	uc.run(job)
+	return job, nil
+}
+
+// Status godoc
+// @Summary Poll an image analysis job
+// @Description Returns the state of a job started through POST /images/{bucket}/analyze.
+// @Tags Media
+// @Produce json
+// @Param bucket path string true "Bucket name"
+// @Param id path string true "Job id"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.AnalyzeJobEntity
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Router /images/{bucket}/jobs/{id} [get]
+func (uc *ImageHandler) Status(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	bucketName := c.Param("bucket")
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucketName, "write") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No write permission for bucket: %s", bucketName),
+		})
+		return
+	}
+
+	job, appErr := uc.loadJob(bucketName, c.Param("id"))
+	if appErr != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// run analyses the images of job one by one, as the listing comes in,
+// saving its state, LastKey included, every saveInterval objects and
+// once it is over. A failing image doesn't stop the job; it ends
+// "failed" instead.
+func (uc *ImageHandler) run(job *entities.AnalyzeJobEntity) {
+	appErr := uc.minioService.WalkObjects(job.Bucket, job.Prefix, func(object minio.ObjectInfo) bool {
+		if keys.Private(object.Key) || (job.StartAfter != "" && object.Key <= job.StartAfter) {
+			return true
+		}
+
+		job.Scanned++
+		analysis, appErr := uc.analyze(job, object.Key)
+		switch {
+		case appErr != nil:
+			job.Failed++
+			if len(job.Errors) < maxReportedErrors {
+				if job.Errors == nil {
+					job.Errors = map[string]string{}
+				}
+				job.Errors[object.Key] = appErr.Message
+			}
+		case analysis:
+			job.Analyzed++
+		default:
+			job.Skipped++
+		}
+		job.LastKey = object.Key
+
+		if job.Scanned%saveInterval == 0 {
+			job.UpdatedAt = time.Now().UTC()
+			if appErr := uc.saveJob(job); appErr != nil {
+				uc.log.Error(appErr.Message, appErr.ToMap())
+			}
+		}
+		return true
+	})
+	if appErr != nil {
+		job.Status = entities.AnalyzeJobStatus.Failed
+		job.Error = appErr.Message
+		uc.finish(job)
+		return
+	}
+
+	job.Status = entities.AnalyzeJobStatus.Succeeded
+	if job.Failed > 0 {
+		job.Status = entities.AnalyzeJobStatus.Failed
+	}
+	uc.finish(job)
+}
+
+// analyze analyses key for job and reports whether it did: files that
+// aren't images, and images analysed already, are left alone.
+func (uc *ImageHandler) analyze(job *entities.AnalyzeJobEntity, key string) (bool, *errors.AppError) {
+	info, appErr := uc.minioService.LookupObject(job.Bucket, key)
+	if appErr != nil || info == nil || !imaging.Decodable(info.ContentType) {
+		return false, appErr
+	}
+	// Analyses recorded before images were hashed lack a DHash.
+	if existing := imaging.AnalysisFromMetadata(info.Metadata); !job.Force && existing != nil && existing.DHash != "" {
+		return false, nil
+	}
+
+	analysis, appErr := uc.variants.Analyze(context.Background(), job.Bucket, key)
+	return analysis != nil, appErr
+}
+
+// finish saves job's final state and logs its outcome.
+func (uc *ImageHandler) finish(job *entities.AnalyzeJobEntity) {
+	job.UpdatedAt = time.Now().UTC()
+	if appErr := uc.saveJob(job); appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+	}
+
+	uc.log.Info(fmt.Sprintf("Image analysis of %s %s", job.Bucket, job.Status), map[string]interface{}{
+		"job":      job.ID,
+		"scanned":  job.Scanned,
+		"analyzed": job.Analyzed,
+		"skipped":  job.Skipped,
+		"failed":   job.Failed,
+	})
+}
+
+func newJob(bucketName string, request entities.AnalyzeRequestEntity) *entities.AnalyzeJobEntity {
+	now := time.Now().UTC()
+	return &entities.AnalyzeJobEntity{
+		ID:         strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Status:     entities.AnalyzeJobStatus.Running,
+		Bucket:     bucketName,
+		Prefix:     strings.TrimPrefix(request.Prefix, "/"),
+		Force:      request.Force,
+		StartAfter: request.StartAfter,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+func jobObject(id string) string {
+	return fmt.Sprintf("%s%s.json", jobStatePrefix, id)
+}
+
+func (uc *ImageHandler) saveJob(job *entities.AnalyzeJobEntity) *errors.AppError {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return errors.UsecaseError(err.Error())
+	}
+
+	_, appErr := uc.minioService.PutObject(
+		job.Bucket,
+		jobObject(job.ID),
+		bytes.NewReader(payload),
+		int64(len(payload)),
+		minio.PutObjectOptions{ContentType: "application/json"},
+	)
+	return appErr
+}
+
+func (uc *ImageHandler) loadJob(bucketName string, id string) (*entities.AnalyzeJobEntity, *errors.AppError) {
+	object, appErr := uc.minioService.GetObject(bucketName, jobObject(id), minio.GetObjectOptions{})
+	if appErr != nil {
+		return nil, appErr
+	}
+	defer object.Close()
+
+	var job entities.AnalyzeJobEntity
+	if err := json.NewDecoder(object).Decode(&job); err != nil {
+		return nil, errors.NotFoundError()
+	}
+
+	if job.ID != id || job.Bucket != bucketName {
+		return nil, errors.NotFoundError()
+	}
+
+	return &job, nil
+}
@@ -0,0 +1,58 @@
+package usecases
+
+import (
+	"io"
+	"testing"
+
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/image/domain/entities"
+	"github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.InitLogger()
+}
+
+// fakeMinio walks a fixed set of keys, none of them images, and counts
+// the saves of job state.
+type fakeMinio struct {
+	services.IMinioService
+	objects []string
+	saves   int
+}
+
+func (f *fakeMinio) WalkObjects(bucket string, prefix string, visit func(object minio.ObjectInfo) bool) *errors.AppError {
+	for _, key := range f.objects {
+		if !visit(minio.ObjectInfo{Key: key}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (f *fakeMinio) LookupObject(bucket string, objectName string) (*minio.ObjectInfo, *errors.AppError) {
+	return &minio.ObjectInfo{Key: objectName, ContentType: "text/plain"}, nil
+}
+
+func (f *fakeMinio) PutObject(bucket string, objectName string, reader io.Reader, size int64, options minio.PutObjectOptions) (int64, *errors.AppError) {
+	f.saves++
+	return size, nil
+}
+
+func TestRunResumesAfterLastKey(t *testing.T) {
+	minioService := &fakeMinio{objects: []string{"a.txt", "b.txt", ".variants/jobs/old.json", "c.txt"}}
+	handler := NewImageHandler(minioService, imaging.NewStore(minioService, logger.Log), logger.Log)
+
+	job := &entities.AnalyzeJobEntity{ID: "job", Bucket: "media", StartAfter: "a.txt"}
+	handler.run(job)
+
+	assert.Equal(t, 2, job.Scanned)
+	assert.Equal(t, 2, job.Skipped)
+	assert.Equal(t, "c.txt", job.LastKey)
+	assert.Equal(t, entities.AnalyzeJobStatus.Succeeded, job.Status)
+	assert.Equal(t, 1, minioService.saves, "the final state is saved")
+}
@@ -0,0 +1,117 @@
+package usecases
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/RodolfoBonis/rb-cdn/features/image/domain/entities"
+	"github.com/gin-gonic/gin"
+)
+
+// Similar godoc
+// @Summary Find near-duplicates of an image
+// @Description Lists the images of the bucket whose DHash is within distance bits of the image's, closest first: the same picture resized, re-encoded or lightly edited. Images are hashed at upload time, in buckets that analyse images or reject near-duplicates, or by POST /images/{bucket}/analyze; one that wasn't yet is hashed now. Images stored before either are only found once analysed.
+// @Tags Media
+// @Produce json
+// @Param bucket path string true "Bucket name"
+// @Param objectPath path string true "Path to the image in the bucket"
+// @Param distance query int false "Largest Hamming distance, 0-7; the bucket's images.duplicates.distance by default"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.SimilarResponseEntity
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Failure 413 {object} errors.HttpError
+// @Failure 415 {object} errors.HttpError
+// @Failure 500 {object} errors.HttpError
+// @Router /similar/{bucket}/{objectPath} [get]
+func (uc *ImageHandler) Similar(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	objectName := c.Param("objectPath")[1:]
+	bucket := c.Param("bucket")
+
+	if objectName == "" || bucket == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid object path"})
+		return
+	}
+
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucket, "read") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No read permission for bucket: %s", bucket),
+		})
+		return
+	}
+
+	distance := config.BucketSettings(bucket).Images.Duplicates.Distance
+	if value := c.Query("distance"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 || parsed > imaging.MaxDistance {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("distance must be between 0 and %d", imaging.MaxDistance)})
+			return
+		}
+		distance = parsed
+	}
+
+	info, appErr := uc.minioService.LookupObject(bucket, objectName)
+	if appErr != nil {
+		c.JSON(appErr.ToHttpError().StatusCode, appErr)
+		return
+	}
+	if info == nil || keys.Private(objectName) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+		return
+	}
+	if !imaging.Decodable(info.ContentType) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Only JPEG, PNG, GIF and WebP images are hashed"})
+		return
+	}
+
+	analysis := imaging.AnalysisFromMetadata(info.Metadata)
+	if analysis == nil || analysis.DHash == "" {
+		analysis, appErr = uc.variants.Analyze(c.Request.Context(), bucket, objectName)
+		if appErr != nil {
+			c.JSON(appErr.ToHttpError().StatusCode, appErr)
+			return
+		}
+		if analysis == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+			return
+		}
+	}
+
+	hash, err := imaging.ParseHash(analysis.DHash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	matches, appErr := uc.variants.Similar(bucket, hash, distance, objectName)
+	if appErr != nil {
+		c.JSON(appErr.ToHttpError().StatusCode, appErr)
+		return
+	}
+
+	response := entities.SimilarResponseEntity{
+		Bucket:   bucket,
+		Key:      objectName,
+		DHash:    analysis.DHash,
+		Distance: distance,
+		Matches:  []entities.SimilarMatchEntity{},
+	}
+	for _, match := range matches {
+		response.Matches = append(response.Matches, entities.SimilarMatchEntity{Key: match.Key, Distance: match.Distance})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
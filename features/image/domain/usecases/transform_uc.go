@@ -0,0 +1,134 @@
+package usecases
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/cachepolicy"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/delivery"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go"
+)
+
+type ImageHandler struct {
+	minioService services.IMinioService
+	variants     *imaging.Store
+	signingKey   []byte
+	log          *logger.CustomLogger
+}
+
+func NewImageHandler(minioService services.IMinioService, variants *imaging.Store, log *logger.CustomLogger) *ImageHandler {
+	return &ImageHandler{
+		minioService: minioService,
+		variants:     variants,
+		signingKey:   []byte(config.EnvImageSigningKey()),
+		log:          log,
+	}
+}
+
+// Transform godoc
+// @Summary Get a transformed image
+// @Description Resizes, crops, rotates and re-encodes a JPEG, PNG, GIF or WebP image. Images are turned upright by their EXIF orientation first and never scaled up; animated GIFs keep their first frame. w and h go up to IMAGE_MAX_DIMENSION; sources over IMAGE_MAX_SOURCE_SIZE bytes or IMAGE_MAX_PIXELS pixels are refused. When IMAGE_SIGNING_KEY is set, sig must be the unpadded base64url HMAC-SHA256 of "<bucket>/<objectPath>?<params>", params being the ones set other than sig, defaults left out, q left out unless the output may be JPEG, sorted by name and URL-encoded (e.g. "fit=cover&h=200&w=200"). Without IMAGE_SIGNING_KEY, only no parameters or those of one of the bucket's presets are accepted. Variants are cached in the bucket and derived again when the source changes; X-Variant-Cache tells whether one was. Responses carry the bucket's cache rules, ETag and Last-Modified, and honour conditional and range requests like /cdn.
+// @Tags Media
+// @Produce image/jpeg
+// @Produce image/png
+// @Produce image/gif
+// @Produce image/webp
+// @Param bucket path string true "Bucket name"
+// @Param objectPath path string true "Path to the source image in the bucket"
+// @Param w query int false "Maximum width"
+// @Param h query int false "Maximum height"
+// @Param fit query string false "How to fit w x h: contain (default), cover (crop around the centre) or fill (stretch)"
+// @Param fmt query string false "Output format: jpeg, png, gif or webp (lossless); the source's by default"
+// @Param q query int false "JPEG quality, 1-100 (default 80)"
+// @Param rot query int false "Clockwise rotation: 90, 180 or 270"
+// @Param sig query string false "Signature of the parameters, required when IMAGE_SIGNING_KEY is set; without it, the parameters must match a preset of the bucket"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {file} binary "Transformed image"
+// @Success 206 {file} binary "Partial transformed image"
+// @Success 304 "The client's copy is current"
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Failure 413 {object} errors.HttpError
+// @Failure 415 {object} errors.HttpError
+// @Failure 500 {object} errors.HttpError
+// @Router /img/{bucket}/{objectPath} [get]
+// @Router /img/{bucket}/{objectPath} [head]
+func (uc *ImageHandler) Transform(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	objectName := c.Param("objectPath")[1:]
+	bucket := c.Param("bucket")
+
+	if objectName == "" || bucket == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid object path"})
+		return
+	}
+
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucket, "read") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No read permission for bucket: %s", bucket),
+		})
+		return
+	}
+
+	params, err := imaging.ParseParams(c.Request.URL.Query(), config.EnvImageMaxDimension())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := imaging.Verify(uc.signingKey, bucket, objectName, params, config.BucketSettings(bucket).Images.Presets); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	info, storedName, appErr := uc.variants.Source(bucket, objectName)
+	if appErr != nil {
+		c.JSON(appErr.ToHttpError().StatusCode, appErr)
+		return
+	}
+	if info == nil || keys.Private(objectName) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+		return
+	}
+
+	// The source's ETag is its content's, also for content-addressed
+	// pointers, so variants follow the content rather than the key.
+	variantKey := imaging.VariantKey(objectName, delivery.ETag(*info), params)
+	// Variants are validated like their source: same Last-Modified,
+	// and an ETag naming the source content and the parameters.
+	validators := minio.ObjectInfo{ETag: path.Base(variantKey), LastModified: info.LastModified}
+
+	variant, appErr := uc.variants.Get(c.Request.Context(), bucket, storedName, variantKey, params)
+	if appErr != nil {
+		c.JSON(appErr.ToHttpError().StatusCode, appErr)
+		return
+	}
+	defer variant.Close()
+
+	cache := "hit"
+	if !variant.Cached {
+		cache = "miss"
+	}
+
+	c.Header("X-Variant-Cache", cache)
+	c.Header("Content-Type", variant.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	cachepolicy.Evaluate(config.BucketSettings(bucket).Cache, bucket, objectName, variant.ContentType).Apply(c.Writer.Header(), time.Now())
+
+	delivery.Serve(c, validators, variant)
+}
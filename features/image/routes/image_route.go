@@ -0,0 +1,26 @@
+package routes
+
+import (
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/idempotency"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/middlewares"
+	"github.com/RodolfoBonis/rb-cdn/features/image/di"
+	"github.com/gin-gonic/gin"
+)
+
+func InjectRoutes(route *gin.RouterGroup, authClient *rbauth.Client) {
+	var uc = di.ImageInjection()
+
+	imageRoute := route.Group("/img")
+	imageRoute.GET("/:bucket/*objectPath", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "read"), uc.Transform)
+	imageRoute.HEAD("/:bucket/*objectPath", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "read"), uc.Transform)
+
+	similarRoute := route.Group("/similar")
+	similarRoute.GET("/:bucket/*objectPath", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "read"), uc.Similar)
+
+	analysisRoute := route.Group("/images")
+	analysisRoute.POST("/:bucket/analyze", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), middlewares.Idempotency(idempotency.Default(), config.EnvIdempotencyTTL(), logger.Log), uc.Analyze)
+	analysisRoute.GET("/:bucket/jobs/:id", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), uc.Status)
+}
@@ -1,11 +1,13 @@
 package di
 
 import (
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
 	"github.com/RodolfoBonis/rb-cdn/core/services"
 	"github.com/RodolfoBonis/rb-cdn/features/media/usecases"
 )
 
 func MediaInjection() *usecases.MediaHandler {
 	minioService := services.NewMinioService()
-	return usecases.NewMediaHandler(minioService)
+	return usecases.NewMediaHandler(minioService, imaging.NewStore(minioService, logger.Log))
 }
@@ -0,0 +1,29 @@
+package entities
+
+import (
+	"time"
+
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+)
+
+// ObjectMetadataResponseEntity describes a stored object without its
+// bytes: what MinIO knows about it plus what the uploader attached.
+type ObjectMetadataResponseEntity struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	ContentType  string    `json:"content_type"`
+	LastModified time.Time `json:"last_modified"`
+	// ScanStatus is "clean", or "unscanned" when the bucket publishes
+	// uploads its scanner couldn't check; empty if never scanned.
+	ScanStatus string `json:"scan_status,omitempty"`
+	// Encryption is "sse-c" or "envelope" for an encrypted object, with
+	// the keyring key it is sealed under; empty for plain ones.
+	Encryption      string `json:"encryption,omitempty"`
+	EncryptionKeyID string `json:"encryption_key_id,omitempty"`
+	// Image is the analysis recorded on an image at upload time or by
+	// a backfill job.
+	Image *coreEntities.ImageAnalysisEntity `json:"image,omitempty"`
+	coreEntities.ObjectMetadataEntity
+}
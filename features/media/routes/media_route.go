@@ -11,4 +11,8 @@ func InjectRoutes(route *gin.RouterGroup, authClient *rbauth.Client) {
 
 	mediaRoute := route.Group("/cdn")
 	mediaRoute.GET("/:bucket/*objectPath", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "read"), uc.Media)
+	mediaRoute.HEAD("/:bucket/*objectPath", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "read"), uc.Media)
+
+	metaRoute := route.Group("/meta")
+	metaRoute.GET("/:bucket/*objectPath", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "read"), uc.Metadata)
 }
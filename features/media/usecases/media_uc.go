@@ -4,35 +4,58 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
 
 	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/cachepolicy"
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/delivery"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/RodolfoBonis/rb-cdn/core/mimetype"
+	"github.com/RodolfoBonis/rb-cdn/core/objectmeta"
 	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/core/sniff"
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio-go"
 )
 
 type MediaHandler struct {
 	minioService services.IMinioService
+	variants     *imaging.Store
 }
 
-func NewMediaHandler(minioService services.IMinioService) *MediaHandler {
-	return &MediaHandler{minioService: minioService}
+func NewMediaHandler(minioService services.IMinioService, variants *imaging.Store) *MediaHandler {
+	return &MediaHandler{minioService: minioService, variants: variants}
 }
 
 // Media godoc
 // @Summary Get media from CDN
-// @Description Retrieves media files from the CDN, supporting images and videos
+// @Description Retrieves media files from the CDN, supporting images and videos. The Content-Type is the bucket's override for the extension (delivery.content_types), else the one stored with the object, else the extension's (extendable with MIME_TYPES), else what the content looks like; textual types get the bucket's charset and responses carry X-Content-Type-Options: nosniff. Cache-Control, Content-Disposition, Content-Language, X-Meta-* and X-Tags set at upload time are echoed back; the bucket's cache rules add Cache-Control, unless one was set at upload, Surrogate-Control, CDN-Cache-Control, Vary and Expires. In buckets with delivery.negotiate_formats, a JPEG, PNG or GIF is answered with a smaller AVIF or WebP variant stored for it when Accept lists the format, with Vary: Accept either way; a missing WebP variant of a PNG or GIF is derived in the background while the original is served. Appending "@<preset>" to the path serves the named preset of the bucket's images settings, derived at upload time or on first request. Responses carry ETag and Last-Modified and honour If-None-Match, If-Modified-Since, Range and If-Range; HEAD answers the headers alone.
 // @Tags Media
 // @Accept json
 // @Produce octet-stream
 // @Produce image/jpeg
 // @Produce image/png
+// @Produce image/webp
+// @Produce image/avif
 // @Param bucket path string true "Bucket name"
 // @Param objectPath path string true "Path to the object in the bucket"
+// @Param redirect query bool false "Redirect to a presigned MinIO URL instead of proxying (bucket setting decides the default)"
+// @Param Range header string false "Byte range to fetch"
+// @Param If-Range header string false "Only honour Range if the object still has this ETag or Last-Modified"
+// @Param If-None-Match header string false "ETags the client holds"
+// @Param If-Modified-Since header string false "Last-Modified of the copy the client holds"
+// @Param Accept header string false "Image formats the client decodes, e.g. image/avif,image/webp"
 // @Param Authorization header string true "Bearer token"
 // @Success 200 {file} binary "Media file"
+// @Success 206 {file} binary "Partial media file"
+// @Success 302 "Redirect to a presigned MinIO URL"
+// @Success 304 "The client's copy is current"
 // @Success 307 {object} errors.HttpError
 // @Failure 400 {object} errors.HttpError
 // @Failure 401 {object} errors.HttpError
@@ -40,6 +63,7 @@ func NewMediaHandler(minioService services.IMinioService) *MediaHandler {
 // @Failure 204 {object} errors.HttpError
 // @Failure 500 {object} errors.HttpError
 // @Router /cdn/{bucket}/{objectPath} [get]
+// @Router /cdn/{bucket}/{objectPath} [head]
 func (uc *MediaHandler) Media(c *gin.Context) {
 	// Service + service-level permission are guaranteed by the route
 	// middlewares (RequireAuth + RequireService("rb-cdn") +
@@ -68,14 +92,45 @@ func (uc *MediaHandler) Media(c *gin.Context) {
 		return
 	}
 
-	object, appError := uc.minioService.GetObject(bucket, objectName, minio.GetObjectOptions{})
+	settings := config.BucketSettings(bucket)
+
+	if source, preset, found := splitPreset(objectName, settings.Images.Presets); found {
+		uc.servePreset(c, bucket, source, preset, settings)
+		return
+	}
+
+	// The key's own stat carries the metadata to echo, also in
+	// content-addressed buckets, where the key is a pointer and the
+	// bytes live in the blob it names. Type detection below keeps
+	// using the key, which carries the extension.
+	info, appError := uc.minioService.GetObjectInfo(bucket, objectName)
+	if appError != nil || keys.Private(objectName) {
+		c.String(http.StatusNoContent, "Error while getting object")
+		return
+	}
+
+	storedName := objectName
+	if digest, found := cas.PointerDigest(info.Metadata); found && settings.Storage.ContentAddressed {
+		storedName = cas.BlobKey(digest)
+	}
+
+	// Redirect mode: rbauth has vetted the caller above, MinIO serves
+	// the bytes through a short-lived presigned URL.
+	if delivery.WantsRedirect(c, settings.Delivery) {
+		if appErr := delivery.Redirect(c, uc.minioService, bucket, storedName, settings.Delivery); appErr != nil {
+			c.JSON(http.StatusInternalServerError, appErr)
+		}
+		return
+	}
+
+	object, appError := uc.minioService.GetObject(bucket, storedName, minio.GetObjectOptions{})
 
 	if appError != nil {
 		c.String(http.StatusNoContent, "Error while getting object")
 		return
 	}
 
-	defer func(object *minio.Object) {
+	defer func(object services.Object) {
 		err := object.Close()
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error while closing object")
@@ -97,29 +152,70 @@ func (uc *MediaHandler) Media(c *gin.Context) {
 	}
 
 	if videoExtensions[extension] {
-		c.Redirect(http.StatusTemporaryRedirect, "/stream/"+objectName)
+		c.Redirect(http.StatusTemporaryRedirect, config.EnvCDNPublicURL()+"/stream/"+escapePath(bucket+"/"+objectName))
 		return
 	}
 
-	contentType := "application/octet-stream"
-
-	extensionToContentType := map[string]string{
-		"jpg":  "image/jpeg",
-		"jpeg": "image/jpeg",
-		"png":  "image/png",
+	// The first bytes are only read when neither the stored type nor
+	// the extension tells what the object is.
+	contentType := mimetype.Default.Resolve(objectName, info.ContentType, mimetype.Options{
+		Overrides: settings.Delivery.ContentTypes,
+		Charset:   settings.Delivery.Charset,
+		Head: func() []byte {
+			head := make([]byte, sniff.HeadSize)
+			n, _ := object.ReadAt(head, 0)
+			return head[:n]
+		},
+	})
+
+	// Buckets negotiating formats answer the same URL differently
+	// depending on Accept, also when the original is the answer.
+	negotiating := len(settings.Delivery.NegotiateFormats) > 0 && imaging.NegotiableSource(contentType)
+	var variant *negotiatedVariant
+	if negotiating {
+		if size, err := object.Seek(0, io.SeekEnd); err == nil {
+			variant = uc.negotiate(c, bucket, objectName, storedName, *info, size, contentType, settings.Delivery.NegotiateFormats)
+		}
+		if _, err := object.Seek(0, io.SeekStart); err != nil {
+			c.String(http.StatusInternalServerError, "Error while reading object")
+			return
+		}
 	}
 
-	if ct, found := extensionToContentType[extension]; found {
-		contentType = ct
+	servedType := contentType
+	if variant != nil {
+		servedType = variant.contentType
+		if closer, ok := variant.content.(io.Closer); ok {
+			defer closer.Close()
+		}
 	}
 
-	c.Header("Content-Type", contentType)
+	c.Header("Content-Type", servedType)
+	// Browsers must not second-guess the type: a user upload sniffed
+	// as HTML would run script on the CDN's origin.
+	c.Header("X-Content-Type-Options", "nosniff")
+	objectmeta.SetHeaders(c.Writer.Header(), objectmeta.FromObject(*info))
+	cachepolicy.Evaluate(settings.Cache, bucket, objectName, contentType).Apply(c.Writer.Header(), time.Now())
+	if negotiating {
+		c.Writer.Header().Add("Vary", "Accept")
+	}
 
-	_, err := io.Copy(c.Writer, object)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if variant != nil {
+		delivery.Serve(c, variant.validators, variant.content)
 		return
 	}
 
-	return
+	// The validators come from the key's stat, so a content-addressed
+	// key changes ETag when it is pointed at other content.
+	delivery.Serve(c, *info, object)
+}
+
+// escapePath escapes each segment of an object path for use in a URL.
+func escapePath(objectPath string) string {
+	segments := strings.Split(objectPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
 }
@@ -0,0 +1,95 @@
+package usecases
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/RodolfoBonis/rb-cdn/core/clamd"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/delivery"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/RodolfoBonis/rb-cdn/core/objectmeta"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/media/entities"
+	"github.com/gin-gonic/gin"
+)
+
+// Metadata godoc
+// @Summary Get an object's metadata
+// @Description Returns what is known about an object without its bytes: size, ETag, content type, virus scan verdict, encryption, the image analysis (upright width and height, dominant colour and BlurHash) and the Cache-Control, Content-Disposition, Content-Language, user metadata and tags set at upload time.
+// @Tags Media
+// @Produce json
+// @Param bucket path string true "Bucket name"
+// @Param objectPath path string true "Path to the object in the bucket"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.ObjectMetadataResponseEntity
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Failure 500 {object} errors.HttpError
+// @Router /meta/{bucket}/{objectPath} [get]
+func (uc *MediaHandler) Metadata(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	objectName := c.Param("objectPath")[1:]
+	bucket := c.Param("bucket")
+
+	if objectName == "" || bucket == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid object path"})
+		return
+	}
+
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucket, "read") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No read permission for bucket: %s", bucket),
+		})
+		return
+	}
+
+	info, appErr := uc.minioService.LookupObject(bucket, objectName)
+	if appErr != nil {
+		c.JSON(appErr.ToHttpError().StatusCode, appErr)
+		return
+	}
+	if info == nil || keys.Private(objectName) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+		return
+	}
+
+	response := entities.ObjectMetadataResponseEntity{
+		Bucket:               bucket,
+		Key:                  objectName,
+		Size:                 info.Size,
+		ETag:                 strings.Trim(delivery.ETag(*info), `"`),
+		ContentType:          info.ContentType,
+		LastModified:         info.LastModified,
+		ScanStatus:           info.Metadata.Get("X-Amz-Meta-" + clamd.StatusMetadata),
+		Encryption:           info.Metadata.Get("X-Amz-Meta-" + services.EncryptionMetadata),
+		EncryptionKeyID:      info.Metadata.Get("X-Amz-Meta-" + services.EncryptionKeyIDMetadata),
+		Image:                imaging.AnalysisFromMetadata(info.Metadata),
+		ObjectMetadataEntity: objectmeta.FromObject(*info),
+	}
+
+	// A pointer is empty; size and encryption are the blob's.
+	if digest, found := cas.PointerDigest(info.Metadata); found && config.BucketSettings(bucket).Storage.ContentAddressed {
+		blob, appErr := uc.minioService.GetObjectInfo(bucket, cas.BlobKey(digest))
+		if appErr != nil {
+			c.JSON(appErr.ToHttpError().StatusCode, appErr)
+			return
+		}
+		response.Size = blob.Size
+		response.Encryption = blob.Metadata.Get("X-Amz-Meta-" + services.EncryptionMetadata)
+		response.EncryptionKeyID = blob.Metadata.Get("X-Amz-Meta-" + services.EncryptionKeyIDMetadata)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
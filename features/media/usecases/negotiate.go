@@ -0,0 +1,59 @@
+package usecases
+
+import (
+	"io"
+	"path"
+
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/delivery"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go"
+)
+
+// negotiatedVariant stands in for an original in a format the client
+// prefers. Stored variants' content must be closed.
+type negotiatedVariant struct {
+	content     io.ReadSeeker
+	contentType string
+	// validators carry the variant's ETag and its original's
+	// Last-Modified.
+	validators minio.ObjectInfo
+}
+
+// negotiate returns a variant of the original, size bytes of
+// contentType, in the first of formats the request accepts for which
+// one is stored smaller than the original. It returns nil when the
+// original should be served. Variants that can be derived but aren't
+// stored yet are derived in the background meanwhile; the ones that
+// turn out larger are still stored, so they aren't derived again.
+func (uc *MediaHandler) negotiate(c *gin.Context, bucket string, objectName string, storedName string, info minio.ObjectInfo, size int64, contentType string, formats []string) *negotiatedVariant {
+	for _, format := range imaging.Negotiate(c.GetHeader("Accept"), formats) {
+		params := imaging.Params{Format: format}
+		variantKey := imaging.VariantKey(objectName, delivery.ETag(info), params)
+		validators := minio.ObjectInfo{ETag: path.Base(variantKey), LastModified: info.LastModified}
+
+		stored, appErr := uc.variants.Lookup(bucket, variantKey)
+		if appErr != nil {
+			continue
+		}
+
+		if stored != nil {
+			if stored.Size >= size {
+				continue
+			}
+			object, appErr := uc.variants.Open(bucket, variantKey)
+			if appErr != nil {
+				continue
+			}
+			return &negotiatedVariant{content: object, contentType: stored.ContentType, validators: validators}
+		}
+
+		if !imaging.Derivable(contentType, format) || size > config.EnvImageMaxSourceSize() {
+			continue
+		}
+		uc.variants.DeriveInBackground(bucket, storedName, variantKey, params)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package usecases
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/cachepolicy"
+	"github.com/RodolfoBonis/rb-cdn/core/delivery"
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go"
+)
+
+// splitPreset splits "photo.jpg@card" into the source key and the
+// preset it names. Keys only lose their suffix when it names one of
+// presets, so "logo@2x.png" is served as stored.
+func splitPreset(objectName string, presets map[string]coreEntities.ImagePresetEntity) (string, string, bool) {
+	at := strings.LastIndex(objectName, "@")
+	if at <= 0 {
+		return objectName, "", false
+	}
+	if _, found := presets[objectName[at+1:]]; !found {
+		return objectName, "", false
+	}
+	return objectName[:at], objectName[at+1:], true
+}
+
+// servePreset answers with the named preset variant of objectName,
+// deriving it when the upload's background job hasn't stored it yet.
+func (uc *MediaHandler) servePreset(c *gin.Context, bucket string, objectName string, name string, settings coreEntities.BucketSettingsEntity) {
+	info, storedName, appErr := uc.variants.Source(bucket, objectName)
+	if appErr != nil {
+		c.JSON(appErr.ToHttpError().StatusCode, appErr)
+		return
+	}
+	if info == nil || keys.Private(objectName) {
+		c.String(http.StatusNoContent, "Error while getting object")
+		return
+	}
+
+	params := imaging.PresetParams(settings.Images.Presets[name])
+	variantKey := imaging.VariantKey(objectName, delivery.ETag(*info), params)
+	validators := minio.ObjectInfo{ETag: path.Base(variantKey), LastModified: info.LastModified}
+
+	variant, appErr := uc.variants.Get(c.Request.Context(), bucket, storedName, variantKey, params)
+	if appErr != nil {
+		c.JSON(appErr.ToHttpError().StatusCode, appErr)
+		return
+	}
+	defer variant.Close()
+
+	c.Header("Content-Type", variant.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	cachepolicy.Evaluate(settings.Cache, bucket, objectName, variant.ContentType).Apply(c.Writer.Header(), time.Now())
+
+	delivery.Serve(c, validators, variant)
+}
@@ -0,0 +1,12 @@
+package di
+
+import (
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/presign/domain/usecases"
+)
+
+func PresignInjection() *usecases.PresignHandler {
+	minioService := services.NewMinioService()
+	return usecases.NewPresignHandler(minioService, logger.Log)
+}
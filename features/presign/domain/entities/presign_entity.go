@@ -0,0 +1,27 @@
+package entities
+
+import "time"
+
+// PresignRequestEntity asks for a URL that lets the caller talk to
+// MinIO directly. GET and PUT sign a single key; POST returns a
+// browser form policy that can also pin the content type, size range
+// and a key prefix.
+type PresignRequestEntity struct {
+	Bucket      string `json:"bucket" binding:"required"`
+	Key         string `json:"key"`
+	KeyPrefix   string `json:"key_prefix"`
+	Method      string `json:"method" binding:"required,oneof=GET PUT POST"`
+	ExpiresIn   int64  `json:"expires_in"`
+	ContentType string `json:"content_type"`
+	MinSize     int64  `json:"min_size"`
+	MaxSize     int64  `json:"max_size"`
+}
+
+// PresignResponseEntity carries the signed URL. Fields is only set for
+// POST and must be sent as form fields alongside the file.
+type PresignResponseEntity struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
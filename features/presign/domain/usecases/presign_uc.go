@@ -0,0 +1,288 @@
+package usecases
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/presign/domain/entities"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go"
+)
+
+type PresignHandler struct {
+	minioService services.IMinioService
+	log          *logger.CustomLogger
+}
+
+func NewPresignHandler(minioService services.IMinioService, log *logger.CustomLogger) *PresignHandler {
+	return &PresignHandler{minioService: minioService, log: log}
+}
+
+// Presign godoc
+// @Summary Presign a direct MinIO URL
+// @Description Returns a presigned GET or PUT URL for a key, or a POST form policy that can pin content type, size range and key prefix. GET requires read permission on the bucket, PUT and POST require write and are refused for buckets with upload rules a signature can't carry: scanning, metadata stripping, content addressing, allowed types or extensions, a per-folder file cap, a key template or collision policy other than the default, or near-duplicate rejection; PUT is also refused for buckets with size limits, which POST enforces. GET of a content-addressed key signs the blob it points to. Encrypted buckets refuse every method.
+// @Tags presign
+// @Accept json
+// @Produce json
+// @Param request body entities.PresignRequestEntity true "What to presign"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.PresignResponseEntity
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 500 {object} errors.HttpError
+// @Router /presign [post]
+func (uc *PresignHandler) Presign(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var request entities.PresignRequestEntity
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permission := "write"
+	if request.Method == http.MethodGet {
+		permission = "read"
+	}
+
+	if !validation.Permissions.HasServicePermission("rb-cdn", permission) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No service-level %s permission for rb-cdn", permission),
+		})
+		return
+	}
+
+	if !validation.Permissions.HasBucketPermission("rb-cdn", request.Bucket, permission) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No %s permission for bucket: %s", permission, request.Bucket),
+		})
+		return
+	}
+
+	// Presigned uploads go to MinIO directly and would never reach
+	// the scanner, the metadata stripper or rb-cdn's encryption, and
+	// presigned downloads of encrypted objects can't be decrypted;
+	// private objects, quarantined uploads among them, are never
+	// handed out.
+	settings := config.BucketSettings(request.Bucket)
+	if settings.Encryption.Mode != coreEntities.EncryptionMode.None {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "this bucket is encrypted; read and write it through /cdn, /stream and /upload instead"})
+		return
+	}
+	if request.Method != http.MethodGet {
+		if rule := unsignableRule(settings, request.Method); rule != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("this bucket %s; a presigned %s would bypass that, upload through /upload instead", rule, request.Method)})
+			return
+		}
+	}
+	// A POST policy only pins the start of the key: a prefix of a
+	// private prefix (".", ".ca") would let the client write under it.
+	if keys.Private(request.Key) || (request.KeyPrefix != "" && keys.OverlapsPrivate(request.KeyPrefix)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "rb-cdn's private objects can't be presigned"})
+		return
+	}
+
+	expiry, err := resolveExpiry(request.ExpiresIn)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Method == http.MethodPost {
+		uc.presignPost(c, request, expiry)
+		return
+	}
+
+	if request.Key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required for GET and PUT"})
+		return
+	}
+
+	// A presigned PUT only signs the key: MinIO would accept any
+	// body under it. Refuse constraints we can't enforce rather than
+	// hand out a URL that silently ignores them.
+	if request.Method == http.MethodPut && (request.ContentType != "" || request.MinSize != 0 || request.MaxSize != 0 || request.KeyPrefix != "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content_type, min_size, max_size and key_prefix are only enforced for POST"})
+		return
+	}
+
+	var signedURL string
+	var appErr *errors.AppError
+	if request.Method == http.MethodGet {
+		signedURL, appErr = uc.presignGet(request, expiry)
+	} else {
+		signedURL, appErr = uc.minioService.PresignObject(request.Method, request.Bucket, request.Key, expiry)
+	}
+	if appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		c.JSON(http.StatusInternalServerError, appErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, entities.PresignResponseEntity{
+		Method:    request.Method,
+		URL:       signedURL,
+		ExpiresAt: time.Now().Add(expiry).UTC(),
+	})
+}
+
+// unsignableRule names the first of the bucket's upload rules that a
+// presigned write would get past, "" when there is none. A POST policy
+// pins the key and the size range and nothing else; a PUT signs the
+// key alone. Everything else, down to the type checks and how keys are
+// named, only runs in the upload pipeline.
+func unsignableRule(settings coreEntities.BucketSettingsEntity, method string) string {
+	switch {
+	case settings.Scan.Enabled || settings.Strip.Enabled:
+		return "processes uploads (virus scan, metadata stripping)"
+	case settings.Storage.ContentAddressed:
+		// A direct write would store a plain object, or replace a
+		// pointer without releasing its blob.
+		return "is content-addressed"
+	case len(settings.Upload.AllowedTypes) > 0 || len(settings.Upload.AllowedExtensions) > 0:
+		return "restricts file types"
+	case settings.Upload.MaxFilesPerFolder > 0:
+		return "caps the files per folder"
+	case settings.Keys.Template != "" && settings.Keys.Template != keys.DefaultTemplate:
+		return "names keys from a template"
+	case settings.Keys.Collision != coreEntities.KeyCollision.Overwrite:
+		return "doesn't overwrite existing keys"
+	case settings.Images.Duplicates.Reject:
+		return "rejects near-duplicate images"
+	case method == http.MethodPut && (settings.Upload.MinSize > 0 || settings.Upload.MaxSize > 0):
+		return "limits upload sizes, which only a POST policy enforces"
+	}
+	return ""
+}
+
+// presignGet signs a download of request.Key. A content-addressed key
+// is an empty pointer, so the blob it points to is signed instead,
+// served with the pointer's content type and disposition.
+func (uc *PresignHandler) presignGet(request entities.PresignRequestEntity, expiry time.Duration) (string, *errors.AppError) {
+	info, appErr := uc.minioService.LookupObject(request.Bucket, request.Key)
+	if appErr != nil {
+		return "", appErr
+	}
+
+	if info != nil {
+		if digest, found := cas.PointerDigest(info.Metadata); found {
+			params := url.Values{}
+			if info.ContentType != "" {
+				params.Set("response-content-type", info.ContentType)
+			}
+			if disposition := info.Metadata.Get("Content-Disposition"); disposition != "" {
+				params.Set("response-content-disposition", disposition)
+			}
+			return uc.minioService.GetObjectURL(request.Bucket, cas.BlobKey(digest), expiry, params)
+		}
+	}
+
+	return uc.minioService.PresignObject(http.MethodGet, request.Bucket, request.Key, expiry)
+}
+
+func (uc *PresignHandler) presignPost(c *gin.Context, request entities.PresignRequestEntity, expiry time.Duration) {
+	maxUploadSize := config.EnvPresignMaxUploadSize()
+
+	// POST uploads go to MinIO directly, past the upload pipeline; the
+	// bucket's size limits are the part of its policy a POST policy
+	// can carry.
+	uploadPolicy := config.BucketSettings(request.Bucket).Upload
+	if uploadPolicy.MaxSize > 0 && uploadPolicy.MaxSize < maxUploadSize {
+		maxUploadSize = uploadPolicy.MaxSize
+	}
+
+	maxSize := request.MaxSize
+	if maxSize == 0 {
+		maxSize = maxUploadSize
+	}
+
+	minSize := max(request.MinSize, uploadPolicy.MinSize)
+
+	if request.MinSize < 0 || maxSize < minSize || maxSize > maxUploadSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("size range must satisfy 0 <= min_size <= max_size <= %d", maxUploadSize),
+		})
+		return
+	}
+
+	if request.Key == "" && request.KeyPrefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key or key_prefix is required for POST"})
+		return
+	}
+
+	if request.Key != "" && !strings.HasPrefix(request.Key, request.KeyPrefix) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key must start with key_prefix"})
+		return
+	}
+
+	expiresAt := time.Now().Add(expiry).UTC()
+	policy := minio.NewPostPolicy()
+
+	err := policy.SetBucket(request.Bucket)
+	if err == nil {
+		err = policy.SetExpires(expiresAt)
+	}
+	if err == nil {
+		err = policy.SetContentLengthRange(minSize, maxSize)
+	}
+	if err == nil && request.Key != "" {
+		err = policy.SetKey(request.Key)
+	}
+	if err == nil && request.Key == "" {
+		err = policy.SetKeyStartsWith(request.KeyPrefix)
+	}
+	if err == nil && request.ContentType != "" {
+		err = policy.SetContentType(request.ContentType)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	postURL, fields, appErr := uc.minioService.PresignPostPolicy(policy)
+	if appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		c.JSON(http.StatusInternalServerError, appErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, entities.PresignResponseEntity{
+		Method:    http.MethodPost,
+		URL:       postURL,
+		Fields:    fields,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// resolveExpiry turns the requested lifetime in seconds into a
+// duration, applying PRESIGN_DEFAULT_EXPIRY when none was asked for
+// and refusing anything beyond PRESIGN_MAX_EXPIRY.
+func resolveExpiry(expiresIn int64) (time.Duration, error) {
+	if expiresIn == 0 {
+		return config.EnvPresignDefaultExpiry(), nil
+	}
+
+	expiry := time.Duration(expiresIn) * time.Second
+	maxExpiry := config.EnvPresignMaxExpiry()
+	if expiresIn < 0 || expiry > maxExpiry {
+		return 0, fmt.Errorf("expires_in must be between 1 and %d seconds", int64(maxExpiry/time.Second))
+	}
+
+	return expiry, nil
+}
@@ -0,0 +1,44 @@
+package usecases
+
+import (
+	"net/http"
+	"testing"
+
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsignableRule(t *testing.T) {
+	plain := func() coreEntities.BucketSettingsEntity {
+		return coreEntities.BucketSettingsEntity{
+			Keys: coreEntities.KeySettingsEntity{Template: "{folder}/{filename}", Collision: coreEntities.KeyCollision.Overwrite},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(*coreEntities.BucketSettingsEntity)
+		method string
+		rule   string
+	}{
+		{"plain bucket", func(*coreEntities.BucketSettingsEntity) {}, http.MethodPut, ""},
+		{"sizes with POST", func(s *coreEntities.BucketSettingsEntity) { s.Upload.MaxSize = 10 }, http.MethodPost, ""},
+		{"sizes with PUT", func(s *coreEntities.BucketSettingsEntity) { s.Upload.MinSize = 1 }, http.MethodPut, "limits upload sizes, which only a POST policy enforces"},
+		{"scan", func(s *coreEntities.BucketSettingsEntity) { s.Scan.Enabled = true }, http.MethodPost, "processes uploads (virus scan, metadata stripping)"},
+		{"content addressed", func(s *coreEntities.BucketSettingsEntity) { s.Storage.ContentAddressed = true }, http.MethodPost, "is content-addressed"},
+		{"allowed types", func(s *coreEntities.BucketSettingsEntity) { s.Upload.AllowedTypes = []string{"image/*"} }, http.MethodPost, "restricts file types"},
+		{"allowed extensions", func(s *coreEntities.BucketSettingsEntity) { s.Upload.AllowedExtensions = []string{"png"} }, http.MethodPut, "restricts file types"},
+		{"files per folder", func(s *coreEntities.BucketSettingsEntity) { s.Upload.MaxFilesPerFolder = 3 }, http.MethodPost, "caps the files per folder"},
+		{"key template", func(s *coreEntities.BucketSettingsEntity) { s.Keys.Template = "{uuid}{ext}" }, http.MethodPost, "names keys from a template"},
+		{"collision", func(s *coreEntities.BucketSettingsEntity) { s.Keys.Collision = coreEntities.KeyCollision.Reject }, http.MethodPut, "doesn't overwrite existing keys"},
+		{"duplicates", func(s *coreEntities.BucketSettingsEntity) { s.Images.Duplicates.Reject = true }, http.MethodPost, "rejects near-duplicate images"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := plain()
+			tt.change(&settings)
+			assert.Equal(t, tt.rule, unsignableRule(settings, tt.method))
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/idempotency"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/middlewares"
+	"github.com/RodolfoBonis/rb-cdn/features/presign/di"
+	"github.com/gin-gonic/gin"
+)
+
+func InjectRoutes(route *gin.RouterGroup, authClient *rbauth.Client) {
+	var uc = di.PresignInjection()
+
+	// The service-level permission depends on the requested method
+	// (read for GET, write for PUT/POST), so it is checked in the
+	// handler instead of with RequireServicePermission.
+	presignRoute := route.Group("/presign")
+	presignRoute.POST("", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), middlewares.Idempotency(idempotency.Default(), config.EnvIdempotencyTTL(), logger.Log), uc.Presign)
+}
@@ -0,0 +1,15 @@
+package di
+
+import (
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/progress"
+	"github.com/RodolfoBonis/rb-cdn/features/progress/domain/usecases"
+)
+
+func ProgressInjection() *usecases.ProgressHandler {
+	progress.Default.StartJanitor(time.Minute)
+
+	return usecases.NewProgressHandler(progress.Default, logger.Log)
+}
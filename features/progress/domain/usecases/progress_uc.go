@@ -0,0 +1,85 @@
+package usecases
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/middlewares"
+	"github.com/RodolfoBonis/rb-cdn/core/progress"
+	"github.com/gin-gonic/gin"
+)
+
+// keepAliveInterval spaces out the comments that keep idle streams
+// from being cut by proxies while an upload is being processed.
+const keepAliveInterval = 15 * time.Second
+
+type ProgressHandler struct {
+	hub *progress.Hub
+	log *logger.CustomLogger
+}
+
+func NewProgressHandler(hub *progress.Hub, log *logger.CustomLogger) *ProgressHandler {
+	return &ProgressHandler{hub: hub, log: log}
+}
+
+// Progress godoc
+// @Summary Follow the progress of an upload
+// @Description Streams the progress of an upload or import as Server-Sent Events, starting with its current state. "progress" events carry the bytes received from the client (from the remote, for imports), the bytes written to MinIO and the processing stage; the stream ends with a "done" event carrying the upload's response or a "failed" event carrying its error. The id is the X-Progress-Id of the upload, the tus upload id or the import job id. Only the caller that started the upload can follow it, on the replica serving it, and finished uploads stay available for five minutes.
+// @Tags progress
+// @Produce text/event-stream
+// @Param id path string true "Progress id"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} progress.Event
+// @Failure 401 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Router /progress/{id} [get]
+func (uc *ProgressHandler) Progress(c *gin.Context) {
+	current, events, cancel, found := uc.hub.Subscribe(c.Param("id"), middlewares.RequestIdentity(c.Request))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No upload is reporting progress under this id"})
+		return
+	}
+	defer cancel()
+
+	c.Header("Cache-Control", "no-cache")
+	// Tells nginx not to buffer the stream.
+	c.Header("X-Accel-Buffering", "no")
+
+	sendEvent(c, current)
+	c.Writer.Flush()
+	if current.Final() {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, open := <-events:
+			if !open {
+				return false
+			}
+			sendEvent(c, event)
+			return !event.Final()
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// sendEvent names an event after the stage that ends a stream, and
+// "progress" before that.
+func sendEvent(c *gin.Context, event progress.Event) {
+	name := "progress"
+	if event.Final() {
+		name = event.Stage
+	}
+
+	c.SSEvent(name, event)
+}
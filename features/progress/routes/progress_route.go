@@ -0,0 +1,16 @@
+package routes
+
+import (
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/features/progress/di"
+	"github.com/gin-gonic/gin"
+)
+
+func InjectRoutes(route *gin.RouterGroup, authClient *rbauth.Client) {
+	var uc = di.ProgressInjection()
+
+	// Progress is scoped to the caller that started the upload, which
+	// the handler checks, so no bucket permission applies here.
+	progressRoute := route.Group("/progress")
+	progressRoute.GET("/:id", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), uc.Progress)
+}
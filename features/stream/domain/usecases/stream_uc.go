@@ -3,14 +3,19 @@ package usecases
 import (
 	"fmt"
 	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/cachepolicy"
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/delivery"
 	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
 	"github.com/RodolfoBonis/rb-cdn/core/logger"
 	"github.com/RodolfoBonis/rb-cdn/core/services"
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio-go"
-	"io"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
 )
 
 type StreamHandler struct {
@@ -25,20 +30,27 @@ func NewStreamHandler(minioService services.IMinioService, logger *logger.Custom
 // StreamVideo godoc
 // @Summary Stream video content
 // @Schemes
-// @Description Streams video content from MinIO with support for range requests
+// @Description Streams video content from MinIO with support for range requests, If-Range included. Responses carry ETag and Last-Modified and honour If-None-Match and If-Modified-Since; HEAD answers the headers alone. The bucket's cache rules set the caching headers. The path is the bucket followed by the object key; a bare key is looked up in the first bucket the caller can read
 // @Tags Stream
 // @Accept json
 // @Produce video/mp4
-// @Param objectPath path string true "Object path in the bucket"
+// @Param objectPath path string true "Bucket and object key (bucket/path/to/file)"
 // @Param Range header string false "Range header for partial content requests"
+// @Param If-Range header string false "Only honour Range if the object still has this ETag or Last-Modified"
+// @Param If-None-Match header string false "ETags the client holds"
+// @Param If-Modified-Since header string false "Last-Modified of the copy the client holds"
+// @Param redirect query bool false "Redirect to a presigned MinIO URL instead of proxying (bucket setting decides the default)"
 // @Param Authorization header string true "Bearer token"
 // @Success 200 {file} binary "Full video content"
 // @Success 206 {file} binary "Partial video content"
+// @Success 304 "The client's copy is current"
+// @Success 307 "Redirect to a presigned MinIO URL"
 // @Failure 400 {object} errors.HttpError
 // @Failure 401 {object} errors.HttpError
 // @Failure 403 {object} errors.HttpError
 // @Failure 500 {object} errors.HttpError
 // @Router /stream/{objectPath} [get]
+// @Router /stream/{objectPath} [head]
 func (vc *StreamHandler) StreamVideo(c *gin.Context) {
 	// Get validation from context
 	validation := rbauth.GetValidation(c)
@@ -64,11 +76,18 @@ func (vc *StreamHandler) StreamVideo(c *gin.Context) {
 
 	objectName := c.Param("objectPath")[1:]
 
-	// Extract bucket from object path (assuming format: bucket/path/to/file)
-	// For stream, we need to determine which bucket to use
-	// We'll check all buckets the user has read access to
+	// /stream/<bucket>/<key> names its bucket, as the URLs uploads
+	// answer with do; the older /stream/<key> is served from the first
+	// bucket the caller can read.
 	var bucketName string
+	if bucket, key, found := strings.Cut(objectName, "/"); found && key != "" && validation.Permissions.HasBucketPermission("rb-cdn", bucket, "read") {
+		bucketName, objectName = bucket, key
+	}
+
 	for service, buckets := range validation.Permissions {
+		if bucketName != "" {
+			break
+		}
 		if service == "rb-cdn" {
 			for bucket, permissions := range buckets {
 				for _, perm := range permissions {
@@ -82,9 +101,6 @@ func (vc *StreamHandler) StreamVideo(c *gin.Context) {
 				}
 			}
 		}
-		if bucketName != "" {
-			break
-		}
 	}
 
 	if bucketName == "" {
@@ -102,6 +118,28 @@ func (vc *StreamHandler) StreamVideo(c *gin.Context) {
 		return
 	}
 
+	if keys.Private(objectName) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+		return
+	}
+
+	settings := config.BucketSettings(bucketName)
+	// Cache rules match the key asked for, not the blob behind it.
+	key := objectName
+	if settings.Storage.ContentAddressed {
+		objectName = cas.Resolve(vc.minioService, bucketName, objectName)
+	}
+
+	if delivery.WantsRedirect(c, settings.Delivery) {
+		// MinIO honours Range on presigned GETs, so seeking players
+		// keep working after the redirect.
+		if appErr := delivery.Redirect(c, vc.minioService, bucketName, objectName, settings.Delivery); appErr != nil {
+			vc.logger.Error(appErr.Message, appErr.ToMap())
+			c.AbortWithStatusJSON(http.StatusInternalServerError, appErr)
+		}
+		return
+	}
+
 	obj, appErr := vc.getMinioObject(c, bucketName, objectName)
 	if appErr != nil {
 		return
@@ -113,19 +151,15 @@ func (vc *StreamHandler) StreamVideo(c *gin.Context) {
 		return
 	}
 
-	contentLength := objInfo.Size
-	vc.setCommonHeaders(c, contentLength)
-
-	rangeHeader := c.Request.Header.Get("Range")
-	if rangeHeader == "" {
-		vc.handleFullContent(c, obj, contentLength)
-		return
-	}
+	vc.setCommonHeaders(c)
+	cachepolicy.Evaluate(settings.Cache, bucketName, key, c.Writer.Header().Get("Content-Type")).Apply(c.Writer.Header(), time.Now())
 
-	vc.handleRangeRequest(c, obj, rangeHeader, contentLength)
+	// Players resume and seek with Range; If-Range keeps a resumed
+	// download from splicing two versions of the object together.
+	delivery.Serve(c, objInfo, obj)
 }
 
-func (vc *StreamHandler) getMinioObject(c *gin.Context, bucket, objectName string) (*minio.Object, *errors.AppError) {
+func (vc *StreamHandler) getMinioObject(c *gin.Context, bucket, objectName string) (services.Object, *errors.AppError) {
 	obj, appErr := vc.minioService.GetObject(bucket, objectName, minio.GetObjectOptions{})
 	if appErr != nil {
 		vc.logger.Error(fmt.Sprintf("Erro ao obter o objeto do MinIO: %v", appErr))
@@ -135,7 +169,7 @@ func (vc *StreamHandler) getMinioObject(c *gin.Context, bucket, objectName strin
 	return obj, nil
 }
 
-func (vc *StreamHandler) getObjectInfo(c *gin.Context, obj *minio.Object) (minio.ObjectInfo, error) {
+func (vc *StreamHandler) getObjectInfo(c *gin.Context, obj services.Object) (minio.ObjectInfo, error) {
 	objInfo, err := obj.Stat()
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Erro ao obter o tamanho do vídeo"})
@@ -144,82 +178,7 @@ func (vc *StreamHandler) getObjectInfo(c *gin.Context, obj *minio.Object) (minio
 	return objInfo, nil
 }
 
-func (vc *StreamHandler) setCommonHeaders(c *gin.Context, contentLength int64) {
+func (vc *StreamHandler) setCommonHeaders(c *gin.Context) {
 	c.Header("Content-Type", "video/mp4")
 	c.Header("Accept-Ranges", "bytes")
 }
-
-func (vc *StreamHandler) handleFullContent(c *gin.Context, obj *minio.Object, contentLength int64) {
-	c.Header("Content-Length", strconv.FormatInt(contentLength, 10))
-	c.Status(http.StatusOK)
-	if _, err := io.Copy(c.Writer, obj); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, err)
-	}
-}
-
-func (vc *StreamHandler) handleRangeRequest(c *gin.Context, obj *minio.Object, rangeHeader string, contentLength int64) {
-	start, end := vc.parseRange(rangeHeader, contentLength)
-	partSize := end - start + 1
-
-	vc.setRangeHeaders(c, start, end, contentLength, partSize)
-
-	if err := vc.streamRange(c, obj, start, partSize); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, err)
-	}
-}
-
-func (vc *StreamHandler) parseRange(rangeHeader string, contentLength int64) (start, end int64) {
-	_, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end)
-	if err != nil {
-		return 0, 0
-	}
-	if end == 0 || end >= contentLength {
-		end = contentLength - 1
-	}
-	return
-}
-
-func (vc *StreamHandler) setRangeHeaders(c *gin.Context, start, end, contentLength, partSize int64) {
-	c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, contentLength))
-	c.Header("Content-Length", strconv.FormatInt(partSize, 10))
-	c.Status(http.StatusPartialContent)
-}
-
-func (vc *StreamHandler) streamRange(c *gin.Context, obj *minio.Object, start, partSize int64) error {
-	if _, err := obj.Seek(start, 0); err != nil {
-		return err
-	}
-
-	buf := make([]byte, 4096)
-	bytesSent := int64(0)
-
-	for bytesSent < partSize {
-		bytesToRead := vc.calculateBytesToRead(buf, bytesSent, partSize)
-		if err := vc.writeChunk(c, obj, buf, bytesToRead, &bytesSent); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (vc *StreamHandler) calculateBytesToRead(buf []byte, bytesSent, partSize int64) int64 {
-	bytesToRead := int64(len(buf))
-	if bytesToRead > partSize-bytesSent {
-		bytesToRead = partSize - bytesSent
-	}
-	return bytesToRead
-}
-
-func (vc *StreamHandler) writeChunk(c *gin.Context, obj *minio.Object, buf []byte, bytesToRead int64, bytesSent *int64) error {
-	n, err := obj.Read(buf[:bytesToRead])
-	if err != nil {
-		return err
-	}
-
-	if _, err := c.Writer.Write(buf[:n]); err != nil {
-		return err
-	}
-
-	*bytesSent += int64(n)
-	return nil
-}
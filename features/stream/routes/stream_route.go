@@ -11,4 +11,5 @@ func InjectRoutes(route *gin.RouterGroup, authClient *rbauth.Client) {
 
 	streamRoute := route.Group("/stream")
 	streamRoute.GET("/*objectPath", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "read"), uc.StreamVideo)
+	streamRoute.HEAD("/*objectPath", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "read"), uc.StreamVideo)
 }
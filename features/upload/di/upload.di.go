@@ -1,6 +1,11 @@
 package di
 
 import (
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/fetch"
 	"github.com/RodolfoBonis/rb-cdn/core/logger"
 	"github.com/RodolfoBonis/rb-cdn/core/services"
 	"github.com/RodolfoBonis/rb-cdn/features/upload/domain/usecases"
@@ -11,3 +16,28 @@ func UploadInjection() *usecases.UploadHandler {
 
 	return usecases.NewUploadHandler(minioService, logger.Log)
 }
+
+func FetchInjection() *usecases.FetchHandler {
+	minioService := services.NewMinioService()
+
+	allowed, err := fetch.ParseCIDRs(config.EnvFetchAllowedCIDRs())
+	if err != nil {
+		appError := errors.EnvironmentError(err.Error())
+		logger.Log.Error(appError.Message, appError.ToMap())
+		panic(err)
+	}
+
+	handler := usecases.NewFetchHandler(minioService, allowed, logger.Log)
+	handler.StartJanitor(time.Hour)
+
+	return handler
+}
+
+func TusInjection() *usecases.TusHandler {
+	minioService := services.NewMinioService()
+
+	handler := usecases.NewTusHandler(minioService, logger.Log)
+	handler.StartExpirationJanitor(time.Hour)
+
+	return handler
+}
@@ -0,0 +1,39 @@
+package entities
+
+var ArchiveEntryStatus = struct {
+	Created    string
+	Failed     string
+	Skipped    string
+	RolledBack string
+}{
+	Created:    "created",
+	Failed:     "failed",
+	Skipped:    "skipped",
+	RolledBack: "rolled_back",
+}
+
+// ArchiveEntryEntity reports what became of one file of an extracted
+// archive, in archive order. Path is the file's path inside the
+// archive, Key the object it was stored as.
+type ArchiveEntryEntity struct {
+	Path        string                `json:"path"`
+	Key         string                `json:"key,omitempty"`
+	Size        int64                 `json:"size,omitempty"`
+	ContentType string                `json:"content_type,omitempty"`
+	Status      string                `json:"status"`
+	Upload      *UploadResponseEntity `json:"upload,omitempty"`
+	Error       string                `json:"error,omitempty"`
+}
+
+// ArchiveUploadResponseEntity is the manifest of an extracted archive.
+type ArchiveUploadResponseEntity struct {
+	Archive    string               `json:"archive"`
+	Bucket     string               `json:"bucket"`
+	Folder     string               `json:"folder"`
+	Atomic     bool                 `json:"atomic"`
+	Created    int                  `json:"created"`
+	Failed     int                  `json:"failed"`
+	Skipped    int                  `json:"skipped"`
+	RolledBack bool                 `json:"rolled_back"`
+	Entries    []ArchiveEntryEntity `json:"entries"`
+}
@@ -0,0 +1,31 @@
+package entities
+
+var BatchUploadStatus = struct {
+	Uploaded   string
+	Failed     string
+	Skipped    string
+	RolledBack string
+}{
+	Uploaded:   "uploaded",
+	Failed:     "failed",
+	Skipped:    "skipped",
+	RolledBack: "rolled_back",
+}
+
+// BatchUploadResultEntity reports the outcome of one file of a batch,
+// in the order the files were sent.
+type BatchUploadResultEntity struct {
+	Index    int                   `json:"index"`
+	Filename string                `json:"filename"`
+	Status   string                `json:"status"`
+	Upload   *UploadResponseEntity `json:"upload,omitempty"`
+	Error    string                `json:"error,omitempty"`
+}
+
+type BatchUploadResponseEntity struct {
+	Atomic     bool                      `json:"atomic"`
+	Uploaded   int                       `json:"uploaded"`
+	Failed     int                       `json:"failed"`
+	RolledBack bool                      `json:"rolled_back"`
+	Results    []BatchUploadResultEntity `json:"results"`
+}
@@ -0,0 +1,40 @@
+package entities
+
+import "time"
+
+type FetchRequestEntity struct {
+	URL    string `json:"url" binding:"required"`
+	Bucket string `json:"bucket" binding:"required"`
+	Key    string `json:"key" binding:"required"`
+	// ContentTypes restricts what the remote may answer with; entries
+	// may be a family such as "image/*". Empty accepts anything.
+	ContentTypes []string `json:"content_types"`
+	// Async returns 202 with a job to poll instead of waiting for the
+	// import to finish.
+	Async bool `json:"async"`
+}
+
+var FetchJobStatus = struct {
+	Pending   string
+	Succeeded string
+	Failed    string
+}{
+	Pending:   "pending",
+	Succeeded: "succeeded",
+	Failed:    "failed",
+}
+
+// FetchJobEntity is the state of a remote import. Async jobs are
+// persisted as JSON in the target bucket so any replica can answer a
+// status poll.
+type FetchJobEntity struct {
+	ID        string                `json:"id"`
+	Status    string                `json:"status"`
+	URL       string                `json:"url"`
+	Bucket    string                `json:"bucket"`
+	Key       string                `json:"key"`
+	Error     string                `json:"error,omitempty"`
+	Upload    *UploadResponseEntity `json:"upload,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+}
@@ -0,0 +1,33 @@
+package entities
+
+import (
+	"time"
+
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+)
+
+// TusUploadEntity is the state of an unfinished tus upload. It is
+// persisted as JSON next to the uploaded chunks so any replica can
+// answer HEAD/PATCH for an upload another replica created.
+type TusUploadEntity struct {
+	ID          string                            `json:"id"`
+	Bucket      string                            `json:"bucket"`
+	Folder      string                            `json:"folder"`
+	Filename    string                            `json:"filename"`
+	ContentType string                            `json:"content_type"`
+	Length      int64                             `json:"length"`
+	Offset      int64                             `json:"offset"`
+	Chunks      []TusChunkEntity                  `json:"chunks"`
+	Metadata    map[string]string                 `json:"metadata"`
+	Object      coreEntities.ObjectMetadataEntity `json:"object"`
+	CreatedAt   time.Time                         `json:"created_at"`
+	ExpiresAt   time.Time                         `json:"expires_at"`
+}
+
+// TusChunkEntity is one PATCH body, stored as its own object until
+// the upload completes and the chunks are concatenated.
+type TusChunkEntity struct {
+	Object string `json:"object"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
@@ -1,6 +1,52 @@
 package entities
 
+import coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+
 type UploadResponseEntity struct {
-	URL     string `json:"url"`
-	Message string `json:"message"`
+	// URL is the one clients should fetch the object from: StreamURL
+	// for videos, CDNURL for every other file.
+	URL       string `json:"url"`
+	CDNURL    string `json:"cdn_url"`
+	StreamURL string `json:"stream_url"`
+	Message   string `json:"message"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	ETag      string `json:"etag"`
+	// VersionID is only set on versioned buckets.
+	VersionID   string                `json:"version_id,omitempty"`
+	Size        int64                 `json:"size"`
+	ContentType string                `json:"content_type"`
+	Checksums   UploadChecksumsEntity `json:"checksums"`
+	// Digest is the hex SHA-256 of the stored body and Integrity the
+	// same sum as a Subresource Integrity string ("sha256-…").
+	Digest    string `json:"digest,omitempty"`
+	Integrity string `json:"integrity,omitempty"`
+	// References counts the keys sharing this body; only set for
+	// content-addressed buckets.
+	References int `json:"references,omitempty"`
+	// MetadataStripped reports that EXIF, XMP or IPTC metadata was
+	// removed from the image.
+	MetadataStripped bool `json:"metadata_stripped,omitempty"`
+	// Width and Height are set for images and videos, Duration (in
+	// seconds) and the codecs for audio and video, as far as their
+	// container headers tell.
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+	VideoCodec string  `json:"video_codec,omitempty"`
+	AudioCodec string  `json:"audio_codec,omitempty"`
+	// Image is the analysis of an image upload, recorded on the object
+	// as well, when the bucket analyses images.
+	Image *coreEntities.ImageAnalysisEntity `json:"image,omitempty"`
+	// Variants are the /cdn URLs of the bucket's image presets, by
+	// name, and Srcset the same URLs as an srcset attribute, ordered by
+	// width. Presets are derived in the background after the upload.
+	Variants map[string]string `json:"variants,omitempty"`
+	Srcset   string            `json:"srcset,omitempty"`
+}
+
+// UploadChecksumsEntity holds the hex sums of the stored body.
+type UploadChecksumsEntity struct {
+	MD5    string `json:"md5"`
+	SHA256 string `json:"sha256"`
 }
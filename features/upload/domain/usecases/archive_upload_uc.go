@@ -0,0 +1,288 @@
+package usecases
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/archive"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/objectmeta"
+	"github.com/RodolfoBonis/rb-cdn/core/sniff"
+	"github.com/RodolfoBonis/rb-cdn/features/upload/domain/entities"
+	"github.com/gin-gonic/gin"
+)
+
+// errExtractionStopped ends the walk of an atomic archive at its first
+// failed file.
+var errExtractionStopped = stdErrors.New("archive extraction stopped")
+
+// UploadArchive godoc
+// @Summary Upload an archive and extract it into a folder
+// @Description Expands a .zip, .tar, .tar.gz or .tgz archive into folder, one object per file, and answers with a manifest of the objects created. Links and special files are skipped. Every file goes through the bucket's upload policy, scanning and stripping like a single upload, typed from its extension and content; the metadata fields apply to every file. The bucket, folder, atomic and metadata fields should precede the file field, which is then streamed; an archive sent before the bucket field is buffered to disk first. Fields over 64 KiB answer 400. Archives with an entry pointing outside the folder answer 400; archives over ARCHIVE_MAX_SIZE, with more than ARCHIVE_MAX_ENTRIES entries or expanding beyond ARCHIVE_MAX_EXPANDED_SIZE or ARCHIVE_MAX_RATIO times their size answer 413. Either way every key already written gets back the object it held before, or is removed if it held none, as it is for any failed file with atomic=true, which then answers 422.
+// @Tags upload
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Archive to extract (.zip, .tar, .tar.gz, .tgz)"
+// @Param bucket formData string true "Bucket name"
+// @Param folder formData string false "Folder to extract into"
+// @Param atomic formData bool false "All-or-nothing: roll back on any failed file"
+// @Param x-meta-name formData string false "User metadata for every file; repeat with any lowercase name"
+// @Param tags formData string false "Object tags for every file as k1=v1&k2=v2"
+// @Param X-Progress-Id header string false "Id to follow the upload's progress under at GET /progress/{id}; one is made up and echoed in X-Progress-Id otherwise"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.ArchiveUploadResponseEntity
+// @Success 207 {object} entities.ArchiveUploadResponseEntity
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 413 {object} errors.HttpError
+// @Failure 415 {object} errors.HttpError
+// @Failure 422 {object} entities.ArchiveUploadResponseEntity
+// @Router /upload/archive [post]
+func (uc *UploadHandler) UploadArchive(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	tracker, finish, ok := trackUpload(c)
+	if !ok {
+		return
+	}
+	defer finish()
+
+	form, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := readUploadForm(form, map[string]string{
+		"bucket": c.Query("bucket"),
+		"folder": c.Query("folder"),
+		"atomic": c.Query("atomic"),
+	}, config.EnvArchiveMaxSize())
+	if stdErrors.Is(err, errSpoolTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("archive exceeds %d bytes", config.EnvArchiveMaxSize())})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	fields := file.Fields()
+
+	bucketName := fields["bucket"]
+	if bucketName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "bucket parameter is required",
+		})
+		return
+	}
+
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucketName, "write") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No write permission for bucket: %s", bucketName),
+		})
+		return
+	}
+
+	format, ok := archive.FormatOf(file.Filename)
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "archive must be a .zip, .tar, .tar.gz or .tgz file",
+		})
+		return
+	}
+
+	metadata, err := objectmeta.FromRequest(fields, c.Request.Header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	atomic, _ := strconv.ParseBool(fields["atomic"])
+	response := entities.ArchiveUploadResponseEntity{
+		Archive: file.Filename,
+		Bucket:  bucketName,
+		Folder:  fields["folder"],
+		Atomic:  atomic,
+		Entries: []entities.ArchiveEntryEntity{},
+	}
+
+	template := uploadRequest{Bucket: bucketName, Folder: fields["folder"], Metadata: metadata, Progress: tracker, Reversible: true}
+	if appErr := uc.extractArchive(c.Request.Context(), format, file, template, &response); appErr != nil {
+		if file.Trailing != nil {
+			tracker.Fail(file.Trailing.Error())
+			c.JSON(http.StatusBadRequest, gin.H{"error": file.Trailing.Error()})
+			return
+		}
+		tracker.Fail(appErr.Message)
+		c.JSON(appErr.ToHttpError().StatusCode, appErr)
+		return
+	}
+
+	status := http.StatusOK
+	switch {
+	case response.RolledBack:
+		status = http.StatusUnprocessableEntity
+	case response.Failed > 0:
+		status = http.StatusMultiStatus
+	}
+
+	tracker.Done(response)
+	c.JSON(status, response)
+}
+
+// extractArchive stores every file of the archive in body through the
+// pipeline, one after the other and in archive order, recording each
+// outcome in response. A limit hit or an unsafe entry aborts the
+// extraction and removes what it had written; so does any failed file
+// when response.Atomic is set.
+func (uc *UploadHandler) extractArchive(ctx context.Context, format archive.Format, body io.Reader, template uploadRequest, response *entities.ArchiveUploadResponseEntity) *errors.AppError {
+	limits := archive.Limits{
+		MaxSize:         config.EnvArchiveMaxSize(),
+		MaxEntries:      config.EnvArchiveMaxEntries(),
+		MaxExpandedSize: config.EnvArchiveMaxExpandedSize(),
+		MaxRatio:        config.EnvArchiveMaxRatio(),
+	}
+
+	stored := []*storedUpload{}
+	visit := func(entry archive.Entry) error {
+		result := entities.ArchiveEntryEntity{Path: entry.Path}
+		defer func() { response.Entries = append(response.Entries, result) }()
+
+		if entry.Skipped != "" {
+			result.Status = entities.ArchiveEntryStatus.Skipped
+			result.Error = entry.Skipped
+			response.Skipped++
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		request := template
+		if folder := path.Dir(entry.Path); folder != "." {
+			request.Folder = path.Join(template.Folder, folder)
+		}
+		request.Filename = path.Base(entry.Path)
+		request.ContentType = sniff.ExtensionType(request.Filename)
+		request.Body = entry.Body
+
+		object, appErr := uc.pipeline.Store(ctx, request)
+		if appErr != nil {
+			result.Status = entities.ArchiveEntryStatus.Failed
+			result.Error = appErr.Message
+			response.Failed++
+			if response.Atomic {
+				return errExtractionStopped
+			}
+			return nil
+		}
+
+		stored = append(stored, object)
+		result.Status = entities.ArchiveEntryStatus.Created
+		result.Key = object.Object.Key
+		result.Size = object.Object.Size
+		result.ContentType = object.Object.ContentType
+		result.Upload = &object.Response
+		response.Created++
+		return nil
+	}
+
+	var err error
+	if format == archive.Zip {
+		spooled, size, appErr := spoolArchive(body, limits.MaxSize)
+		if appErr != nil {
+			return appErr
+		}
+		defer func() {
+			spooled.Close()
+			os.Remove(spooled.Name())
+		}()
+
+		err = archive.WalkZip(spooled, size, limits, visit)
+	} else {
+		err = archive.WalkTar(body, format == archive.TarGzip, limits, visit)
+	}
+
+	if err == nil && !(response.Atomic && response.Failed > 0) {
+		for _, object := range stored {
+			uc.pipeline.Commit(object)
+		}
+		return nil
+	}
+
+	uc.rollbackArchive(stored, response)
+
+	switch {
+	case err == nil, stdErrors.Is(err, errExtractionStopped):
+		return nil
+	case stdErrors.Is(err, archive.ErrUnsafePath), stdErrors.Is(err, archive.ErrMalformed):
+		return errors.EntityError(err.Error())
+	case stdErrors.Is(err, archive.ErrTooLarge), stdErrors.Is(err, archive.ErrTooManyEntries),
+		stdErrors.Is(err, archive.ErrExpansion), stdErrors.Is(err, archive.ErrRatio):
+		return errors.PayloadTooLargeError(err.Error())
+	}
+
+	return errors.ServiceError(err.Error())
+}
+
+// rollbackArchive gives every key an aborted extraction wrote back
+// what it held before, newest first so a path the archive carries
+// twice ends up with its original object.
+func (uc *UploadHandler) rollbackArchive(stored []*storedUpload, response *entities.ArchiveUploadResponseEntity) {
+	removed := make(map[string]bool, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		if appErr := uc.pipeline.Rollback(stored[i]); appErr != nil {
+			uc.log.Error(appErr.Message, appErr.ToMap())
+			continue
+		}
+		removed[stored[i].Object.Key] = true
+	}
+
+	for i, entry := range response.Entries {
+		if entry.Status == entities.ArchiveEntryStatus.Created && removed[entry.Key] {
+			response.Entries[i].Status = entities.ArchiveEntryStatus.RolledBack
+			response.Entries[i].Upload = nil
+			response.Created--
+		}
+	}
+	response.RolledBack = true
+}
+
+// spoolArchive copies a zip archive to a temporary file, since zip
+// keeps its directory at the end. At most one byte past maxSize is
+// copied, which is enough for the walk to refuse the archive.
+func spoolArchive(body io.Reader, maxSize int64) (*os.File, int64, *errors.AppError) {
+	spooled, err := os.CreateTemp("", "rb-cdn-archive-*.zip")
+	if err != nil {
+		return nil, 0, errors.ServiceError(err.Error())
+	}
+
+	source := body
+	if maxSize > 0 {
+		source = io.LimitReader(body, maxSize+1)
+	}
+
+	size, err := io.Copy(spooled, source)
+	if err != nil {
+		spooled.Close()
+		os.Remove(spooled.Name())
+		return nil, 0, errors.EntityError(err.Error())
+	}
+
+	return spooled, size, nil
+}
@@ -0,0 +1,163 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+)
+
+// errBatchTooLarge is returned once the files of a batch together go
+// over the limit; callers answer 413.
+var errBatchTooLarge = errors.New("the files of the batch exceed the size a batch may carry")
+
+// batchForm is a batch upload's form, read part by part rather than
+// through ReadForm. Fields are read into memory, bounded as /upload's
+// are; files are spooled to temporary files, as they are stored
+// concurrently, within a limit on the whole batch.
+type batchForm struct {
+	form   *multipart.Reader
+	fields map[string]string
+	// sent holds the fields the form set, over the defaults.
+	sent  map[string]bool
+	next  *multipart.Part
+	files []*batchFile
+}
+
+// batchFile is one "files" part, spooled.
+type batchFile struct {
+	Filename    string
+	ContentType string
+	spool       *os.File
+}
+
+// readBatchForm reads form up to its first file, so the bucket can be
+// checked before any file is read when it comes first. fields holds
+// the defaults taken from the query string; form fields override them.
+func readBatchForm(form *multipart.Reader, fields map[string]string) (*batchForm, error) {
+	batch := &batchForm{form: form, fields: fields, sent: map[string]bool{}}
+
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return batch, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FileName() != "" {
+			batch.next = part
+			return batch, nil
+		}
+		if err := batch.readField(part); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Fields returns the plain form fields read so far, merged over the
+// query string defaults.
+func (b *batchForm) Fields() map[string]string {
+	return b.fields
+}
+
+// Files returns the spooled files, in the order they were sent.
+func (b *batchForm) Files() []*batchFile {
+	return b.files
+}
+
+// ReadFiles reads the rest of the form, spooling every "files" part.
+// It fails once more than maxFiles files come, or once they add up to
+// more than limit bytes, with errBatchTooLarge.
+func (b *batchForm) ReadFiles(maxFiles int, limit int64) error {
+	part := b.next
+	b.next = nil
+
+	for {
+		if part == nil {
+			var err error
+			part, err = b.form.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case part.FileName() == "":
+			if err := b.readField(part); err != nil {
+				return err
+			}
+		case part.FormName() != "files":
+			part.Close()
+			return fmt.Errorf("file field %q is not accepted; send files as \"files\"", part.FormName())
+		case len(b.files) == maxFiles:
+			part.Close()
+			return fmt.Errorf("a batch carries at most %d files", maxFiles)
+		default:
+			written, err := b.spoolFile(part, limit)
+			if err != nil {
+				return err
+			}
+			limit -= written
+		}
+		part = nil
+	}
+}
+
+// Close removes the spooled files.
+func (b *batchForm) Close() {
+	if b.next != nil {
+		b.next.Close()
+	}
+	for _, file := range b.files {
+		file.spool.Close()
+		os.Remove(file.spool.Name())
+	}
+}
+
+func (b *batchForm) spoolFile(part *multipart.Part, limit int64) (int64, error) {
+	defer part.Close()
+
+	spool, err := os.CreateTemp("", "rb-cdn-batch-*")
+	if err != nil {
+		return 0, err
+	}
+	b.files = append(b.files, &batchFile{
+		Filename:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		spool:       spool,
+	})
+
+	written, err := io.Copy(spool, io.LimitReader(part, limit+1))
+	if err != nil {
+		return 0, err
+	}
+	if written > limit {
+		return 0, errBatchTooLarge
+	}
+	return written, nil
+}
+
+func (b *batchForm) readField(part *multipart.Part) error {
+	value, err := readFormField(part)
+	if err != nil {
+		return err
+	}
+
+	// The first non-empty value of a repeated field wins.
+	if value != "" && !b.sent[part.FormName()] {
+		b.fields[part.FormName()] = value
+		b.sent[part.FormName()] = true
+	}
+	return nil
+}
+
+// open returns a reader of the spooled file from its start.
+func (f *batchFile) open() io.Reader {
+	return io.NewSectionReader(f.spool, 0, 1<<63-1)
+}
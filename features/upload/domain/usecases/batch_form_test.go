@@ -0,0 +1,63 @@
+package usecases
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBatchFormStopsAtFirstFile(t *testing.T) {
+	form := multipartForm(t,
+		formPart{name: "bucket", value: "media"},
+		formPart{name: "files", filename: "a.txt", value: "alpha"},
+		formPart{name: "metadata[1]", value: `{"owner": "ana"}`},
+		formPart{name: "files", filename: "b.txt", value: "beta"},
+	)
+
+	batch, err := readBatchForm(form, map[string]string{"bucket": "query", "folder": "docs"})
+	require.NoError(t, err)
+	defer batch.Close()
+
+	assert.Equal(t, "media", batch.Fields()["bucket"], "the form overrides the query string")
+	assert.Empty(t, batch.Files(), "no file read before the bucket is checked")
+
+	require.NoError(t, batch.ReadFiles(10, 1<<20))
+	assert.Equal(t, "docs", batch.Fields()["folder"])
+	assert.Equal(t, `{"owner": "ana"}`, batch.Fields()["metadata[1]"])
+
+	files := batch.Files()
+	require.Len(t, files, 2)
+	assert.Equal(t, "b.txt", files[1].Filename)
+	body, err := io.ReadAll(files[1].open())
+	require.NoError(t, err)
+	assert.Equal(t, "beta", string(body))
+
+	spool := files[0].spool.Name()
+	batch.Close()
+	assert.NoFileExists(t, spool)
+}
+
+func TestReadBatchFormLimits(t *testing.T) {
+	parts := []formPart{
+		{name: "files", filename: "a.txt", value: strings.Repeat("a", 6)},
+		{name: "files", filename: "b.txt", value: strings.Repeat("b", 6)},
+	}
+
+	batch, err := readBatchForm(multipartForm(t, parts...), map[string]string{})
+	require.NoError(t, err)
+	assert.ErrorIs(t, batch.ReadFiles(10, 11), errBatchTooLarge)
+	batch.Close()
+
+	batch, err = readBatchForm(multipartForm(t, parts...), map[string]string{})
+	require.NoError(t, err)
+	assert.EqualError(t, batch.ReadFiles(1, 1<<20), "a batch carries at most 1 files")
+	batch.Close()
+
+	batch, err = readBatchForm(multipartForm(t, parts...), map[string]string{})
+	require.NoError(t, err)
+	assert.NoError(t, batch.ReadFiles(2, 12), "exactly at the limit")
+	batch.Close()
+}
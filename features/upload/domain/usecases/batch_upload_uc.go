@@ -0,0 +1,274 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/objectmeta"
+	"github.com/RodolfoBonis/rb-cdn/features/upload/domain/entities"
+	"github.com/gin-gonic/gin"
+)
+
+// UploadBatch godoc
+// @Summary Upload several files in one request
+// @Description Uploads every "files" part of the form to the bucket concurrently and reports the outcome per file. "folders[i]" and "metadata[i]" (a JSON object of strings) apply to the i-th file, on top of the x-meta-*, tags, cache_control, content_disposition and content_language fields shared by every file. With atomic=true a single failure gives every key already written back the object it held before, or removes it if it held none, and the batch answers 422. The bucket field should precede the files, which are then only read once the bucket was found writable. Files are buffered to disk, up to BATCH_MAX_SIZE bytes for the whole batch, over which the batch answers 413. Fields over 64 KiB answer 400.
+// @Tags upload
+// @Accept multipart/form-data
+// @Produce json
+// @Param files formData file true "Files to upload (repeat the field)"
+// @Param bucket formData string true "Bucket name"
+// @Param folder formData string false "Default folder for every file"
+// @Param atomic formData bool false "All-or-nothing: roll back on any failure"
+// @Param X-Progress-Id header string false "Id to follow the upload's progress under at GET /progress/{id}; one is made up and echoed in X-Progress-Id otherwise"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.BatchUploadResponseEntity
+// @Success 207 {object} entities.BatchUploadResponseEntity
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 413 {object} errors.HttpError
+// @Failure 422 {object} entities.BatchUploadResponseEntity
+// @Router /upload/batch [post]
+func (uc *UploadHandler) UploadBatch(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	tracker, finish, ok := trackUpload(c)
+	if !ok {
+		return
+	}
+	defer finish()
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	form, err := readBatchForm(reader, map[string]string{
+		"bucket": c.Query("bucket"),
+		"folder": c.Query("folder"),
+		"atomic": c.Query("atomic"),
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer form.Close()
+
+	// A bucket sent before the files is checked before they are read;
+	// one sent after them only once they were.
+	checked := form.Fields()["bucket"]
+	if checked != "" && !uc.authorizeBatch(c, checked) {
+		return
+	}
+
+	err = form.ReadFiles(config.EnvBatchMaxFiles(), config.EnvBatchMaxSize())
+	if stdErrors.Is(err, errBatchTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("the files of a batch may not exceed %d bytes together", config.EnvBatchMaxSize()),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fields := form.Fields()
+	bucketName := fields["bucket"]
+	if bucketName != checked && !uc.authorizeBatch(c, bucketName) {
+		return
+	}
+
+	files := form.Files()
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one files field is required"})
+		return
+	}
+
+	atomic, _ := strconv.ParseBool(fields["atomic"])
+	defaultFolder := fields["folder"]
+
+	shared, err := objectmeta.FromRequest(fields, c.Request.Header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	requests := make([]uploadRequest, len(files))
+	for i, file := range files {
+		metadata := shared
+		metadata.Metadata = make(map[string]string, len(shared.Metadata))
+		for key, value := range shared.Metadata {
+			metadata.Metadata[key] = value
+		}
+
+		if raw := fields[fmt.Sprintf("metadata[%d]", i)]; raw != "" {
+			var own map[string]string
+			if err := json.Unmarshal([]byte(raw), &own); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("metadata[%d] must be a JSON object of strings", i),
+				})
+				return
+			}
+			for key, value := range own {
+				metadata.Metadata[strings.ToLower(key)] = value
+			}
+		}
+
+		if err := objectmeta.Validate(metadata); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("metadata[%d]: %s", i, err)})
+			return
+		}
+
+		requests[i] = uploadRequest{
+			Bucket:      bucketName,
+			Folder:      firstNonEmpty(fields[fmt.Sprintf("folders[%d]", i)], defaultFolder),
+			Filename:    file.Filename,
+			ContentType: file.ContentType,
+			Metadata:    metadata,
+			Progress:    tracker,
+			Reversible:  atomic,
+		}
+	}
+
+	response := uc.runBatch(c.Request.Context(), files, requests, atomic)
+
+	status := http.StatusOK
+	switch {
+	case response.RolledBack:
+		status = http.StatusUnprocessableEntity
+	case response.Failed > 0:
+		status = http.StatusMultiStatus
+	}
+
+	tracker.Done(response)
+	c.JSON(status, response)
+}
+
+// authorizeBatch answers 400 or 403 and reports false unless
+// bucketName is set and writable.
+func (uc *UploadHandler) authorizeBatch(c *gin.Context, bucketName string) bool {
+	if bucketName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket parameter is required"})
+		return false
+	}
+
+	if !rbauth.GetValidation(c).Permissions.HasBucketPermission("rb-cdn", bucketName, "write") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No write permission for bucket: %s", bucketName),
+		})
+		return false
+	}
+
+	return true
+}
+
+// runBatch uploads files through the pipeline with at most
+// BATCH_CONCURRENCY of them in flight. In atomic mode the first
+// failure cancels the files not yet started and every key already
+// written gets back what it held before the batch.
+func (uc *UploadHandler) runBatch(ctx context.Context, files []*batchFile, requests []uploadRequest, atomic bool) entities.BatchUploadResponseEntity {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]entities.BatchUploadResultEntity, len(files))
+	stored := make([]*storedUpload, len(files))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < config.EnvBatchConcurrency(); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = entities.BatchUploadResultEntity{Index: i, Filename: files[i].Filename}
+
+				if ctx.Err() != nil {
+					results[i].Status = entities.BatchUploadStatus.Skipped
+					continue
+				}
+
+				object, err := uc.storeBatchFile(ctx, files[i], requests[i])
+				if err != nil {
+					results[i].Status = entities.BatchUploadStatus.Failed
+					results[i].Error = err.Error()
+					if atomic {
+						cancel()
+					}
+					continue
+				}
+
+				stored[i] = object
+				results[i].Status = entities.BatchUploadStatus.Uploaded
+				results[i].Upload = &object.Response
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	response := entities.BatchUploadResponseEntity{Atomic: atomic, Results: results}
+	for _, result := range results {
+		if result.Status == entities.BatchUploadStatus.Failed {
+			response.Failed++
+		}
+	}
+
+	if atomic && response.Failed > 0 {
+		for i := len(stored) - 1; i >= 0; i-- {
+			object := stored[i]
+			if object == nil {
+				continue
+			}
+
+			if appErr := uc.pipeline.Rollback(object); appErr != nil {
+				uc.log.Error(appErr.Message, appErr.ToMap())
+				results[i].Error = appErr.Message
+				continue
+			}
+
+			results[i].Status = entities.BatchUploadStatus.RolledBack
+			results[i].Upload = nil
+		}
+		response.RolledBack = true
+		return response
+	}
+
+	for i, result := range results {
+		if result.Status == entities.BatchUploadStatus.Uploaded {
+			response.Uploaded++
+		}
+		if atomic && stored[i] != nil {
+			uc.pipeline.Commit(stored[i])
+		}
+	}
+
+	return response
+}
+
+func (uc *UploadHandler) storeBatchFile(ctx context.Context, file *batchFile, request uploadRequest) (*storedUpload, error) {
+	request.Body = file.open()
+	object, appErr := uc.pipeline.Store(ctx, request)
+	if appErr != nil {
+		return nil, stdErrors.New(appErr.Message)
+	}
+
+	return object, nil
+}
@@ -0,0 +1,393 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/fetch"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/progress"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/core/sniff"
+	"github.com/RodolfoBonis/rb-cdn/features/upload/domain/entities"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go"
+)
+
+const fetchStatePrefix = ".fetch/"
+
+// FetchHandler imports remote URLs into MinIO. The download goes
+// through fetch.Client, so it can't be pointed at private networks,
+// and the body streams through the upload pipeline like any other
+// upload.
+type FetchHandler struct {
+	minioService services.IMinioService
+	pipeline     *UploadPipeline
+	client       *http.Client
+	log          *logger.CustomLogger
+
+	// authorizer applies the per-bucket write check; it is authorize
+	// outside of tests.
+	authorizer func(c *gin.Context, bucketName string) bool
+}
+
+func NewFetchHandler(minioService services.IMinioService, allowed []*net.IPNet, log *logger.CustomLogger) *FetchHandler {
+	handler := &FetchHandler{
+		minioService: minioService,
+		pipeline:     NewUploadPipeline(minioService, log),
+		client: fetch.NewClient(fetch.Options{
+			Timeout:      config.EnvFetchTimeout(),
+			MaxRedirects: config.EnvFetchMaxRedirects(),
+			Allowed:      allowed,
+		}),
+		log: log,
+	}
+	handler.authorizer = handler.authorize
+	return handler
+}
+
+// Fetch godoc
+// @Summary Import a remote URL
+// @Description Downloads url server-side and stores it in bucket under key. Private, loopback and link-local addresses are refused unless allowlisted, and the body is bounded by FETCH_MAX_SIZE and FETCH_TIMEOUT. With async=true the call answers 202 with a job to poll at the Location header.
+// @Tags upload
+// @Accept json
+// @Produce json
+// @Param request body entities.FetchRequestEntity true "What to import"
+// @Param X-Progress-Id header string false "Id to follow the import's progress under at GET /progress/{id}; the job id otherwise"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.FetchJobEntity
+// @Success 202 {object} entities.FetchJobEntity
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} entities.FetchJobEntity
+// @Failure 413 {object} entities.FetchJobEntity
+// @Failure 415 {object} entities.FetchJobEntity
+// @Failure 502 {object} entities.FetchJobEntity
+// @Router /upload/fetch [post]
+func (uc *FetchHandler) Fetch(c *gin.Context) {
+	var request entities.FetchRequestEntity
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !uc.authorizer(c, request.Bucket) {
+		return
+	}
+
+	if err := validateFetchRequest(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	job := &entities.FetchJobEntity{
+		ID:        strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Status:    entities.FetchJobStatus.Pending,
+		URL:       request.URL,
+		Bucket:    request.Bucket,
+		Key:       strings.TrimPrefix(request.Key, "/"),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// The job id doubles as the progress id unless the client picked
+	// one to follow the job by.
+	tracker, ok := trackProgress(c, job.ID, 0)
+	if !ok {
+		return
+	}
+
+	if !request.Async {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), config.EnvFetchTimeout())
+		defer cancel()
+
+		status := uc.run(ctx, job, request.ContentTypes, tracker)
+		c.JSON(status, job)
+		return
+	}
+
+	if appErr := uc.saveJob(job); appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		tracker.Fail(appErr.Message)
+		c.JSON(http.StatusInternalServerError, appErr)
+		return
+	}
+
+	// The answer carries the job as it was accepted; the goroutine
+	// updates its own copy.
+	accepted := *job
+
+	// The job outlives the request. If this replica dies before it
+	// finishes, the job stays pending until the janitor drops it.
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), config.EnvFetchTimeout())
+		defer cancel()
+
+		uc.run(ctx, job, request.ContentTypes, tracker)
+		if appErr := uc.saveJob(job); appErr != nil {
+			uc.log.Error(appErr.Message, appErr.ToMap())
+		}
+	}()
+
+	c.Header("Location", fmt.Sprintf("%s/upload/fetch/%s/%s", config.EnvCDNPublicURL(), job.Bucket, job.ID))
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// Status godoc
+// @Summary Poll a remote import job
+// @Description Returns the state of an async import started through POST /upload/fetch.
+// @Tags upload
+// @Produce json
+// @Param bucket path string true "Bucket name"
+// @Param id path string true "Job id"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.FetchJobEntity
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Router /upload/fetch/{bucket}/{id} [get]
+func (uc *FetchHandler) Status(c *gin.Context) {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	bucketName := c.Param("bucket")
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucketName, "write") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No write permission for bucket: %s", bucketName),
+		})
+		return
+	}
+
+	job, appErr := uc.loadJob(bucketName, c.Param("id"))
+	if appErr != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (uc *FetchHandler) authorize(c *gin.Context, bucketName string) bool {
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return false
+	}
+
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucketName, "write") {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": fmt.Sprintf("No write permission for bucket: %s", bucketName),
+		})
+		return false
+	}
+
+	return true
+}
+
+// run downloads job.URL into the pipeline and records the outcome on
+// job and tracker. Returns the HTTP status that outcome maps to.
+func (uc *FetchHandler) run(ctx context.Context, job *entities.FetchJobEntity, contentTypes []string, tracker *progress.Tracker) int {
+	stored, status, err := uc.download(ctx, job, contentTypes, tracker)
+
+	job.UpdatedAt = time.Now().UTC()
+	if err != nil {
+		uc.log.Warning(fmt.Sprintf("Fetch of %s failed: %s", job.URL, err), map[string]interface{}{"job": job.ID})
+		job.Status = entities.FetchJobStatus.Failed
+		job.Error = err.Error()
+		tracker.Fail(job.Error)
+		return status
+	}
+
+	job.Status = entities.FetchJobStatus.Succeeded
+	job.Upload = &stored.Response
+	tracker.Done(stored.Response)
+	return http.StatusOK
+}
+
+// download streams job.URL into the pipeline; what arrives from the
+// remote counts as received on tracker.
+func (uc *FetchHandler) download(ctx context.Context, job *entities.FetchJobEntity, contentTypes []string, tracker *progress.Tracker) (*storedUpload, int, error) {
+	response, err := fetch.Get(ctx, uc.client, job.URL)
+	if err != nil {
+		return nil, fetchFailureStatus(err), err
+	}
+	defer response.Body.Close()
+
+	contentType := response.Header.Get("Content-Type")
+	if !sniff.MatchType(contentType, contentTypes) {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("remote content type %q is not accepted", contentType)
+	}
+
+	maxSize := config.EnvFetchMaxSize()
+	if response.ContentLength > maxSize {
+		return nil, http.StatusRequestEntityTooLarge, fetch.ErrTooLarge
+	}
+
+	if response.ContentLength > 0 {
+		tracker.SetTotal(response.ContentLength)
+	}
+
+	folder, filename := path.Split(job.Key)
+	body := &failureReader{reader: fetch.LimitReader(response.Body, maxSize)}
+	stored, appErr := uc.pipeline.Store(ctx, uploadRequest{
+		Bucket:      job.Bucket,
+		Folder:      strings.TrimSuffix(folder, "/"),
+		Filename:    filename,
+		ContentType: contentType,
+		Body:        tracker.CountReceived(body),
+		Progress:    tracker,
+	})
+	if appErr != nil {
+		// The pipeline reports the reader's error as a failed upload;
+		// the limit is told apart by what the reader itself returned.
+		if stdErrors.Is(body.err, fetch.ErrTooLarge) {
+			return nil, http.StatusRequestEntityTooLarge, fetch.ErrTooLarge
+		}
+		return nil, appErr.ToHttpError().StatusCode, stdErrors.New(appErr.Message)
+	}
+
+	return stored, http.StatusOK, nil
+}
+
+// failureReader keeps the error reading from reader failed with.
+type failureReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *failureReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// fetchFailureStatus maps a failed download to the status the client
+// gets: refused destinations are the caller's fault, anything else is
+// the remote's.
+func fetchFailureStatus(err error) int {
+	switch {
+	case stdErrors.Is(err, fetch.ErrBlockedAddress):
+		return http.StatusForbidden
+	case stdErrors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// validateFetchRequest rejects what can be refused before any job is
+// created.
+func validateFetchRequest(request entities.FetchRequestEntity) error {
+	target, err := url.Parse(request.URL)
+	if err != nil {
+		return err
+	}
+	if err := fetch.CheckURL(target); err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(request.Key, "/") {
+		return fmt.Errorf("key must name a file, not a folder")
+	}
+
+	return nil
+}
+
+// PurgeFinished drops job state older than FETCH_JOB_RETENTION from
+// every bucket, finished or not.
+func (uc *FetchHandler) PurgeFinished() {
+	buckets, appErr := uc.minioService.ListBuckets()
+	if appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		return
+	}
+
+	deadline := time.Now().Add(-config.EnvFetchJobRetention())
+	for _, bucket := range buckets {
+		objects, appErr := uc.minioService.ListObjects(bucket.Name, fetchStatePrefix)
+		if appErr != nil {
+			uc.log.Error(appErr.Message, appErr.ToMap())
+			continue
+		}
+
+		for _, object := range objects {
+			if object.LastModified.After(deadline) {
+				continue
+			}
+			if appErr := uc.minioService.RemoveObject(bucket.Name, object.Key); appErr != nil {
+				uc.log.Error(appErr.Message, appErr.ToMap())
+			}
+		}
+	}
+}
+
+// StartJanitor runs PurgeFinished every interval until the process
+// exits.
+func (uc *FetchHandler) StartJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			uc.PurgeFinished()
+		}
+	}()
+}
+
+func fetchJobObject(id string) string {
+	return fmt.Sprintf("%s%s.json", fetchStatePrefix, id)
+}
+
+func (uc *FetchHandler) saveJob(job *entities.FetchJobEntity) *errors.AppError {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return errors.UsecaseError(err.Error())
+	}
+
+	_, appErr := uc.minioService.PutObject(
+		job.Bucket,
+		fetchJobObject(job.ID),
+		bytes.NewReader(payload),
+		int64(len(payload)),
+		minio.PutObjectOptions{ContentType: "application/json"},
+	)
+	return appErr
+}
+
+func (uc *FetchHandler) loadJob(bucketName string, id string) (*entities.FetchJobEntity, *errors.AppError) {
+	object, appErr := uc.minioService.GetObject(bucketName, fetchJobObject(id), minio.GetObjectOptions{})
+	if appErr != nil {
+		return nil, appErr
+	}
+	defer object.Close()
+
+	var job entities.FetchJobEntity
+	if err := json.NewDecoder(object).Decode(&job); err != nil {
+		return nil, errors.NotFoundError()
+	}
+
+	if job.ID != id || job.Bucket != bucketName {
+		return nil, errors.NotFoundError()
+	}
+
+	return &job, nil
+}
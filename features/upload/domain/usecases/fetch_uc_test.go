@@ -0,0 +1,103 @@
+package usecases
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/upload/domain/entities"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFetchRouter(t *testing.T, minioService services.IMinioService) *gin.Engine {
+	t.Helper()
+
+	_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+
+	handler := NewFetchHandler(minioService, []*net.IPNet{loopback}, logger.Log)
+	handler.authorizer = func(c *gin.Context, bucketName string) bool { return true }
+
+	router := gin.New()
+	router.POST("/upload/fetch", handler.Fetch)
+	return router
+}
+
+func postFetch(t *testing.T, router *gin.Engine, request entities.FetchRequestEntity) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/upload/fetch", bytes.NewReader(body)))
+	return recorder
+}
+
+func TestFetchAsync(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("remote body"))
+	}))
+	defer remote.Close()
+
+	minioService := newFakeMinio("media")
+	router := newFetchRouter(t, minioService)
+
+	recorder := postFetch(t, router, entities.FetchRequestEntity{
+		URL:    remote.URL + "/notes.txt",
+		Bucket: "media",
+		Key:    "docs/notes.txt",
+		Async:  true,
+	})
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+
+	var accepted entities.FetchJobEntity
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &accepted))
+	assert.Equal(t, entities.FetchJobStatus.Pending, accepted.Status)
+	assert.Nil(t, accepted.Upload)
+
+	// The job finishes in the background and saves its final state.
+	require.Eventually(t, func() bool {
+		state := minioService.lookup("media", fetchStatePrefix+accepted.ID+".json")
+		if state == nil {
+			return false
+		}
+		var job entities.FetchJobEntity
+		return json.Unmarshal(state.data, &job) == nil && job.Status == entities.FetchJobStatus.Succeeded
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []byte("remote body"), minioService.lookup("media", "docs/notes.txt").data)
+}
+
+func TestFetchTooLarge(t *testing.T) {
+	t.Setenv("FETCH_MAX_SIZE", "8")
+
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flushed before the body, so no Content-Length tells the size
+		// up front and the limit is only hit while streaming.
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		w.Write([]byte(strings.Repeat("a", 64)))
+	}))
+	defer remote.Close()
+
+	minioService := newFakeMinio("media")
+	recorder := postFetch(t, newFetchRouter(t, minioService), entities.FetchRequestEntity{
+		URL:    remote.URL + "/big.txt",
+		Bucket: "media",
+		Key:    "big.txt",
+	})
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	assert.Nil(t, minioService.lookup("media", "big.txt"))
+}
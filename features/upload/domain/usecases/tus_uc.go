@@ -0,0 +1,648 @@
+package usecases
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/middlewares"
+	"github.com/RodolfoBonis/rb-cdn/core/objectmeta"
+	"github.com/RodolfoBonis/rb-cdn/core/progress"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/upload/domain/entities"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go"
+)
+
+const (
+	tusVersion       = "1.0.0"
+	tusExtensions    = "creation,termination,expiration"
+	tusContentType   = "application/offset+octet-stream"
+	tusStatePrefix   = ".tus/"
+	tusInfoExtension = ".info"
+)
+
+// TusHandler implements the tus 1.0 resumable upload protocol
+// (core + creation, termination and expiration extensions) on top of
+// MinIO. Every PATCH body is stored as its own chunk object under
+// .tus/<id>/ in the target bucket, next to a .tus/<id>.info state
+// file, so the upload survives pod restarts and can be resumed
+// through any replica. Once the last byte arrives the chunks are
+// concatenated into the final object and the state is removed.
+type TusHandler struct {
+	minioService services.IMinioService
+	pipeline     *UploadPipeline
+	log          *logger.CustomLogger
+
+	// locks serialises PATCH/DELETE per upload id inside this
+	// replica. Concurrent PATCHes to the same upload through two
+	// different replicas are not guarded; tus clients never issue
+	// them, they always wait for the previous PATCH to return.
+	locks sync.Map
+
+	// authorizer applies the per-bucket write check; it is authorize
+	// outside of tests.
+	authorizer func(c *gin.Context, bucketName string) bool
+}
+
+func NewTusHandler(minioService services.IMinioService, log *logger.CustomLogger) *TusHandler {
+	handler := &TusHandler{
+		minioService: minioService,
+		pipeline:     NewUploadPipeline(minioService, log),
+		log:          log,
+	}
+	handler.authorizer = handler.authorize
+	return handler
+}
+
+// Options godoc
+// @Summary Discover tus capabilities
+// @Description Returns the tus protocol versions, extensions and maximum upload size supported by the server
+// @Tags upload
+// @Success 204
+// @Router /upload/tus [options]
+func (uc *TusHandler) Options(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(config.EnvTusMaxSize(), 10))
+	c.Status(http.StatusNoContent)
+}
+
+// Create godoc
+// @Summary Create a resumable upload
+// @Description Creates a tus upload. Upload-Metadata must carry "bucket" and "filename" (or "name"); "folder", "filetype" (or "type"), "x-meta-*", "tags", "cache_control", "content_disposition" and "content_language" are optional.
+// @Tags upload
+// @Param Tus-Resumable header string true "tus protocol version (1.0.0)"
+// @Param Upload-Length header int true "Total upload size in bytes"
+// @Param Upload-Metadata header string true "tus metadata (key base64 pairs)"
+// @Param Authorization header string true "Bearer token"
+// @Success 201
+// @Failure 400 {object} errors.HttpError
+// @Failure 401 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 412 {object} errors.HttpError
+// @Failure 413 {object} errors.HttpError
+// @Failure 500 {object} errors.HttpError
+// @Router /upload/tus [post]
+func (uc *TusHandler) Create(c *gin.Context) {
+	if !uc.checkVersion(c) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		uc.abort(c, http.StatusBadRequest, "Upload-Length header is required and must be a non-negative integer")
+		return
+	}
+
+	if length > config.EnvTusMaxSize() {
+		uc.abort(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload-Length exceeds Tus-Max-Size (%d)", config.EnvTusMaxSize()))
+		return
+	}
+
+	metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		uc.abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bucketName := metadata["bucket"]
+	if bucketName == "" {
+		uc.abort(c, http.StatusBadRequest, "bucket metadata is required")
+		return
+	}
+
+	if !uc.authorizer(c, bucketName) {
+		return
+	}
+
+	filename := firstNonEmpty(metadata["filename"], metadata["name"])
+	if filename == "" {
+		uc.abort(c, http.StatusBadRequest, "filename metadata is required")
+		return
+	}
+
+	// The type checks need the content and run when the upload
+	// completes; the size limit can be refused right away.
+	if maxSize := config.BucketSettings(bucketName).Upload.MaxSize; maxSize > 0 && length > maxSize {
+		uc.abort(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload-Length exceeds the bucket's max_size (%d)", maxSize))
+		return
+	}
+
+	// x-meta-*, tags, cache_control, content_disposition and
+	// content_language travel in Upload-Metadata like everything else.
+	objectMetadata, err := objectmeta.FromRequest(metadata, c.Request.Header)
+	if err != nil {
+		uc.abort(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	upload := &entities.TusUploadEntity{
+		ID:          strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Bucket:      bucketName,
+		Folder:      metadata["folder"],
+		Filename:    filename,
+		ContentType: firstNonEmpty(metadata["filetype"], metadata["type"]),
+		Length:      length,
+		Metadata:    metadata,
+		Object:      objectMetadata,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(config.EnvTusExpiration()),
+	}
+
+	if appErr := uc.saveUpload(upload); appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		uc.abort(c, http.StatusInternalServerError, appErr.Message)
+		return
+	}
+
+	uc.log.Info(fmt.Sprintf("Created tus upload %s for %s in Bucket: %s", upload.ID, filename, bucketName))
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Location", fmt.Sprintf("%s/upload/tus/%s/%s", config.EnvCDNPublicURL(), bucketName, upload.ID))
+	c.Header("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	c.Header(progressHeader, upload.ID)
+
+	tracker := trackTus(c, upload)
+
+	// A zero-length upload is complete the moment it is created.
+	if length == 0 {
+		held := uc.lock(upload.ID)
+		defer held.unlock()
+		uc.finish(c, upload, held, tracker, http.StatusCreated)
+		return
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+// Head godoc
+// @Summary Query a resumable upload offset
+// @Description Returns the number of bytes the server has already received for a tus upload
+// @Tags upload
+// @Param bucket path string true "Bucket name"
+// @Param id path string true "Upload id"
+// @Param Tus-Resumable header string true "tus protocol version (1.0.0)"
+// @Param Authorization header string true "Bearer token"
+// @Success 200
+// @Failure 403 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Failure 410 {object} errors.HttpError
+// @Router /upload/tus/{bucket}/{id} [head]
+func (uc *TusHandler) Head(c *gin.Context) {
+	upload, ok := uc.loadAuthorized(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+}
+
+// Patch godoc
+// @Summary Append to a resumable upload
+// @Description Appends the request body at Upload-Offset. The PATCH that completes the upload answers 200 with the same body as POST /upload; intermediate PATCHes answer 204.
+// @Tags upload
+// @Accept application/offset+octet-stream
+// @Produce json
+// @Param bucket path string true "Bucket name"
+// @Param id path string true "Upload id"
+// @Param Tus-Resumable header string true "tus protocol version (1.0.0)"
+// @Param Upload-Offset header int true "Offset the body starts at"
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} entities.UploadResponseEntity
+// @Success 204
+// @Failure 400 {object} errors.HttpError
+// @Failure 403 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Failure 409 {object} errors.HttpError
+// @Failure 410 {object} errors.HttpError
+// @Failure 413 {object} errors.HttpError
+// @Failure 415 {object} errors.HttpError
+// @Failure 500 {object} errors.HttpError
+// @Router /upload/tus/{bucket}/{id} [patch]
+func (uc *TusHandler) Patch(c *gin.Context) {
+	if c.ContentType() != tusContentType {
+		uc.abort(c, http.StatusUnsupportedMediaType, fmt.Sprintf("Content-Type must be %s", tusContentType))
+		return
+	}
+
+	held := uc.lock(c.Param("id"))
+	defer held.unlock()
+
+	upload, ok := uc.loadAuthorized(c)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		uc.abort(c, http.StatusConflict, fmt.Sprintf("Upload-Offset mismatch: server is at %d", upload.Offset))
+		return
+	}
+
+	remaining := upload.Length - upload.Offset
+	if c.Request.ContentLength > remaining {
+		uc.abort(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Body exceeds the %d bytes left in this upload", remaining))
+		return
+	}
+
+	tracker := trackTus(c, upload)
+
+	if c.Request.ContentLength != 0 {
+		chunk := entities.TusChunkEntity{
+			Object: fmt.Sprintf("%s%s/%020d", tusStatePrefix, upload.ID, upload.Offset),
+			Offset: upload.Offset,
+		}
+
+		// An interrupted body fails the whole streamed put, so the
+		// offset only moves forward by chunks that were stored in
+		// full; the client resumes from the last complete PATCH.
+		stored, appErr := uc.minioService.UploadObjectStream(
+			c.Request.Context(),
+			upload.Bucket,
+			chunk.Object,
+			tracker.CountReceived(io.LimitReader(c.Request.Body, remaining)),
+			minio.PutObjectOptions{ContentType: tusContentType},
+		)
+		if appErr != nil {
+			uc.log.Error(appErr.Message, appErr.ToMap())
+			uc.abort(c, http.StatusInternalServerError, appErr.Message)
+			return
+		}
+
+		chunk.Size = stored.Size
+		upload.Chunks = append(upload.Chunks, chunk)
+		upload.Offset += stored.Size
+	}
+
+	upload.ExpiresAt = time.Now().UTC().Add(config.EnvTusExpiration())
+	if appErr := uc.saveUpload(upload); appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		uc.abort(c, http.StatusInternalServerError, appErr.Message)
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+
+	if upload.Offset == upload.Length {
+		uc.finish(c, upload, held, tracker, http.StatusOK)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Terminate godoc
+// @Summary Terminate a resumable upload
+// @Description Discards an unfinished tus upload and every chunk received so far
+// @Tags upload
+// @Param bucket path string true "Bucket name"
+// @Param id path string true "Upload id"
+// @Param Tus-Resumable header string true "tus protocol version (1.0.0)"
+// @Param Authorization header string true "Bearer token"
+// @Success 204
+// @Failure 403 {object} errors.HttpError
+// @Failure 404 {object} errors.HttpError
+// @Failure 500 {object} errors.HttpError
+// @Router /upload/tus/{bucket}/{id} [delete]
+func (uc *TusHandler) Terminate(c *gin.Context) {
+	held := uc.lock(c.Param("id"))
+	defer held.unlock()
+
+	upload, ok := uc.loadAuthorized(c)
+	if !ok {
+		return
+	}
+
+	if appErr := uc.discard(upload.Bucket, held); appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		uc.abort(c, http.StatusInternalServerError, appErr.Message)
+		return
+	}
+
+	trackTus(c, upload).Fail("upload terminated")
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Status(http.StatusNoContent)
+}
+
+// PurgeExpired removes every unfinished upload whose state file
+// hasn't been touched for longer than TUS_EXPIRATION. The state file
+// is rewritten on every PATCH, so its LastModified is the time of the
+// last activity and no state has to be downloaded to decide. Chunks
+// left behind without a state file, by a discard that failed half
+// way, go once they are as old.
+func (uc *TusHandler) PurgeExpired() {
+	buckets, appErr := uc.minioService.ListBuckets()
+	if appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		return
+	}
+
+	deadline := time.Now().Add(-config.EnvTusExpiration())
+	for _, bucket := range buckets {
+		objects, appErr := uc.minioService.ListObjects(bucket.Name, tusStatePrefix)
+		if appErr != nil {
+			uc.log.Error(appErr.Message, appErr.ToMap())
+			continue
+		}
+
+		uploads := map[string]bool{}
+		for _, object := range objects {
+			if id, found := strings.CutSuffix(strings.TrimPrefix(object.Key, tusStatePrefix), tusInfoExtension); found {
+				uploads[id] = true
+			}
+		}
+
+		for _, object := range objects {
+			if object.LastModified.After(deadline) {
+				continue
+			}
+
+			id, found := strings.CutSuffix(strings.TrimPrefix(object.Key, tusStatePrefix), tusInfoExtension)
+			if !found {
+				upload, _, _ := strings.Cut(strings.TrimPrefix(object.Key, tusStatePrefix), "/")
+				if uploads[upload] {
+					continue
+				}
+				if appErr := uc.minioService.RemoveObject(bucket.Name, object.Key); appErr != nil {
+					uc.log.Error(appErr.Message, appErr.ToMap())
+				}
+				continue
+			}
+
+			held := uc.lock(id)
+			appErr := uc.discard(bucket.Name, held)
+			held.unlock()
+			if appErr != nil {
+				uc.log.Error(appErr.Message, appErr.ToMap())
+				continue
+			}
+			uc.log.Info(fmt.Sprintf("Expired tus upload %s in Bucket: %s", id, bucket.Name))
+		}
+	}
+}
+
+// StartExpirationJanitor runs PurgeExpired every interval until the
+// process exits.
+func (uc *TusHandler) StartExpirationJanitor(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			uc.PurgeExpired()
+		}
+	}()
+}
+
+// finish concatenates the chunks into the final object, drops the
+// upload state and answers with the regular upload response.
+func (uc *TusHandler) finish(c *gin.Context, upload *entities.TusUploadEntity, held *tusLock, tracker *progress.Tracker, status int) {
+	readers := make([]io.Reader, 0, len(upload.Chunks))
+	for _, chunk := range upload.Chunks {
+		object, appErr := uc.minioService.GetObject(upload.Bucket, chunk.Object, minio.GetObjectOptions{})
+		if appErr != nil {
+			uc.log.Error(appErr.Message, appErr.ToMap())
+			tracker.Fail(appErr.Message)
+			uc.abort(c, http.StatusInternalServerError, appErr.Message)
+			return
+		}
+		defer object.Close()
+		readers = append(readers, object)
+	}
+
+	stored, appErr := uc.pipeline.Store(c.Request.Context(), uploadRequest{
+		Bucket:      upload.Bucket,
+		Folder:      upload.Folder,
+		Filename:    upload.Filename,
+		ContentType: upload.ContentType,
+		Metadata:    upload.Object,
+		Body:        io.MultiReader(readers...),
+		Progress:    tracker,
+	})
+	if appErr != nil {
+		uc.log.Error(appErr.Message, appErr.ToMap())
+		tracker.Fail(appErr.Message)
+		uc.abort(c, appErr.ToHttpError().StatusCode, appErr.Message)
+		return
+	}
+
+	if appErr := uc.discard(upload.Bucket, held); appErr != nil {
+		// The object is already stored; leftover chunks are collected
+		// by the expiration janitor, so the client still gets a 2xx.
+		uc.log.Warning(appErr.Message, appErr.ToMap())
+	}
+
+	tracker.Done(stored.Response)
+	c.JSON(status, stored.Response)
+}
+
+// trackTus picks up the progress of upload, which clients follow by its
+// upload id across every PATCH that carries it.
+func trackTus(c *gin.Context, upload *entities.TusUploadEntity) *progress.Tracker {
+	return progress.Default.Continue(upload.ID, middlewares.RequestIdentity(c.Request), upload.Length, upload.Offset)
+}
+
+// loadAuthorized resolves the upload addressed by the :bucket/:id path
+// params and applies the same per-bucket write check as POST /upload.
+// It writes the error response itself and reports false when the
+// request must stop.
+func (uc *TusHandler) loadAuthorized(c *gin.Context) (*entities.TusUploadEntity, bool) {
+	if !uc.checkVersion(c) {
+		return nil, false
+	}
+
+	bucketName := c.Param("bucket")
+	if !uc.authorizer(c, bucketName) {
+		return nil, false
+	}
+
+	upload, appErr := uc.loadUpload(bucketName, c.Param("id"))
+	if appErr != nil {
+		uc.abort(c, http.StatusNotFound, "Upload not found")
+		return nil, false
+	}
+
+	if time.Now().After(upload.ExpiresAt) {
+		uc.abort(c, http.StatusGone, "Upload expired")
+		return nil, false
+	}
+
+	return upload, true
+}
+
+func (uc *TusHandler) authorize(c *gin.Context, bucketName string) bool {
+	// Service + service-level permission are guaranteed by the route
+	// middlewares; only the per-bucket check is left to the handler.
+	validation := rbauth.GetValidation(c)
+	if validation == nil {
+		uc.abort(c, http.StatusUnauthorized, "Authentication required")
+		return false
+	}
+
+	if !validation.Permissions.HasBucketPermission("rb-cdn", bucketName, "write") {
+		uc.abort(c, http.StatusForbidden, fmt.Sprintf("No write permission for bucket: %s", bucketName))
+		return false
+	}
+
+	return true
+}
+
+func (uc *TusHandler) checkVersion(c *gin.Context) bool {
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		uc.abort(c, http.StatusPreconditionFailed, fmt.Sprintf("Unsupported Tus-Resumable version, expected %s", tusVersion))
+		return false
+	}
+	return true
+}
+
+func (uc *TusHandler) abort(c *gin.Context, status int, message string) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.AbortWithStatusJSON(status, errors.NewHTTPError(status, message))
+}
+
+// tusLock is an upload's mutex, held by the request working on it.
+type tusLock struct {
+	locks *sync.Map
+	id    string
+	mutex *sync.Mutex
+	// discarded drops the entry from locks on unlock, once nothing
+	// can be left of the upload for a later request to lock.
+	discarded bool
+}
+
+func (uc *TusHandler) lock(id string) *tusLock {
+	mutex, _ := uc.locks.LoadOrStore(id, &sync.Mutex{})
+	mutex.(*sync.Mutex).Lock()
+	return &tusLock{locks: &uc.locks, id: id, mutex: mutex.(*sync.Mutex)}
+}
+
+// unlock releases the mutex. A request that was waiting for it finds
+// the state file of a discarded upload gone and answers 404.
+func (l *tusLock) unlock() {
+	l.mutex.Unlock()
+	if l.discarded {
+		l.locks.Delete(l.id)
+	}
+}
+
+func (uc *TusHandler) saveUpload(upload *entities.TusUploadEntity) *errors.AppError {
+	payload, err := json.Marshal(upload)
+	if err != nil {
+		return errors.UsecaseError(err.Error())
+	}
+
+	_, appErr := uc.minioService.PutObject(
+		upload.Bucket,
+		tusInfoObject(upload.ID),
+		bytes.NewReader(payload),
+		int64(len(payload)),
+		minio.PutObjectOptions{ContentType: "application/json"},
+	)
+	return appErr
+}
+
+func (uc *TusHandler) loadUpload(bucketName string, id string) (*entities.TusUploadEntity, *errors.AppError) {
+	object, appErr := uc.minioService.GetObject(bucketName, tusInfoObject(id), minio.GetObjectOptions{})
+	if appErr != nil {
+		return nil, appErr
+	}
+	defer object.Close()
+
+	var upload entities.TusUploadEntity
+	if err := json.NewDecoder(object).Decode(&upload); err != nil {
+		return nil, errors.NotFoundError()
+	}
+
+	// The state file is addressed by bucket and id from the URL;
+	// refuse one that claims to belong elsewhere.
+	if upload.ID != id || upload.Bucket != bucketName {
+		return nil, errors.NotFoundError()
+	}
+
+	return &upload, nil
+}
+
+// discard removes the state file of the upload held locks and then
+// every chunk of it. With the state file gone first, no PATCH can add
+// a chunk once the chunks were listed.
+func (uc *TusHandler) discard(bucketName string, held *tusLock) *errors.AppError {
+	if appErr := uc.minioService.RemoveObject(bucketName, tusInfoObject(held.id)); appErr != nil {
+		return appErr
+	}
+	held.discarded = true
+
+	chunks, appErr := uc.minioService.ListObjects(bucketName, fmt.Sprintf("%s%s/", tusStatePrefix, held.id))
+	if appErr != nil {
+		return appErr
+	}
+
+	for _, chunk := range chunks {
+		if appErr := uc.minioService.RemoveObject(bucketName, chunk.Key); appErr != nil {
+			return appErr
+		}
+	}
+	return nil
+}
+
+func tusInfoObject(id string) string {
+	return tusStatePrefix + id + tusInfoExtension
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// "key base64value" pairs, where the value may be omitted.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		switch len(parts) {
+		case 1:
+			metadata[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("Upload-Metadata value for %q is not valid base64", parts[0])
+			}
+			metadata[parts[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("malformed Upload-Metadata pair %q", pair)
+		}
+	}
+
+	return metadata, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
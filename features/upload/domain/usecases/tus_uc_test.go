@@ -0,0 +1,526 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	uploadEntities "github.com/RodolfoBonis/rb-cdn/features/upload/domain/entities"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	os.Setenv("NEW_RELIC_LICENSE_KEY", "40charslicensekeyrequiredfortestingpurpo")
+	gin.SetMode(gin.TestMode)
+	logger.InitLogger()
+}
+
+type fakeObject struct {
+	data []byte
+	info minio.ObjectInfo
+}
+
+// fakeMinio keeps objects in memory, keyed by bucket and object name.
+// The embedded interface is never set; it only supplies the unexported
+// method, and calling anything not implemented here panics.
+type fakeMinio struct {
+	services.IMinioService
+
+	mu      sync.Mutex
+	objects map[string]map[string]*fakeObject
+}
+
+func newFakeMinio(buckets ...string) *fakeMinio {
+	fake := &fakeMinio{objects: map[string]map[string]*fakeObject{}}
+	for _, bucket := range buckets {
+		fake.objects[bucket] = map[string]*fakeObject{}
+	}
+	return fake
+}
+
+// store writes an object; metadata is user metadata, which MinIO
+// reports back under X-Amz-Meta- headers.
+func (f *fakeMinio) store(bucket string, objectName string, data []byte, contentType string, metadata map[string]string) *fakeObject {
+	header := http.Header{}
+	for key, value := range metadata {
+		header.Set("X-Amz-Meta-"+key, value)
+	}
+	return f.storeInfo(bucket, objectName, data, contentType, header)
+}
+
+func (f *fakeMinio) storeInfo(bucket string, objectName string, data []byte, contentType string, metadata http.Header) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	object := &fakeObject{
+		data: data,
+		info: minio.ObjectInfo{
+			Key:          objectName,
+			Size:         int64(len(data)),
+			ETag:         fmt.Sprintf("%x", md5.Sum(data)),
+			ContentType:  contentType,
+			LastModified: time.Now().UTC(),
+			Metadata:     metadata,
+		},
+	}
+	f.objects[bucket][objectName] = object
+	return object
+}
+
+func (f *fakeMinio) lookup(bucket string, objectName string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[bucket][objectName]
+}
+
+func (f *fakeMinio) keys(bucket string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects[bucket]))
+	for key := range f.objects[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeMinio) UploadObject(bucket string, file entities.FileEntity, options minio.PutObjectOptions) (string, *errors.AppError) {
+	return "", errors.ServiceError("UploadObject is not faked")
+}
+
+func (f *fakeMinio) UploadObjectStream(ctx context.Context, bucket string, objectName string, reader io.Reader, options minio.PutObjectOptions) (*entities.StoredObjectEntity, *errors.AppError) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.ServiceError(err.Error())
+	}
+	object := f.store(bucket, objectName, data, options.ContentType, options.UserMetadata)
+	return &entities.StoredObjectEntity{Bucket: bucket, Key: objectName, ETag: object.info.ETag, Size: object.info.Size}, nil
+}
+
+func (f *fakeMinio) PutObject(bucket string, objectName string, reader io.Reader, size int64, options minio.PutObjectOptions) (int64, *errors.AppError) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, errors.ServiceError(err.Error())
+	}
+	f.store(bucket, objectName, data, options.ContentType, options.UserMetadata)
+	return int64(len(data)), nil
+}
+
+func (f *fakeMinio) RemoveObject(bucket string, objectName string) *errors.AppError {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects[bucket], objectName)
+	return nil
+}
+
+func (f *fakeMinio) CopyObject(bucket string, source string, destination string, options *minio.PutObjectOptions) *errors.AppError {
+	object := f.lookup(bucket, source)
+	if object == nil {
+		return errors.NotFoundError()
+	}
+
+	if options != nil {
+		f.store(bucket, destination, object.data, options.ContentType, options.UserMetadata)
+		return nil
+	}
+	f.storeInfo(bucket, destination, object.data, object.info.ContentType, object.info.Metadata.Clone())
+	return nil
+}
+
+func (f *fakeMinio) SetObjectMetadata(bucket string, objectName string, metadata map[string]string) *errors.AppError {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	object := f.objects[bucket][objectName]
+	if object == nil {
+		return errors.NotFoundError()
+	}
+	for key, value := range metadata {
+		object.info.Metadata.Set("X-Amz-Meta-"+key, value)
+	}
+	return nil
+}
+
+func (f *fakeMinio) ObjectExists(bucket string, objectName string) (bool, *errors.AppError) {
+	return f.lookup(bucket, objectName) != nil, nil
+}
+
+func (f *fakeMinio) LookupObject(bucket string, objectName string) (*minio.ObjectInfo, *errors.AppError) {
+	object := f.lookup(bucket, objectName)
+	if object == nil {
+		return nil, nil
+	}
+	info := object.info
+	return &info, nil
+}
+
+func (f *fakeMinio) ListObjects(bucket string, prefix string) ([]minio.ObjectInfo, *errors.AppError) {
+	var infos []minio.ObjectInfo
+	for _, key := range f.keys(bucket) {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, f.lookup(bucket, key).info)
+		}
+	}
+	return infos, nil
+}
+
+func (f *fakeMinio) GetObject(bucket string, objectName string, options minio.GetObjectOptions) (services.Object, *errors.AppError) {
+	object := f.lookup(bucket, objectName)
+	if object == nil {
+		return nil, errors.NotFoundError()
+	}
+	return &fakeReader{Reader: bytes.NewReader(object.data), info: object.info}, nil
+}
+
+func (f *fakeMinio) GetObjectURL(bucket string, objectName string, expiry time.Duration, reqParams url.Values) (string, *errors.AppError) {
+	return fmt.Sprintf("https://minio.test/%s/%s", bucket, objectName), nil
+}
+
+func (f *fakeMinio) GetObjectInfo(bucket string, objectName string) (*minio.ObjectInfo, *errors.AppError) {
+	object := f.lookup(bucket, objectName)
+	if object == nil {
+		return nil, errors.NotFoundError()
+	}
+	info := object.info
+	return &info, nil
+}
+
+func (f *fakeMinio) ListBuckets() ([]minio.BucketInfo, *errors.AppError) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var buckets []minio.BucketInfo
+	for name := range f.objects {
+		buckets = append(buckets, minio.BucketInfo{Name: name})
+	}
+	return buckets, nil
+}
+
+type fakeReader struct {
+	*bytes.Reader
+	info minio.ObjectInfo
+}
+
+func (r *fakeReader) Close() error                    { return nil }
+func (r *fakeReader) Stat() (minio.ObjectInfo, error) { return r.info, nil }
+
+// newTusRouter wires the tus handler the way routes does, minus the
+// auth middlewares; every caller may write to every bucket.
+func newTusRouter(minioService services.IMinioService) (*gin.Engine, *TusHandler) {
+	handler := NewTusHandler(minioService, logger.Log)
+	handler.authorizer = func(c *gin.Context, bucketName string) bool { return true }
+
+	router := gin.New()
+	router.POST("/upload/tus", handler.Create)
+	router.HEAD("/upload/tus/:bucket/:id", handler.Head)
+	router.PATCH("/upload/tus/:bucket/:id", handler.Patch)
+	router.DELETE("/upload/tus/:bucket/:id", handler.Terminate)
+	return router, handler
+}
+
+func tusRequest(router *gin.Engine, method string, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, bytes.NewReader(body))
+	request.Header.Set("Tus-Resumable", tusVersion)
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func tusMetadata(values map[string]string) string {
+	pairs := make([]string, 0, len(values))
+	for key, value := range values {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// createTusUpload creates an upload of length bytes in bucket "media"
+// and returns its id.
+func createTusUpload(t *testing.T, router *gin.Engine, length int) string {
+	t.Helper()
+
+	recorder := tusRequest(router, http.MethodPost, "/upload/tus", nil, map[string]string{
+		"Upload-Length":   fmt.Sprint(length),
+		"Upload-Metadata": tusMetadata(map[string]string{"bucket": "media", "filename": "notes.txt", "filetype": "text/plain"}),
+	})
+	require.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+
+	location := recorder.Header().Get("Location")
+	require.Contains(t, location, "/upload/tus/media/")
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+func patchTus(router *gin.Engine, id string, offset int, body []byte) *httptest.ResponseRecorder {
+	return tusRequest(router, http.MethodPatch, "/upload/tus/media/"+id, body, map[string]string{
+		"Content-Type":  tusContentType,
+		"Upload-Offset": fmt.Sprint(offset),
+	})
+}
+
+func loadTusState(t *testing.T, minioService *fakeMinio, id string) uploadEntities.TusUploadEntity {
+	t.Helper()
+
+	object := minioService.lookup("media", tusInfoObject(id))
+	require.NotNil(t, object, "state file for %s", id)
+
+	var upload uploadEntities.TusUploadEntity
+	require.NoError(t, json.Unmarshal(object.data, &upload))
+	return upload
+}
+
+func TestTusCreate(t *testing.T) {
+	minioService := newFakeMinio("media")
+	router, _ := newTusRouter(minioService)
+
+	id := createTusUpload(t, router, 11)
+
+	upload := loadTusState(t, minioService, id)
+	assert.Equal(t, "media", upload.Bucket)
+	assert.Equal(t, "notes.txt", upload.Filename)
+	assert.Equal(t, "text/plain", upload.ContentType)
+	assert.Equal(t, int64(11), upload.Length)
+	assert.Zero(t, upload.Offset)
+	assert.True(t, upload.ExpiresAt.After(time.Now()))
+
+	head := tusRequest(router, http.MethodHead, "/upload/tus/media/"+id, nil, nil)
+	assert.Equal(t, http.StatusOK, head.Code)
+	assert.Equal(t, "0", head.Header().Get("Upload-Offset"))
+	assert.Equal(t, "11", head.Header().Get("Upload-Length"))
+}
+
+func TestTusCreateRejects(t *testing.T) {
+	t.Setenv("TUS_MAX_SIZE", "1024")
+	router, _ := newTusRouter(newFakeMinio("media"))
+	metadata := tusMetadata(map[string]string{"bucket": "media", "filename": "notes.txt"})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"missing length", map[string]string{"Upload-Metadata": metadata}, http.StatusBadRequest},
+		{"negative length", map[string]string{"Upload-Length": "-1", "Upload-Metadata": metadata}, http.StatusBadRequest},
+		{"length over Tus-Max-Size", map[string]string{"Upload-Length": "1025", "Upload-Metadata": metadata}, http.StatusRequestEntityTooLarge},
+		{"length overflowing int64", map[string]string{"Upload-Length": "9223372036854775808", "Upload-Metadata": metadata}, http.StatusBadRequest},
+		{"missing bucket", map[string]string{"Upload-Length": "1", "Upload-Metadata": tusMetadata(map[string]string{"filename": "notes.txt"})}, http.StatusBadRequest},
+		{"missing filename", map[string]string{"Upload-Length": "1", "Upload-Metadata": tusMetadata(map[string]string{"bucket": "media"})}, http.StatusBadRequest},
+		{"malformed metadata", map[string]string{"Upload-Length": "1", "Upload-Metadata": "bucket !!!"}, http.StatusBadRequest},
+		{"wrong protocol version", map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "1", "Upload-Metadata": metadata}, http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tusRequest(router, http.MethodPost, "/upload/tus", nil, tt.headers)
+			assert.Equal(t, tt.status, recorder.Code, recorder.Body.String())
+			assert.Empty(t, recorder.Header().Get("Location"))
+		})
+	}
+}
+
+func TestTusPatchOffsetMismatch(t *testing.T) {
+	minioService := newFakeMinio("media")
+	router, _ := newTusRouter(minioService)
+	id := createTusUpload(t, router, 11)
+
+	recorder := patchTus(router, id, 3, []byte("hello"))
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "server is at 0")
+
+	recorder = tusRequest(router, http.MethodPatch, "/upload/tus/media/"+id, []byte("hello"), map[string]string{
+		"Content-Type": tusContentType,
+	})
+	assert.Equal(t, http.StatusConflict, recorder.Code, "missing Upload-Offset")
+
+	upload := loadTusState(t, minioService, id)
+	assert.Zero(t, upload.Offset)
+	assert.Empty(t, upload.Chunks)
+}
+
+func TestTusPatchOutOfOrder(t *testing.T) {
+	minioService := newFakeMinio("media")
+	router, _ := newTusRouter(minioService)
+	id := createTusUpload(t, router, 11)
+
+	first := patchTus(router, id, 0, []byte("hello"))
+	require.Equal(t, http.StatusNoContent, first.Code, first.Body.String())
+	assert.Equal(t, "5", first.Header().Get("Upload-Offset"))
+
+	// A replay of the first PATCH and one that skips ahead both leave
+	// the stored chunks alone.
+	replay := patchTus(router, id, 0, []byte("HELLO"))
+	assert.Equal(t, http.StatusConflict, replay.Code)
+	ahead := patchTus(router, id, 8, []byte("rld"))
+	assert.Equal(t, http.StatusConflict, ahead.Code)
+
+	upload := loadTusState(t, minioService, id)
+	assert.Equal(t, int64(5), upload.Offset)
+	require.Len(t, upload.Chunks, 1)
+	assert.Equal(t, []byte("hello"), minioService.lookup("media", upload.Chunks[0].Object).data)
+
+	last := patchTus(router, id, 5, []byte(" world"))
+	require.Equal(t, http.StatusOK, last.Code, last.Body.String())
+
+	var response uploadEntities.UploadResponseEntity
+	require.NoError(t, json.Unmarshal(last.Body.Bytes(), &response))
+	stored := minioService.lookup("media", response.Key)
+	require.NotNil(t, stored, "final object %s", response.Key)
+	assert.Equal(t, []byte("hello world"), stored.data)
+
+	for _, key := range minioService.keys("media") {
+		assert.False(t, strings.HasPrefix(key, tusStatePrefix), "leftover tus state %s", key)
+	}
+}
+
+func TestTusPatchOverflow(t *testing.T) {
+	minioService := newFakeMinio("media")
+	router, _ := newTusRouter(minioService)
+	id := createTusUpload(t, router, 4)
+
+	recorder := patchTus(router, id, 0, []byte("hello"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	upload := loadTusState(t, minioService, id)
+	assert.Zero(t, upload.Offset)
+	assert.Empty(t, upload.Chunks)
+}
+
+func TestTusPatchContentType(t *testing.T) {
+	router, _ := newTusRouter(newFakeMinio("media"))
+	id := createTusUpload(t, router, 4)
+
+	recorder := tusRequest(router, http.MethodPatch, "/upload/tus/media/"+id, []byte("data"), map[string]string{
+		"Content-Type":  "application/octet-stream",
+		"Upload-Offset": "0",
+	})
+	assert.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+}
+
+func TestTusUnknownUpload(t *testing.T) {
+	router, _ := newTusRouter(newFakeMinio("media"))
+
+	head := tusRequest(router, http.MethodHead, "/upload/tus/media/missing", nil, nil)
+	assert.Equal(t, http.StatusNotFound, head.Code)
+	patch := patchTus(router, "missing", 0, []byte("data"))
+	assert.Equal(t, http.StatusNotFound, patch.Code)
+}
+
+func TestTusExpiry(t *testing.T) {
+	minioService := newFakeMinio("media")
+	router, handler := newTusRouter(minioService)
+	id := createTusUpload(t, router, 11)
+	require.Equal(t, http.StatusNoContent, patchTus(router, id, 0, []byte("hello")).Code)
+
+	// Move the upload into the past: the state says it expired and
+	// its state file hasn't been written for longer than
+	// TUS_EXPIRATION.
+	upload := loadTusState(t, minioService, id)
+	upload.ExpiresAt = time.Now().Add(-time.Minute)
+	payload, _ := json.Marshal(upload)
+	minioService.store("media", tusInfoObject(id), payload, "application/json", nil).info.LastModified = time.Now().Add(-48 * time.Hour)
+
+	head := tusRequest(router, http.MethodHead, "/upload/tus/media/"+id, nil, nil)
+	assert.Equal(t, http.StatusGone, head.Code)
+	patch := patchTus(router, id, 5, []byte(" world"))
+	assert.Equal(t, http.StatusGone, patch.Code)
+
+	fresh := createTusUpload(t, router, 3)
+
+	handler.PurgeExpired()
+
+	for _, key := range minioService.keys("media") {
+		assert.False(t, strings.HasPrefix(key, tusStatePrefix+id), "expired upload left %s", key)
+	}
+	assert.NotNil(t, minioService.lookup("media", tusInfoObject(fresh)), "active upload was purged")
+}
+
+func TestTusTerminate(t *testing.T) {
+	minioService := newFakeMinio("media")
+	router, _ := newTusRouter(minioService)
+	id := createTusUpload(t, router, 11)
+	require.Equal(t, http.StatusNoContent, patchTus(router, id, 0, []byte("hello")).Code)
+
+	recorder := tusRequest(router, http.MethodDelete, "/upload/tus/media/"+id, nil, nil)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Empty(t, minioService.keys("media"))
+
+	head := tusRequest(router, http.MethodHead, "/upload/tus/media/"+id, nil, nil)
+	assert.Equal(t, http.StatusNotFound, head.Code)
+}
+
+// removalLog records the objects removed, and whether the upload's lock
+// was still registered when each went.
+type removalLog struct {
+	*fakeMinio
+	handler *TusHandler
+	id      string
+	removed []string
+	locked  []bool
+}
+
+func (r *removalLog) RemoveObject(bucket string, objectName string) *errors.AppError {
+	_, locked := r.handler.locks.Load(r.id)
+	r.removed = append(r.removed, objectName)
+	r.locked = append(r.locked, locked)
+	return r.fakeMinio.RemoveObject(bucket, objectName)
+}
+
+func TestTusTerminateRemovesStateFirst(t *testing.T) {
+	minioService := newFakeMinio("media")
+	router, _ := newTusRouter(minioService)
+	id := createTusUpload(t, router, 11)
+	require.Equal(t, http.StatusNoContent, patchTus(router, id, 0, []byte("hello")).Code)
+
+	recording := &removalLog{fakeMinio: minioService, id: id}
+	router, recording.handler = newTusRouter(recording)
+	// The upload was locked through this handler before.
+	recording.handler.lock(id).unlock()
+
+	recorder := tusRequest(router, http.MethodDelete, "/upload/tus/media/"+id, nil, nil)
+	require.Equal(t, http.StatusNoContent, recorder.Code)
+
+	require.Len(t, recording.removed, 2)
+	assert.Equal(t, tusInfoObject(id), recording.removed[0])
+	assert.Equal(t, []bool{true, true}, recording.locked, "lock dropped while held")
+	_, locked := recording.handler.locks.Load(id)
+	assert.False(t, locked)
+}
+
+func TestTusPurgeOrphanChunks(t *testing.T) {
+	minioService := newFakeMinio("media")
+	_, handler := newTusRouter(minioService)
+
+	orphan := tusStatePrefix + "gone/0"
+	minioService.store("media", orphan, []byte("chunk"), "application/octet-stream", nil).info.LastModified = time.Now().Add(-48 * time.Hour)
+	recent := tusStatePrefix + "discarding/0"
+	minioService.store("media", recent, []byte("chunk"), "application/octet-stream", nil)
+
+	handler.PurgeExpired()
+
+	assert.Nil(t, minioService.lookup("media", orphan))
+	assert.NotNil(t, minioService.lookup("media", recent))
+}
@@ -0,0 +1,65 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+)
+
+// analyze analyses an image upload and records the analysis on it, in
+// buckets that ask for it. An upload checked for near-duplicates was
+// analysed then, inspected, and is recorded whatever the bucket's
+// analyze setting, so later uploads are checked against it. A failure
+// is only logged: the image is stored either way, and a backfill job
+// can analyse it later.
+func (p *UploadPipeline) analyze(ctx context.Context, settings coreEntities.ImageSettingsEntity, stored *coreEntities.StoredObjectEntity, inspected *coreEntities.ImageAnalysisEntity) *coreEntities.ImageAnalysisEntity {
+	if inspected != nil {
+		if appErr := p.variants.Record(stored.Bucket, stored.Key, *inspected); appErr != nil {
+			p.log.Error(appErr.Message, appErr.ToMap())
+			return nil
+		}
+		return inspected
+	}
+
+	if !settings.Analyze || !imaging.Decodable(stored.ContentType) {
+		return nil
+	}
+
+	analysis, appErr := p.variants.Analyze(ctx, stored.Bucket, stored.Key)
+	if appErr != nil {
+		p.log.Error(appErr.Message, appErr.ToMap())
+		return nil
+	}
+	return analysis
+}
+
+// rejectDuplicates refuses the image staged under target when the
+// bucket holds one looking like it under another key than key, and
+// returns its analysis otherwise. Images that can't be analysed, animated
+// WebP or ones over the size limits for instance, can't be compared
+// and are let through.
+func (p *UploadPipeline) rejectDuplicates(ctx context.Context, settings coreEntities.ImageDuplicateSettingsEntity, request uploadRequest, target string, key string) (*coreEntities.ImageAnalysisEntity, *errors.AppError) {
+	analysis, appErr := p.variants.Inspect(ctx, request.Bucket, target)
+	if appErr != nil {
+		p.log.Error(appErr.Message, appErr.ToMap())
+		return nil, nil
+	}
+
+	hash, err := imaging.ParseHash(analysis.DHash)
+	if err != nil {
+		return nil, errors.UsecaseError(err.Error())
+	}
+
+	matches, appErr := p.variants.Similar(request.Bucket, hash, settings.Distance, key)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if len(matches) > 0 {
+		return nil, errors.ConflictError(fmt.Sprintf("%s is a near-duplicate of %s (%d bits apart)", request.Filename, matches[0].Key, matches[0].Distance))
+	}
+
+	return analysis, nil
+}
@@ -0,0 +1,198 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+)
+
+// maxFormFieldSize caps how much of a non-file form field is read
+// into memory; longer fields are refused rather than cut short.
+const maxFormFieldSize = 64 * 1024
+
+// errSpoolTooLarge is returned for a spooled file over the limit;
+// callers answer 413.
+var errSpoolTooLarge = errors.New("file exceeds the largest size a bucket accepts")
+
+// uploadForm is a multipart form carrying one "file" field. The file
+// is streamed straight from the request when the bucket is known by
+// the time it arrives; otherwise it is spooled to a temporary file so
+// the fields after it can still be read, as the form was parsed before
+// uploads were streamed.
+type uploadForm struct {
+	form   *multipart.Reader
+	fields map[string]string
+	part   *multipart.Part
+	spool  *os.File
+	// limit bounds the spooled copy: the bucket, and with it its
+	// permission check and size limits, is only known after it.
+	limit int64
+
+	// Filename and ContentType are the file part's.
+	Filename    string
+	ContentType string
+
+	// Trailing is set when a field turned up after a streamed file,
+	// too late to apply. Reading the file fails with it, so nothing
+	// is stored; callers answer 400 with it.
+	Trailing error
+	drained  bool
+}
+
+// readUploadForm reads form up to the file and, when the file has to
+// be spooled, to its end; a spooled file over limit bytes fails with
+// errSpoolTooLarge. fields holds the defaults taken from the query
+// string; form fields override them.
+func readUploadForm(form *multipart.Reader, fields map[string]string, limit int64) (*uploadForm, error) {
+	upload := &uploadForm{form: form, fields: fields, limit: limit}
+
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("file field is required")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "file" && part.FileName() != "" {
+			upload.part = part
+			upload.Filename = part.FileName()
+			upload.ContentType = part.Header.Get("Content-Type")
+			break
+		}
+
+		if err := upload.readField(part); err != nil {
+			return nil, err
+		}
+	}
+
+	if fields["bucket"] != "" {
+		return upload, nil
+	}
+
+	if err := upload.spoolFile(); err != nil {
+		upload.Close()
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Fields returns the plain form fields, merged over the query string
+// defaults.
+func (f *uploadForm) Fields() map[string]string {
+	return f.fields
+}
+
+// Read reads the file's body.
+func (f *uploadForm) Read(p []byte) (int, error) {
+	if f.spool != nil {
+		return f.spool.Read(p)
+	}
+
+	n, err := f.part.Read(p)
+	if err == io.EOF {
+		if trailing := f.drain(); trailing != nil {
+			return n, trailing
+		}
+	}
+	return n, err
+}
+
+// Close releases the file part and removes the spooled copy, if any.
+func (f *uploadForm) Close() error {
+	f.part.Close()
+	if f.spool != nil {
+		f.spool.Close()
+		return os.Remove(f.spool.Name())
+	}
+	return nil
+}
+
+// spoolFile copies the file part to a temporary file and reads the
+// fields that follow it.
+func (f *uploadForm) spoolFile() error {
+	spool, err := os.CreateTemp("", "rb-cdn-upload-*")
+	if err != nil {
+		return err
+	}
+	f.spool = spool
+
+	written, err := io.Copy(spool, io.LimitReader(f.part, f.limit+1))
+	if err != nil {
+		return err
+	}
+	if written > f.limit {
+		return errSpoolTooLarge
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	for {
+		part, err := f.form.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if part.FileName() != "" {
+			part.Close()
+			return fmt.Errorf("only one file field is accepted")
+		}
+		if err := f.readField(part); err != nil {
+			return err
+		}
+	}
+}
+
+// drain checks that nothing but the closing boundary follows a
+// streamed file.
+func (f *uploadForm) drain() error {
+	if f.drained {
+		return f.Trailing
+	}
+	f.drained = true
+
+	part, err := f.form.NextPart()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	part.Close()
+
+	f.Trailing = fmt.Errorf("form field %q comes after the file field; with the bucket sent before the file, every other field must precede it as well", part.FormName())
+	return f.Trailing
+}
+
+func (f *uploadForm) readField(part *multipart.Part) error {
+	value, err := readFormField(part)
+	if err != nil {
+		return err
+	}
+
+	f.fields[part.FormName()] = value
+	return nil
+}
+
+// readFormField reads a plain field, refusing one over
+// maxFormFieldSize.
+func readFormField(part *multipart.Part) (string, error) {
+	defer part.Close()
+
+	value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxFormFieldSize {
+		return "", fmt.Errorf("form field %q exceeds %d bytes", part.FormName(), maxFormFieldSize)
+	}
+
+	return string(value), nil
+}
@@ -0,0 +1,136 @@
+package usecases
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type formPart struct {
+	name, filename, value string
+}
+
+func multipartForm(t *testing.T, parts ...formPart) *multipart.Reader {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		var w io.Writer
+		var err error
+		if part.filename != "" {
+			w, err = writer.CreateFormFile(part.name, part.filename)
+		} else {
+			w, err = writer.CreateFormField(part.name)
+		}
+		require.NoError(t, err)
+		_, err = io.WriteString(w, part.value)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	return multipart.NewReader(&body, writer.Boundary())
+}
+
+func TestReadUploadFormStreamsAfterFields(t *testing.T) {
+	form := multipartForm(t,
+		formPart{name: "bucket", value: "media"},
+		formPart{name: "folder", value: "docs"},
+		formPart{name: "file", filename: "notes.txt", value: "hello"},
+	)
+
+	file, err := readUploadForm(form, map[string]string{"bucket": "", "folder": "query"}, 1<<20)
+	require.NoError(t, err)
+	defer file.Close()
+
+	assert.Nil(t, file.spool)
+	assert.Equal(t, "notes.txt", file.Filename)
+	assert.Equal(t, map[string]string{"bucket": "media", "folder": "docs"}, file.Fields())
+
+	body, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.NoError(t, file.Trailing)
+}
+
+func TestReadUploadFormSpoolsFileBeforeBucket(t *testing.T) {
+	form := multipartForm(t,
+		formPart{name: "file", filename: "notes.txt", value: "hello"},
+		formPart{name: "bucket", value: "media"},
+		formPart{name: "x-meta-owner", value: "ana"},
+	)
+
+	file, err := readUploadForm(form, map[string]string{"bucket": ""}, 1<<20)
+	require.NoError(t, err)
+
+	require.NotNil(t, file.spool)
+	assert.Equal(t, "media", file.Fields()["bucket"])
+	assert.Equal(t, "ana", file.Fields()["x-meta-owner"])
+
+	body, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	spool := file.spool.Name()
+	require.NoError(t, file.Close())
+	assert.NoFileExists(t, spool)
+}
+
+func TestReadUploadFormRefusesTrailingField(t *testing.T) {
+	form := multipartForm(t,
+		formPart{name: "file", filename: "notes.txt", value: "hello"},
+		formPart{name: "folder", value: "docs"},
+	)
+
+	// The bucket came in the query string, so the file is streamed and
+	// the folder after it can't be applied any more.
+	file, err := readUploadForm(form, map[string]string{"bucket": "media"}, 1<<20)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = io.ReadAll(file)
+	require.Error(t, err)
+	assert.Equal(t, file.Trailing, err)
+	assert.Contains(t, err.Error(), `"folder"`)
+}
+
+func TestReadUploadFormRefusesLongField(t *testing.T) {
+	form := multipartForm(t,
+		formPart{name: "bucket", value: "media"},
+		formPart{name: "x-meta-note", value: strings.Repeat("a", maxFormFieldSize+1)},
+		formPart{name: "file", filename: "notes.txt", value: "hello"},
+	)
+
+	_, err := readUploadForm(form, map[string]string{}, 1<<20)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"x-meta-note" exceeds`)
+
+	form = multipartForm(t,
+		formPart{name: "bucket", value: "media"},
+		formPart{name: "x-meta-note", value: strings.Repeat("a", maxFormFieldSize)},
+		formPart{name: "file", filename: "notes.txt", value: "hello"},
+	)
+	file, err := readUploadForm(form, map[string]string{}, 1<<20)
+	require.NoError(t, err)
+	file.Close()
+}
+
+func TestReadUploadFormRequiresFile(t *testing.T) {
+	_, err := readUploadForm(multipartForm(t, formPart{name: "bucket", value: "media"}), map[string]string{}, 1<<20)
+	assert.EqualError(t, err, "file field is required")
+}
+
+func TestReadUploadFormLimitsSpool(t *testing.T) {
+	form := multipartForm(t,
+		formPart{name: "file", filename: "big.bin", value: strings.Repeat("a", 11)},
+		formPart{name: "bucket", value: "media"},
+	)
+
+	_, err := readUploadForm(form, map[string]string{}, 10)
+	assert.ErrorIs(t, err, errSpoolTooLarge)
+}
@@ -0,0 +1,111 @@
+package usecases
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/RodolfoBonis/rb-cdn/core/delivery"
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/minio/minio-go"
+)
+
+// maxRenameAttempts bounds the "name-N.ext" probing of the rename
+// collision policy.
+const maxRenameAttempts = 100
+
+// preconditions are an upload's If-Match / If-None-Match headers,
+// checked against whatever is stored under the resolved key. Checks
+// run before the write, so they narrow the race between two writers
+// of the same key rather than close it: MinIO has no conditional PUT.
+type preconditions struct {
+	IfMatch     string
+	IfNoneMatch string
+}
+
+func preconditionsFrom(header http.Header) preconditions {
+	return preconditions{
+		IfMatch:     strings.TrimSpace(header.Get("If-Match")),
+		IfNoneMatch: strings.TrimSpace(header.Get("If-None-Match")),
+	}
+}
+
+// check compares the headers with the ETag /cdn serves current with,
+// which for a content-addressed key is its digest, not the ETag of the
+// empty pointer.
+func (c preconditions) check(key string, current *minio.ObjectInfo) *errors.AppError {
+	etag := ""
+	if current != nil {
+		etag = delivery.ETag(*current)
+	}
+
+	if c.IfNoneMatch != "" && current != nil && (c.IfNoneMatch == "*" || sameETag(c.IfNoneMatch, etag)) {
+		return errors.PreconditionError(fmt.Sprintf("%s already exists", key))
+	}
+
+	if c.IfMatch != "" && (current == nil || (c.IfMatch != "*" && !sameETag(c.IfMatch, etag))) {
+		return errors.PreconditionError(fmt.Sprintf("%s does not match If-Match", key))
+	}
+
+	return nil
+}
+
+func sameETag(header string, etag string) bool {
+	return strings.Trim(strings.TrimPrefix(header, "W/"), `"`) == strings.Trim(etag, `"`)
+}
+
+// resolveKey renders the bucket's key template for request, then
+// applies preconditions and the collision policy to it.
+func (p *UploadPipeline) resolveKey(settings coreEntities.KeySettingsEntity, request uploadRequest, vars keys.Vars) (string, *errors.AppError) {
+	template, err := keys.Parse(settings.Template)
+	if err != nil {
+		return "", errors.EnvironmentError(err.Error())
+	}
+
+	key, err := template.Render(vars)
+	if err != nil {
+		return "", errors.EntityError(err.Error())
+	}
+
+	if prefix := keys.PrivatePrefix(key); prefix != "" {
+		return "", errors.EntityError(fmt.Sprintf("keys under %s are reserved", prefix))
+	}
+
+	current, appErr := p.minioService.LookupObject(request.Bucket, key)
+	if appErr != nil {
+		return "", appErr
+	}
+
+	if appErr := request.Preconditions.check(key, current); appErr != nil {
+		return "", appErr
+	}
+
+	// If-Match names the object being replaced, which is an explicit
+	// overwrite whatever the bucket's policy.
+	if current == nil || settings.Collision == coreEntities.KeyCollision.Overwrite || request.Preconditions.IfMatch != "" {
+		return key, nil
+	}
+
+	if settings.Collision == coreEntities.KeyCollision.Reject {
+		return "", errors.ConflictError(fmt.Sprintf("%s already exists", key))
+	}
+
+	for attempt := 1; attempt <= maxRenameAttempts; attempt++ {
+		candidate, err := keys.Normalize(keys.Renamed(key, attempt))
+		if err != nil {
+			return "", errors.EntityError(err.Error())
+		}
+
+		taken, appErr := p.minioService.ObjectExists(request.Bucket, candidate)
+		if appErr != nil {
+			return "", appErr
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+
+	return "", errors.ConflictError(fmt.Sprintf("no free name left for %s", key))
+}
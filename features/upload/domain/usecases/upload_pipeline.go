@@ -0,0 +1,558 @@
+package usecases
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/RodolfoBonis/rb-cdn/core/clamd"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/delivery"
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/imagemeta"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/keys"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/objectmeta"
+	"github.com/RodolfoBonis/rb-cdn/core/probe"
+	"github.com/RodolfoBonis/rb-cdn/core/progress"
+	"github.com/RodolfoBonis/rb-cdn/core/services"
+	"github.com/RodolfoBonis/rb-cdn/features/upload/domain/entities"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go"
+)
+
+// uploadRequest is one file on its way into MinIO, whichever route it
+// arrived through (form upload, tus, batch).
+type uploadRequest struct {
+	Bucket      string
+	Folder      string
+	Filename    string
+	ContentType string
+	// Metadata is what the client attached to the object; it has
+	// already passed objectmeta.Validate.
+	Metadata coreEntities.ObjectMetadataEntity
+	// Checksums the client declared for Body; a mismatch rejects the
+	// upload without touching the target key.
+	Checksums     cas.Checksums
+	Preconditions preconditions
+	Body          io.Reader
+	// Progress, when set, is told the bytes sent to MinIO and the
+	// stages the upload moves through. Ending it is up to the caller.
+	Progress *progress.Tracker
+	// Reversible keeps what the upload replaces until Commit or
+	// Rollback settles it; set for uploads that are part of an
+	// all-or-nothing batch.
+	Reversible bool
+}
+
+// storedUpload is what the pipeline hands back: the object as MinIO
+// sees it plus the response the client gets.
+type storedUpload struct {
+	Object   *coreEntities.StoredObjectEntity
+	Response entities.UploadResponseEntity
+
+	// snapshots maps each key a reversible upload wrote to a copy of
+	// what it held before, "" when it held nothing.
+	snapshots map[string]string
+	// replaced is the digest a reversible upload's content-addressed
+	// key pointed to before; that reference is only dropped on Commit.
+	replaced string
+}
+
+// UploadPipeline is the single path every upload takes into MinIO, so
+// the rules applied to an object don't depend on the route it came in
+// through. Authorisation stays with the handlers.
+type UploadPipeline struct {
+	minioService services.IMinioService
+	// scanner is nil when CLAMD_ADDRESS is unset.
+	scanner *clamd.Client
+	// variants analyses image uploads and derives their presets.
+	variants *imaging.Store
+	log      *logger.CustomLogger
+}
+
+func NewUploadPipeline(minioService services.IMinioService, log *logger.CustomLogger) *UploadPipeline {
+	pipeline := &UploadPipeline{
+		minioService: minioService,
+		variants:     imaging.NewStore(minioService, log),
+		log:          log,
+	}
+	if address := config.EnvClamdAddress(); address != "" {
+		pipeline.scanner = clamd.NewClient(address, config.EnvClamdTimeout())
+	}
+
+	return pipeline
+}
+
+// Store streams request.Body into bucket under the key the bucket's
+// template gives it. The body is hashed, and scanned where the bucket
+// asks for it, on the way; when the key depends on that hash, the
+// client declared checksums, the bucket scans, has a minimum size or
+// is content-addressed, the body is staged first and only moved to its
+// key once it has been verified.
+func (p *UploadPipeline) Store(ctx context.Context, request uploadRequest) (*storedUpload, *errors.AppError) {
+	settings := config.BucketSettings(request.Bucket)
+
+	template, err := keys.Parse(settings.Keys.Template)
+	if err != nil {
+		return nil, errors.EnvironmentError(err.Error())
+	}
+
+	vars := keys.Vars{
+		Folder:   request.Folder,
+		Filename: request.Filename,
+		ID:       uuid.NewString(),
+		Time:     time.Now(),
+	}
+
+	key := ""
+	if !template.NeedsDigest() {
+		resolved, appErr := p.placeKey(settings, request, vars)
+		if appErr != nil {
+			return nil, appErr
+		}
+		key = resolved
+	}
+
+	policy, appErr := p.applyPolicy(settings.Upload, request)
+	if appErr != nil {
+		return nil, appErr
+	}
+	request.ContentType = policy.ContentType
+
+	image, appErr := p.stripImage(settings.Strip, &request, policy, settings.Upload.MaxSize)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	contentAddressed := settings.Storage.ContentAddressed
+	scanned := settings.Scan.Enabled
+	// Near-duplicates are looked for before the image reaches its key,
+	// which a rejected upload mustn't overwrite.
+	deduplicated := settings.Images.Duplicates.Reject && imaging.Decodable(request.ContentType)
+	staged := key == "" || contentAddressed || scanned || deduplicated || request.Reversible || !request.Checksums.Empty() || settings.Upload.MinSize > 0
+
+	target := key
+	if staged {
+		target = cas.StagingKey(uuid.NewString())
+	}
+
+	body := cas.NewHashingReader(image)
+
+	var source io.Reader = body
+	var scan *scanJob
+	if scanned {
+		scan = p.startScan(ctx)
+		source = io.TeeReader(body, scan.writer)
+	}
+
+	p.log.Info(fmt.Sprintf("Sending %s to Bucket: %s", request.Filename, request.Bucket))
+	source = request.Progress.CountWritten(source)
+
+	stored, appErr := p.minioService.UploadObjectStream(ctx, request.Bucket, target, source, putOptions(request, nil))
+	if scan != nil {
+		scan.finish(appErr != nil)
+	}
+	if appErr != nil {
+		if policy.exceeded {
+			return nil, errors.PayloadTooLargeError(fmt.Sprintf("%s exceeds the bucket's max_size of %d bytes", request.Filename, settings.Upload.MaxSize))
+		}
+		return nil, appErr
+	}
+	stored.Digest = body.Digest()
+	stored.ContentType = request.ContentType
+
+	request.Progress.SetStage(progress.Stage.Verifying)
+
+	if err := request.Checksums.Verify(body); err != nil {
+		p.discard(request.Bucket, target)
+		return nil, errors.IntegrityError(err.Error())
+	}
+
+	if stored.Size < settings.Upload.MinSize {
+		p.discard(request.Bucket, target)
+		return nil, errors.PolicyError(fmt.Sprintf("%s is below the bucket's min_size of %d bytes", request.Filename, settings.Upload.MinSize))
+	}
+
+	var verdict map[string]string
+	if scan != nil {
+		verdict, appErr = p.applyVerdict(settings.Scan, request, target, scan)
+		if appErr != nil {
+			p.discard(request.Bucket, target)
+			return nil, appErr
+		}
+	}
+
+	if key == "" {
+		vars.Digest = stored.Digest
+		key, appErr = p.placeKey(settings, request, vars)
+		if appErr != nil {
+			p.discard(request.Bucket, target)
+			return nil, appErr
+		}
+	}
+
+	var inspected *coreEntities.ImageAnalysisEntity
+	if deduplicated {
+		inspected, appErr = p.rejectDuplicates(ctx, settings.Images.Duplicates, request, target, key)
+		if appErr != nil {
+			p.discard(request.Bucket, target)
+			return nil, appErr
+		}
+	}
+
+	var snapshots map[string]string
+	if request.Reversible {
+		written := []string{key}
+		if image.record != nil {
+			written = append(written, imagemeta.RecordKey(key))
+		}
+		snapshots, appErr = p.snapshot(request.Bucket, written)
+		if appErr != nil {
+			p.discard(request.Bucket, target)
+			return nil, appErr
+		}
+	}
+
+	request.Progress.SetStage(progress.Stage.Storing)
+
+	references, replaced := 0, ""
+	switch {
+	case contentAddressed:
+		references, replaced, appErr = p.link(request, stored, key, verdict)
+	case staged:
+		// The verdict is only known now; recording it means replacing
+		// the staged object's metadata on the way to the key.
+		var options *minio.PutObjectOptions
+		if verdict != nil {
+			withVerdict := putOptions(request, verdict)
+			options = &withVerdict
+		}
+		appErr = p.minioService.CopyObject(request.Bucket, target, key, options)
+		p.discard(request.Bucket, target)
+		stored.Key = key
+	}
+	if appErr != nil {
+		p.restore(request.Bucket, snapshots)
+		return nil, appErr
+	}
+
+	if image.record != nil {
+		p.saveStripRecord(request.Bucket, key, image.record)
+	}
+
+	request.Progress.SetStage(progress.Stage.Processing)
+
+	// Recording the analysis rewrites the object, so it comes before
+	// describe reads the ETag and version back.
+	analysis := p.analyze(ctx, settings.Images, stored, inspected)
+
+	p.describe(stored)
+
+	response := buildUploadResponse(request.Filename, stored)
+	response.Checksums = entities.UploadChecksumsEntity{
+		MD5:    hex.EncodeToString(body.MD5()),
+		SHA256: stored.Digest,
+	}
+	response.Digest = stored.Digest
+	response.Integrity = cas.SRI(body.SHA256())
+	response.References = references
+	response.MetadataStripped = image.stripped
+
+	facts := p.inspect(stored)
+	response.Width = facts.Width
+	response.Height = facts.Height
+	response.Duration = facts.Duration.Seconds()
+	response.VideoCodec = facts.VideoCodec
+	response.AudioCodec = facts.AudioCodec
+	response.Image = analysis
+
+	p.presets(settings.Images, stored, &response)
+
+	return &storedUpload{Object: stored, Response: response, snapshots: snapshots, replaced: replaced}, nil
+}
+
+// placeKey resolves the key for request and checks the folder it
+// lands in still has room.
+func (p *UploadPipeline) placeKey(settings coreEntities.BucketSettingsEntity, request uploadRequest, vars keys.Vars) (string, *errors.AppError) {
+	key, appErr := p.resolveKey(settings.Keys, request, vars)
+	if appErr != nil {
+		return "", appErr
+	}
+
+	if appErr := p.checkFolderQuota(request.Bucket, key, settings.Upload.MaxFilesPerFolder); appErr != nil {
+		return "", appErr
+	}
+
+	return key, nil
+}
+
+// link turns a staged body into a content-addressed object: the blob
+// is kept only if no identical one exists yet, the logical key becomes
+// a pointer to it and registers its ref marker. verdict, if any, is
+// recorded on the pointer. Returns how many keys now share the blob
+// and, for a reversible request, the digest the key pointed to before,
+// whose reference is left for Commit to drop.
+//
+// Ref markers are separate objects rather than a counter so that two
+// uploads of the same body never race on a read-modify-write.
+func (p *UploadPipeline) link(request uploadRequest, stored *coreEntities.StoredObjectEntity, logicalKey string, verdict map[string]string) (int, string, *errors.AppError) {
+	staging := stored.Key
+	defer p.discard(request.Bucket, staging)
+
+	// The ref marker goes in before the blob is checked: a release of
+	// the same digest that lists the refs after it keeps the blob, and
+	// one that listed them before and deleted it already is caught by
+	// the check, which copies the body in again from staging.
+	blob := cas.BlobKey(stored.Digest)
+	unlock := lockDigest(stored.Digest)
+	if _, appErr := p.minioService.PutObject(request.Bucket, cas.RefKey(stored.Digest, logicalKey), strings.NewReader(""), 0, minio.PutObjectOptions{}); appErr != nil {
+		unlock()
+		return 0, "", appErr
+	}
+	exists, appErr := p.minioService.ObjectExists(request.Bucket, blob)
+	if appErr == nil && !exists {
+		appErr = p.minioService.CopyObject(request.Bucket, staging, blob, nil)
+	}
+	unlock()
+	if appErr != nil {
+		return 0, "", appErr
+	}
+
+	// Re-uploading a key with a different body moves its reference.
+	replaced := ""
+	if previous, appErr := p.minioService.GetObjectInfo(request.Bucket, logicalKey); appErr == nil {
+		if digest, found := cas.PointerDigest(previous.Metadata); found {
+			switch {
+			case request.Reversible:
+				replaced = digest
+			case digest != stored.Digest:
+				p.release(request.Bucket, digest, logicalKey)
+			}
+		}
+	}
+
+	// The pointer carries this upload's metadata; the blob keeps
+	// whatever the first upload of the body had.
+	internal := map[string]string{cas.DigestMetadata: stored.Digest}
+	for key, value := range verdict {
+		internal[key] = value
+	}
+	pointer := putOptions(request, internal)
+	if _, appErr := p.minioService.PutObject(request.Bucket, logicalKey, strings.NewReader(""), 0, pointer); appErr != nil {
+		return 0, "", appErr
+	}
+
+	refs, appErr := p.minioService.ListObjects(request.Bucket, cas.RefPrefix(stored.Digest))
+	if appErr != nil {
+		return 0, "", appErr
+	}
+
+	stored.Key = logicalKey
+	stored.Blob = blob
+	return len(refs), replaced, nil
+}
+
+// describe fills in what only the stored object can tell: its version
+// ID on versioned buckets, and its final ETag, which moving a staged
+// body can change. The ETag is the one /cdn serves and preconditions
+// compare, so a content-addressed key reports its digest. Failures are
+// only logged; the object is stored either way.
+func (p *UploadPipeline) describe(stored *coreEntities.StoredObjectEntity) {
+	info, appErr := p.minioService.GetObjectInfo(stored.Bucket, stored.Key)
+	if appErr != nil {
+		p.log.Error(appErr.Message, appErr.ToMap())
+		return
+	}
+
+	// Unversioned buckets answer with the literal "null".
+	if version := info.Metadata.Get("X-Amz-Version-Id"); version != "null" {
+		stored.VersionID = version
+	}
+
+	stored.ETag = strings.Trim(delivery.ETag(*info), `"`)
+}
+
+// inspect probes a stored image, audio or video file for the facts the
+// upload response reports; other files are left alone.
+func (p *UploadPipeline) inspect(stored *coreEntities.StoredObjectEntity) probe.Facts {
+	family, _, _ := strings.Cut(stored.ContentType, "/")
+	if family != "image" && family != "audio" && family != "video" {
+		return probe.Facts{}
+	}
+
+	source := stored.Key
+	if stored.Blob != "" {
+		source = stored.Blob
+	}
+
+	object, appErr := p.minioService.GetObject(stored.Bucket, source, minio.GetObjectOptions{})
+	if appErr != nil {
+		p.log.Error(appErr.Message, appErr.ToMap())
+		return probe.Facts{}
+	}
+	defer object.Close()
+
+	return probe.Inspect(object, stored.Size)
+}
+
+// putOptions maps request's content type and metadata onto the
+// options of a put, adding internal on top of the client's metadata.
+func putOptions(request uploadRequest, internal map[string]string) minio.PutObjectOptions {
+	metadata := objectmeta.UserMetadata(request.Metadata)
+	for key, value := range internal {
+		metadata[key] = value
+	}
+
+	return minio.PutObjectOptions{
+		ContentType:        request.ContentType,
+		CacheControl:       request.Metadata.CacheControl,
+		ContentDisposition: request.Metadata.ContentDisposition,
+		ContentLanguage:    request.Metadata.ContentLanguage,
+		UserMetadata:       metadata,
+	}
+}
+
+// release drops logicalKey's reference on digest and deletes the blob
+// once nothing refers to it any more.
+func (p *UploadPipeline) release(bucket string, digest string, logicalKey string) {
+	unlock := lockDigest(digest)
+	defer unlock()
+
+	p.discard(bucket, cas.RefKey(digest, logicalKey))
+
+	refs, appErr := p.minioService.ListObjects(bucket, cas.RefPrefix(digest))
+	if appErr != nil {
+		p.log.Error(appErr.Message, appErr.ToMap())
+		return
+	}
+
+	if len(refs) == 0 {
+		p.discard(bucket, cas.BlobKey(digest))
+	}
+}
+
+// digestLocks serialises link and release per digest inside this
+// replica, so a blob is never deleted between a link finding it and
+// its ref marker landing. Across replicas link's re-check after writing
+// the marker covers the same race, short of a release whose delete is
+// still in flight when that check runs. Digests share one of a fixed
+// set of stripes, so the locks don't grow with the bucket.
+var digestLocks [256]sync.Mutex
+
+func lockDigest(digest string) func() {
+	stripe := fnv.New32a()
+	stripe.Write([]byte(digest))
+	mutex := &digestLocks[stripe.Sum32()%uint32(len(digestLocks))]
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+// discard removes a temporary or orphaned object; failures are only
+// logged since the upload's outcome no longer depends on them.
+func (p *UploadPipeline) discard(bucket string, objectName string) {
+	if appErr := p.minioService.RemoveObject(bucket, objectName); appErr != nil {
+		p.log.Error(appErr.Message, appErr.ToMap())
+	}
+}
+
+// snapshot copies what each of keys holds aside before a reversible
+// upload overwrites it.
+func (p *UploadPipeline) snapshot(bucket string, keys []string) (map[string]string, *errors.AppError) {
+	snapshots := make(map[string]string, len(keys))
+	for _, key := range keys {
+		info, appErr := p.minioService.LookupObject(bucket, key)
+		if appErr == nil && info != nil {
+			copied := cas.StagingKey(uuid.NewString())
+			appErr = p.minioService.CopyObject(bucket, key, copied, nil)
+			if appErr == nil {
+				snapshots[key] = copied
+				continue
+			}
+		}
+		if appErr != nil {
+			p.dropSnapshots(bucket, snapshots)
+			return nil, appErr
+		}
+		snapshots[key] = ""
+	}
+	return snapshots, nil
+}
+
+// restore puts the snapshots back over the keys they were taken of;
+// keys that held nothing are removed again. A snapshot that can't be
+// put back is kept, and the failure logged and returned.
+func (p *UploadPipeline) restore(bucket string, snapshots map[string]string) *errors.AppError {
+	var failed *errors.AppError
+	for key, snapshot := range snapshots {
+		if snapshot == "" {
+			if appErr := p.minioService.RemoveObject(bucket, key); appErr != nil {
+				p.log.Error(appErr.Message, appErr.ToMap())
+				failed = appErr
+			}
+			continue
+		}
+
+		if appErr := p.minioService.CopyObject(bucket, snapshot, key, nil); appErr != nil {
+			p.log.Error(appErr.Message, appErr.ToMap())
+			failed = appErr
+			continue
+		}
+		p.discard(bucket, snapshot)
+	}
+	return failed
+}
+
+func (p *UploadPipeline) dropSnapshots(bucket string, snapshots map[string]string) {
+	for _, snapshot := range snapshots {
+		if snapshot != "" {
+			p.discard(bucket, snapshot)
+		}
+	}
+}
+
+// Commit settles a reversible upload once its batch went through: the
+// snapshots go, and so does the reference the key held on the blob it
+// pointed to before.
+func (p *UploadPipeline) Commit(upload *storedUpload) {
+	object := upload.Object
+	p.dropSnapshots(object.Bucket, upload.snapshots)
+
+	if upload.replaced != "" && upload.replaced != object.Digest {
+		p.release(object.Bucket, upload.replaced, object.Key)
+	}
+}
+
+// Rollback undoes a reversible upload of a failed all-or-nothing
+// batch: every key it wrote gets back what it held before, or is
+// removed if it held nothing, its reference on a content-addressed
+// blob is dropped unless the key pointed to that blob already, the
+// presets derived from it are removed, and its image catalog row
+// follows what the key holds again.
+func (p *UploadPipeline) Rollback(upload *storedUpload) *errors.AppError {
+	object := upload.Object
+	if appErr := p.restore(object.Bucket, upload.snapshots); appErr != nil {
+		return appErr
+	}
+
+	if object.Blob != "" && upload.replaced != object.Digest {
+		p.release(object.Bucket, object.Digest, object.Key)
+	}
+
+	if appErr := p.variants.RemoveVariants(object.Bucket, object.Key); appErr != nil {
+		p.log.Error(appErr.Message, appErr.ToMap())
+	}
+	if appErr := p.variants.Recatalog(object.Bucket, object.Key); appErr != nil {
+		p.log.Error(appErr.Message, appErr.ToMap())
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package usecases
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/minio/minio-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withBucketSettings(t *testing.T, content string) {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "buckets.json")
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+
+	t.Setenv("BUCKET_SETTINGS_FILE", filename)
+	require.NoError(t, config.LoadBucketSettings())
+	t.Cleanup(func() {
+		os.Unsetenv("BUCKET_SETTINGS_FILE")
+		_ = config.LoadBucketSettings()
+	})
+}
+
+func storeText(t *testing.T, pipeline *UploadPipeline, body string, reversible bool) *storedUpload {
+	t.Helper()
+
+	stored, appErr := pipeline.Store(context.Background(), uploadRequest{
+		Bucket:      "media",
+		Folder:      "docs",
+		Filename:    "notes.txt",
+		ContentType: "text/plain",
+		Body:        strings.NewReader(body),
+		Reversible:  reversible,
+	})
+	require.Nil(t, appErr)
+	return stored
+}
+
+func assertNoSnapshots(t *testing.T, minioService *fakeMinio) {
+	t.Helper()
+	for _, key := range minioService.keys("media") {
+		assert.False(t, strings.HasPrefix(key, cas.StagingKey("")), "leftover %s", key)
+	}
+}
+
+func TestPipelineRollbackRestoresReplacedObject(t *testing.T) {
+	minioService := newFakeMinio("media")
+	pipeline := NewUploadPipeline(minioService, logger.Log)
+
+	original := storeText(t, pipeline, "old", false)
+	key := original.Object.Key
+
+	variant := imaging.VariantFolder(key) + "stale"
+	minioService.store("media", variant, []byte("variant"), "image/webp", nil)
+
+	replacement := storeText(t, pipeline, "new", true)
+	require.Equal(t, key, replacement.Object.Key)
+	assert.Equal(t, []byte("new"), minioService.lookup("media", key).data)
+
+	require.Nil(t, pipeline.Rollback(replacement))
+	assert.Equal(t, []byte("old"), minioService.lookup("media", key).data)
+	assert.Nil(t, minioService.lookup("media", variant), "presets of the rolled back upload")
+	assertNoSnapshots(t, minioService)
+}
+
+func TestPipelineRollbackRemovesNewObject(t *testing.T) {
+	minioService := newFakeMinio("media")
+	pipeline := NewUploadPipeline(minioService, logger.Log)
+
+	created := storeText(t, pipeline, "new", true)
+	require.Nil(t, pipeline.Rollback(created))
+
+	assert.Empty(t, minioService.keys("media"))
+}
+
+func TestPipelineCommitKeepsReplacement(t *testing.T) {
+	minioService := newFakeMinio("media")
+	pipeline := NewUploadPipeline(minioService, logger.Log)
+
+	key := storeText(t, pipeline, "old", false).Object.Key
+	pipeline.Commit(storeText(t, pipeline, "new", true))
+
+	assert.Equal(t, []byte("new"), minioService.lookup("media", key).data)
+	assertNoSnapshots(t, minioService)
+}
+
+func TestPipelineRollbackContentAddressed(t *testing.T) {
+	withBucketSettings(t, `{"buckets": {"media": {"storage": {"content_addressed": true}}}}`)
+	minioService := newFakeMinio("media")
+	pipeline := NewUploadPipeline(minioService, logger.Log)
+
+	original := storeText(t, pipeline, "old", false)
+	key, oldDigest := original.Object.Key, original.Object.Digest
+
+	replacement := storeText(t, pipeline, "new", true)
+	newDigest := replacement.Object.Digest
+	require.NotEqual(t, oldDigest, newDigest)
+
+	// Until the batch settles, the blob the key pointed to keeps its
+	// reference.
+	assert.NotNil(t, minioService.lookup("media", cas.BlobKey(oldDigest)))
+	assert.NotNil(t, minioService.lookup("media", cas.RefKey(oldDigest, key)))
+
+	require.Nil(t, pipeline.Rollback(replacement))
+
+	pointer := minioService.lookup("media", key)
+	require.NotNil(t, pointer)
+	digest, found := cas.PointerDigest(pointer.info.Metadata)
+	assert.True(t, found)
+	assert.Equal(t, oldDigest, digest)
+	assert.Equal(t, []byte("old"), minioService.lookup("media", cas.BlobKey(oldDigest)).data)
+	assert.NotNil(t, minioService.lookup("media", cas.RefKey(oldDigest, key)))
+	assert.Nil(t, minioService.lookup("media", cas.BlobKey(newDigest)))
+	assert.Nil(t, minioService.lookup("media", cas.RefKey(newDigest, key)))
+	assertNoSnapshots(t, minioService)
+}
+
+func TestPipelineRollbackContentAddressedSameBody(t *testing.T) {
+	withBucketSettings(t, `{"buckets": {"media": {"storage": {"content_addressed": true}}}}`)
+	minioService := newFakeMinio("media")
+	pipeline := NewUploadPipeline(minioService, logger.Log)
+
+	original := storeText(t, pipeline, "same", false)
+	require.Nil(t, pipeline.Rollback(storeText(t, pipeline, "same", true)))
+
+	digest := original.Object.Digest
+	assert.NotNil(t, minioService.lookup("media", cas.BlobKey(digest)))
+	assert.NotNil(t, minioService.lookup("media", cas.RefKey(digest, original.Object.Key)))
+}
+
+func TestPipelineCommitContentAddressedReleasesPrevious(t *testing.T) {
+	withBucketSettings(t, `{"buckets": {"media": {"storage": {"content_addressed": true}}}}`)
+	minioService := newFakeMinio("media")
+	pipeline := NewUploadPipeline(minioService, logger.Log)
+
+	original := storeText(t, pipeline, "old", false)
+	pipeline.Commit(storeText(t, pipeline, "new", true))
+
+	assert.Nil(t, minioService.lookup("media", cas.BlobKey(original.Object.Digest)))
+	assert.Nil(t, minioService.lookup("media", cas.RefKey(original.Object.Digest, original.Object.Key)))
+}
+
+// racingMinio deletes a blob the moment a ref marker for it is written,
+// as a release of the same digest on another replica would.
+type racingMinio struct {
+	*fakeMinio
+}
+
+func (r racingMinio) PutObject(bucket string, objectName string, reader io.Reader, size int64, options minio.PutObjectOptions) (int64, *errors.AppError) {
+	if ref, found := strings.CutPrefix(objectName, cas.Prefix+"refs/"); found {
+		digest, _, _ := strings.Cut(ref, "/")
+		r.RemoveObject(bucket, cas.BlobKey(digest))
+	}
+	return r.fakeMinio.PutObject(bucket, objectName, reader, size, options)
+}
+
+func TestPipelineLinkRecopiesReleasedBlob(t *testing.T) {
+	withBucketSettings(t, `{"buckets": {"media": {"storage": {"content_addressed": true}}}}`)
+	minioService := newFakeMinio("media")
+	pipeline := NewUploadPipeline(minioService, logger.Log)
+	storeText(t, pipeline, "shared", false)
+
+	racing := NewUploadPipeline(racingMinio{minioService}, logger.Log)
+	stored, appErr := racing.Store(context.Background(), uploadRequest{
+		Bucket:      "media",
+		Folder:      "other",
+		Filename:    "copy.txt",
+		ContentType: "text/plain",
+		Body:        strings.NewReader("shared"),
+	})
+	require.Nil(t, appErr)
+
+	blob := minioService.lookup("media", cas.BlobKey(stored.Object.Digest))
+	require.NotNil(t, blob, "pointer left without its blob")
+	assert.Equal(t, []byte("shared"), blob.data)
+}
+
+func TestPreconditionsUseServedETag(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	pointer := &minio.ObjectInfo{
+		ETag:     "d41d8cd98f00b204e9800998ecf8427e",
+		Metadata: http.Header{"X-Amz-Meta-" + cas.DigestMetadata: {digest}},
+	}
+
+	assert.Nil(t, preconditions{IfMatch: `"` + digest + `"`}.check("a.txt", pointer))
+	assert.NotNil(t, preconditions{IfMatch: `"d41d8cd98f00b204e9800998ecf8427e"`}.check("a.txt", pointer))
+	assert.NotNil(t, preconditions{IfNoneMatch: digest}.check("a.txt", pointer))
+
+	plain := &minio.ObjectInfo{ETag: "5d41402abc4b2a76b9719d911017c592"}
+	assert.Nil(t, preconditions{IfMatch: `"5d41402abc4b2a76b9719d911017c592"`}.check("a.txt", plain))
+	assert.NotNil(t, preconditions{IfMatch: `"other"`}.check("a.txt", plain))
+}
+
+func TestContentAddressedUploadReportsDigestETag(t *testing.T) {
+	withBucketSettings(t, `{"buckets": {"media": {"storage": {"content_addressed": true}}}}`)
+	minioService := newFakeMinio("media")
+	pipeline := NewUploadPipeline(minioService, logger.Log)
+
+	stored := storeText(t, pipeline, "hello", false)
+	assert.Equal(t, stored.Object.Digest, stored.Response.ETag)
+
+	// The ETag the response reported is the one If-Match accepts.
+	_, appErr := pipeline.Store(context.Background(), uploadRequest{
+		Bucket:        "media",
+		Folder:        "docs",
+		Filename:      "notes.txt",
+		ContentType:   "text/plain",
+		Preconditions: preconditions{IfMatch: `"` + stored.Response.ETag + `"`},
+		Body:          strings.NewReader("hello again"),
+	})
+	assert.Nil(t, appErr)
+}
@@ -0,0 +1,133 @@
+package usecases
+
+import (
+	"bufio"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/sniff"
+)
+
+var errUploadTooLarge = stdErrors.New("upload exceeds the bucket's max_size")
+
+// policyBody is an upload body that passed the checks the bucket's
+// upload policy can make up front. Type checks run on the first bytes
+// before anything is sent to MinIO; the size limit is enforced while
+// the body streams.
+type policyBody struct {
+	io.Reader
+	ContentType string
+	exceeded    bool
+}
+
+// applyPolicy checks request's name and content against policy. The
+// folder quota depends on the final key and is checked separately.
+func (p *UploadPipeline) applyPolicy(policy coreEntities.UploadPolicyEntity, request uploadRequest) (*policyBody, *errors.AppError) {
+	if !allowedExtension(request.Filename, policy.AllowedExtensions) {
+		return nil, errors.UnsupportedMediaError(fmt.Sprintf("extension of %s is not accepted by this bucket", request.Filename))
+	}
+
+	buffered := bufio.NewReaderSize(request.Body, sniff.HeadSize)
+	head, err := buffered.Peek(sniff.HeadSize)
+	if err != nil && err != io.EOF {
+		return nil, errors.EntityError(err.Error())
+	}
+
+	detected := sniff.Detect(head)
+	if !sniff.Compatible(sniff.ExtensionType(request.Filename), detected) {
+		return nil, errors.UnsupportedMediaError(fmt.Sprintf("content of %s is %s, which its extension does not allow", request.Filename, detected))
+	}
+
+	if !sniff.MatchType(detected, policy.AllowedTypes) {
+		return nil, errors.UnsupportedMediaError(fmt.Sprintf("type %s is not accepted by this bucket", detected))
+	}
+
+	// The sniffed type wins unless it only says "binary" or "text", in
+	// which case the client's more specific header is kept.
+	contentType := detected
+	if sniff.IsGeneric(detected) && request.ContentType != "" {
+		contentType = request.ContentType
+	}
+
+	body := &policyBody{ContentType: contentType}
+	body.Reader = buffered
+	if policy.MaxSize > 0 {
+		body.Reader = &maxSizeReader{reader: buffered, remaining: policy.MaxSize, body: body}
+	}
+
+	return body, nil
+}
+
+// checkFolderQuota refuses a new key once its folder already holds
+// limit files. Overwriting an existing key doesn't count.
+func (p *UploadPipeline) checkFolderQuota(bucket string, key string, limit int) *errors.AppError {
+	if limit == 0 {
+		return nil
+	}
+
+	prefix := path.Dir(key) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+
+	objects, appErr := p.minioService.ListObjects(bucket, prefix)
+	if appErr != nil {
+		return appErr
+	}
+
+	files := 0
+	for _, object := range objects {
+		if object.Key == key {
+			return nil
+		}
+		if !strings.Contains(strings.TrimPrefix(object.Key, prefix), "/") {
+			files++
+		}
+	}
+
+	if files >= limit {
+		return errors.PolicyError(fmt.Sprintf("folder %s already holds the maximum of %d files", strings.TrimSuffix(prefix, "/"), limit))
+	}
+
+	return nil
+}
+
+func allowedExtension(filename string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	extension := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	for _, candidate := range allowed {
+		if strings.TrimPrefix(strings.ToLower(candidate), ".") == extension {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxSizeReader fails the read that goes past the limit, which aborts
+// the multipart upload instead of storing a truncated object.
+type maxSizeReader struct {
+	reader    io.Reader
+	remaining int64
+	body      *policyBody
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		r.body.exceeded = true
+		return n, errUploadTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,57 @@
+package usecases
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/imaging"
+	"github.com/RodolfoBonis/rb-cdn/features/upload/domain/entities"
+)
+
+// presets lists the bucket's image presets of an image upload in
+// response, with a srcset of their widths, and starts deriving them.
+// Clients may request a preset before it is ready: /cdn derives it
+// then.
+func (p *UploadPipeline) presets(settings coreEntities.ImageSettingsEntity, stored *coreEntities.StoredObjectEntity, response *entities.UploadResponseEntity) {
+	if len(settings.Presets) == 0 || !imaging.Decodable(stored.ContentType) {
+		return
+	}
+
+	type candidate struct {
+		url   string
+		width int
+	}
+
+	response.Variants = map[string]string{}
+	var candidates []candidate
+	for _, name := range slices.Sorted(maps.Keys(settings.Presets)) {
+		url := response.CDNURL + "@" + name
+		response.Variants[name] = url
+
+		// The width the variant comes out at: presets never scale up,
+		// so it depends on the image when its size is known.
+		width := settings.Presets[name].Width
+		if response.Width > 0 && response.Height > 0 {
+			width, _ = imaging.PresetParams(settings.Presets[name]).Size(response.Width, response.Height)
+		}
+		if width > 0 {
+			candidates = append(candidates, candidate{url: url, width: width})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].width < candidates[j].width })
+	var srcset []string
+	for i, entry := range candidates {
+		if i > 0 && entry.width == candidates[i-1].width {
+			continue
+		}
+		srcset = append(srcset, fmt.Sprintf("%s %dw", entry.url, entry.width))
+	}
+	response.Srcset = strings.Join(srcset, ", ")
+
+	p.variants.DerivePresets(stored.Bucket, stored.Key, settings.Presets)
+}
@@ -0,0 +1,69 @@
+package usecases
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/RodolfoBonis/rb-cdn/core/middlewares"
+	"github.com/RodolfoBonis/rb-cdn/core/progress"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// progressHeader carries the id an upload's progress is published
+// under. Clients that want to follow an upload while it is still
+// running pick the id themselves; otherwise one is made up and only
+// known from the response.
+const progressHeader = "X-Progress-Id"
+
+// trackProgress opens the tracker of c's upload under the id from
+// X-Progress-Id or the progress_id query, else fallback, else a new
+// one, and echoes it in X-Progress-Id. It answers the request itself
+// and returns false when the client's id is malformed or in use.
+func trackProgress(c *gin.Context, fallback string, total int64) (*progress.Tracker, bool) {
+	id := firstNonEmpty(c.GetHeader(progressHeader), c.Query("progress_id"), fallback, uuid.NewString())
+	if !progress.ValidID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("%s must be 1 to 64 letters, digits, dashes or underscores", progressHeader),
+		})
+		return nil, false
+	}
+
+	if total < 0 {
+		total = 0
+	}
+
+	tracker, err := progress.Default.Start(id, middlewares.RequestIdentity(c.Request), total)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s %s: %s", progressHeader, id, err)})
+		return nil, false
+	}
+
+	c.Header(progressHeader, id)
+	return tracker, true
+}
+
+// trackUpload tracks an upload whose request body is the file, or the
+// form carrying it: what is read of the body counts as received. A
+// request that ends without reaching Done is reported failed with its
+// status.
+func trackUpload(c *gin.Context) (*progress.Tracker, func(), bool) {
+	tracker, ok := trackProgress(c, "", c.Request.ContentLength)
+	if !ok {
+		return nil, nil, false
+	}
+
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{tracker.CountReceived(c.Request.Body), c.Request.Body}
+
+	return tracker, func() { failUnlessDone(c, tracker) }, true
+}
+
+// failUnlessDone reports a request that ended without its tracker
+// reaching Done as failed; after Done it does nothing.
+func failUnlessDone(c *gin.Context, tracker *progress.Tracker) {
+	tracker.Fail(fmt.Sprintf("request ended with status %d", c.Writer.Status()))
+}
@@ -0,0 +1,116 @@
+package usecases
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+
+	"github.com/RodolfoBonis/rb-cdn/core/clamd"
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+)
+
+// quarantinedAsMetadata records the name a quarantined upload came in
+// under.
+const quarantinedAsMetadata = "Quarantined-Filename"
+
+var (
+	errScannerMissing = stdErrors.New("virus scanning is required by this bucket but CLAMD_ADDRESS is not set")
+	errUploadAborted  = stdErrors.New("upload aborted")
+)
+
+// scanJob is a scan running alongside an upload: the body is teed into
+// it while it streams to MinIO, so scanning costs no second pass.
+type scanJob struct {
+	writer *io.PipeWriter
+	done   chan struct{}
+	result clamd.Result
+	err    error
+}
+
+func (p *UploadPipeline) startScan(ctx context.Context) *scanJob {
+	reader, writer := io.Pipe()
+	job := &scanJob{writer: writer, done: make(chan struct{})}
+
+	go func() {
+		defer close(job.done)
+
+		if p.scanner == nil {
+			job.err = errScannerMissing
+		} else {
+			job.result, job.err = p.scanner.Scan(ctx, reader)
+		}
+
+		// The scanner may stop reading early; the upload must not
+		// stall on the other end of the pipe.
+		io.Copy(io.Discard, reader)
+	}()
+
+	return job
+}
+
+// finish ends the scanned stream and waits for the verdict. An aborted
+// upload only releases the scan; its verdict is meaningless.
+func (j *scanJob) finish(aborted bool) {
+	if aborted {
+		j.writer.CloseWithError(errUploadAborted)
+	} else {
+		j.writer.Close()
+	}
+	<-j.done
+}
+
+// applyVerdict decides what happens to the staged upload after its
+// scan. A publishable upload gets back the metadata recording its
+// verdict; anything else is moved to quarantine where the bucket asks
+// for it, and the upload fails.
+func (p *UploadPipeline) applyVerdict(settings coreEntities.ScanSettingsEntity, request uploadRequest, staging string, job *scanJob) (map[string]string, *errors.AppError) {
+	switch {
+	case job.err != nil:
+		p.log.Warning(fmt.Sprintf("Scan of %s failed: %s", request.Filename, job.err), map[string]interface{}{"bucket": request.Bucket})
+
+		verdict := map[string]string{clamd.StatusMetadata: clamd.Status.Unscanned}
+		switch settings.OnError {
+		case coreEntities.ScanAction.Publish:
+			return verdict, nil
+		case coreEntities.ScanAction.Quarantine:
+			if appErr := p.quarantine(request, staging, verdict); appErr != nil {
+				return nil, appErr
+			}
+			return nil, errors.UnavailableError(fmt.Sprintf("%s could not be scanned and was quarantined for review", request.Filename))
+		default:
+			return nil, errors.UnavailableError(fmt.Sprintf("%s could not be scanned: %s", request.Filename, job.err))
+		}
+
+	case job.result.Infected:
+		p.log.Warning(fmt.Sprintf("%s is infected with %s", request.Filename, job.result.Signature), map[string]interface{}{"bucket": request.Bucket})
+
+		if settings.OnInfected == coreEntities.ScanAction.Quarantine {
+			verdict := map[string]string{
+				clamd.StatusMetadata:    clamd.Status.Infected,
+				clamd.SignatureMetadata: job.result.Signature,
+			}
+			if appErr := p.quarantine(request, staging, verdict); appErr != nil {
+				return nil, appErr
+			}
+			return nil, errors.InfectedError(fmt.Sprintf("%s is infected with %s and was quarantined", request.Filename, job.result.Signature))
+		}
+		return nil, errors.InfectedError(fmt.Sprintf("%s is infected with %s", request.Filename, job.result.Signature))
+
+	default:
+		return map[string]string{clamd.StatusMetadata: clamd.Status.Clean}, nil
+	}
+}
+
+// quarantine keeps a copy of the staged upload under the quarantine
+// prefix, which nothing serves, along with its verdict and the name it
+// was uploaded as.
+func (p *UploadPipeline) quarantine(request uploadRequest, staging string, verdict map[string]string) *errors.AppError {
+	verdict[quarantinedAsMetadata] = mime.QEncoding.Encode("utf-8", path.Join(request.Folder, request.Filename))
+
+	options := putOptions(request, verdict)
+	return p.minioService.CopyObject(request.Bucket, staging, clamd.QuarantinePrefix+path.Base(staging), &options)
+}
@@ -0,0 +1,85 @@
+package usecases
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
+	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/imagemeta"
+	"github.com/minio/minio-go"
+)
+
+// strippedImage is an image upload with its metadata removed.
+type strippedImage struct {
+	io.Reader
+	stripped bool
+	// record is what was removed, set only when the bucket keeps it.
+	record *imagemeta.Record
+}
+
+// stripImage removes EXIF, XMP and IPTC metadata from an image upload
+// before anything reaches MinIO. The image is buffered for it, up to
+// STRIP_MAX_SIZE. Checksums the client declared describe the original
+// bytes, so they are verified here and cleared from request.
+func (p *UploadPipeline) stripImage(settings coreEntities.StripSettingsEntity, request *uploadRequest, body *policyBody, maxSize int64) (*strippedImage, *errors.AppError) {
+	if !settings.Enabled || !imagemeta.Supported(body.ContentType) {
+		return &strippedImage{Reader: body}, nil
+	}
+
+	limit := config.EnvStripMaxSize()
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if body.exceeded {
+		return nil, errors.PayloadTooLargeError(fmt.Sprintf("%s exceeds the bucket's max_size of %d bytes", request.Filename, maxSize))
+	}
+	if err != nil {
+		return nil, errors.EntityError(err.Error())
+	}
+	if int64(len(data)) > limit {
+		return nil, errors.PayloadTooLargeError(fmt.Sprintf("%s is larger than the %d bytes this bucket strips image metadata from", request.Filename, limit))
+	}
+
+	if !request.Checksums.Empty() {
+		original := cas.NewHashingReader(bytes.NewReader(data))
+		if _, err := io.Copy(io.Discard, original); err != nil {
+			return nil, errors.EntityError(err.Error())
+		}
+		if err := request.Checksums.Verify(original); err != nil {
+			return nil, errors.IntegrityError(err.Error())
+		}
+		request.Checksums = cas.Checksums{}
+	}
+
+	result, err := imagemeta.Strip(data, body.ContentType)
+	if err != nil {
+		return nil, errors.UnsupportedMediaError(fmt.Sprintf("%s: %s", request.Filename, err))
+	}
+
+	image := &strippedImage{Reader: bytes.NewReader(result.Data), stripped: result.Stripped}
+	if result.Stripped && settings.KeepRecord {
+		image.record = &result.Record
+	}
+
+	return image, nil
+}
+
+// saveStripRecord keeps what was stripped from key's upload. Failing
+// to do so is logged but doesn't fail an upload that is already
+// stored: the record is a convenience, the stripping is what matters.
+func (p *UploadPipeline) saveStripRecord(bucket string, key string, record *imagemeta.Record) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		p.log.Error(err.Error(), map[string]interface{}{"bucket": bucket, "key": key})
+		return
+	}
+
+	if _, appErr := p.minioService.PutObject(bucket, imagemeta.RecordKey(key), bytes.NewReader(payload), int64(len(payload)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	}); appErr != nil {
+		p.log.Error(appErr.Message, appErr.ToMap())
+	}
+}
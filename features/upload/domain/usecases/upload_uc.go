@@ -1,45 +1,69 @@
 package usecases
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strings"
 
 	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/cas"
 	"github.com/RodolfoBonis/rb-cdn/core/config"
 	coreEntities "github.com/RodolfoBonis/rb-cdn/core/entities"
 	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/objectmeta"
 	"github.com/RodolfoBonis/rb-cdn/core/services"
 	"github.com/RodolfoBonis/rb-cdn/features/upload/domain/entities"
 	"github.com/gin-gonic/gin"
-	"github.com/minio/minio-go"
 )
 
 type UploadHandler struct {
 	minioService services.IMinioService
+	pipeline     *UploadPipeline
 	log          *logger.CustomLogger
 }
 
 func NewUploadHandler(minioService services.IMinioService, log *logger.CustomLogger) *UploadHandler {
-	return &UploadHandler{minioService: minioService, log: log}
+	return &UploadHandler{
+		minioService: minioService,
+		pipeline:     NewUploadPipeline(minioService, log),
+		log:          log,
+	}
 }
 
 // Upload godoc
 // @Summary Upload a file to CDN
-// @Description Uploads a file to the CDN storage and returns the access URL
+// @Description Uploads a file to the CDN storage and returns the access URL. The body is streamed to MinIO as it arrives when the bucket (a form field or query parameter) comes before the file field; the folder and metadata fields must then precede the file too, and a field after it answers 400. A file sent before the bucket field is buffered to disk first, so the fields may follow it; one larger than every bucket accepts answers 413. Fields over 64 KiB answer 400. Buckets with scanning enabled check the file with clamd first: infected files answer 422, and 503 means the scan could not complete. Buckets that strip image metadata remove EXIF, XMP and IPTC from JPEG, PNG, WebP and HEIF files before storing them. For images in buckets with image presets, the response lists each preset's /cdn URL (the object URL followed by "@<preset>") under variants, plus a srcset of them; presets are derived in the background. In buckets with images.analyze set, images are analysed for their upright size, dominant colour, BlurHash and DHash, returned under image; buckets rejecting near-duplicates answer 409 for an image whose DHash is within images.duplicates.distance bits of another image's.
 // @Tags upload
 // @Accept multipart/form-data
 // @Produce json
 // @Param file formData file true "File to upload"
 // @Param bucket formData string true "Bucket name"
 // @Param folder formData string false "Folder name (optional)"
+// @Param x-meta-name formData string false "User metadata; repeat with any lowercase name (also accepted as X-Meta-Name headers)"
+// @Param tags formData string false "Object tags as k1=v1&k2=v2 (also accepted as an X-Tags header)"
+// @Param cache_control formData string false "Cache-Control to serve the object with"
+// @Param content_disposition formData string false "Content-Disposition to serve the object with"
+// @Param content_language formData string false "Content-Language to serve the object with"
+// @Param Content-MD5 header string false "Base64 MD5 of the file; the upload is rejected on mismatch"
+// @Param X-Checksum-SHA256 header string false "Hex or base64 SHA-256 of the file; the upload is rejected on mismatch"
+// @Param If-None-Match header string false "* to only create, never replace"
+// @Param If-Match header string false "ETag the object being replaced must have"
+// @Param X-Progress-Id header string false "Id to follow the upload's progress under at GET /progress/{id}; one is made up and echoed in X-Progress-Id otherwise"
 // @Param Authorization header string true "Bearer token"
 // @Success 200 {object} entities.UploadResponseEntity
 // @Failure 400 {object} errors.HttpError
 // @Failure 401 {object} errors.HttpError
 // @Failure 403 {object} errors.HttpError
+// @Failure 409 {object} errors.HttpError
+// @Failure 412 {object} errors.HttpError
+// @Failure 413 {object} errors.HttpError
+// @Failure 415 {object} errors.HttpError
+// @Failure 422 {object} errors.HttpError
 // @Failure 500 {object} errors.HttpError
+// @Failure 503 {object} errors.HttpError
 // @Router /upload [post]
 func (uc *UploadHandler) Upload(c *gin.Context) {
 	// Service + service-level permission are guaranteed by the route
@@ -53,8 +77,35 @@ func (uc *UploadHandler) Upload(c *gin.Context) {
 		return
 	}
 
+	tracker, finish, ok := trackUpload(c)
+	if !ok {
+		return
+	}
+	defer finish()
+
+	form, err := c.Request.MultipartReader()
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("Erro ao obter o arquivo: %s", err))
+		return
+	}
+
+	file, err := readUploadForm(form, map[string]string{
+		"bucket": c.Query("bucket"),
+		"folder": c.Query("folder"),
+	}, config.MaxUploadSize())
+	if errors.Is(err, errSpoolTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.String(http.StatusBadRequest, fmt.Sprintf("Erro ao obter o arquivo: %s", err))
+		return
+	}
+	defer file.Close()
+	fields := file.Fields()
+
 	// Get bucket from form
-	bucketName := c.PostForm("bucket")
+	bucketName := fields["bucket"]
 	if bucketName == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "bucket parameter is required",
@@ -70,49 +121,56 @@ func (uc *UploadHandler) Upload(c *gin.Context) {
 		return
 	}
 
-	file, header, err := c.Request.FormFile("file")
-	folderName := c.Request.FormValue("folder")
+	// Content-MD5 and X-Checksum-SHA256 describe the file, not the
+	// multipart envelope around it.
+	checksums, err := cas.ParseChecksums(c.Request.Header)
 	if err != nil {
-		c.String(http.StatusBadRequest, fmt.Sprintf("Erro ao obter o arquivo: %s", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer file.Close()
 
-	reader, err := header.Open()
+	metadata, err := objectmeta.FromRequest(fields, c.Request.Header)
 	if err != nil {
-		c.String(http.StatusInternalServerError, fmt.Sprintf("Erro ao abrir o arquivo: %s", err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer reader.Close()
 
-	objectName := header.Filename
-	contentType := header.Header.Get("Content-Type")
-	fileSize := header.Size
-
-	fileNameLocation := fmt.Sprintf("%s/%s", folderName, objectName)
-
-	if folderName == "" {
-		fileNameLocation = objectName
-	}
-
-	fileEntity := coreEntities.FileEntity{
-		File: file,
-		Name: fileNameLocation,
-		Size: fileSize,
+	stored, appErr := uc.pipeline.Store(c.Request.Context(), uploadRequest{
+		Bucket:        bucketName,
+		Folder:        fields["folder"],
+		Filename:      file.Filename,
+		ContentType:   file.ContentType,
+		Metadata:      metadata,
+		Checksums:     checksums,
+		Preconditions: preconditionsFrom(c.Request.Header),
+		Body:          file,
+		Progress:      tracker,
+	})
+	if file.Trailing != nil {
+		tracker.Fail(file.Trailing.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": file.Trailing.Error()})
+		return
 	}
-
-	uc.log.Info(fmt.Sprintf("Sending %s to Bucket: %s", objectName, bucketName))
-	filePath, appErr := uc.minioService.UploadObject(bucketName, fileEntity, minio.PutObjectOptions{ContentType: contentType})
 	if appErr != nil {
-		c.JSON(http.StatusInternalServerError, appErr)
+		tracker.Fail(appErr.Message)
+		c.JSON(appErr.ToHttpError().StatusCode, appErr)
 		return
 	}
 
+	tracker.Done(stored.Response)
+	c.JSON(http.StatusOK, stored.Response)
+}
+
+// buildUploadResponse describes a stored object and the URLs to fetch
+// it from: /cdn for any file and /stream, which supports ranges, for
+// media; videos are pointed at /stream. Shared by every upload flavour
+// so they all answer with the same UploadResponseEntity.
+func buildUploadResponse(filename string, object *coreEntities.StoredObjectEntity) entities.UploadResponseEntity {
 	// filepath.Ext returns the last "." segment (".tar.gz" → ".gz")
 	// and "" for extension-less files — both cases strings.Split(name,
 	// ".")[1] gets wrong. The leading dot is trimmed so the lookup
 	// keys below stay simple.
-	extension := strings.TrimPrefix(filepath.Ext(objectName), ".")
+	extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
 
 	videoExtensions := map[string]bool{
 		"mp4": true,
@@ -123,18 +181,35 @@ func (uc *UploadHandler) Upload(c *gin.Context) {
 		"wmv": true,
 	}
 
-	message := fmt.Sprintf("Arquivo '%s' enviado com sucesso!", objectName)
 	rootUri := config.EnvCDNPublicURL()
-	if videoExtensions[extension] {
-		c.JSON(http.StatusOK, entities.UploadResponseEntity{
-			URL:     fmt.Sprintf("%s/stream/%s", rootUri, objectName),
-			Message: message,
-		})
-		return
+	objectPath := escapePath(object.Bucket + "/" + object.Key)
+
+	response := entities.UploadResponseEntity{
+		CDNURL:      fmt.Sprintf("%s/cdn/%s", rootUri, objectPath),
+		StreamURL:   fmt.Sprintf("%s/stream/%s", rootUri, objectPath),
+		Message:     fmt.Sprintf("Arquivo '%s' enviado com sucesso!", filename),
+		Bucket:      object.Bucket,
+		Key:         object.Key,
+		ETag:        object.ETag,
+		VersionID:   object.VersionID,
+		Size:        object.Size,
+		ContentType: object.ContentType,
 	}
 
-	c.JSON(http.StatusOK, entities.UploadResponseEntity{
-		URL:     fmt.Sprintf("%s/cdn/%s", rootUri, filePath),
-		Message: message,
-	})
+	response.URL = response.CDNURL
+	if videoExtensions[extension] || strings.HasPrefix(object.ContentType, "video/") {
+		response.URL = response.StreamURL
+	}
+
+	return response
+}
+
+// escapePath escapes each segment of an object path for use in a URL.
+func escapePath(objectPath string) string {
+	segments := strings.Split(objectPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
 }
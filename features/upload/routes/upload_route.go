@@ -2,13 +2,35 @@ package routes
 
 import (
 	rbauth "github.com/RodolfoBonis/rb_auth_client"
+	"github.com/RodolfoBonis/rb-cdn/core/config"
+	"github.com/RodolfoBonis/rb-cdn/core/idempotency"
+	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/middlewares"
 	"github.com/RodolfoBonis/rb-cdn/features/upload/di"
 	"github.com/gin-gonic/gin"
 )
 
 func InjectRoutes(route *gin.RouterGroup, authClient *rbauth.Client) {
 	var uc = di.UploadInjection()
+	var tus = di.TusInjection()
+	var fetch = di.FetchInjection()
+
+	// Retries carrying an Idempotency-Key get the first response back.
+	idempotent := middlewares.Idempotency(idempotency.Default(), config.EnvIdempotencyTTL(), logger.Log)
 
 	uploadRoute := route.Group("/upload")
-	uploadRoute.POST("/", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), uc.Upload)
+	uploadRoute.POST("/", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), idempotent, uc.Upload)
+	uploadRoute.POST("/batch", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), idempotent, uc.UploadBatch)
+	uploadRoute.POST("/archive", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), idempotent, uc.UploadArchive)
+	uploadRoute.POST("/fetch", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), idempotent, fetch.Fetch)
+	uploadRoute.GET("/fetch/:bucket/:id", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), fetch.Status)
+
+	// tus discovery is unauthenticated by spec: clients probe it
+	// before they know which bucket they'll write to.
+	tusRoute := uploadRoute.Group("/tus")
+	tusRoute.OPTIONS("", tus.Options)
+	tusRoute.POST("", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), idempotent, tus.Create)
+	tusRoute.HEAD("/:bucket/:id", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), tus.Head)
+	tusRoute.PATCH("/:bucket/:id", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), idempotent, tus.Patch)
+	tusRoute.DELETE("/:bucket/:id", authClient.RequireAuth(), authClient.RequireService("rb-cdn"), authClient.RequireServicePermission("rb-cdn", "write"), idempotent, tus.Terminate)
 }
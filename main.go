@@ -6,7 +6,9 @@ import (
 	"github.com/RodolfoBonis/rb-cdn/core/config"
 	"github.com/RodolfoBonis/rb-cdn/core/entities"
 	"github.com/RodolfoBonis/rb-cdn/core/errors"
+	"github.com/RodolfoBonis/rb-cdn/core/keyring"
 	"github.com/RodolfoBonis/rb-cdn/core/logger"
+	"github.com/RodolfoBonis/rb-cdn/core/mimetype"
 	"github.com/RodolfoBonis/rb-cdn/core/middlewares"
 	"github.com/RodolfoBonis/rb-cdn/core/services"
 	"github.com/RodolfoBonis/rb-cdn/docs"
@@ -23,6 +25,12 @@ import (
 var authClient *rbauth.Client
 
 func main() {
+	// "rb-cdn analyze <bucket>" backfills image analyses and exits
+	// instead of serving.
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		os.Exit(runAnalyze(os.Args[2:]))
+	}
+
 	// Auth client + capability sync live in main() (not init) so
 	// tests that call initializeApp() directly don't trip the
 	// rbauth.NewClient validation panic when their env doesn't
@@ -55,9 +63,9 @@ func main() {
 	app.Use(gin.ErrorLogger())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "OPTIONS", "POST"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "Range", "X-Api-Key"},
-		ExposeHeaders:    []string{"Content-Range", "Content-Length"},
+		AllowMethods:     []string{"GET", "OPTIONS", "POST", "HEAD", "PATCH", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "Range", "X-Api-Key", "Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset", "Idempotency-Key", "X-Progress-Id"},
+		ExposeHeaders:    []string{"Content-Range", "Content-Length", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Expires", "Idempotent-Replayed", "X-Progress-Id"},
 		AllowCredentials: true,
 	}))
 
@@ -91,6 +99,24 @@ func initializeApp() {
 	config.LoadEnvVars()
 	logger.InitLogger()
 
+	if err := config.LoadBucketSettings(); err != nil {
+		appError := errors.EnvironmentError(err.Error())
+		logger.Log.Error(appError.Message, appError.ToMap())
+		panic(err)
+	}
+
+	if err := keyring.LoadDefault(); err != nil {
+		appError := errors.EnvironmentError(err.Error())
+		logger.Log.Error(appError.Message, appError.ToMap())
+		panic(err)
+	}
+
+	if err := mimetype.LoadDefault(); err != nil {
+		appError := errors.EnvironmentError(err.Error())
+		logger.Log.Error(appError.Message, appError.ToMap())
+		panic(err)
+	}
+
 	versionFileName := "version.txt"
 	if config.EnvironmentConfig() == entities.Environment.Production {
 		versionFileName = "/version.txt"
@@ -3,7 +3,12 @@ package routes
 import (
 	rbauth "github.com/RodolfoBonis/rb_auth_client"
 	"github.com/RodolfoBonis/rb-cdn/core/health"
+	cacheRoutes "github.com/RodolfoBonis/rb-cdn/features/cache/routes"
+	encryptionRoutes "github.com/RodolfoBonis/rb-cdn/features/encryption/routes"
+	imageRoutes "github.com/RodolfoBonis/rb-cdn/features/image/routes"
 	mediaRoutes "github.com/RodolfoBonis/rb-cdn/features/media/routes"
+	presignRoutes "github.com/RodolfoBonis/rb-cdn/features/presign/routes"
+	progressRoutes "github.com/RodolfoBonis/rb-cdn/features/progress/routes"
 	streamRoutes "github.com/RodolfoBonis/rb-cdn/features/stream/routes"
 	uploadRoutes "github.com/RodolfoBonis/rb-cdn/features/upload/routes"
 
@@ -24,4 +29,9 @@ func InitializeRoutes(router *gin.Engine, authClient *rbauth.Client) {
 	uploadRoutes.InjectRoutes(root, authClient)
 	streamRoutes.InjectRoutes(root, authClient)
 	mediaRoutes.InjectRoutes(root, authClient)
+	presignRoutes.InjectRoutes(root, authClient)
+	progressRoutes.InjectRoutes(root, authClient)
+	encryptionRoutes.InjectRoutes(root, authClient)
+	cacheRoutes.InjectRoutes(root, authClient)
+	imageRoutes.InjectRoutes(root, authClient)
 }